@@ -0,0 +1,43 @@
+//go:build !noadmin && !minimal
+// +build !noadmin,!minimal
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/TasSM/labns/internal/admin"
+	"github.com/TasSM/labns/internal/config"
+)
+
+func init() {
+	subcommands["status"] = runStatus
+	startupHooks = append(startupHooks, startAdmin)
+	reloadHooks = append(reloadHooks, func(conf *config.Configuration) { admin.SetAccess(&conf.AdminAccess) })
+}
+
+func startAdmin(conf *config.Configuration) {
+	admin.SetAccess(&conf.AdminAccess)
+	admin.Handle("/reload", reloadHandler)
+	if conf.AdminListen != "" {
+		go admin.Serve(conf.AdminListen)
+	}
+	if conf.AdminSocket != "" {
+		go admin.ServeSocket(conf.AdminSocket)
+	}
+}
+
+/*
+*	POST /reload does what SIGHUP does, answering 422 with the reason when the configuration file is invalid
+ */
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := reloadConfig(); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	admin.WriteJSON(w, map[string]bool{"Reloading": true})
+}
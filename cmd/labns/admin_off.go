@@ -0,0 +1,13 @@
+//go:build noadmin || minimal
+// +build noadmin minimal
+
+package main
+
+import "github.com/TasSM/labns/internal/feature"
+
+func init() {
+	feature.Omit("admin")
+	// the web UI is served by the admin listener
+	feature.Omit("webui")
+	subcommands["status"] = notCompiled("status", "admin")
+}
@@ -0,0 +1,315 @@
+//go:build !notools && !minimal
+// +build !notools,!minimal
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TasSM/labns/internal/service"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func init() {
+	subcommands["bench"] = runBench
+}
+
+var benchTypeMap = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"MX":    dnsmessage.TypeMX,
+	"NS":    dnsmessage.TypeNS,
+	"PTR":   dnsmessage.TypePTR,
+	"SOA":   dnsmessage.TypeSOA,
+	"SRV":   dnsmessage.TypeSRV,
+	"TXT":   dnsmessage.TypeTXT,
+}
+
+type benchOptions struct {
+	Server   string
+	QPS      float64
+	Duration time.Duration
+	RampUp   time.Duration
+	Timeout  time.Duration
+	Sockets  int
+	Names    []string
+	Types    []dnsmessage.Type
+}
+
+type BenchSummary struct {
+	Sent        uint64
+	Received    uint64
+	Timeouts    uint64
+	SendErrors  uint64
+	ElapsedSec  float64
+	AchievedQPS float64
+	RCodes      map[string]uint64
+	P50Ms       float64
+	P95Ms       float64
+	P99Ms       float64
+	MaxMs       float64
+}
+
+type benchSocket struct {
+	lock        sync.Mutex
+	conn        net.Conn
+	nextId      uint16
+	outstanding map[uint16]time.Time
+	latencies   []time.Duration
+	rcodes      map[dnsmessage.RCode]uint64
+	sent        uint64
+	timeouts    uint64
+	sendErrors  uint64
+}
+
+/*
+*	labns bench - paced query load generator reporting rcode distribution and latency percentiles
+ */
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	server := fs.String("server", "127.0.0.1:53", "address of the nameserver under test")
+	qps := fs.Float64("qps", 1000, "target queries per second once ramp-up completes")
+	duration := fs.Duration("duration", 10*time.Second, "total time to send queries for")
+	rampUp := fs.Duration("rampup", 0, "linearly increase the query rate from zero over this period")
+	timeout := fs.Duration("timeout", 2*time.Second, "time after which an unanswered query counts as a timeout")
+	sockets := fs.Int("sockets", 4, "number of UDP sockets used to send and receive queries")
+	namesPath := fs.String("names", "", "file with one query name per line (required)")
+	types := fs.String("types", "A", "comma separated query types picked at random per query e.g. A,AAAA,A")
+	jsonPath := fs.String("json", "", "also write the summary as JSON to this file (- for stdout)")
+	fs.Parse(args)
+
+	opts := benchOptions{Server: *server, QPS: *qps, Duration: *duration, RampUp: *rampUp, Timeout: *timeout, Sockets: *sockets}
+	var err error
+	if opts.Names, err = readNames(*namesPath); err != nil {
+		fmt.Fprintln(os.Stderr, "bench: "+err.Error())
+		return 2
+	}
+	for _, t := range strings.Split(*types, ",") {
+		qtype, ok := benchTypeMap[strings.ToUpper(strings.TrimSpace(t))]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "bench: unsupported query type %q\n", t)
+			return 2
+		}
+		opts.Types = append(opts.Types, qtype)
+	}
+	if opts.Sockets < 1 || opts.QPS <= 0 || opts.Duration <= 0 {
+		fmt.Fprintln(os.Stderr, "bench: -sockets, -qps and -duration must be positive")
+		return 2
+	}
+	summary, err := bench(&opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bench: "+err.Error())
+		return 1
+	}
+	printBenchSummary(summary)
+	if *jsonPath != "" {
+		out, _ := json.MarshalIndent(summary, "", "  ")
+		if *jsonPath == "-" {
+			fmt.Println(string(out))
+		} else if err = ioutil.WriteFile(*jsonPath, out, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, "bench: "+err.Error())
+			return 1
+		}
+	}
+	return 0
+}
+
+func readNames(path string) ([]string, error) {
+	if path == "" {
+		return nil, errors.New("-names is required")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasSuffix(line, ".") {
+			line += "."
+		}
+		names = append(names, line)
+	}
+	if len(names) == 0 {
+		return nil, errors.New("no names found in " + path)
+	}
+	return names, scanner.Err()
+}
+
+func bench(opts *benchOptions) (*BenchSummary, error) {
+	socks := make([]*benchSocket, opts.Sockets)
+	for i := range socks {
+		c, err := net.Dial("udp", opts.Server)
+		if err != nil {
+			return nil, err
+		}
+		defer c.Close()
+		socks[i] = &benchSocket{conn: c, nextId: uint16(rand.Intn(65535)), outstanding: make(map[uint16]time.Time), rcodes: make(map[dnsmessage.RCode]uint64)}
+		go socks[i].readLoop()
+	}
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, s := range socks {
+		wg.Add(1)
+		go func(s *benchSocket) {
+			defer wg.Done()
+			s.sendLoop(opts, start, opts.QPS/float64(opts.Sockets))
+		}(s)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	time.Sleep(opts.Timeout)
+
+	summary := &BenchSummary{RCodes: make(map[string]uint64), ElapsedSec: elapsed.Seconds()}
+	var latencies []time.Duration
+	for _, s := range socks {
+		s.lock.Lock()
+		s.timeouts += uint64(len(s.outstanding))
+		summary.Sent += s.sent
+		summary.Timeouts += s.timeouts
+		summary.SendErrors += s.sendErrors
+		for k, v := range s.rcodes {
+			summary.RCodes[k.String()] += v
+			summary.Received += v
+		}
+		latencies = append(latencies, s.latencies...)
+		s.lock.Unlock()
+	}
+	summary.AchievedQPS = float64(summary.Sent) / elapsed.Seconds()
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	summary.P50Ms = percentileMs(latencies, 0.50)
+	summary.P95Ms = percentileMs(latencies, 0.95)
+	summary.P99Ms = percentileMs(latencies, 0.99)
+	summary.MaxMs = percentileMs(latencies, 1)
+	return summary, nil
+}
+
+/*
+*	Paces sends on a 10ms tick, carrying the fractional remainder so low per-socket rates are still honoured
+ */
+func (s *benchSocket) sendLoop(opts *benchOptions, start time.Time, rate float64) {
+	const tick = 10 * time.Millisecond
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	due := 0.0
+	for now := range ticker.C {
+		elapsed := now.Sub(start)
+		if elapsed >= opts.Duration {
+			return
+		}
+		current := rate
+		if opts.RampUp > 0 && elapsed < opts.RampUp {
+			current = rate * float64(elapsed) / float64(opts.RampUp)
+		}
+		due += current * tick.Seconds()
+		for ; due >= 1; due-- {
+			s.send(opts)
+		}
+		s.expire(now, opts.Timeout)
+	}
+}
+
+func (s *benchSocket) send(opts *benchOptions) {
+	name := opts.Names[rand.Intn(len(opts.Names))]
+	qtype := opts.Types[rand.Intn(len(opts.Types))]
+	s.lock.Lock()
+	s.nextId++
+	id := s.nextId
+	query, err := service.BuildQuery(name, qtype, id)
+	if err != nil {
+		s.sendErrors++
+		s.lock.Unlock()
+		return
+	}
+	s.outstanding[id] = time.Now()
+	s.sent++
+	s.lock.Unlock()
+	if _, err = s.conn.Write(query); err != nil {
+		s.lock.Lock()
+		delete(s.outstanding, id)
+		s.sendErrors++
+		s.lock.Unlock()
+	}
+}
+
+func (s *benchSocket) expire(now time.Time, timeout time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for id, sent := range s.outstanding {
+		if now.Sub(sent) > timeout {
+			delete(s.outstanding, id)
+			s.timeouts++
+		}
+	}
+}
+
+func (s *benchSocket) readLoop() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		received := time.Now()
+		var p dnsmessage.Parser
+		header, err := p.Start(buf[:n])
+		if err != nil {
+			continue
+		}
+		s.lock.Lock()
+		if sent, ok := s.outstanding[header.ID]; ok {
+			delete(s.outstanding, header.ID)
+			s.latencies = append(s.latencies, received.Sub(sent))
+			s.rcodes[header.RCode]++
+		}
+		s.lock.Unlock()
+	}
+}
+
+func percentileMs(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted))*p+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return float64(sorted[idx]) / float64(time.Millisecond)
+}
+
+func printBenchSummary(s *BenchSummary) {
+	fmt.Printf("sent %d, received %d, timeouts %d, send errors %d over %.1fs (%.0f qps)\n", s.Sent, s.Received, s.Timeouts, s.SendErrors, s.ElapsedSec, s.AchievedQPS)
+	fmt.Printf("latency p50 %.2fms p95 %.2fms p99 %.2fms max %.2fms\n", s.P50Ms, s.P95Ms, s.P99Ms, s.MaxMs)
+	codes := make([]string, 0, len(s.RCodes))
+	for k := range s.RCodes {
+		codes = append(codes, k)
+	}
+	sort.Strings(codes)
+	for _, k := range codes {
+		fmt.Printf("  %-22s %d\n", k, s.RCodes[k])
+	}
+}
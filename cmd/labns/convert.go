@@ -0,0 +1,54 @@
+//go:build !notools && !minimal
+// +build !notools,!minimal
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/TasSM/labns/internal/config"
+)
+
+func init() {
+	subcommands["convert-dnsmasq"] = runConvertDnsmasq
+}
+
+/*
+*	labns convert-dnsmasq - prints a labns configuration equivalent to a dnsmasq configuration file
+ */
+func runConvertDnsmasq(args []string) int {
+	fs := flag.NewFlagSet("convert-dnsmasq", flag.ExitOnError)
+	output := fs.String("o", "", "write the configuration to this file instead of stdout")
+	ttl := fs.Uint("ttl", 300, "TTL for converted records when dnsmasq local-ttl is not set")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: labns convert-dnsmasq [-o labns.json] [-ttl 300] /etc/dnsmasq.conf")
+		return 2
+	}
+	conf, warnings, err := config.ConvertDnsmasq(fs.Arg(0), uint32(*ttl))
+	for _, w := range warnings {
+		fmt.Fprintln(os.Stderr, "warning: "+w)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "convert-dnsmasq: "+err.Error())
+		return 1
+	}
+	out, err := json.MarshalIndent(conf, "", "    ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "convert-dnsmasq: "+err.Error())
+		return 1
+	}
+	if *output == "" {
+		fmt.Println(string(out))
+		return 0
+	}
+	if err = ioutil.WriteFile(*output, out, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "convert-dnsmasq: "+err.Error())
+		return 1
+	}
+	return 0
+}
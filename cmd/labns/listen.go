@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"net"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+	"github.com/TasSM/labns/internal/service"
+)
+
+/*
+*	Binds a DNS socket for every configured address and interface address, an unspecified address or DualStack
+*	gets separate IPv4 and IPv6 sockets so replies leave from the family the query arrived on, Listeners are bound
+*	last and tagged with their profile
+ */
+func openListeners(conf *config.Configuration, port uint16) ([]*net.UDPConn, error) {
+	addrs, err := listenAddresses(conf, port)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 && len(conf.Listeners) == 0 {
+		if !conf.DualStack {
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: int(port)})
+			if err != nil {
+				return nil, err
+			}
+			return []*net.UDPConn{conn}, nil
+		}
+		addrs = append(addrs, &net.UDPAddr{Port: int(port)})
+	}
+	var conns []*net.UDPConn
+	for _, addr := range addrs {
+		bound, err := bindAddress(addr)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, bound...)
+	}
+	for _, l := range conf.Listeners {
+		addr, err := config.ParseListenAddress(l.Address, port)
+		if err == nil {
+			var bound []*net.UDPConn
+			if bound, err = bindAddress(addr); err == nil {
+				for _, c := range bound {
+					service.SetListenerProfile(c, l.Profile)
+				}
+				conns = append(conns, bound...)
+				continue
+			}
+		}
+		for _, c := range conns {
+			c.Close()
+		}
+		return nil, err
+	}
+	return conns, nil
+}
+
+/*
+*	Collects ListenAddress, ListenAddresses and the current addresses of ListenInterfaces without duplicates
+ */
+func listenAddresses(conf *config.Configuration, port uint16) ([]*net.UDPAddr, error) {
+	var addrs []*net.UDPAddr
+	seen := make(map[string]bool)
+	add := func(addr *net.UDPAddr) {
+		if !seen[addr.String()] {
+			seen[addr.String()] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	values := conf.ListenAddresses
+	if conf.ListenAddress != "" {
+		values = append([]string{conf.ListenAddress}, values...)
+	}
+	for _, v := range values {
+		addr, err := config.ParseListenAddress(v, port)
+		if err != nil {
+			return nil, err
+		}
+		add(addr)
+	}
+	for _, name := range conf.ListenInterfaces {
+		ips := interfaceAddresses(name)
+		if len(ips) == 0 {
+			logging.LogMessage(logging.LogError, "Interface "+name+" has no usable addresses, not listening on it")
+			continue
+		}
+		for _, ip := range ips {
+			add(&net.UDPAddr{IP: ip, Port: int(port)})
+		}
+	}
+	return addrs, nil
+}
+
+/*
+*	Returns the unicast addresses of the named interface, link-local IPv6 addresses are skipped as they need a zone
+ */
+func interfaceAddresses(name string) []net.IP {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		logging.LogMessage(logging.LogError, "Unable to find interface "+name+": "+err.Error())
+		return nil
+	}
+	list, err := iface.Addrs()
+	if err != nil {
+		logging.LogMessage(logging.LogError, "Unable to read addresses of interface "+name+": "+err.Error())
+		return nil
+	}
+	var ips []net.IP
+	for _, a := range list {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		ips = append(ips, ipNet.IP)
+	}
+	return ips
+}
+
+func bindAddress(addr *net.UDPAddr) ([]*net.UDPConn, error) {
+	if addr.IP != nil && !addr.IP.IsUnspecified() {
+		network := "udp6"
+		if addr.IP.To4() != nil {
+			network = "udp4"
+		}
+		conn, err := net.ListenUDP(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.UDPConn{conn}, nil
+	}
+	var conns []*net.UDPConn
+	var lastErr error
+	for _, l := range []struct {
+		network string
+		ip      net.IP
+	}{{"udp4", net.IPv4zero}, {"udp6", net.IPv6unspecified}} {
+		conn, err := net.ListenUDP(l.network, &net.UDPAddr{IP: l.ip, Port: addr.Port})
+		if err != nil {
+			logging.LogMessage(logging.LogError, "Unable to bind "+l.network+" listener: "+err.Error())
+			lastErr = err
+			continue
+		}
+		conns = append(conns, conn)
+	}
+	if len(conns) == 0 {
+		return nil, errors.New("no listener could be bound: " + lastErr.Error())
+	}
+	return conns, nil
+}
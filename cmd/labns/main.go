@@ -1,26 +1,144 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
+	"github.com/TasSM/labns/internal/audit"
+	"github.com/TasSM/labns/internal/buildinfo"
 	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/history"
 	"github.com/TasSM/labns/internal/logging"
 	"github.com/TasSM/labns/internal/service"
 )
 
+/*
+*	Optional parts of the binary wire themselves in from their own files, so a build that leaves them out with a
+*	build tag doesn't link them
+ */
+var (
+	subcommands  = make(map[string]func(args []string) int)
+	startupHooks []func(conf *config.Configuration)
+	reloadHooks  []func(conf *config.Configuration)
+)
+
 func main() {
 	config.ReadEnvironment()
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(run(os.Args[2:]))
+		}
+		switch os.Args[1] {
+		case "-version", "version":
+			info := buildinfo.Describe(nil)
+			fmt.Printf("labns %s (commit %s, built %s, %s)\n", info.Version, info.Commit, info.BuildDate, info.GoVersion)
+			if len(info.Omitted) > 0 {
+				fmt.Printf("built without %s\n", strings.Join(info.Omitted, ", "))
+			}
+			os.Exit(0)
+		}
+	}
 	go logging.InitLogging(config.LOG_FILE_PATH)
-	conf, err := config.LoadConfig(config.CONFIG_FILE_PATH)
+	conf, err := loadStartupConfig(len(os.Args) > 1 && os.Args[1] == "-defaults")
 	if err != nil {
 		logging.LogMessage(logging.LogFatal, "Failed to load configuration file: "+err.Error())
 		return
 	}
-	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: int(config.SERVICE_DNS_PORT)})
+	logging.LogMessage(logging.LogInfo, buildinfo.Banner(buildinfo.Describe(conf)))
+	sl := conf.Syslog
+	if err := logging.ConfigureTarget(conf.LogTarget, config.LOG_FILE_PATH, logging.SyslogOptions{Network: sl.Network, Address: sl.Address, Facility: sl.Facility, Tag: sl.Tag}); err != nil {
+		logging.LogMessage(logging.LogFatal, "Failed to configure log target: "+err.Error())
+		return
+	}
+	if err := logging.SetPrivacy(conf.QueryLogPrivacy, []byte(conf.QueryLogKey)); err != nil {
+		logging.LogMessage(logging.LogFatal, "Failed to generate query log key: "+err.Error())
+		return
+	}
+	conns, err := openListeners(conf, config.SERVICE_DNS_PORT)
 	if err != nil {
-		logging.LogMessage(logging.LogFatal, fmt.Sprintf("Failed to bind UDP listener for DNS service on port: %d", config.SERVICE_DNS_PORT))
+		logging.LogMessage(logging.LogFatal, fmt.Sprintf("Failed to bind UDP listener for DNS service on port %d: %v", config.SERVICE_DNS_PORT, err))
 		return
 	}
-	service.StartDNSService(conn, conf)
+	if err := audit.Configure(conf.AuditLogPath); err != nil {
+		logging.LogMessage(logging.LogFatal, "Failed to open audit log: "+err.Error())
+		return
+	}
+	if err := history.Configure(&conf.QueryHistory); err != nil {
+		logging.LogMessage(logging.LogFatal, "Failed to load query history: "+err.Error())
+		return
+	}
+	go dumpStatsOnSignal()
+	go reloadOnSignal()
+	go toggleOfflineOnSignal()
+	for _, hook := range startupHooks {
+		hook(conf)
+	}
+	service.StartDNSService(conns, conf)
+}
+
+/*
+*	Uses the built-in defaults when asked to, or when no config path was set and the default file doesn't exist
+ */
+func loadStartupConfig(defaults bool) (*config.Configuration, error) {
+	if !defaults && os.Getenv(config.ENV_CONFIG_PATH) == "" {
+		if _, err := os.Stat(config.CONFIG_FILE_PATH); os.IsNotExist(err) {
+			defaults = true
+		}
+	}
+	if !defaults {
+		buildinfo.ConfigSource = config.CONFIG_FILE_PATH
+		return config.LoadConfig(config.CONFIG_FILE_PATH)
+	}
+	buildinfo.ConfigSource = "built-in defaults"
+	logging.LogEvery(logging.LogInfo, "**********************************************************************")
+	logging.LogMessage(logging.LogInfo, "No configuration file in use, running with built-in defaults: forwarding to 1.1.1.1 and 9.9.9.9")
+	logging.LogMessage(logging.LogInfo, fmt.Sprintf("Set %s or create %s to configure labns", config.ENV_CONFIG_PATH, config.DEFAULT_CONFIG_PATH))
+	logging.LogEvery(logging.LogInfo, "**********************************************************************")
+	return config.DefaultConfiguration()
+}
+
+/*
+*	Reloads the configuration file on SIGHUP, an invalid file is logged and the running configuration kept
+ */
+func reloadOnSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for range sig {
+		reloadConfig()
+	}
+}
+
+/*
+*	Reads the configuration file again and queues the reload, an invalid file is reported and the running
+*	configuration kept
+ */
+func reloadConfig() error {
+	conf, err := config.LoadConfig(config.CONFIG_FILE_PATH)
+	if err != nil {
+		err = errors.New("configuration file is invalid: " + err.Error())
+		service.ReloadFailed(err)
+		return err
+	}
+	if err := audit.Configure(conf.AuditLogPath); err != nil {
+		logging.LogMessage(logging.LogError, "Failed to open audit log: "+err.Error())
+	}
+	for _, hook := range reloadHooks {
+		hook(conf)
+	}
+	service.Reload(conf)
+	return nil
+}
+
+/*
+*	The subcommand registered in place of one that was left out of this build
+ */
+func notCompiled(name, feature string) func(args []string) int {
+	return func(args []string) int {
+		fmt.Fprintf(os.Stderr, "labns %s: this labns was built without %s\n", name, feature)
+		return 2
+	}
 }
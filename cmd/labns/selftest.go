@@ -0,0 +1,160 @@
+//go:build !notools && !minimal
+// +build !notools,!minimal
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+	"github.com/TasSM/labns/internal/service"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func init() {
+	subcommands["selftest"] = runSelfTest
+}
+
+type checkResult struct {
+	Name  string
+	Stage string
+	Err   error
+}
+
+/*
+*	labns selftest - starts the service on an ephemeral loopback port and checks the local and forwarding paths
+ */
+func runSelfTest(args []string) int {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	configPath := fs.String("config", config.CONFIG_FILE_PATH, "path to the JSON configuration file")
+	probe := fs.String("probe", "example.com.", "name used for the forwarded and upstream probe queries")
+	fs.Parse(args)
+	go logging.InitLogging(config.LOG_FILE_PATH)
+
+	var results []checkResult
+	conf, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return reportSelfTest(append(results, checkResult{"config", "load", err}))
+	}
+	results = append(results, checkResult{"config", "load", nil})
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return reportSelfTest(append(results, checkResult{"listener", "bind", err}))
+	}
+	results = append(results, checkResult{"listener", "bind", nil})
+	server := conn.LocalAddr().String()
+	go service.StartDNSService([]*net.UDPConn{conn}, conf)
+
+	timeout := time.Duration(conf.UpstreamNameservers.TimeoutMs) * time.Millisecond
+	if timeout > 2*time.Second {
+		timeout = 2 * time.Second
+	}
+	if len(conf.LocalRecords) > 0 {
+		record := conf.LocalRecords[0]
+		results = append(results, checkResult{"local " + record.Name + " " + record.Type, "local answer", checkLocalRecord(server, &record, timeout)})
+	}
+	results = append(results, checkResult{"forward " + *probe, "upstream timeout", checkResolves(server, *probe, timeout+time.Second)})
+	upstreams := conf.UpstreamNameservers.Nameservers()
+	for k, label := range []string{"primary", "secondary"}[:len(upstreams)] {
+		addr := nameserverAddr(&upstreams[k])
+		results = append(results, checkResult{"upstream " + label + " " + addr, "upstream timeout", checkResolves(addr, *probe, timeout)})
+	}
+	return reportSelfTest(results)
+}
+
+func reportSelfTest(results []checkResult) int {
+	code := 0
+	for _, r := range results {
+		if r.Err != nil {
+			code = 1
+			fmt.Printf("FAIL  %-40s stage=%s: %v\n", r.Name, r.Stage, r.Err)
+			continue
+		}
+		fmt.Printf("PASS  %s\n", r.Name)
+	}
+	return code
+}
+
+func nameserverAddr(ns *config.Nameserver) string {
+	ip := ns.IPv4
+	if ip == "" {
+		ip = ns.IPv6
+	}
+	return net.JoinHostPort(ip, fmt.Sprint(ns.Port))
+}
+
+func exchange(server string, name string, qtype dnsmessage.Type, timeout time.Duration) (*dnsmessage.Message, error) {
+	id := uint16(rand.Intn(65534) + 1)
+	query, err := service.BuildQuery(name, qtype, id)
+	if err != nil {
+		return nil, err
+	}
+	c, err := net.Dial("udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(timeout))
+	if _, err = c.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := c.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		var m dnsmessage.Message
+		if err = m.Unpack(buf[:n]); err != nil || m.ID != id {
+			continue
+		}
+		return &m, nil
+	}
+}
+
+func checkResolves(server string, name string, timeout time.Duration) error {
+	m, err := exchange(server, name, dnsmessage.TypeA, timeout)
+	if err != nil {
+		return err
+	}
+	if m.RCode != dnsmessage.RCodeSuccess && m.RCode != dnsmessage.RCodeNameError {
+		return errors.New("unexpected rcode " + m.RCode.String())
+	}
+	return nil
+}
+
+func checkLocalRecord(server string, record *config.LocalDNSRecord, timeout time.Duration) error {
+	qtype, want := record.QueryType(), record.Target
+	if record.Type == "NULL" {
+		qtype, want = dnsmessage.TypeA, "0.0.0.0"
+	}
+	m, err := exchange(server, record.Name, qtype, timeout)
+	if err != nil {
+		return err
+	}
+	if len(m.Answers) == 0 {
+		return errors.New("no answer returned, rcode " + m.RCode.String())
+	}
+	var got string
+	switch body := m.Answers[0].Body.(type) {
+	case *dnsmessage.AResource:
+		got = net.IP(body.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		got = net.IP(body.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		got = body.CNAME.String()
+	}
+	if ip := net.ParseIP(want); ip != nil {
+		want = ip.String()
+	}
+	if got != want {
+		return fmt.Errorf("answer mismatch, expected %s got %s", want, got)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/TasSM/labns/internal/logging"
+	"github.com/TasSM/labns/internal/service"
+	"github.com/TasSM/labns/internal/stats"
+)
+
+/*
+*	Writes the counters, histograms, top lists, inflight queries and background tasks to the log on SIGUSR1
+ */
+func dumpStatsOnSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	for range sig {
+		logging.LogMessage(logging.LogInfo, stats.Dump())
+		if h := stats.DumpHistograms(); h != "" {
+			logging.LogMessage(logging.LogInfo, h)
+		}
+		logging.LogMessage(logging.LogInfo, stats.DumpTop(10))
+		logging.LogMessage(logging.LogInfo, service.DumpInflight(10))
+		logging.LogMessage(logging.LogInfo, service.DumpTasks())
+	}
+}
+
+/*
+*	Toggles offline mode on SIGUSR2
+ */
+func toggleOfflineOnSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR2)
+	for range sig {
+		service.SetOfflineEnabled(service.OfflineMode() == "")
+	}
+}
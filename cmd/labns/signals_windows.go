@@ -0,0 +1,7 @@
+package main
+
+// Windows has no SIGUSR1, the stats are read from the admin API instead
+func dumpStatsOnSignal() {}
+
+// Windows has no SIGUSR2, offline mode is switched with POST /offline instead
+func toggleOfflineOnSignal() {}
@@ -0,0 +1,161 @@
+//go:build !noadmin && !minimal
+// +build !noadmin,!minimal
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/TasSM/labns/internal/admin"
+	"github.com/TasSM/labns/internal/config"
+)
+
+const statusTimeout = 5 * time.Second
+
+/*
+*	labns status - prints the live view of a running labns from its admin listener or admin socket
+ */
+func runStatus(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	configPath := fs.String("config", config.CONFIG_FILE_PATH, "configuration file to find AdminSocket or AdminListen in")
+	addr := fs.String("admin", "", "admin listener to query as host:port, instead of the one in the configuration")
+	socket := fs.String("socket", "", "admin unix socket to query, instead of the one in the configuration")
+	asJSON := fs.Bool("json", false, "print the status as JSON")
+	fs.Parse(args)
+
+	if *addr == "" && *socket == "" {
+		var err error
+		if *addr, *socket, err = adminEndpoints(*configPath); err != nil {
+			fmt.Fprintln(os.Stderr, "labns status: "+err.Error())
+			return 1
+		}
+	}
+	body, err := fetchStatus(*addr, *socket)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "labns status: "+err.Error())
+		return 1
+	}
+	if *asJSON {
+		os.Stdout.Write(body)
+		return 0
+	}
+	var status admin.Status
+	if err := json.Unmarshal(body, &status); err != nil {
+		fmt.Fprintln(os.Stderr, "labns status: unexpected response: "+err.Error())
+		return 1
+	}
+	printStatus(&status)
+	return 0
+}
+
+/*
+*	Reads only the admin settings from the configuration file, the socket is preferred when both are set
+ */
+func adminEndpoints(path string) (string, string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", errors.New(fmt.Sprintf("unable to read %s (%v), use -admin or -socket", path, err))
+	}
+	var conf struct {
+		AdminListen string
+		AdminSocket string
+	}
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return "", "", errors.New(fmt.Sprintf("unable to parse %s (%v), use -admin or -socket", path, err))
+	}
+	if conf.AdminSocket != "" {
+		return "", conf.AdminSocket, nil
+	}
+	if conf.AdminListen == "" {
+		return "", "", errors.New(fmt.Sprintf("%s sets neither AdminSocket nor AdminListen, use -admin or -socket", path))
+	}
+	return conf.AdminListen, "", nil
+}
+
+func fetchStatus(addr, socket string) ([]byte, error) {
+	client := &http.Client{Timeout: statusTimeout}
+	url := "http://" + addr + "/status"
+	if socket != "" {
+		url = "http://labns/status"
+		client.Transport = &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}}
+	}
+	res, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("admin listener answered %s", res.Status))
+	}
+	return body, nil
+}
+
+func printStatus(s *admin.Status) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "uptime\t%s\n", s.Uptime)
+	fmt.Fprintf(w, "queries/s\t1m %.1f\t5m %.1f\t15m %.1f\n", s.QPS["1m"], s.QPS["5m"], s.QPS["15m"])
+	if s.CacheHitRate != nil {
+		fmt.Fprintf(w, "cache hit rate (5m)\t%.1f%%\n", *s.CacheHitRate*100)
+	}
+	if s.Offline != "" {
+		fmt.Fprintf(w, "offline\t%s\n", s.Offline)
+	}
+	w.Flush()
+
+	sources := make([]string, 0, len(s.Answers))
+	for source := range s.Answers {
+		sources = append(sources, source)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if s.Answers[sources[i]] != s.Answers[sources[j]] {
+			return s.Answers[sources[i]] > s.Answers[sources[j]]
+		}
+		return sources[i] < sources[j]
+	})
+	fmt.Println("\nanswers (5m)")
+	for _, source := range sources {
+		fmt.Fprintf(w, "  %s\t%d\n", source, s.Answers[source])
+	}
+	w.Flush()
+
+	fmt.Println("\nupstreams")
+	for _, u := range s.Upstreams {
+		state := "healthy"
+		if !u.Healthy {
+			state = fmt.Sprintf("DOWN, %d failures", u.Failures)
+		}
+		last := "never answered"
+		if u.LastAnswer != nil {
+			last = "last answer " + s.Time.Sub(*u.LastAnswer).Truncate(time.Second).String() + " ago"
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", u.Address, state, last)
+	}
+	w.Flush()
+
+	fmt.Println("\nrecent SERVFAILs")
+	if len(s.Servfails) == 0 {
+		fmt.Println("  none")
+	}
+	for _, f := range s.Servfails {
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", f.Time.Local().Format("15:04:05"), f.Name, f.Source)
+	}
+	w.Flush()
+}
@@ -0,0 +1,13 @@
+//go:build notools || minimal
+// +build notools minimal
+
+package main
+
+import "github.com/TasSM/labns/internal/feature"
+
+func init() {
+	feature.Omit("tools")
+	for _, name := range []string{"selftest", "bench", "convert-dnsmasq"} {
+		subcommands[name] = notCompiled(name, "tools")
+	}
+}
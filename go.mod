@@ -1,5 +1,5 @@
 module github.com/TasSM/labns
 
-go 1.15
+go 1.16
 
-require golang.org/x/net v0.0.0-20210119194325-5f4716e94777
+require golang.org/x/net v0.0.0-20210405180319-a5a99cb37ef4
@@ -0,0 +1,150 @@
+package admin
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+)
+
+type capability int
+
+const (
+	capabilityDNSOnly capability = iota
+	capabilityRead
+	capabilityWrite
+)
+
+var capabilityNames = map[string]capability{
+	"dns-only":    capabilityDNSOnly,
+	"admin-read":  capabilityRead,
+	"admin-write": capabilityWrite,
+}
+
+func (c capability) String() string {
+	for name, v := range capabilityNames {
+		if v == c {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+type accessRule struct {
+	network    *net.IPNet
+	capability capability
+}
+
+/*
+*	The capability of each client network, the most specific network wins and clients outside all of them get
+*	fallback. Replaced as a whole on reload
+ */
+type accessPolicy struct {
+	fallback capability
+	rules    []accessRule
+}
+
+var (
+	access         atomic.Value
+	deniedWarnings = &logging.RateLimited{Interval: 10 * time.Second}
+)
+
+/*
+*	Loads the AdminAccess settings. Clients matching no rule get admin-read unless DefaultCapability says otherwise,
+*	admin-write is only ever granted explicitly. Until called every client has admin-read
+ */
+func SetAccess(a *config.AdminAccess) {
+	policy := &accessPolicy{fallback: capabilityRead}
+	if c, ok := capabilityNames[a.DefaultCapability]; ok {
+		policy.fallback = c
+	}
+	for _, rule := range a.Rules {
+		for _, client := range rule.Clients {
+			network, err := config.ParseClientAddress(client)
+			if err != nil {
+				continue
+			}
+			policy.rules = append(policy.rules, accessRule{network: network, capability: capabilityNames[rule.Capability]})
+		}
+	}
+	sort.SliceStable(policy.rules, func(i, j int) bool {
+		a, _ := policy.rules[i].network.Mask.Size()
+		b, _ := policy.rules[j].network.Mask.Size()
+		return a > b
+	})
+	access.Store(policy)
+}
+
+// marks requests that arrived on the admin unix socket
+type socketConn struct{}
+
+func capabilityOf(ip net.IP) capability {
+	policy, _ := access.Load().(*accessPolicy)
+	if policy == nil {
+		return capabilityRead
+	}
+	for _, rule := range policy.rules {
+		if rule.network.Contains(ip) {
+			return rule.capability
+		}
+	}
+	return policy.fallback
+}
+
+/*
+*	Whether a browser sent the request on behalf of a page from another origin. Browsers set Origin on every
+*	POST and DELETE and Sec-Fetch-Site on every request, clients such as curl send neither
+ */
+func crossOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host != r.Host
+}
+
+/*
+*	Wraps every admin endpoint: GET and HEAD need admin-read, any other method admin-write, and dns-only clients
+*	get nothing. Requests beyond the caller's capability are answered 403 before reaching the handler, requests on
+*	the admin socket are always allowed. A request that changes state is also refused when a browser sent it from
+*	another origin, so a page open on a client with admin-write can't flush or reload behind its back
+ */
+func requireCapability(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		need := capabilityWrite
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			need = capabilityRead
+		}
+		if need == capabilityWrite && crossOrigin(r) {
+			deniedWarnings.LogMessage(logging.LogInfo, fmt.Sprintf("Denied admin %s %s from %s, it came from a page on %s", r.Method, r.URL.Path, r.RemoteAddr, r.Header.Get("Origin")))
+			http.Error(w, "forbidden: cross-origin request", http.StatusForbidden)
+			return
+		}
+		if local, _ := r.Context().Value(socketConn{}).(bool); local {
+			next.ServeHTTP(w, r)
+			return
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		ip := net.ParseIP(host)
+		if err != nil || ip == nil {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if have := capabilityOf(ip); have < need {
+			deniedWarnings.LogMessage(logging.LogInfo, fmt.Sprintf("Denied admin %s %s from %s, it has %s and needs %s", r.Method, r.URL.Path, logging.Client(ip), have, need))
+			http.Error(w, fmt.Sprintf("forbidden: %s needs %s", r.Method, need), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,138 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+)
+
+func TestMain(m *testing.M) {
+	go logging.InitLogging(os.DevNull)
+	os.Exit(m.Run())
+}
+
+/*
+*	Sends method from client through requireCapability and returns the status, 200 when the request reached the handler
+ */
+func statusFor(method string, client string, socket bool) int {
+	handler := requireCapability(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r := httptest.NewRequest(method, "/cache", nil)
+	r.RemoteAddr = client + ":40000"
+	if socket {
+		r = r.WithContext(context.WithValue(r.Context(), socketConn{}, true))
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestRequireCapability(t *testing.T) {
+	SetAccess(&config.AdminAccess{
+		DefaultCapability: "dns-only",
+		Rules: []config.AdminAccessRule{
+			{Clients: []string{"10.0.10.0/24"}, Capability: "admin-write"},
+			{Clients: []string{"10.0.20.0/24"}, Capability: "admin-read"},
+			{Clients: []string{"10.0.10.5"}, Capability: "admin-read"},
+		},
+	})
+	defer SetAccess(&config.AdminAccess{})
+
+	cases := []struct {
+		client string
+		method string
+		want   int
+	}{
+		{"10.0.10.1", http.MethodGet, http.StatusOK},
+		{"10.0.10.1", http.MethodDelete, http.StatusOK},
+		{"10.0.20.1", http.MethodGet, http.StatusOK},
+		{"10.0.20.1", http.MethodHead, http.StatusOK},
+		{"10.0.20.1", http.MethodPost, http.StatusForbidden},
+		// the host rule is more specific than the write network around it
+		{"10.0.10.5", http.MethodGet, http.StatusOK},
+		{"10.0.10.5", http.MethodDelete, http.StatusForbidden},
+		{"192.0.2.1", http.MethodGet, http.StatusForbidden},
+		{"192.0.2.1", http.MethodPost, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		if got := statusFor(c.method, c.client, false); got != c.want {
+			t.Errorf("%s from %s answered %d, want %d", c.method, c.client, got, c.want)
+		}
+	}
+	if got := statusFor(http.MethodDelete, "192.0.2.1", true); got != http.StatusOK {
+		t.Errorf("DELETE on the admin socket answered %d, want 200", got)
+	}
+}
+
+func TestUnmatchedClientIsReadOnlyByDefault(t *testing.T) {
+	a := &config.AdminAccess{Rules: []config.AdminAccessRule{{Clients: []string{"10.0.10.0/24"}, Capability: "admin-write"}}}
+	SetAccess(a)
+	defer SetAccess(&config.AdminAccess{})
+
+	if got := statusFor(http.MethodGet, "192.0.2.1", false); got != http.StatusOK {
+		t.Errorf("GET from an unmatched client answered %d, want 200", got)
+	}
+	for _, method := range []string{http.MethodPost, http.MethodDelete, http.MethodPut} {
+		if got := statusFor(method, "192.0.2.1", false); got != http.StatusForbidden {
+			t.Errorf("%s from an unmatched client answered %d, want 403", method, got)
+		}
+	}
+	if got := statusFor(http.MethodPost, "10.0.10.1", false); got != http.StatusOK {
+		t.Errorf("POST from a client granted admin-write answered %d, want 200", got)
+	}
+
+	// an explicit default grants write to everyone
+	SetAccess(&config.AdminAccess{DefaultCapability: "admin-write"})
+	if got := statusFor(http.MethodPost, "192.0.2.1", false); got != http.StatusOK {
+		t.Errorf("POST with DefaultCapability admin-write answered %d, want 200", got)
+	}
+}
+
+func TestUnparsableRemoteAddressIsDenied(t *testing.T) {
+	SetAccess(&config.AdminAccess{DefaultCapability: "admin-write"})
+	defer SetAccess(&config.AdminAccess{})
+	if got := statusFor(http.MethodGet, "not-an-address", false); got != http.StatusForbidden {
+		t.Errorf("GET from an unparsable address answered %d, want 403", got)
+	}
+}
+
+func TestCrossOriginWriteIsRefused(t *testing.T) {
+	SetAccess(&config.AdminAccess{DefaultCapability: "admin-write"})
+	defer SetAccess(&config.AdminAccess{})
+	handler := requireCapability(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	cases := []struct {
+		method  string
+		headers map[string]string
+		want    int
+	}{
+		// curl and scripts send neither header
+		{http.MethodPost, nil, http.StatusOK},
+		// the web UI itself
+		{http.MethodPost, map[string]string{"Origin": "http://labns.lab.home:8053", "Sec-Fetch-Site": "same-origin"}, http.StatusOK},
+		{http.MethodDelete, map[string]string{"Origin": "http://labns.lab.home:8053"}, http.StatusOK},
+		{http.MethodPost, map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{http.MethodDelete, map[string]string{"Origin": "http://labns.lab.home:9000"}, http.StatusForbidden},
+		{http.MethodPost, map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{http.MethodPost, map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-site"}, http.StatusForbidden},
+		// reads are not state changing and stay available to other origins the client rules allow
+		{http.MethodGet, map[string]string{"Origin": "https://evil.example", "Sec-Fetch-Site": "cross-site"}, http.StatusOK},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, "http://labns.lab.home:8053/reload", nil)
+		r.RemoteAddr = "10.0.10.1:40000"
+		for k, v := range c.headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != c.want {
+			t.Errorf("%s with %v answered %d, want %d", c.method, c.headers, w.Code, c.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/TasSM/labns/internal/logging"
+	"github.com/TasSM/labns/internal/stats"
+)
+
+const defaultTopN = 10
+
+var mux = http.NewServeMux()
+
+func init() {
+	mux.HandleFunc("/stats", statsHandler)
+	mux.HandleFunc("/top", topHandler)
+}
+
+/*
+*	Registers an additional handler on the admin listener, must be called before Serve
+ */
+func Handle(pattern string, handler http.HandlerFunc) {
+	mux.HandleFunc(pattern, handler)
+}
+
+func Serve(addr string) {
+	logging.LogMessage(logging.LogInfo, "Starting admin listener on "+addr)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		logging.LogMessage(logging.LogError, "Admin listener stopped: "+err.Error())
+		return
+	}
+	if err := http.Serve(sniffListener{ln}, requireCapability(mux)); err != nil {
+		logging.LogMessage(logging.LogError, "Admin listener stopped: "+err.Error())
+	}
+}
+
+/*
+*	Serves the admin endpoints on a unix socket at path as well, replacing a socket left behind by an earlier run.
+*	Access is controlled by the socket file's permissions, so AdminAccess rules don't apply to it
+ */
+func ServeSocket(path string) {
+	logging.LogMessage(logging.LogInfo, "Starting admin listener on unix socket "+path)
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		logging.LogMessage(logging.LogError, "Admin socket stopped: "+err.Error())
+		return
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		logging.LogMessage(logging.LogError, "Unable to restrict admin socket permissions: "+err.Error())
+	}
+	server := &http.Server{Handler: requireCapability(mux), ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+		return context.WithValue(ctx, socketConn{}, true)
+	}}
+	if err := server.Serve(ln); err != nil {
+		logging.LogMessage(logging.LogError, "Admin socket stopped: "+err.Error())
+	}
+}
+
+func WriteJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		logging.LogMessage(logging.LogError, "Failed to write admin response: "+err.Error())
+	}
+}
+
+func topN(r *http.Request) int {
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n <= 0 {
+		return defaultTopN
+	}
+	return n
+}
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, map[string]interface{}{
+		"Counters":   stats.Snapshot(),
+		"Histograms": stats.Histograms(),
+	})
+}
+
+func topHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	n := topN(r)
+	WriteJSON(w, map[string][]stats.TopEntry{
+		"Clients": stats.TopClients.Top(n, now),
+		"Domains": stats.TopDomains.Top(n, now),
+	})
+}
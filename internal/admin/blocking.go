@@ -0,0 +1,40 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TasSM/labns/internal/service"
+)
+
+func init() {
+	mux.HandleFunc("/blocking", blockingHandler)
+}
+
+/*
+*	GET lists the running blocking pauses, POST ?minutes=15 pauses all blocking for that long and ?minutes=0 resumes
+*	it, with &list=name either applies to the named blocklist alone
+ */
+func blockingHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		list := r.URL.Query().Get("list")
+		minutes, err := strconv.ParseUint(r.URL.Query().Get("minutes"), 10, 32)
+		if err != nil {
+			http.Error(w, "minutes must be given as a whole number, 0 resumes blocking", http.StatusBadRequest)
+			return
+		}
+		if minutes == 0 {
+			service.ResumeBlocking(list)
+		} else if _, err := service.PauseBlocking(list, time.Duration(minutes)*time.Minute); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	WriteJSON(w, map[string]interface{}{"Paused": service.BlockingPauses()})
+}
@@ -0,0 +1,51 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TasSM/labns/internal/service"
+)
+
+func blockingRequest(t *testing.T, method string, query string) (int, []service.BlockingPause) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	blockingHandler(w, httptest.NewRequest(method, "/blocking"+query, nil))
+	var body struct{ Paused []service.BlockingPause }
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s /blocking%s returned %q: %v", method, query, w.Body.String(), err)
+		}
+	}
+	return w.Code, body.Paused
+}
+
+func TestBlockingPauseEndpoint(t *testing.T) {
+	defer service.ResumeBlocking("")
+	for _, query := range []string{"", "?minutes=soon", "?minutes=-5", "?minutes=1441", "?minutes=10&list=not-configured"} {
+		if code, _ := blockingRequest(t, http.MethodPost, query); code != http.StatusBadRequest {
+			t.Errorf("POST /blocking%s returned %d, want 400", query, code)
+		}
+	}
+	if code, _ := blockingRequest(t, http.MethodDelete, ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE /blocking returned %d, want 405", code)
+	}
+
+	start := time.Now()
+	code, paused := blockingRequest(t, http.MethodPost, "?minutes=15")
+	if code != http.StatusOK || len(paused) != 1 || paused[0].List != "" {
+		t.Fatalf("pausing all blocking returned %d with %+v, want the pause listed", code, paused)
+	}
+	if until := paused[0].Until; until.Before(start.Add(15*time.Minute)) || until.After(time.Now().Add(15*time.Minute)) {
+		t.Fatalf("pause of 15 minutes runs until %s", until)
+	}
+	if _, paused := blockingRequest(t, http.MethodGet, ""); len(paused) != 1 {
+		t.Fatalf("GET /blocking lists %+v, want the running pause", paused)
+	}
+	if code, paused := blockingRequest(t, http.MethodPost, "?minutes=0"); code != http.StatusOK || len(paused) != 0 {
+		t.Fatalf("resuming returned %d with %+v, want no pauses left", code, paused)
+	}
+}
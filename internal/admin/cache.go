@@ -0,0 +1,23 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/TasSM/labns/internal/service"
+)
+
+func init() {
+	mux.HandleFunc("/cache", cacheHandler)
+}
+
+/*
+*	DELETE drops every cached answer, the flush is queued behind the queries already waiting for the state worker
+ */
+func cacheHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	service.FlushCache()
+	WriteJSON(w, map[string]bool{"Flushed": true})
+}
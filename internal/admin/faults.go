@@ -0,0 +1,39 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/TasSM/labns/internal/service"
+)
+
+func init() {
+	mux.HandleFunc("/faults", faultsHandler)
+}
+
+/*
+*	GET reports whether fault injection is on, POST ?enabled=true or ?enabled=false toggles the configured rules
+ */
+func faultsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var enabled bool
+		switch r.URL.Query().Get("enabled") {
+		case "true":
+			enabled = true
+		case "false":
+		default:
+			http.Error(w, "enabled must be given explicitly as true or false", http.StatusBadRequest)
+			return
+		}
+		if !service.SetFaultInjectionEnabled(enabled) {
+			http.Error(w, "no FaultInjection rules are configured", http.StatusConflict)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	enabled, rules := service.FaultInjectionEnabled()
+	WriteJSON(w, map[string]interface{}{"Enabled": enabled, "Rules": rules})
+}
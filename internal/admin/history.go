@@ -0,0 +1,56 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TasSM/labns/internal/history"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+func init() {
+	mux.HandleFunc("/history", historyHandler)
+}
+
+/*
+*	GET /history?client=10.0.0.23&name=*.doubleclick.net&type=A&rcode=NXDOMAIN&since=<RFC 3339>&until=...&offset=0&limit=100
+ */
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	if !history.Enabled() {
+		http.Error(w, "query history is not enabled", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	f := history.Filter{Client: q.Get("client"), Name: q.Get("name"), Type: q.Get("type"), RCode: q.Get("rcode"), Limit: defaultHistoryLimit}
+	var err error
+	for param, dest := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if v := q.Get(param); v != "" {
+			if *dest, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, param+" should be an RFC 3339 time such as 2021-04-05T03:14:00Z", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if f.Offset, err = strconv.Atoi(v); err != nil || f.Offset < 0 {
+			http.Error(w, "offset should be 0 or more", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit <= 0 || f.Limit > maxHistoryLimit {
+			http.Error(w, "limit should be between 1 and "+strconv.Itoa(maxHistoryLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	entries, total := history.Search(f)
+	if entries == nil {
+		entries = []history.Entry{}
+	}
+	WriteJSON(w, map[string]interface{}{"Total": total, "Offset": f.Offset, "Entries": entries})
+}
@@ -0,0 +1,19 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/TasSM/labns/internal/buildinfo"
+	"github.com/TasSM/labns/internal/service"
+)
+
+func init() {
+	mux.HandleFunc("/info", infoHandler)
+}
+
+/*
+*	GET reports the build, the configuration file in use, record counts by type, upstreams and active features
+ */
+func infoHandler(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, buildinfo.Describe(service.RunningConfig()))
+}
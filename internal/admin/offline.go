@@ -0,0 +1,35 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/TasSM/labns/internal/service"
+)
+
+func init() {
+	mux.HandleFunc("/offline", offlineHandler)
+}
+
+/*
+*	GET reports whether labns is offline and why, POST ?enabled=true or ?enabled=false turns offline mode on or off
+ */
+func offlineHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		switch r.URL.Query().Get("enabled") {
+		case "true":
+			service.SetOfflineEnabled(true)
+		case "false":
+			service.SetOfflineEnabled(false)
+		default:
+			http.Error(w, "enabled must be given explicitly as true or false", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	reason := service.OfflineMode()
+	WriteJSON(w, map[string]interface{}{"Offline": reason != "", "Reason": reason})
+}
@@ -0,0 +1,75 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnsname"
+	"github.com/TasSM/labns/internal/service"
+)
+
+/*
+*	A served record plus the Unicode forms of its name and target when they contain A-labels, and with
+*	RecordAudit.TagStale whether its target has failed enough audits to be possibly stale
+ */
+type recordView struct {
+	config.LocalDNSRecord
+	UnicodeName       string `json:",omitempty"`
+	UnicodeTarget     string `json:",omitempty"`
+	PossiblyStale     bool   `json:",omitempty"`
+	UnreachableAudits uint32 `json:",omitempty"`
+}
+
+func init() {
+	mux.HandleFunc("/records", recordsHandler)
+}
+
+/*
+*	GET lists the served local records, ?tag=k8s keeps records carrying that tag and ?name=x. records of that name
+ */
+func recordsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tag := r.URL.Query().Get("tag")
+	name := r.URL.Query().Get("name")
+	canonical, err := dnsname.Canonical(name)
+	if name != "" && err != nil {
+		http.Error(w, "name is invalid: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	out := make([]recordView, 0)
+	for _, rec := range service.LocalRecords() {
+		if name != "" && rec.Name != canonical {
+			continue
+		}
+		if tag != "" && !hasTag(rec.Tags, tag) {
+			continue
+		}
+		view := recordView{LocalDNSRecord: rec}
+		if unicode := dnsname.Display(rec.Name); unicode != rec.Name {
+			view.UnicodeName = unicode
+		}
+		if unicode := dnsname.Display(rec.Target); unicode != rec.Target {
+			view.UnicodeTarget = unicode
+		}
+		if rec.Type == "A" || rec.Type == "AAAA" {
+			if failures, stale := service.RecordAuditStatus(rec.Target); stale {
+				view.PossiblyStale, view.UnreachableAudits = true, failures
+			}
+		}
+		out = append(out, view)
+	}
+	WriteJSON(w, map[string][]recordView{"Records": out})
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package admin
+
+import (
+	"io"
+	"net"
+
+	"github.com/TasSM/labns/internal/logging"
+)
+
+const dnsOnHTTPResponse = "HTTP/1.1 400 Bad Request\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\n" +
+	"This is the labns admin HTTP listener, the request looks like a DNS message. Send DNS queries to the DNS port instead.\n"
+
+/*
+*	Answers connections whose first bytes look like a DNS over TCP message with a plain 400 instead of the
+*	generic error net/http gives for an unparseable request line
+ */
+type sniffListener struct {
+	net.Listener
+}
+
+type sniffConn struct {
+	net.Conn
+	sniffed bool
+}
+
+func (l sniffListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &sniffConn{Conn: c}, nil
+}
+
+func (c *sniffConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if !c.sniffed && n > 0 {
+		c.sniffed = true
+		if looksLikeDNS(p[:n]) {
+			logging.LogMessage(logging.LogDebug, "DNS message received on admin listener from "+c.RemoteAddr().String())
+			c.Conn.Write([]byte(dnsOnHTTPResponse))
+			c.Conn.Close()
+			return 0, io.EOF
+		}
+	}
+	return n, err
+}
+
+/*
+*	A DNS over TCP message starts with a two byte length and a header with one question, an HTTP request line
+*	starts with a printable method name
+ */
+func looksLikeDNS(data []byte) bool {
+	if len(data) < 14 || data[0] >= 0x20 {
+		return false
+	}
+	length := int(data[0])<<8 | int(data[1])
+	return length >= 12 && data[6] == 0 && data[7] == 1
+}
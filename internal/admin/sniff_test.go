@@ -0,0 +1,85 @@
+package admin
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+*	A DNS over TCP query for nas.lab.home. A, with its two byte length prefix
+ */
+var tcpQuery = []byte{
+	0, 30, 0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0,
+	3, 'n', 'a', 's', 3, 'l', 'a', 'b', 4, 'h', 'o', 'm', 'e', 0, 0, 1, 0, 1,
+}
+
+func TestLooksLikeDNS(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"tcp query", tcpQuery, true},
+		{"http request", []byte("GET /status HTTP/1.1\r\nHost: labns\r\n\r\n"), false},
+		{"short", tcpQuery[:13], false},
+		{"no question", append([]byte{0, 12, 0x12, 0x34, 0x01, 0x00, 0, 0}, make([]byte, 6)...), false},
+		{"length below a header", append([]byte{0, 4}, tcpQuery[2:]...), false},
+	}
+	for _, c := range cases {
+		if got := looksLikeDNS(c.data); got != c.want {
+			t.Errorf("%s: looksLikeDNS = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+/*
+*	Serves mux behind the sniffing listener on a loopback port, closed when the test ends
+ */
+func sniffServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "ok") })
+	go http.Serve(sniffListener{ln}, handler)
+	return ln.Addr().String()
+}
+
+func TestDNSOnAdminListenerGetsHint(t *testing.T) {
+	conn, err := net.Dial("tcp", sniffServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write(tcpQuery); err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("DNS message on the admin listener got no HTTP response: %v", err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), "DNS port") {
+		t.Fatalf("DNS message on the admin listener answered %d %q, want a 400 pointing at the DNS port", res.StatusCode, body)
+	}
+}
+
+func TestHTTPPassesSniffListener(t *testing.T) {
+	res, err := http.Get("http://" + sniffServer(t) + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Fatalf("HTTP request through the sniffing listener answered %d %q, want the handler's 200", res.StatusCode, body)
+	}
+}
@@ -0,0 +1,67 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/TasSM/labns/internal/buildinfo"
+	"github.com/TasSM/labns/internal/service"
+	"github.com/TasSM/labns/internal/stats"
+)
+
+// the window the answer sources and cache hit rate are reported over
+const statusWindow = 5 * time.Minute
+
+func init() {
+	mux.HandleFunc("/status", statusHandler)
+}
+
+/*
+*	The compact live view shown by labns status. QPS is measured over the last 1, 5 and 15 minutes, or over the
+*	time sampled so far shortly after startup, and CacheHitRate is omitted while no cacheable query was seen
+ */
+type Status struct {
+	Time         time.Time
+	Uptime       string
+	QPS          map[string]float64
+	Answers      map[string]uint64
+	CacheHitRate *float64 `json:",omitempty"`
+	Offline      string   `json:",omitempty"`
+	Upstreams    []service.UpstreamStatus
+	Servfails    []stats.ServfailEntry
+}
+
+func buildStatus(now time.Time) Status {
+	s := Status{Time: now, Uptime: now.Sub(buildinfo.Started).Truncate(time.Second).String(), QPS: make(map[string]float64),
+		Answers: make(map[string]uint64), Offline: service.OfflineMode(), Upstreams: service.UpstreamHealth(), Servfails: stats.RecentServfails()}
+	for _, w := range []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute} {
+		delta, elapsed := stats.Since(w, now)
+		var queries uint64
+		for c, v := range delta {
+			if strings.HasPrefix(string(c), "qtype_") {
+				queries += v
+			}
+		}
+		rate := 0.0
+		if elapsed > 0 {
+			rate = float64(queries) / elapsed.Seconds()
+		}
+		s.QPS[strings.TrimSuffix(w.String(), "0s")] = rate
+	}
+	delta, _ := stats.Since(statusWindow, now)
+	for c, v := range delta {
+		if source := strings.TrimPrefix(string(c), "answers_"); source != string(c) && v > 0 {
+			s.Answers[source] = v
+		}
+	}
+	if lookups := delta[stats.CacheHit] + delta[stats.CacheMiss]; lookups > 0 {
+		rate := float64(delta[stats.CacheHit]) / float64(lookups)
+		s.CacheHitRate = &rate
+	}
+	return s
+}
+
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, buildStatus(time.Now()))
+}
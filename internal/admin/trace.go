@@ -0,0 +1,47 @@
+package admin
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/service"
+)
+
+func init() {
+	mux.HandleFunc("/trace", traceHandler)
+}
+
+/*
+*	GET lists the traced suffixes, POST ?domain=x. adds one and DELETE ?domain=x. removes it
+ */
+func traceHandler(w http.ResponseWriter, r *http.Request) {
+	domains := service.TraceDomains()
+	domain := strings.ToLower(r.URL.Query().Get("domain"))
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodDelete:
+		if ok, _ := regexp.MatchString(config.VALID_FQDN_REGEX, domain); !ok || domain == "" {
+			http.Error(w, "domain should follow pattern domain.name.", http.StatusBadRequest)
+			return
+		}
+		kept := make([]string, 0, len(domains)+1)
+		for _, d := range domains {
+			if d != domain {
+				kept = append(kept, d)
+			}
+		}
+		if r.Method == http.MethodPost {
+			kept = append(kept, domain)
+		}
+		service.SetTraceDomains(kept)
+		domains = kept
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sort.Strings(domains)
+	WriteJSON(w, map[string][]string{"TraceDomains": domains})
+}
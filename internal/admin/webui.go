@@ -0,0 +1,30 @@
+//go:build !nowebui && !minimal
+// +build !nowebui,!minimal
+
+package admin
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed webui
+var webUI embed.FS
+
+func init() {
+	static, _ := fs.Sub(webUI, "webui")
+	mux.Handle("/ui/", http.StripPrefix("/ui/", http.FileServer(http.FS(static))))
+	mux.HandleFunc("/", rootHandler)
+}
+
+/*
+*	Sends browsers opening the admin listener to the web UI, any other unknown path is still 404
+ */
+func rootHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	http.Redirect(w, r, "/ui/", http.StatusFound)
+}
@@ -0,0 +1,133 @@
+"use strict";
+
+// the UI is served under /ui/, the API endpoints sit one level up
+const api = (path) => "../" + path;
+const refreshMs = 5000;
+let records = [];
+
+function cell(row, text, heading) {
+  const c = document.createElement(heading ? "th" : "td");
+  c.textContent = text === undefined || text === null ? "" : text;
+  row.appendChild(c);
+  return c;
+}
+
+function fill(id, headings, rows, empty) {
+  const table = document.getElementById(id);
+  table.replaceChildren();
+  if (headings) {
+    const head = table.insertRow();
+    headings.forEach((h) => cell(head, h, true));
+  }
+  if (rows.length === 0) {
+    cell(table.insertRow(), empty || "none").className = "empty";
+    return;
+  }
+  rows.forEach((values) => {
+    const row = table.insertRow();
+    values.forEach((v) => {
+      if (v instanceof Node) {
+        row.insertCell().appendChild(v);
+      } else {
+        cell(row, v);
+      }
+    });
+  });
+}
+
+function ago(time, now) {
+  const seconds = Math.round((new Date(now) - new Date(time)) / 1000);
+  return seconds < 60 ? seconds + "s ago" : Math.round(seconds / 60) + "m ago";
+}
+
+function show(text, error) {
+  const message = document.getElementById("message");
+  message.textContent = text;
+  message.className = error ? "error" : "";
+  message.hidden = false;
+}
+
+async function request(path, options) {
+  const res = await fetch(api(path), options);
+  if (!res.ok) {
+    const err = new Error((await res.text()).trim() || res.statusText);
+    err.status = res.status;
+    throw err;
+  }
+  return res.json();
+}
+
+async function loadStatus() {
+  const s = await request("status");
+  document.getElementById("uptime").textContent = "up " + s.Uptime;
+  const rows = [
+    ["Queries/s (1m, 5m, 15m)", ["1m", "5m", "15m"].map((w) => s.QPS[w].toFixed(1)).join(", ")],
+    ["Cache hit rate (5m)", s.CacheHitRate === undefined ? "no cacheable queries" : (s.CacheHitRate * 100).toFixed(1) + "%"],
+  ];
+  if (s.Offline) {
+    rows.push(["Offline", s.Offline]);
+  }
+  fill("status", null, rows);
+  const sources = Object.keys(s.Answers).sort((a, b) => s.Answers[b] - s.Answers[a]);
+  fill("answers", null, sources.map((source) => [source, s.Answers[source]]));
+  fill("upstreams", null, s.Upstreams.map((u) => {
+    const state = document.createElement("span");
+    state.textContent = u.Healthy ? "healthy" : "down, " + u.Failures + " failures";
+    state.className = u.Healthy ? "" : "down";
+    return [u.Address, state, u.LastAnswer ? "last answer " + ago(u.LastAnswer, s.Time) : "never answered"];
+  }));
+  fill("servfails", null, s.Servfails.map((f) => [new Date(f.Time).toLocaleTimeString(), f.Name, f.Source]));
+}
+
+async function loadHistory() {
+  try {
+    const h = await request("history?limit=50");
+    fill("history", ["Time", "Client", "Name", "Type", "Answer", "Source"],
+      h.Entries.map((e) => [new Date(e.Time).toLocaleTimeString(), e.Client, e.Name, e.Type, e.RCode, e.Source + (e.TTLPinned ? " (TTL pinned)" : "")]), "no queries yet");
+  } catch (err) {
+    fill("history", null, [], err.status === 404 ? "Query history is not enabled, set QueryHistory.Enabled to see recent queries" : err.message);
+  }
+}
+
+function showRecords() {
+  const filter = document.getElementById("filter").value.toLowerCase();
+  const matches = records.filter((r) => !filter || [r.Name, r.UnicodeName, r.Target, r.Comment].concat(r.Tags || [])
+    .some((v) => v && v.toLowerCase().includes(filter)));
+  fill("records", ["Name", "Type", "Target", "TTL", "Tags", "Comment"], matches.map((r) => [
+    r.UnicodeName || r.Name, r.Type, (r.UnicodeTarget || r.Target) + (r.PossiblyStale ? " (possibly stale)" : ""),
+    r.TTL, (r.Tags || []).join(", "), r.Comment]), "no local records");
+}
+
+async function loadRecords() {
+  records = (await request("records")).Records;
+  showRecords();
+}
+
+async function refresh() {
+  try {
+    await Promise.all([loadStatus(), loadHistory()]);
+  } catch (err) {
+    show("Unable to reach labns: " + err.message, true);
+  }
+}
+
+async function action(button, path, method, done) {
+  button.disabled = true;
+  try {
+    await request(path, { method: method });
+    show(done);
+    setTimeout(() => { refresh(); loadRecords().catch(() => {}); }, 500);
+  } catch (err) {
+    show(err.message, true);
+  } finally {
+    button.disabled = false;
+  }
+}
+
+document.getElementById("flush").addEventListener("click", (e) => action(e.target, "cache", "DELETE", "Cache flushed"));
+document.getElementById("reload").addEventListener("click", (e) => action(e.target, "reload", "POST", "Configuration reloaded, check the log if the changes don't show up"));
+document.getElementById("filter").addEventListener("input", showRecords);
+
+refresh();
+loadRecords().catch((err) => show("Unable to load local records: " + err.message, true));
+setInterval(refresh, refreshMs);
@@ -0,0 +1,10 @@
+//go:build nowebui || minimal
+// +build nowebui minimal
+
+package admin
+
+import "github.com/TasSM/labns/internal/feature"
+
+func init() {
+	feature.Omit("webui")
+}
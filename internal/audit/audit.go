@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+)
+
+type Action string
+
+const (
+	ActionAdd    Action = "add"
+	ActionUpdate Action = "update"
+	ActionRemove Action = "remove"
+	ActionNone   Action = "no-changes"
+
+	SourceReload = "config-reload"
+)
+
+type Change struct {
+	Time   time.Time
+	Source string
+	Action Action
+	Name   string `json:",omitempty"`
+	Type   string `json:",omitempty"`
+	Before string `json:",omitempty"`
+	After  string `json:",omitempty"`
+}
+
+var (
+	lock sync.Mutex
+	file *os.File
+)
+
+/*
+*	Opens the dedicated audit file, entries are always logged and additionally appended as JSON lines when set
+ */
+func Configure(path string) error {
+	lock.Lock()
+	defer lock.Unlock()
+	if file != nil {
+		file.Close()
+		file = nil
+	}
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	file = f
+	return nil
+}
+
+func Record(changes []Change, source string) {
+	if len(changes) == 0 {
+		changes = []Change{{Time: time.Now(), Source: source, Action: ActionNone}}
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	for _, c := range changes {
+		if c.Action == ActionNone {
+			logging.LogMessage(logging.LogInfo, fmt.Sprintf("audit source=%s action=%s", c.Source, c.Action))
+		} else {
+			logging.LogMessage(logging.LogInfo, fmt.Sprintf("audit source=%s action=%s name=%s type=%s before=%q after=%q", c.Source, c.Action, c.Name, c.Type, c.Before, c.After))
+		}
+		if file != nil {
+			line, _ := json.Marshal(c)
+			if _, err := file.Write(append(line, '\n')); err != nil {
+				logging.LogMessage(logging.LogError, "Failed to write audit file: "+err.Error())
+			}
+		}
+	}
+}
+
+/*
+*	Compares two record sets by name and type and returns one change per added, removed or modified record
+ */
+func DiffRecords(before []config.LocalDNSRecord, after []config.LocalDNSRecord, source string) []Change {
+	old := recordValues(before)
+	cur := recordValues(after)
+	now := time.Now()
+	var changes []Change
+	for k, v := range cur {
+		prev, ok := old[k]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Time: now, Source: source, Action: ActionAdd, Name: k[0], Type: k[1], After: v})
+		case prev != v:
+			changes = append(changes, Change{Time: now, Source: source, Action: ActionUpdate, Name: k[0], Type: k[1], Before: prev, After: v})
+		}
+	}
+	for k, v := range old {
+		if _, ok := cur[k]; !ok {
+			changes = append(changes, Change{Time: now, Source: source, Action: ActionRemove, Name: k[0], Type: k[1], Before: v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Name != changes[j].Name {
+			return changes[i].Name < changes[j].Name
+		}
+		return changes[i].Type < changes[j].Type
+	})
+	return changes
+}
+
+func recordValues(records []config.LocalDNSRecord) map[[2]string]string {
+	out := make(map[[2]string]string, len(records))
+	for _, r := range records {
+		key := [2]string{strings.ToLower(r.Name), r.Type}
+		if prev, ok := out[key]; ok {
+			out[key] = prev + ", " + RecordValue(&r)
+			continue
+		}
+		out[key] = RecordValue(&r)
+	}
+	return out
+}
+
+/*
+*	Renders the answer data of a record, e.g. "300 10.0.0.2"
+ */
+func RecordValue(r *config.LocalDNSRecord) string {
+	value := fmt.Sprintf("%d %s", r.TTL, r.Target)
+	if r.Type == "RAW" {
+		value = fmt.Sprintf("%d TYPE%d %s", r.TTL, r.RRType, r.RData)
+	}
+	if r.Svc != nil {
+		svc, _ := json.Marshal(r.Svc)
+		value += " " + string(svc)
+	}
+	var params interface{}
+	switch {
+	case r.SSHFP != nil:
+		params = r.SSHFP
+	case r.TLSA != nil:
+		params = r.TLSA
+	case r.HINFO != nil:
+		params = r.HINFO
+	}
+	if params != nil {
+		data, _ := json.Marshal(params)
+		value += " " + string(data)
+	}
+	return value
+}
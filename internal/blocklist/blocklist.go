@@ -0,0 +1,98 @@
+package blocklist
+
+import "github.com/TasSM/labns/internal/dnsname"
+
+const MaxSources = 64
+
+/*
+*	Maps names to a bitmask of the lists they were loaded from so callers can apply per-list behaviour,
+*	a source outside 0-63 only records presence
+ */
+type Matcher struct {
+	exact  map[string]uint64
+	suffix map[string]uint64
+}
+
+type Set struct {
+	Block *Matcher
+	Allow *Matcher
+}
+
+func NewMatcher() *Matcher {
+	return &Matcher{exact: make(map[string]uint64), suffix: make(map[string]uint64)}
+}
+
+func NewSet() *Set {
+	return &Set{Block: NewMatcher(), Allow: NewMatcher()}
+}
+
+func sourceBit(source int) uint64 {
+	if source < 0 || source >= MaxSources {
+		return 0
+	}
+	return 1 << uint(source)
+}
+
+func (m *Matcher) Add(name string, subdomains bool, source int) {
+	name = dnsname.Key(name)
+	target := m.exact
+	if subdomains {
+		target = m.suffix
+	}
+	target[name] |= sourceBit(source)
+}
+
+func (m *Matcher) Len() int {
+	return len(m.exact) + len(m.suffix)
+}
+
+/*
+*	Name must be a lowercase FQDN, the returned mask combines every exact and parent suffix rule that matched
+ */
+func (m *Matcher) Match(name string) (uint64, bool) {
+	mask, found := m.exact[name]
+	if len(m.suffix) == 0 {
+		return mask, found
+	}
+	if s, ok := m.suffix[name]; ok {
+		mask, found = mask|s, true
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] == '.' && i+1 < len(name) {
+			if s, ok := m.suffix[name[i+1:]]; ok {
+				mask, found = mask|s, true
+			}
+		}
+	}
+	return mask, found
+}
+
+func (s *Set) AddRules(rules []Rule, source int) {
+	for _, r := range rules {
+		if r.Exception {
+			s.Allow.Add(r.Name, r.Subdomain, source)
+			continue
+		}
+		s.Block.Add(r.Name, r.Subdomain, source)
+	}
+}
+
+/*
+*	Returns the mask of blocklists that block name, bit n set meaning list n
+ */
+func (s *Set) Lookup(name string) (uint64, bool) {
+	name = dnsname.Key(name)
+	mask, ok := s.Block.Match(name)
+	if !ok {
+		return 0, false
+	}
+	if _, allowed := s.Allow.Match(name); allowed {
+		return 0, false
+	}
+	return mask, true
+}
+
+func (s *Set) IsBlocked(name string) bool {
+	_, ok := s.Lookup(name)
+	return ok
+}
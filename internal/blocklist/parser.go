@@ -0,0 +1,166 @@
+package blocklist
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/TasSM/labns/internal/dnsname"
+)
+
+type Format string
+
+const (
+	FormatAuto    Format = "auto"
+	FormatDomains Format = "domains"
+	FormatHosts   Format = "hosts"
+	FormatAdGuard Format = "adguard"
+)
+
+type Rule struct {
+	Name      string
+	Subdomain bool
+	Exception bool
+}
+
+type ParseResult struct {
+	Rules    []Rule
+	Accepted int
+	Skipped  int
+}
+
+var hostsIgnoredNames = map[string]bool{
+	"localhost.": true, "localhost.localdomain.": true, "local.": true, "broadcasthost.": true,
+	"ip6-localhost.": true, "ip6-loopback.": true, "ip6-localnet.": true, "ip6-mcastprefix.": true,
+	"ip6-allnodes.": true, "ip6-allrouters.": true, "ip6-allhosts.": true, "0.0.0.0.": true,
+}
+
+/*
+*	Parses plain domain, hosts and AdGuard/ABP style lists, comments and blank lines are neither accepted nor skipped
+ */
+func Parse(r io.Reader, format Format) (*ParseResult, error) {
+	res := &ParseResult{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") || strings.HasPrefix(line, "[") {
+			continue
+		}
+		lineFormat := format
+		if format == FormatAuto || format == "" {
+			lineFormat = detectFormat(line)
+		}
+		var rules []Rule
+		var ok bool
+		switch lineFormat {
+		case FormatDomains:
+			rules, ok = parseDomainLine(line)
+		case FormatHosts:
+			rules, ok = parseHostsLine(line)
+		case FormatAdGuard:
+			rules, ok = parseAdGuardLine(line)
+		}
+		if !ok {
+			res.Skipped++
+			continue
+		}
+		res.Accepted++
+		res.Rules = append(res.Rules, rules...)
+	}
+	return res, scanner.Err()
+}
+
+func detectFormat(line string) Format {
+	if strings.HasPrefix(line, "||") || strings.HasPrefix(line, "@@") || strings.ContainsAny(line, "^$|") || strings.Contains(line, "##") {
+		return FormatAdGuard
+	}
+	fields := strings.Fields(line)
+	if len(fields) > 1 && net.ParseIP(fields[0]) != nil {
+		return FormatHosts
+	}
+	return FormatDomains
+}
+
+func parseDomainLine(line string) ([]Rule, bool) {
+	if idx := strings.Index(line, "#"); idx >= 0 {
+		line = strings.TrimSpace(line[:idx])
+	}
+	name, ok := normaliseDomain(line)
+	if !ok {
+		return nil, false
+	}
+	return []Rule{{Name: name}}, true
+}
+
+func parseHostsLine(line string) ([]Rule, bool) {
+	if idx := strings.Index(line, "#"); idx >= 0 {
+		line = line[:idx]
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+		return nil, false
+	}
+	var rules []Rule
+	for _, f := range fields[1:] {
+		name, ok := normaliseDomain(f)
+		if !ok {
+			return nil, false
+		}
+		if hostsIgnoredNames[name] {
+			continue
+		}
+		rules = append(rules, Rule{Name: name})
+	}
+	return rules, len(rules) > 0
+}
+
+/*
+*	Only network-level domain rules are usable, cosmetic rules, paths, wildcards and modifiers other than
+*	$important are skipped
+ */
+func parseAdGuardLine(line string) ([]Rule, bool) {
+	if strings.Contains(line, "##") || strings.Contains(line, "#@#") || strings.Contains(line, "#$#") || strings.Contains(line, "#?#") {
+		return nil, false
+	}
+	rule := Rule{}
+	if strings.HasPrefix(line, "@@") {
+		rule.Exception = true
+		line = line[2:]
+	}
+	if idx := strings.Index(line, "$"); idx >= 0 {
+		if line[idx+1:] != "important" {
+			return nil, false
+		}
+		line = line[:idx]
+	}
+	if strings.HasPrefix(line, "||") {
+		if !strings.HasSuffix(line, "^") {
+			return nil, false
+		}
+		rule.Subdomain = true
+		line = line[2 : len(line)-1]
+	} else if strings.HasPrefix(line, "|") || strings.HasSuffix(line, "^") {
+		line = strings.TrimSuffix(strings.TrimPrefix(line, "|"), "^")
+	}
+	name, ok := normaliseDomain(line)
+	if !ok {
+		return nil, false
+	}
+	rule.Name = name
+	return []Rule{rule}, true
+}
+
+func normaliseDomain(in string) (string, bool) {
+	name, err := dnsname.Canonical(in)
+	if err != nil || name == "." {
+		return "", false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return "", false
+		}
+	}
+	return name, true
+}
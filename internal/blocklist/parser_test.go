@@ -0,0 +1,110 @@
+package blocklist
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFormats(t *testing.T) {
+	cases := []struct {
+		name     string
+		format   Format
+		input    string
+		rules    []Rule
+		accepted int
+		skipped  int
+	}{
+		{
+			name:     "domains",
+			format:   FormatDomains,
+			input:    "ads.example.com\nTracker.Example.NET. # trailing comment\n\nnot a domain\n",
+			rules:    []Rule{{Name: "ads.example.com."}, {Name: "tracker.example.net."}},
+			accepted: 2,
+			skipped:  1,
+		},
+		{
+			name:   "hosts",
+			format: FormatHosts,
+			input: "127.0.0.1 localhost\n::1 ip6-localhost ip6-loopback\n0.0.0.0 ads.example.com tracker.example.com # two names\n" +
+				"0.0.0.0 0.0.0.0\nads.example.org\n",
+			rules:    []Rule{{Name: "ads.example.com."}, {Name: "tracker.example.com."}},
+			accepted: 1,
+			// the lines naming only loopback names block nothing, like the one without an address
+			skipped: 4,
+		},
+		{
+			name:   "adguard",
+			format: FormatAdGuard,
+			input: "||ads.example.com^\n@@||good.ads.example.com^\n|exact.example.com^\n||important.example.com^$important\n" +
+				"||third-party.example.com^$third-party\nexample.com##.banner\nexample.com#@#.banner\n||example.com/path^\n||*.example.com^\n||nocaret.example.com\n",
+			rules: []Rule{
+				{Name: "ads.example.com.", Subdomain: true},
+				{Name: "good.ads.example.com.", Subdomain: true, Exception: true},
+				{Name: "exact.example.com."},
+				{Name: "important.example.com.", Subdomain: true},
+			},
+			accepted: 4,
+			skipped:  6,
+		},
+		{
+			name:   "comments",
+			format: FormatAuto,
+			input:  "# hosts comment\n! adblock comment\n[Adblock Plus 2.0]\n   \n",
+		},
+		{
+			name:   "auto",
+			format: FormatAuto,
+			input:  "plain.example.com\n0.0.0.0 hosts.example.com\n||adguard.example.com^\n@@allowed.example.com^\n",
+			rules: []Rule{
+				{Name: "plain.example.com."},
+				{Name: "hosts.example.com."},
+				{Name: "adguard.example.com.", Subdomain: true},
+				{Name: "allowed.example.com.", Exception: true},
+			},
+			accepted: 4,
+		},
+	}
+	for _, c := range cases {
+		res, err := Parse(strings.NewReader(c.input), c.format)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(res.Rules, c.rules) {
+			t.Errorf("%s: rules are %+v, want %+v", c.name, res.Rules, c.rules)
+		}
+		if res.Accepted != c.accepted || res.Skipped != c.skipped {
+			t.Errorf("%s: %d accepted and %d skipped, want %d and %d", c.name, res.Accepted, res.Skipped, c.accepted, c.skipped)
+		}
+	}
+}
+
+func TestExceptionsFeedTheAllowlist(t *testing.T) {
+	res, err := Parse(strings.NewReader("||ads.example.com^\n@@||good.ads.example.com^\n0.0.0.0 tracker.example.com\n"), FormatAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSet()
+	s.AddRules(res.Rules, 3)
+	if s.Allow.Len() != 1 || s.Block.Len() != 2 {
+		t.Fatalf("set holds %d block and %d allow rules, want 2 and 1", s.Block.Len(), s.Allow.Len())
+	}
+	cases := map[string]bool{
+		"ads.example.com.":          true,
+		"cdn.ads.example.com.":      true,
+		"good.ads.example.com.":     false,
+		"www.good.ads.example.com.": false,
+		"tracker.example.com.":      true,
+		"www.tracker.example.com.":  false,
+		"example.com.":              false,
+		"ADS.Example.COM":           true,
+	}
+	for name, want := range cases {
+		if got := s.IsBlocked(name); got != want {
+			t.Errorf("IsBlocked(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if mask, _ := s.Lookup("ads.example.com."); mask != 1<<3 {
+		t.Errorf("mask for a name from list 3 is %b", mask)
+	}
+}
@@ -0,0 +1,49 @@
+package blocklist
+
+import "time"
+
+const minutesPerWeek = 7 * 24 * 60
+
+type Window struct {
+	Days     []time.Weekday
+	StartMin int
+	EndMin   int
+}
+
+/*
+*	One bit per minute of the week so evaluating a schedule is a single lookup,
+*	windows ending before they start run past midnight into the following day
+ */
+type Schedule struct {
+	bits [minutesPerWeek/64 + 1]uint64
+}
+
+func NewSchedule(windows []Window) *Schedule {
+	s := &Schedule{}
+	for _, w := range windows {
+		days := w.Days
+		if len(days) == 0 {
+			days = []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}
+		}
+		length := w.EndMin - w.StartMin
+		if length <= 0 {
+			length += 24 * 60
+		}
+		for _, d := range days {
+			start := int(d)*24*60 + w.StartMin
+			for m := start; m < start+length; m++ {
+				s.set(m % minutesPerWeek)
+			}
+		}
+	}
+	return s
+}
+
+func (s *Schedule) set(minute int) {
+	s.bits[minute/64] |= 1 << uint(minute%64)
+}
+
+func (s *Schedule) Active(t time.Time) bool {
+	minute := int(t.Weekday())*24*60 + t.Hour()*60 + t.Minute()
+	return s.bits[minute/64]&(1<<uint(minute%64)) != 0
+}
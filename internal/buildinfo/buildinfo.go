@@ -0,0 +1,165 @@
+package buildinfo
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/feature"
+)
+
+/*
+*	Set at build time, e.g. go build -ldflags "-X github.com/TasSM/labns/internal/buildinfo.Version=1.2.0"
+ */
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+var (
+	// file the running configuration was loaded from, or "built-in defaults"
+	ConfigSource string
+	Started      = time.Now()
+)
+
+type Upstream struct {
+	Address  string
+	Protocol string
+	Role     string
+}
+
+type Info struct {
+	Version    string
+	Commit     string
+	BuildDate  string
+	GoVersion  string
+	ConfigPath string
+	Started    time.Time
+	Records    map[string]int
+	Upstreams  []Upstream
+	Features   []string
+	// the optional parts this binary was built without
+	Omitted []string `json:",omitempty"`
+}
+
+/*
+*	Summarises the build and the running configuration for -version, the startup banner and the admin API
+ */
+func Describe(conf *config.Configuration) Info {
+	info := Info{Version: Version, Commit: Commit, BuildDate: BuildDate, GoVersion: runtime.Version(),
+		ConfigPath: ConfigSource, Started: Started, Records: make(map[string]int), Omitted: feature.Omitted()}
+	if conf == nil {
+		return info
+	}
+	for _, r := range conf.LocalRecords {
+		info.Records[r.Type]++
+	}
+	info.Upstreams = append(info.Upstreams, upstream(&conf.UpstreamNameservers.Primary, "primary"))
+	if conf.UpstreamNameservers.HasSecondary() {
+		info.Upstreams = append(info.Upstreams, upstream(&conf.UpstreamNameservers.Secondary, "secondary"))
+	}
+	for _, rule := range conf.ForwardingRules {
+		for k := range rule.Nameservers {
+			info.Upstreams = append(info.Upstreams, upstream(&rule.Nameservers[k], "rule "+strings.Join(rule.Domains, ",")))
+		}
+	}
+	info.Features = features(conf)
+	return info
+}
+
+func upstream(ns *config.Nameserver, role string) Upstream {
+	ip := ns.IPv4
+	if ip == "" {
+		ip = ns.IPv6
+	}
+	protocol := ns.Protocol
+	if protocol == "" {
+		protocol = "udp"
+	}
+	return Upstream{Address: net.JoinHostPort(ip, fmt.Sprint(ns.Port)), Protocol: protocol, Role: role}
+}
+
+/*
+*	The optional features the configuration turns on, in a fixed order
+ */
+func features(conf *config.Configuration) []string {
+	enabled := []struct {
+		name string
+		on   bool
+	}{
+		{"cache", !conf.Cache.Disabled},
+		{"blocklists", len(conf.Blocklists) > 0},
+		{"forwarding-rules", len(conf.ForwardingRules) > 0},
+		{"local-zones", len(conf.LocalZones) > 0},
+		{"delegations", len(conf.Delegations) > 0},
+		{"generated-ranges", len(conf.GeneratedRanges) > 0},
+		{"pinned-names", len(conf.PinnedNames) > 0},
+		{"fast-path", !conf.Cache.Disabled && (!conf.FastPath.DisableDefaults || len(conf.FastPath.Domains) > 0)},
+		{"overrides-file", conf.OverridesFile != ""},
+		{"search-domain", conf.SearchDomain != "" || len(conf.ClientSearchDomains) > 0},
+		{"self-hostname", conf.SelfHostname != ""},
+		{"strip-ech", conf.StripECH},
+		{"ttl-decay", ttlDecay(conf)},
+		{"profiles", len(conf.Profiles) > 0},
+		{"query-history", conf.QueryHistory.Enabled},
+		{"audit-log", conf.AuditLogPath != ""},
+		{"admin", conf.AdminListen != "" || conf.AdminSocket != ""},
+		{"health-records", !conf.HealthRecords.Disabled},
+		{"alerting", conf.Alerting.WebhookURL != "" || len(conf.Alerting.Command) > 0},
+		{"fault-injection", conf.FaultInjection.Enabled},
+		{"record-audit", conf.RecordAudit.Enabled},
+		{"mirror", conf.MirrorTo.Address != ""},
+		{"drift-detection", conf.DriftDetection.SampleRate > 0},
+		{"offline", conf.Offline.Enabled || conf.Offline.AutoAfterSeconds > 0},
+		{"response-hook", len(conf.ResponseHook.Command) > 0},
+		{"answer-rewrites", len(conf.AnswerRewrites) > 0},
+		{"ttl-overrides", len(conf.TTLOverrides) > 0},
+	}
+	out := []string{}
+	for _, f := range enabled {
+		if f.on {
+			out = append(out, f.name)
+		}
+	}
+	return out
+}
+
+/*
+*	Renders info as one line of key=value pairs so log parsers can pick it apart
+ */
+func Banner(info Info) string {
+	types := make([]string, 0, len(info.Records))
+	for t, n := range info.Records {
+		types = append(types, fmt.Sprintf("%s:%d", t, n))
+	}
+	sort.Strings(types)
+	upstreams := make([]string, 0, len(info.Upstreams))
+	for _, u := range info.Upstreams {
+		upstreams = append(upstreams, u.Protocol+"://"+u.Address)
+	}
+	return fmt.Sprintf("labns starting version=%s commit=%s built=%s go=%s config=%q records=%s upstreams=%s features=%s",
+		info.Version, info.Commit, info.BuildDate, info.GoVersion, info.ConfigPath,
+		orNone(strings.Join(types, ",")), orNone(strings.Join(upstreams, ",")), orNone(strings.Join(info.Features, ",")))
+}
+
+// on when any local record decays, records can turn it on or off individually
+func ttlDecay(conf *config.Configuration) bool {
+	for _, r := range conf.LocalRecords {
+		if (r.TTLDecay == nil && conf.TTLDecay.Enabled) || (r.TTLDecay != nil && *r.TTLDecay) {
+			return true
+		}
+	}
+	return false
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
@@ -0,0 +1,135 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+*	The time source of the TTL, cache and upstream health logic. Real is used in production, Fake lets a test or a
+*	simulation move time forward by hours without waiting for it
+ */
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+/*
+*	A timer created by a Clock, C fires once when the timer expires unless it was stopped first
+ */
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type realClock struct{}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+// the wall clock
+var Real Clock = realClock{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{t: time.NewTimer(d)} }
+
+func (r realTimer) C() <-chan time.Time { return r.t.C }
+func (r realTimer) Stop() bool          { return r.t.Stop() }
+
+/*
+*	A clock that only moves when Advance is called. Timers and After channels fire, in the order they are due, once
+*	Advance reaches their time, and a duration of zero or less fires on creation like the real clock would
+ */
+type Fake struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *Fake
+	at    time.Time
+	c     chan time.Time
+	done  bool
+}
+
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+func (f *Fake) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	t := &fakeTimer{clock: f, at: f.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.done = true
+		t.c <- f.now
+		return t
+	}
+	f.waiters = append(f.waiters, t)
+	return t
+}
+
+/*
+*	Moves the clock forward by d and fires every timer due by then, each receiving the time it was due at
+ */
+func (f *Fake) Advance(d time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	end := f.now.Add(d)
+	sort.SliceStable(f.waiters, func(a, b int) bool { return f.waiters[a].at.Before(f.waiters[b].at) })
+	kept := f.waiters[:0]
+	for _, t := range f.waiters {
+		if t.at.After(end) {
+			kept = append(kept, t)
+			continue
+		}
+		t.done = true
+		t.c <- t.at
+	}
+	f.waiters = kept
+	f.now = end
+}
+
+/*
+*	Returns how many timers are waiting to fire, so a test can tell a goroutine has reached its wait before
+*	advancing the clock
+ */
+func (f *Fake) Waiters() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.waiters)
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	f := t.clock
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if t.done {
+		return false
+	}
+	for k, w := range f.waiters {
+		if w == t {
+			f.waiters = append(f.waiters[:k], f.waiters[k+1:]...)
+			break
+		}
+	}
+	t.done = true
+	return true
+}
@@ -0,0 +1,82 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var start = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func fired(t Timer) (time.Time, bool) {
+	select {
+	case at := <-t.C():
+		return at, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestFakeFiresTimersInOrder(t *testing.T) {
+	f := NewFake(start)
+	late, early := f.NewTimer(2*time.Hour), f.NewTimer(time.Hour)
+	if f.Waiters() != 2 {
+		t.Fatalf("%d waiters, want 2", f.Waiters())
+	}
+	f.Advance(time.Hour - time.Nanosecond)
+	if _, ok := fired(early); ok {
+		t.Fatal("timer fired before its time")
+	}
+	f.Advance(time.Nanosecond)
+	if at, ok := fired(early); !ok || !at.Equal(start.Add(time.Hour)) {
+		t.Fatalf("timer due in an hour fired %t at %v", ok, at)
+	}
+	if _, ok := fired(late); ok {
+		t.Fatal("later timer fired early")
+	}
+	// advancing past several due times hands each timer its own
+	f.Advance(10 * time.Hour)
+	if at, ok := fired(late); !ok || !at.Equal(start.Add(2*time.Hour)) {
+		t.Fatalf("timer due in two hours fired %t at %v", ok, at)
+	}
+	if !f.Now().Equal(start.Add(11 * time.Hour)) {
+		t.Fatalf("Now is %v after advancing 11 hours", f.Now())
+	}
+	if f.Waiters() != 0 {
+		t.Fatalf("%d waiters left after every timer fired", f.Waiters())
+	}
+}
+
+func TestFakeStop(t *testing.T) {
+	f := NewFake(start)
+	timer := f.NewTimer(time.Minute)
+	if !timer.Stop() {
+		t.Fatal("Stop of a pending timer returned false")
+	}
+	if timer.Stop() {
+		t.Fatal("second Stop returned true")
+	}
+	f.Advance(time.Hour)
+	if _, ok := fired(timer); ok {
+		t.Fatal("stopped timer fired")
+	}
+	done := f.NewTimer(time.Second)
+	f.Advance(time.Second)
+	if done.Stop() {
+		t.Fatal("Stop of a fired timer returned true")
+	}
+}
+
+func TestFakeZeroDurationFiresAtOnce(t *testing.T) {
+	f := NewFake(start)
+	if at, ok := fired(f.NewTimer(0)); !ok || !at.Equal(start) {
+		t.Fatal("zero duration timer did not fire on creation")
+	}
+	select {
+	case <-f.After(-time.Second):
+	default:
+		t.Fatal("negative After did not fire on creation")
+	}
+	if f.Waiters() != 0 {
+		t.Fatal("timers that already fired are counted as waiting")
+	}
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func delegationConfig(delegations string) string {
+	return `{"UpstreamNameservers": {"Primary": {"IPv4": "198.51.100.1"}}, "Delegations": [` + delegations + `]}`
+}
+
+func TestDelegationValidation(t *testing.T) {
+	cases := []struct {
+		name        string
+		delegations string
+		err         string
+	}{
+		{"glue inside the zone", `{"Zone": "k8s.lab.home", "Nameservers": [{"Name": "ns.k8s.lab.home", "Addresses": ["10.0.5.53", "fd00::53"]}]}`, ""},
+		{"nameserver outside the zone", `{"Zone": "k8s.lab.home", "Nameservers": [{"Name": "ns1.example.net"}]}`, ""},
+		{"recursing with glue", `{"Zone": "k8s.lab.home", "Recurse": true, "Nameservers": [{"Name": "ns.k8s.lab.home", "Addresses": ["10.0.5.53"]}]}`, ""},
+		{"missing glue", `{"Zone": "k8s.lab.home", "Nameservers": [{"Name": "ns.k8s.lab.home"}]}`,
+			"Nameserver ns.k8s.lab.home. of Delegation k8s.lab.home. is inside the delegated zone and needs glue Addresses"},
+		{"zone apex as nameserver without glue", `{"Zone": "k8s.lab.home", "Nameservers": [{"Name": "k8s.lab.home"}]}`,
+			"Nameserver k8s.lab.home. of Delegation k8s.lab.home. is inside the delegated zone and needs glue Addresses"},
+		{"recursing without addresses", `{"Zone": "k8s.lab.home", "Recurse": true, "Nameservers": [{"Name": "ns1.example.net"}]}`,
+			"Delegation of k8s.lab.home. has Recurse set but none of its nameservers list Addresses to send queries to"},
+		{"no nameservers", `{"Zone": "k8s.lab.home"}`, "Delegation of k8s.lab.home. must list at least one nameserver"},
+		{"bad glue", `{"Zone": "k8s.lab.home", "Nameservers": [{"Name": "ns.k8s.lab.home", "Addresses": ["10.0.5"]}]}`,
+			"Address 10.0.5 of nameserver ns.k8s.lab.home. of Delegation k8s.lab.home. is invalid"},
+		{"root zone", `{"Zone": ".", "Nameservers": [{"Name": "ns1.example.net"}]}`, "Zone of Delegation at index 0 is invalid"},
+		{"repeated zone", `{"Zone": "k8s.lab.home", "Nameservers": [{"Name": "ns1.example.net"}]}, {"Zone": "K8S.lab.home.", "Nameservers": [{"Name": "ns2.example.net"}]}`,
+			"Delegation at index 1 repeats the zone k8s.lab.home."},
+	}
+	for _, c := range cases {
+		_, err := ReadConfig(strings.NewReader(delegationConfig(c.delegations)))
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("%s: rejected with %v", c.name, err)
+		case c.err != "" && (err == nil || !strings.HasPrefix(err.Error(), c.err)):
+			t.Errorf("%s: got %v, want an error starting %q", c.name, err, c.err)
+		}
+	}
+}
+
+func TestDelegationDefaults(t *testing.T) {
+	conf, err := ReadConfig(strings.NewReader(delegationConfig(`{"Zone": "K8s.Lab.Home", "Nameservers": [{"Name": "NS.k8s.lab.home", "Addresses": ["10.0.5.53", "fd00::53"]}]}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := conf.Delegations[0]
+	if d.Zone != "k8s.lab.home." || d.Nameservers[0].Name != "ns.k8s.lab.home." || d.TTL != DEFAULT_DELEGATION_TTL || d.Port != 53 {
+		t.Fatalf("delegation loaded as %+v, want canonical names, the default TTL and port 53", d)
+	}
+	upstreams := d.Upstreams()
+	if len(upstreams) != 2 || upstreams[0] != (Nameserver{IPv4: "10.0.5.53", Port: 53}) || upstreams[1] != (Nameserver{IPv6: "fd00::53", Port: 53}) {
+		t.Fatalf("delegation upstreams are %+v, want both glue addresses on port 53", upstreams)
+	}
+}
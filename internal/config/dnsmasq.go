@@ -0,0 +1,355 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type dnsmasqConverter struct {
+	records   []LocalDNSRecord
+	upstreams []Nameserver
+	zones     []string
+	rules     []ForwardingRule
+	warnings  []string
+	localTTL  uint32
+	resolv    string
+	noResolv  bool
+}
+
+var dnsmasqIgnoredDirectives = map[string]bool{
+	"bind-dynamic": true, "bind-interfaces": true, "bogus-priv": true, "cache-size": true,
+	"domain-needed": true, "except-interface": true, "interface": true, "listen-address": true,
+	"log-queries": true, "no-hosts": true, "no-negcache": true, "port": true, "strict-order": true,
+	"user": true, "group": true, "pid-file": true, "log-facility": true, "expand-hosts": true,
+}
+
+/*
+*	Translates the DNS related subset of a dnsmasq configuration file into a validated Configuration,
+*	directives without a labns equivalent are reported as warnings rather than errors
+ */
+func ConvertDnsmasq(filePath string, defaultTTL uint32) (*Configuration, []string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	conv := &dnsmasqConverter{resolv: "/etc/resolv.conf"}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value := text, ""
+		if idx := strings.Index(text, "="); idx >= 0 {
+			key, value = strings.TrimSpace(text[:idx]), strings.TrimSpace(text[idx+1:])
+		}
+		conv.directive(line, key, value)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if conv.localTTL == 0 {
+		conv.localTTL = defaultTTL
+	}
+	for k := range conv.records {
+		if conv.records[k].TTL == 0 {
+			conv.records[k].TTL = conv.localTTL
+		}
+	}
+	if len(conv.upstreams) == 0 && !conv.noResolv {
+		conv.readResolvConf()
+	}
+	if len(conv.upstreams) == 0 {
+		return nil, conv.warnings, errors.New("no upstream nameservers found (server= directives or resolv-file)")
+	}
+	if len(conv.upstreams) > 2 {
+		conv.warnf(0, "labns supports two upstream nameservers, ignoring %d additional servers", len(conv.upstreams)-2)
+	}
+	out := Configuration{
+		LocalRecords:        conv.records,
+		UpstreamNameservers: UpstreamNameservers{Primary: conv.upstreams[0]},
+		LocalZones:          conv.zones,
+		ForwardingRules:     conv.rules,
+	}
+	// a single upstream is left without a secondary rather than failing over to itself
+	if len(conv.upstreams) > 1 {
+		out.UpstreamNameservers.Secondary = conv.upstreams[1]
+	}
+	serial, err := json.Marshal(out)
+	if err != nil {
+		return nil, conv.warnings, err
+	}
+	validated, err := ReadConfig(bytes.NewReader(serial))
+	if err != nil {
+		return nil, conv.warnings, errors.New("converted configuration failed validation: " + err.Error())
+	}
+	return validated, conv.warnings, nil
+}
+
+func (c *dnsmasqConverter) warnf(line int, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if line > 0 {
+		msg = fmt.Sprintf("line %d: %s", line, msg)
+	}
+	c.warnings = append(c.warnings, msg)
+}
+
+func (c *dnsmasqConverter) directive(line int, key string, value string) {
+	switch key {
+	case "address":
+		domains, target := splitDnsmasqDomains(value)
+		if len(domains) == 0 {
+			c.warnf(line, "address=%s has no domain, skipped", value)
+			return
+		}
+		switch target {
+		case "":
+			c.warnf(line, "address=%s (NXDOMAIN for a domain) has no labns equivalent, skipped", value)
+			return
+		case "#":
+			c.warnf(line, "address=%s (null address for a domain) has no labns equivalent, skipped", value)
+			return
+		}
+		c.warnf(line, "address=%s also matches subdomains in dnsmasq, only the exact name is converted", value)
+		for _, d := range domains {
+			c.addAddress(line, d, target, 0)
+		}
+	case "host-record":
+		var names, addrs []string
+		var ttl uint32
+		parts := strings.Split(value, ",")
+		for k, p := range parts {
+			p = strings.TrimSpace(p)
+			if net.ParseIP(p) != nil {
+				addrs = append(addrs, p)
+			} else if n, err := strconv.ParseUint(p, 10, 32); err == nil && k == len(parts)-1 {
+				ttl = uint32(n)
+			} else if p != "" {
+				names = append(names, p)
+			}
+		}
+		for _, n := range names {
+			for _, a := range addrs {
+				c.addAddress(line, n, a, ttl)
+			}
+		}
+	case "cname":
+		parts := strings.Split(value, ",")
+		var ttl uint32
+		if n, err := strconv.ParseUint(strings.TrimSpace(parts[len(parts)-1]), 10, 32); err == nil && len(parts) > 2 {
+			ttl = uint32(n)
+			parts = parts[:len(parts)-1]
+		}
+		if len(parts) < 2 {
+			c.warnf(line, "cname=%s needs an alias and a target, skipped", value)
+			return
+		}
+		target := toFQDN(parts[len(parts)-1])
+		if !c.validName(line, target) {
+			return
+		}
+		for _, alias := range parts[:len(parts)-1] {
+			if c.validName(line, toFQDN(alias)) {
+				c.records = append(c.records, LocalDNSRecord{Name: toFQDN(alias), Type: "CNAME", TTL: ttl, Target: target})
+			}
+		}
+	case "server", "local":
+		if strings.HasPrefix(value, "/") {
+			domains, target := splitDnsmasqDomains(value)
+			if target == "" {
+				c.addZones(domains)
+			} else if key == "server" {
+				ns, err := parseDnsmasqServer(target)
+				if err != nil {
+					c.warnf(line, "server=%s: %v, skipped", value, err)
+					return
+				}
+				c.addRule(domains, *ns)
+			} else {
+				c.warnf(line, "local=%s has no labns equivalent, skipped", value)
+			}
+			return
+		}
+		if key == "local" {
+			c.warnf(line, "local=%s has no labns equivalent, skipped", value)
+			return
+		}
+		ns, err := parseDnsmasqServer(value)
+		if err != nil {
+			c.warnf(line, "server=%s: %v, skipped", value, err)
+			return
+		}
+		c.upstreams = append(c.upstreams, *ns)
+	case "addn-hosts":
+		c.readHostsFile(line, value)
+	case "local-ttl":
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.warnf(line, "local-ttl=%s is not a number, ignored", value)
+			return
+		}
+		c.localTTL = uint32(n)
+	case "resolv-file":
+		c.resolv = value
+	case "no-resolv":
+		c.noResolv = true
+	default:
+		if dnsmasqIgnoredDirectives[key] {
+			c.warnf(line, "%s does not affect labns, ignored", key)
+			return
+		}
+		c.warnf(line, "unsupported directive %s, skipped", key)
+	}
+}
+
+func (c *dnsmasqConverter) addAddress(line int, name string, addr string, ttl uint32) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		c.warnf(line, "%s is not an IP address, skipped", addr)
+		return
+	}
+	if !c.validName(line, toFQDN(name)) {
+		return
+	}
+	recordType := "AAAA"
+	if ip.To4() != nil {
+		recordType = "A"
+	}
+	c.records = append(c.records, LocalDNSRecord{Name: toFQDN(name), Type: recordType, TTL: ttl, Target: ip.String()})
+}
+
+/*
+*	Reports whether name can be a labns record name, dnsmasq also takes wildcards like *.internal that labns
+*	records can't express
+ */
+func (c *dnsmasqConverter) validName(line int, name string) bool {
+	if err := canonicalizeName(&name); err != nil {
+		c.warnf(line, "%s is not a valid record name (%v), skipped", name, err)
+		return false
+	}
+	return true
+}
+
+func (c *dnsmasqConverter) readHostsFile(line int, path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		c.warnf(line, "unable to read addn-hosts file %s: %v", path, err)
+		return
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err == nil && info.IsDir() {
+		c.warnf(line, "addn-hosts directory %s is not supported, skipped", path)
+		return
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		text := scanner.Text()
+		if idx := strings.Index(text, "#"); idx >= 0 {
+			text = text[:idx]
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, name := range fields[1:] {
+			c.addAddress(line, name, fields[0], 0)
+		}
+	}
+}
+
+func (c *dnsmasqConverter) readResolvConf() {
+	file, err := os.Open(c.resolv)
+	if err != nil {
+		c.warnf(0, "unable to read %s for upstream nameservers: %v", c.resolv, err)
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		if ns, err := parseDnsmasqServer(fields[1]); err == nil {
+			c.upstreams = append(c.upstreams, *ns)
+		}
+	}
+}
+
+func (c *dnsmasqConverter) addZones(domains []string) {
+	for _, d := range domains {
+		c.zones = append(c.zones, strings.ToLower(strings.TrimSuffix(d, "."))+".")
+	}
+}
+
+/*
+*	Repeated server=/domain/ lines for the same domains add nameservers to one rule, as dnsmasq treats them
+ */
+func (c *dnsmasqConverter) addRule(domains []string, ns Nameserver) {
+	names := make([]string, len(domains))
+	for k, d := range domains {
+		names[k] = strings.ToLower(strings.TrimSuffix(d, ".")) + "."
+	}
+	for k := range c.rules {
+		if strings.Join(c.rules[k].Domains, "/") == strings.Join(names, "/") {
+			c.rules[k].Nameservers = append(c.rules[k].Nameservers, ns)
+			return
+		}
+	}
+	c.rules = append(c.rules, ForwardingRule{Domains: names, Nameservers: []Nameserver{ns}})
+}
+
+/*
+*	Splits the /domain1/domain2/value form used by address= and server=
+ */
+func splitDnsmasqDomains(value string) ([]string, string) {
+	parts := strings.Split(value, "/")
+	if len(parts) < 3 {
+		return nil, ""
+	}
+	var domains []string
+	for _, d := range parts[1 : len(parts)-1] {
+		if d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains, parts[len(parts)-1]
+}
+
+func parseDnsmasqServer(value string) (*Nameserver, error) {
+	if idx := strings.Index(value, "@"); idx >= 0 {
+		value = value[:idx]
+	}
+	port := uint64(53)
+	if idx := strings.Index(value, "#"); idx >= 0 {
+		var err error
+		if port, err = strconv.ParseUint(value[idx+1:], 10, 16); err != nil {
+			return nil, errors.New("invalid port")
+		}
+		value = value[:idx]
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, errors.New("not an IP address")
+	}
+	if ip.To4() != nil {
+		return &Nameserver{IPv4: ip.String(), Port: uint16(port)}, nil
+	}
+	return &Nameserver{IPv6: ip.String(), Port: uint16(port)}, nil
+}
+
+func toFQDN(name string) string {
+	name = strings.TrimSpace(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
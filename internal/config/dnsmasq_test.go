@@ -0,0 +1,113 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var (
+	dnsmasqDir    = filepath.Join("..", "..", "testdata", "dnsmasq")
+	updateDnsmasq = flag.Bool("update", false, "write the converted configurations and warnings into the dnsmasq fixtures instead of comparing them")
+)
+
+func writeDnsmasq(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dnsmasq.conf")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+/*
+*	The sections of a Configuration the converter fills in, written out as the expected labns configuration
+ */
+type convertedSections struct {
+	LocalRecords        []LocalDNSRecord
+	UpstreamNameservers UpstreamNameservers
+	LocalZones          []string
+	ForwardingRules     []ForwardingRule
+}
+
+/*
+*	Converts each dnsmasq configuration in testdata/dnsmasq and compares the result with the labns configuration and
+*	warnings next to it. {{dir}} in a fixture stands for the fixture directory, for the files it points dnsmasq at.
+*	With -update the fixtures are written from the conversion instead
+ */
+func TestDnsmasqFixtures(t *testing.T) {
+	dir, err := filepath.Abs(dnsmasqDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.conf"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no dnsmasq fixtures in %s: %v", dir, err)
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".conf")
+		t.Run(name, func(t *testing.T) {
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conf, warnings, err := ConvertDnsmasq(writeDnsmasq(t, strings.Replace(string(content), "{{dir}}", dir, -1)), 300)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.MarshalIndent(convertedSections{conf.LocalRecords, conf.UpstreamNameservers, conf.LocalZones, conf.ForwardingRules}, "", "    ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+			gotWarnings := strings.Replace(strings.Join(warnings, "\n")+"\n", dir, "{{dir}}", -1)
+
+			expectedPath, warningsPath := filepath.Join(dir, name+".json"), filepath.Join(dir, name+".warnings")
+			if *updateDnsmasq {
+				if err := ioutil.WriteFile(expectedPath, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(warningsPath, []byte(gotWarnings), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := ioutil.ReadFile(expectedPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, expected) {
+				t.Errorf("converted configuration differs from %s:\n%s", expectedPath, got)
+			}
+			expectedWarnings, err := ioutil.ReadFile(warningsPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotWarnings != string(expectedWarnings) {
+				t.Errorf("warnings differ from %s:\n%s", warningsPath, gotWarnings)
+			}
+			// the expected configuration is itself a labns configuration, loaded as the converter's output would be
+			if _, err := LoadConfig(expectedPath); err != nil {
+				t.Errorf("%s does not load: %v", expectedPath, err)
+			}
+		})
+	}
+}
+
+func TestDnsmasqSingleUpstreamHasNoSecondary(t *testing.T) {
+	conf, _, err := ConvertDnsmasq(writeDnsmasq(t, "no-resolv\nserver=198.51.100.1\nserver=/corp.example.com/10.8.0.1\n"), 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.UpstreamNameservers.Primary.IPv4 != "198.51.100.1" || conf.UpstreamNameservers.HasSecondary() {
+		t.Fatalf("upstreams are %+v, want 198.51.100.1 without a secondary", conf.UpstreamNameservers)
+	}
+	if len(conf.ForwardingRules) != 1 || len(conf.ForwardingRules[0].Nameservers) != 1 || conf.ForwardingRules[0].Nameservers[0].IPv4 != "10.8.0.1" {
+		t.Fatalf("forwarding rules are %+v, want corp.example.com. to 10.8.0.1", conf.ForwardingRules)
+	}
+}
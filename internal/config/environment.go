@@ -3,15 +3,90 @@ package config
 import (
 	"os"
 	"strconv"
+
+	"golang.org/x/net/dns/dnsmessage"
 )
 
 const (
-	VALID_FQDN_REGEX     = `^[a-zA-Z0-9-.]*\.$`
+	VALID_FQDN_REGEX     = `^[a-zA-Z0-9_.-]*\.$`
 	ENV_CONFIG_PATH      = "LABNS_CONFIG_PATH"
 	ENV_LOG_PATH         = "LABNS_LOG_PATH"
 	ENV_DNS_SERVICE_PORT = "LABNS_DNS_SERVICE_PORT"
+	DEFAULT_CONFIG_PATH  = "/etc/labns/labns.json"
+
+	DEFAULT_BLOCKED_RESPONSE_TTL  uint32 = 10
+	DEFAULT_MAX_UPSTREAM_ANSWERS  uint16 = 100
+	DEFAULT_MAX_UPSTREAM_BYTES    uint32 = 65535
+	DEFAULT_MAX_CNAME_CHAIN       uint8  = 8
+	DEFAULT_MAX_CHAIN_DEPTH       uint8  = 8
+	DEFAULT_CACHE_MAX_ENTRIES     uint32 = 10000
+	DEFAULT_CACHE_MAX_TTL         uint32 = 86400
+	DEFAULT_CACHE_MAX_ENTRY_BYTES uint32 = 8192
+
+	DEFAULT_MAX_INFLIGHT_PER_CLIENT uint32 = 100
+
+	DEFAULT_HISTORY_MAX_ENTRIES     uint32 = 100000
+	DEFAULT_HISTORY_MAX_AGE_MINUTES uint32 = 1440
+
+	DEFAULT_ALERT_AFTER_SECONDS    uint32 = 60
+	DEFAULT_ALERT_COOLDOWN_SECONDS uint32 = 900
+
+	DEFAULT_HEALTH_SUFFIX = "labns.self."
+
+	DEFAULT_TTL_DECAY_MIN uint32 = 30
+
+	DEFAULT_FAST_PATH_MIN_TTL uint32 = 300
+
+	DEFAULT_DELEGATION_TTL uint32 = 3600
+
+	GENERATED_IP_DASHED       = "{ip-dashed}"
+	GENERATED_LAST_OCTET      = "{last-octet}"
+	MIN_GENERATED_IPV6_PREFIX = 64
+
+	DEFAULT_RECORD_AUDIT_INTERVAL_MINUTES  uint32 = 1440
+	DEFAULT_RECORD_AUDIT_FAIL_AFTER        uint32 = 3
+	DEFAULT_RECORD_AUDIT_PROBE_INTERVAL_MS uint32 = 1000
+	MIN_RECORD_AUDIT_PROBE_INTERVAL_MS     uint32 = 100
+
+	DEFAULT_MAX_GOROUTINES          uint32 = 20000
+	DEFAULT_WARN_GOROUTINES         uint32 = 5000
+	DEFAULT_WARN_OPEN_FILES_PERCENT uint8  = 80
+
+	DEFAULT_RESPONSE_HOOK_TIMEOUT_MS uint32 = 200
+	MAX_RESPONSE_HOOK_TIMEOUT_MS     uint32 = 5000
+
+	// TTLOverrides above this are accepted with a warning
+	TTL_OVERRIDE_WARN_SECONDS uint32 = 86400
+
+	// upstream TimeoutMs and Retries outside these are accepted with a warning
+	MIN_UPSTREAM_TIMEOUT_MS uint16 = 50
+	MAX_UPSTREAM_TIMEOUT_MS uint16 = 30000
+	MAX_UPSTREAM_RETRIES    uint8  = 5
+
+	MAX_RAW_RDATA_LENGTH = 4096
+	RAW_RECORD_EXAMPLE   = `Example CAA record: {"Name": "lab.home.", "Type": "RAW", "TTL": 300, "RRType": 257, "RData": "0005 6973737565 6c657473656e63727970742e6f7267"}`
+
+	TypeSSHFP dnsmessage.Type = 44
+	TypeTLSA  dnsmessage.Type = 52
+	TypeSVCB  dnsmessage.Type = 64
+	TypeHTTPS dnsmessage.Type = 65
 )
 
+// connectivity and captive portal checks of Apple, Android, Windows, Firefox and GNOME
+var DEFAULT_FAST_PATH_DOMAINS = []string{
+	"captive.apple.com.",
+	"connectivitycheck.gstatic.com.",
+	"connectivitycheck.android.com.",
+	"clients3.google.com.",
+	"www.msftconnecttest.com.",
+	"www.msftncsi.com.",
+	"detectportal.firefox.com.",
+	"nmcheck.gnome.org.",
+}
+
+// ssh, http and https, a target with none of them listening still counts as up when it refuses the connection
+var DEFAULT_RECORD_AUDIT_PORTS = []uint16{22, 80, 443}
+
 var (
 	CONFIG_FILE_PATH string
 	LOG_FILE_PATH    string
@@ -27,7 +102,7 @@ func GetEnv(value string, def string) string {
 }
 
 func ReadEnvironment() error {
-	CONFIG_FILE_PATH = GetEnv(ENV_CONFIG_PATH, "/etc/labns/labns.json")
+	CONFIG_FILE_PATH = GetEnv(ENV_CONFIG_PATH, DEFAULT_CONFIG_PATH)
 	LOG_FILE_PATH = GetEnv(ENV_LOG_PATH, "")
 	port, err := strconv.ParseUint(GetEnv(ENV_DNS_SERVICE_PORT, "53"), 10, 16)
 	if err != nil {
@@ -1,13 +1,25 @@
 package config
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
+	"net/url"
 	"os"
+	"reflect"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/TasSM/labns/internal/dnsname"
+	"github.com/TasSM/labns/internal/feature"
 	"github.com/TasSM/labns/internal/logging"
 	"golang.org/x/net/dns/dnsmessage"
 )
@@ -17,23 +29,352 @@ type LocalDNSRecord struct {
 	Type   string
 	TTL    uint32
 	Target string
+	Svc    *SvcParams   `json:",omitempty"`
+	RRType uint16       `json:",omitempty"`
+	RData  string       `json:",omitempty"`
+	SSHFP  *SSHFPParams `json:",omitempty"`
+	TLSA   *TLSAParams  `json:",omitempty"`
+	HINFO  *HINFOParams `json:",omitempty"`
+	// Comment and Tags are inventory metadata and never change the answer
+	Comment string   `json:",omitempty"`
+	Tags    []string `json:",omitempty"`
+	// overrides TTLDecay.Enabled for this record
+	TTLDecay *bool `json:",omitempty"`
+	// leaves the record's target out of RecordAudit probes
+	NoAudit bool `json:",omitempty"`
+	ttlSet  bool
+}
+
+/*
+*	Parameters for HTTPS and SVCB records, Priority 0 is AliasMode and takes no other parameters
+ */
+type SvcParams struct {
+	Priority uint16
+	Alpn     []string `json:",omitempty"`
+	Port     uint16   `json:",omitempty"`
+	IPv4Hint []string `json:",omitempty"`
+	IPv6Hint []string `json:",omitempty"`
+}
+
+/*
+*	SSH host key fingerprint (RFC 4255), Fingerprint is hex
+ */
+type SSHFPParams struct {
+	Algorithm       uint8
+	FingerprintType uint8
+	Fingerprint     string
+}
+
+/*
+*	DANE certificate association (RFC 6698), CertData is hex
+ */
+type TLSAParams struct {
+	Usage        uint8
+	Selector     uint8
+	MatchingType uint8
+	CertData     string
+}
+
+type HINFOParams struct {
+	CPU string
+	OS  string
 }
 
 type Nameserver struct {
+	IPv4          string
+	IPv6          string
+	Port          uint16
+	BindAddress   string `json:",omitempty"`
+	BindInterface string `json:",omitempty"`
+	// the transport queries are sent over, udp unless set
+	Protocol string `json:",omitempty"`
+}
+
+type UpstreamNameservers struct {
+	Primary                  Nameserver
+	Secondary                Nameserver
+	TimeoutMs                uint16
+	Strategy                 string
+	Retries                  uint8
+	DisableCaseRandomization bool
+	DisableCookies           bool
+}
+
+type ForwardingRule struct {
+	Domains     []string
+	Nameservers []Nameserver
+	TimeoutMs   uint16
+	Strategy    string
+	Retries     *uint8
+}
+
+type GeneratedRange struct {
+	CIDR      string
+	Template  string
+	TTL       *uint32
+	Direction string
+}
+
+type DelegatedNameserver struct {
+	Name      string
+	Addresses []string `json:",omitempty"`
+}
+
+type Delegation struct {
+	Zone        string
+	Nameservers []DelegatedNameserver
+	TTL         uint32
+	Recurse     bool
+	Port        uint16
+}
+
+type BlockResponse struct {
+	Mode string
 	IPv4 string
 	IPv6 string
-	Port uint16
 }
 
-type UpstreamNameservers struct {
-	Primary   Nameserver
-	Secondary Nameserver
-	TimeoutMs uint16
+type Schedule struct {
+	Days  []string
+	Start string
+	End   string
+}
+
+type Blocklist struct {
+	// names the list so it can be paused on its own from the admin API
+	Name          string
+	Path          string
+	Domains       []string
+	Format        string
+	BlockResponse *BlockResponse
+	Groups        []string
+	Schedules     []Schedule
+}
+
+type Syslog struct {
+	Network  string
+	Address  string
+	Facility string
+	Tag      string
+}
+
+type ResponseLimits struct {
+	MaxAnswers    uint16
+	MaxBytes      uint32
+	MaxCNAMEChain uint8
+}
+
+type Cache struct {
+	Disabled      bool
+	MaxEntries    uint32
+	MaxTTL        uint32
+	MaxEntryBytes uint32
+}
+
+type WarmupName struct {
+	Name string
+	Type string
+}
+
+type QueryHistory struct {
+	Enabled       bool
+	MaxEntries    uint32
+	MaxAgeMinutes uint32
+	Path          string
+}
+
+type Profile struct {
+	Name            string
+	Blocklists      []Blocklist
+	DisableBlocking bool
+	Allowlist       []string
+	Cache           *Cache
+	TraceAll        bool
+}
+
+type FaultRule struct {
+	Domains     []string
+	Mode        string
+	Probability float64
+	DelayMs     uint32
+	JitterMs    uint32
+}
+
+type FaultInjection struct {
+	Enabled bool
+	Rules   []FaultRule
+}
+
+type Alerting struct {
+	WebhookURL      string
+	Command         []string
+	AfterSeconds    uint32
+	CooldownSeconds uint32
+}
+
+type AdminAccessRule struct {
+	Clients    []string
+	Capability string
+}
+
+type AdminAccess struct {
+	DefaultCapability string
+	Rules             []AdminAccessRule
+}
+
+type FastPath struct {
+	Domains         []string
+	DisableDefaults bool
+	MinTTL          uint32
+}
+
+type RecordAudit struct {
+	Enabled         bool
+	IntervalMinutes uint32
+	Ports           []uint16
+	FailAfter       uint32
+	ProbeIntervalMs uint32
+	TagStale        bool
+}
+
+type ResponseHook struct {
+	// the program and its arguments, run once per uncached query under Domains, empty turns the hook off
+	Command      []string
+	Domains      []string
+	TimeoutMs    uint32
+	CacheSeconds uint32
+}
+
+type AnswerRewrite struct {
+	// applies to A/AAAA records owned by or answered for these names and everything below them
+	Domains []string
+	// an IP or CIDR, answers with an address inside it are rewritten
+	Match   string
+	Replace string
+}
+
+type TTLOverride struct {
+	// an exact name, or *.domain.name. for the domain and every name below it
+	Name string
+	TTL  uint32
+}
+
+type Offline struct {
+	Enabled bool
+	// goes offline once every upstream has been unhealthy this long, 0 never goes offline by itself
+	AutoAfterSeconds uint32
+}
+
+type DriftDetection struct {
+	// share of answered queries asked again of the other upstream, 0 turns the comparison off
+	SampleRate float64
+}
+
+type MirrorTo struct {
+	// the analysis resolver as ip:port, empty turns mirroring off
+	Address    string
+	SampleRate float64
+	Domains    []string
+	Compare    bool
+}
+
+type ResourceLimits struct {
+	// at this many goroutines queries that would need more are answered SERVFAIL
+	MaxGoroutines        uint32
+	WarnGoroutines       uint32
+	WarnOpenFilesPercent uint8
+}
+
+type TTLDecay struct {
+	Enabled bool
+	MinTTL  uint32
+}
+
+type HealthRecords struct {
+	Disabled bool
+	Suffix   string
+}
+
+type Listener struct {
+	Address string
+	Profile string
+}
+
+type ClientGroup struct {
+	Name    string
+	Clients []string
 }
 
 type Configuration struct {
-	LocalRecords        []LocalDNSRecord
-	UpstreamNameservers UpstreamNameservers
+	LocalRecords                 []LocalDNSRecord
+	UpstreamNameservers          UpstreamNameservers
+	MaxConcurrentUpstreamQueries uint32
+	QueryDeadlineMs              uint32
+	Blocklists                   []Blocklist
+	Allowlist                    []string
+	LocalZones                   []string
+	ForwardingRules              []ForwardingRule
+	BlockResponse                BlockResponse
+	ClientGroups                 []ClientGroup
+	DefaultLocalTTL              uint32
+	BlockedResponseTTL           *uint32
+	MultipleQuestions            string
+	AdminListen                  string
+	AdminSocket                  string
+	TopDomainDepth               int
+	QueryLogPrivacy              string
+	QueryLogKey                  string
+	LogTarget                    string
+	Syslog                       Syslog
+	ListenAddress                string
+	ListenAddresses              []string
+	ListenInterfaces             []string
+	DualStack                    bool
+	AuditLogPath                 string
+	SearchDomain                 string
+	NeverForwardSingleLabel      *bool
+	TraceDomains                 []string
+	UpstreamResponseLimits       ResponseLimits
+	OverridesFile                string
+	AnswerOrdering               string
+	OrderUpstreamAnswers         bool
+	Cache                        Cache
+	WarmupNames                  []WarmupName
+	StripECH                     bool
+	StripECHExempt               []string
+	MaxChainDepth                uint8
+	QueryHistory                 QueryHistory
+	SelfHostname                 string
+	PrivateReverseForwarding     bool
+	Profiles                     []Profile
+	Listeners                    []Listener
+	FaultInjection               FaultInjection
+	PinnedNames                  []string
+	ClientSearchDomains          map[string]string
+	Alerting                     Alerting
+	HealthRecords                HealthRecords
+	MaxInflightPerClient         uint32
+	InflightLimitAction          string
+	StrictConfig                 *bool
+	SanitizeOutbound             *bool
+	TTLDecay                     TTLDecay
+	FastPath                     FastPath
+	RecordAudit                  RecordAudit
+	UDPReceiveBufferBytes        uint32
+	UDPSendBufferBytes           uint32
+	IPTOS                        uint8
+	Delegations                  []Delegation
+	AdminAccess                  AdminAccess
+	GeneratedRanges              []GeneratedRange
+	ResourceLimits               ResourceLimits
+	MirrorTo                     MirrorTo
+	DriftDetection               DriftDetection
+	Offline                      Offline
+	ResponseHook                 ResponseHook
+	AnswerRewrites               []AnswerRewrite
+	TTLOverrides                 []TTLOverride
+	CacheBypassClients           []string
+	SelfCheck                    bool
 }
 
 var (
@@ -41,8 +382,31 @@ var (
 		"CNAME": dnsmessage.TypeCNAME,
 		"AAAA":  dnsmessage.TypeAAAA,
 		"A":     dnsmessage.TypeA,
+		"SVCB":  TypeSVCB,
+		"HTTPS": TypeHTTPS,
+		"HINFO": dnsmessage.TypeHINFO,
+		"SSHFP": TypeSSHFP,
+		"TLSA":  TypeTLSA,
+	}
+	PermittedRecordTypes      []string = []string{"A", "AAAA", "CNAME", "SVCB", "HTTPS", "RAW", "HINFO", "SSHFP", "TLSA", "NULL"}
+	PermittedBlocklistFormats []string = []string{"", "auto", "domains", "hosts", "adguard"}
+	PermittedBlockModes       []string = []string{"", "nxdomain", "null", "refused", "custom"}
+	PermittedPrivacyModes     []string = []string{"", "full", "anonymize-client", "hash-names"}
+	PermittedLogTargets       []string = []string{"", "stdout", "file", "syslog"}
+	PermittedSyslogNetworks   []string = []string{"", "udp", "tcp"}
+	PermittedAnswerOrderings  []string = []string{"", "as-configured", "random", "round-robin", "prefer-client-subnet"}
+	PermittedQuestionModes    []string = []string{"", "first", "formerr"}
+	PermittedStrategies       []string = []string{"", "failover", "race"}
+	PermittedProtocols        []string = []string{"tcp"}
+	PermittedFaultModes       []string = []string{"servfail", "delay", "drop"}
+	PermittedInflightActions  []string = []string{"", "servfail", "drop"}
+	PermittedCapabilities     []string = []string{"", "dns-only", "admin-read", "admin-write"}
+	PermittedDirections       []string = []string{"", "forward", "reverse", "both"}
+	validFQDN                          = regexp.MustCompile(VALID_FQDN_REGEX)
+	DayMap                             = map[string]time.Weekday{
+		"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+		"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
 	}
-	PermittedRecordTypes []string = []string{"A", "AAAA", "CNAME"}
 )
 
 func LoadConfig(filePath string) (*Configuration, error) {
@@ -51,37 +415,402 @@ func LoadConfig(filePath string) (*Configuration, error) {
 		return nil, err
 	}
 	defer file.Close()
+	return ReadConfig(file)
+}
+
+/*
+*	Built-in configuration for running without a file: Cloudflare and Quad9 upstreams with a 2s timeout and no local records.
+*	It goes through ReadConfig so it gets the same validation and defaults as a file
+ */
+func DefaultConfiguration() (*Configuration, error) {
+	defaults := Configuration{
+		UpstreamNameservers: UpstreamNameservers{
+			Primary:   Nameserver{IPv4: "1.1.1.1"},
+			Secondary: Nameserver{IPv4: "9.9.9.9"},
+			TimeoutMs: 2000,
+		},
+	}
+	serial, err := json.Marshal(defaults)
+	if err != nil {
+		return nil, err
+	}
+	return ReadConfig(bytes.NewReader(serial))
+}
+
+func ReadConfig(r io.Reader) (*Configuration, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
 	config := &Configuration{}
-	decoder := json.NewDecoder(file)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if strictConfig(data) {
+		if err := findUnknownField(data, reflect.TypeOf(config), ""); err != nil {
+			return nil, errors.New(err.Error() + ", check the spelling or set \"StrictConfig\": false to ignore unknown fields")
+		}
+		decoder.DisallowUnknownFields()
+	}
 	err = decoder.Decode(config)
 	if err != nil {
 		return nil, err
 	}
 	for k, v := range config.LocalRecords {
-		if !isValidRecordName(v.Name) {
-			return nil, errors.New(fmt.Sprintf("Name for LocalRecord at index %d is invalid, should follow pattern domain.name.:", k))
+		if err := canonicalizeName(&config.LocalRecords[k].Name); err != nil {
+			return nil, errors.New(fmt.Sprintf("Name for LocalRecord at index %d is invalid (%v), should follow pattern domain.name.", k, err))
+		}
+		v.Name = config.LocalRecords[k].Name
+		if (v.Type == "CNAME" || v.Type == "SVCB" || v.Type == "HTTPS") && v.Target != "." {
+			target, err := dnsname.Canonical(v.Target)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Target for LocalRecord at index %d is invalid: %v", k, err))
+			}
+			config.LocalRecords[k].Target, v.Target = target, target
 		}
 		if !isValidType(v.Type) {
 			return nil, errors.New(fmt.Sprintf("Type for LocalRecord at index %d is invalid:", k))
 		}
-		if v.TTL == 0 {
-			return nil, errors.New(fmt.Sprintf("TTL for LocalRecord at index %d is invalid", k))
+		if !v.ttlSet {
+			if config.DefaultLocalTTL == 0 {
+				return nil, errors.New(fmt.Sprintf("TTL for LocalRecord at index %d is missing and no DefaultLocalTTL is set (use an explicit TTL of 0 to disable caching)", k))
+			}
+			config.LocalRecords[k].TTL = config.DefaultLocalTTL
+		}
+		if v.Type == "NULL" && v.Target != "" {
+			return nil, errors.New(fmt.Sprintf("Target for NULL LocalRecord at index %d must be empty, the name is always answered with 0.0.0.0 and ::", k))
+		}
+		if err := validateTarget(v.Type, v.Target); err != nil {
+			return nil, errors.New(fmt.Sprintf("Target for LocalRecord at index %d is invalid: %v", k, err))
+		}
+		// AliasMode SVCB and HTTPS records point elsewhere like a CNAME, so the same loop applies
+		aliasing := v.Type == "CNAME" || ((v.Type == "SVCB" || v.Type == "HTTPS") && (v.Svc == nil || v.Svc.Priority == 0))
+		if aliasing && strings.EqualFold(v.Target, v.Name) {
+			return nil, errors.New(fmt.Sprintf("Target for LocalRecord at index %d is its own name %s, the record would point at itself", k, v.Name))
+		}
+		if v.Type == "SVCB" || v.Type == "HTTPS" {
+			if err := ValidateSvcParams(v.Svc); err != nil {
+				return nil, errors.New(fmt.Sprintf("Svc for LocalRecord at index %d is invalid: %v", k, err))
+			}
+		} else if v.Svc != nil {
+			return nil, errors.New(fmt.Sprintf("Svc for LocalRecord at index %d is only permitted on SVCB and HTTPS records", k))
+		}
+		if v.Type == "RAW" {
+			if err := ValidateRawRecord(&v); err != nil {
+				return nil, errors.New(fmt.Sprintf("RAW LocalRecord at index %d is invalid: %v. %s", k, err, RAW_RECORD_EXAMPLE))
+			}
+		} else if v.RRType != 0 || v.RData != "" {
+			return nil, errors.New(fmt.Sprintf("RRType and RData for LocalRecord at index %d are only permitted on RAW records", k))
 		}
-		if !isValidTarget(v.Type, v.Target) {
-			return nil, errors.New(fmt.Sprintf("Target for LocalRecord at index %d is invalid (check type and target format)", k))
+		if strings.ContainsAny(v.Comment, "\r\n") {
+			return nil, errors.New(fmt.Sprintf("Comment for LocalRecord at index %d must be a single line", k))
+		}
+		for _, t := range v.Tags {
+			if t == "" || strings.ContainsAny(t, " \t,") {
+				return nil, errors.New(fmt.Sprintf("Tag %q for LocalRecord at index %d is invalid, tags cannot be empty or contain spaces or commas", t, k))
+			}
+		}
+		if err := validateTypedRecord(&v); err != nil {
+			return nil, errors.New(fmt.Sprintf("LocalRecord at index %d is invalid: %v", k, err))
 		}
 	}
-	err = ValidateNameserver(&config.UpstreamNameservers.Primary)
-	if err != nil {
+	if err := validateNullRecords(config.LocalRecords); err != nil {
+		return nil, err
+	}
+	groups := make(map[string]bool)
+	for k, v := range config.ClientGroups {
+		if v.Name == "" || groups[v.Name] {
+			return nil, errors.New(fmt.Sprintf("Name for ClientGroup at index %d must be provided and unique", k))
+		}
+		groups[v.Name] = true
+		for _, c := range v.Clients {
+			if _, err := ParseClientAddress(c); err != nil {
+				return nil, errors.New(fmt.Sprintf("Client %s for ClientGroup %s is invalid, should be an IP or CIDR", c, v.Name))
+			}
+		}
+	}
+	if len(config.Blocklists) > 64 {
+		return nil, errors.New("At most 64 Blocklists may be configured")
+	}
+	if err := validateBlocklists(config.Blocklists, groups, ""); err != nil {
+		return nil, err
+	}
+	if err := ValidateBlockResponse(&config.BlockResponse); err != nil {
+		return nil, err
+	}
+	if err := validateProfiles(config, groups); err != nil {
+		return nil, err
+	}
+	if err := validateFaultInjection(&config.FaultInjection); err != nil {
+		return nil, err
+	}
+	if err := validateAlerting(&config.Alerting); err != nil {
+		return nil, err
+	}
+	if err := validateRecordAudit(&config.RecordAudit); err != nil {
+		return nil, err
+	}
+	if err := validateAdminAccess(&config.AdminAccess); err != nil {
 		return nil, err
 	}
-	err = ValidateNameserver(&config.UpstreamNameservers.Secondary)
+	if err := validateResourceLimits(&config.ResourceLimits); err != nil {
+		return nil, err
+	}
+	if err := validateMirrorTo(config); err != nil {
+		return nil, err
+	}
+	if r := config.DriftDetection.SampleRate; r < 0 || r > 1 {
+		return nil, errors.New(fmt.Sprintf("SampleRate of DriftDetection is invalid, should be between 0 and 1: %v", r))
+	}
+	if err := validateResponseHook(&config.ResponseHook); err != nil {
+		return nil, err
+	}
+	for k := range config.AnswerRewrites {
+		if err := validateAnswerRewrite(k, &config.AnswerRewrites[k]); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateTTLOverrides(config.TTLOverrides); err != nil {
+		return nil, err
+	}
+	for _, client := range config.CacheBypassClients {
+		if _, err := ParseClientAddress(client); err != nil {
+			return nil, errors.New(fmt.Sprintf("CacheBypassClients entry %s is invalid, should be an IP or CIDR", client))
+		}
+	}
+	if err := validateFeatures(config); err != nil {
+		return nil, err
+	}
+	for k := range config.Allowlist {
+		if err := canonicalizeName(&config.Allowlist[k]); err != nil {
+			return nil, errors.New(fmt.Sprintf("Allowlist entry at index %d is invalid (%v), should follow pattern domain.name.", k, err))
+		}
+	}
+	for k := range config.TraceDomains {
+		if err := canonicalizeName(&config.TraceDomains[k]); err != nil {
+			return nil, errors.New(fmt.Sprintf("TraceDomain at index %d is invalid (%v), should follow pattern domain.name.", k, err))
+		}
+	}
+	for k, v := range config.PinnedNames {
+		name := strings.TrimPrefix(v, "*.")
+		if err := canonicalizeName(&name); err != nil {
+			return nil, errors.New(fmt.Sprintf("PinnedName at index %d is invalid (%v), should follow pattern domain.name. or *.domain.name.", k, err))
+		}
+		config.PinnedNames[k] = v[:len(v)-len(strings.TrimPrefix(v, "*."))] + name
+	}
+	for k := range config.FastPath.Domains {
+		if err := canonicalizeName(&config.FastPath.Domains[k]); err != nil {
+			return nil, errors.New(fmt.Sprintf("FastPath domain at index %d is invalid (%v), should follow pattern domain.name.", k, err))
+		}
+	}
+	if config.FastPath.MinTTL == 0 {
+		config.FastPath.MinTTL = DEFAULT_FAST_PATH_MIN_TTL
+	}
+	for k := range config.StripECHExempt {
+		if err := canonicalizeName(&config.StripECHExempt[k]); err != nil {
+			return nil, errors.New(fmt.Sprintf("StripECHExempt domain at index %d is invalid (%v), should follow pattern domain.name.", k, err))
+		}
+	}
+	for k := range config.LocalZones {
+		if err := canonicalizeName(&config.LocalZones[k]); err != nil {
+			return nil, errors.New(fmt.Sprintf("LocalZone at index %d is invalid (%v), should follow pattern domain.name.", k, err))
+		}
+	}
+	err = ValidateNameserver(&config.UpstreamNameservers.Primary)
 	if err != nil {
 		return nil, err
 	}
+	if config.UpstreamNameservers.HasSecondary() {
+		err = ValidateNameserver(&config.UpstreamNameservers.Secondary)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if config.UpstreamNameservers.TimeoutMs == 0 {
 		config.UpstreamNameservers.TimeoutMs = 5000
 	}
+	if config.UpstreamNameservers.Strategy == "" {
+		config.UpstreamNameservers.Strategy = "failover"
+	}
+	err = validateUpstreamSettings("UpstreamNameservers", config.UpstreamNameservers.TimeoutMs, config.UpstreamNameservers.Strategy, config.UpstreamNameservers.Retries)
+	if err != nil {
+		return nil, err
+	}
+	if config.UpstreamNameservers.HasSecondary() && sameNameserver(&config.UpstreamNameservers.Primary, &config.UpstreamNameservers.Secondary) {
+		logging.LogMessage(logging.LogWarning, fmt.Sprintf("Primary and Secondary upstream nameservers are both %s, failing over will not reach another server", nameserverAddress(&config.UpstreamNameservers.Primary)))
+	}
+	for _, ns := range config.UpstreamNameservers.Nameservers() {
+		if isOwnListener(&ns, config) {
+			return nil, errors.New(fmt.Sprintf("Upstream nameserver %s is labns itself, queries would loop", nameserverAddress(&ns)))
+		}
+	}
+	maxTimeout := config.UpstreamNameservers.TimeoutMs
+	for k := range config.ForwardingRules {
+		rule := &config.ForwardingRules[k]
+		err = validateForwardingRule(k, rule, &config.UpstreamNameservers)
+		if err != nil {
+			return nil, err
+		}
+		for i := range rule.Nameservers {
+			if isOwnListener(&rule.Nameservers[i], config) {
+				return nil, errors.New(fmt.Sprintf("Nameserver %s of ForwardingRule %d is labns itself, queries would loop", nameserverAddress(&rule.Nameservers[i]), k))
+			}
+		}
+		if rule.TimeoutMs > maxTimeout {
+			maxTimeout = rule.TimeoutMs
+		}
+	}
+	if err := validateDelegations(config); err != nil {
+		return nil, err
+	}
+	for k := range config.GeneratedRanges {
+		if err := validateGeneratedRange(k, &config.GeneratedRanges[k], config.DefaultLocalTTL); err != nil {
+			return nil, err
+		}
+	}
+	if !isPermitted(PermittedQuestionModes, config.MultipleQuestions) {
+		return nil, errors.New("MultipleQuestions is invalid, should be one of first or formerr")
+	}
+	if config.AdminListen != "" {
+		if _, _, err := net.SplitHostPort(config.AdminListen); err != nil {
+			return nil, errors.New(fmt.Sprintf("AdminListen %s is invalid, should be host:port", config.AdminListen))
+		}
+	}
+	if !isPermitted(PermittedAnswerOrderings, config.AnswerOrdering) {
+		return nil, errors.New("AnswerOrdering is invalid, should be one of as-configured, random, round-robin or prefer-client-subnet")
+	}
+	if !isPermitted(PermittedPrivacyModes, config.QueryLogPrivacy) {
+		return nil, errors.New("QueryLogPrivacy is invalid, should be one of full, anonymize-client or hash-names")
+	}
+	if config.ListenAddress != "" {
+		if _, err := ParseListenAddress(config.ListenAddress, 53); err != nil {
+			return nil, errors.New(fmt.Sprintf("ListenAddress %s is invalid, should be an IP or IP:port such as [fd00::53]:53", config.ListenAddress))
+		}
+	}
+	for k, v := range config.ListenAddresses {
+		if _, err := ParseListenAddress(v, 53); err != nil {
+			return nil, errors.New(fmt.Sprintf("ListenAddress at index %d is invalid, should be an IP or IP:port such as [fd00::53]:53", k))
+		}
+	}
+	for k, v := range config.ListenInterfaces {
+		if strings.TrimSpace(v) == "" {
+			return nil, errors.New(fmt.Sprintf("ListenInterface at index %d is empty", k))
+		}
+	}
+	if config.SearchDomain != "" {
+		if err := canonicalizeDomain(&config.SearchDomain); err != nil {
+			return nil, errors.New(fmt.Sprintf("SearchDomain is invalid (%v), should follow pattern domain.name.", err))
+		}
+	}
+	for cidr, domain := range config.ClientSearchDomains {
+		if _, err := ParseClientAddress(cidr); err != nil {
+			return nil, errors.New(fmt.Sprintf("ClientSearchDomains key %s is invalid: %v", cidr, err))
+		}
+		if err := canonicalizeDomain(&domain); err != nil {
+			return nil, errors.New(fmt.Sprintf("ClientSearchDomains domain for %s is invalid (%v), should follow pattern domain.name.", cidr, err))
+		}
+		config.ClientSearchDomains[cidr] = domain
+	}
+	if config.TTLDecay.MinTTL == 0 {
+		config.TTLDecay.MinTTL = DEFAULT_TTL_DECAY_MIN
+	}
+	if config.HealthRecords.Suffix == "" {
+		config.HealthRecords.Suffix = DEFAULT_HEALTH_SUFFIX
+	}
+	if err := canonicalizeDomain(&config.HealthRecords.Suffix); err != nil {
+		return nil, errors.New(fmt.Sprintf("Suffix of HealthRecords is invalid (%v), should follow pattern domain.name.", err))
+	}
+	if config.SelfHostname != "" {
+		if err := canonicalizeDomain(&config.SelfHostname); err != nil {
+			return nil, errors.New(fmt.Sprintf("SelfHostname is invalid (%v), should follow pattern domain.name.", err))
+		}
+	}
+	if config.NeverForwardSingleLabel == nil {
+		never := true
+		config.NeverForwardSingleLabel = &never
+	}
+	if config.SanitizeOutbound == nil {
+		sanitize := true
+		config.SanitizeOutbound = &sanitize
+	}
+	if !isPermitted(PermittedLogTargets, config.LogTarget) {
+		return nil, errors.New("LogTarget is invalid, should be one of stdout, file or syslog")
+	}
+	if !isPermitted(PermittedSyslogNetworks, config.Syslog.Network) {
+		return nil, errors.New("Syslog Network is invalid, should be udp or tcp")
+	}
+	if config.Syslog.Address != "" {
+		if _, _, err := net.SplitHostPort(config.Syslog.Address); err != nil {
+			return nil, errors.New(fmt.Sprintf("Syslog Address %s is invalid, should be host:port", config.Syslog.Address))
+		}
+		if config.Syslog.Network == "" {
+			config.Syslog.Network = "udp"
+		}
+	}
+	if config.TopDomainDepth < 0 {
+		return nil, errors.New("TopDomainDepth is invalid, should be 1 or more")
+	}
+	if config.TopDomainDepth == 0 {
+		config.TopDomainDepth = 2
+	}
+	if config.BlockedResponseTTL == nil {
+		ttl := DEFAULT_BLOCKED_RESPONSE_TTL
+		config.BlockedResponseTTL = &ttl
+	}
+	if config.QueryDeadlineMs == 0 {
+		config.QueryDeadlineMs = uint32(maxTimeout) + 500
+	}
+	if config.UpstreamResponseLimits.MaxAnswers == 0 {
+		config.UpstreamResponseLimits.MaxAnswers = DEFAULT_MAX_UPSTREAM_ANSWERS
+	}
+	if config.UpstreamResponseLimits.MaxBytes == 0 {
+		config.UpstreamResponseLimits.MaxBytes = DEFAULT_MAX_UPSTREAM_BYTES
+	}
+	if config.UpstreamResponseLimits.MaxCNAMEChain == 0 {
+		config.UpstreamResponseLimits.MaxCNAMEChain = DEFAULT_MAX_CNAME_CHAIN
+	}
+	if config.QueryHistory.MaxEntries == 0 {
+		config.QueryHistory.MaxEntries = DEFAULT_HISTORY_MAX_ENTRIES
+	}
+	if config.QueryHistory.MaxAgeMinutes == 0 {
+		config.QueryHistory.MaxAgeMinutes = DEFAULT_HISTORY_MAX_AGE_MINUTES
+	}
+	if config.MaxChainDepth == 0 {
+		config.MaxChainDepth = DEFAULT_MAX_CHAIN_DEPTH
+	}
+	if config.Cache.MaxEntries == 0 {
+		config.Cache.MaxEntries = DEFAULT_CACHE_MAX_ENTRIES
+	}
+	if config.Cache.MaxTTL == 0 {
+		config.Cache.MaxTTL = DEFAULT_CACHE_MAX_TTL
+	}
+	if config.Cache.MaxEntryBytes == 0 {
+		config.Cache.MaxEntryBytes = DEFAULT_CACHE_MAX_ENTRY_BYTES
+	}
+	for k := range config.WarmupNames {
+		w := &config.WarmupNames[k]
+		if err := canonicalizeName(&w.Name); err != nil {
+			return nil, errors.New(fmt.Sprintf("WarmupName at index %d is invalid (%v), should follow pattern domain.name.", k, err))
+		}
+		if w.Type == "" {
+			w.Type = "A"
+		}
+		if _, ok := RecordTypeMap[strings.ToUpper(w.Type)]; !ok {
+			return nil, errors.New(fmt.Sprintf("Type of WarmupName at index %d is invalid, should be one of A, AAAA, CNAME, SVCB, HTTPS, HINFO, SSHFP or TLSA", k))
+		}
+		w.Type = strings.ToUpper(w.Type)
+	}
+	if config.MaxConcurrentUpstreamQueries == 0 {
+		config.MaxConcurrentUpstreamQueries = 1024
+	}
+	if config.MaxInflightPerClient == 0 {
+		config.MaxInflightPerClient = DEFAULT_MAX_INFLIGHT_PER_CLIENT
+	}
+	config.InflightLimitAction = strings.ToLower(config.InflightLimitAction)
+	if !isPermitted(PermittedInflightActions, config.InflightLimitAction) {
+		return nil, errors.New("InflightLimitAction is invalid, should be one of servfail or drop")
+	}
+	if config.InflightLimitAction == "" {
+		config.InflightLimitAction = "servfail"
+	}
 	return config, nil
 }
 
@@ -89,6 +818,13 @@ func ValidateNameserver(ns *Nameserver) error {
 	if ns.Port == 0 {
 		ns.Port = 53
 	}
+	ns.Protocol = strings.ToLower(ns.Protocol)
+	if ns.Protocol == "udp" {
+		ns.Protocol = ""
+	}
+	if ns.Protocol != "" && !isPermitted(PermittedProtocols, ns.Protocol) {
+		return errors.New(fmt.Sprintf("Protocol of upstream nameserver is invalid, should be one of udp, %s: %v", strings.Join(PermittedProtocols, ", "), ns.Protocol))
+	}
 	if ns.IPv4 == "" && ns.IPv6 == "" {
 		return errors.New(fmt.Sprintf("IPv4 OR IPv6 of upstream nameserver must be provided"))
 	}
@@ -104,49 +840,1072 @@ func ValidateNameserver(ns *Nameserver) error {
 			return errors.New(fmt.Sprintf("IPv6 of upstream nameserver is invalid %v", ns.IPv6))
 		}
 	}
+	if ns.BindAddress != "" {
+		if err := validateBindAddress(ns); err != nil {
+			return err
+		}
+	}
+	if ns.BindInterface != "" {
+		if _, err := net.InterfaceByName(ns.BindInterface); err != nil {
+			return errors.New(fmt.Sprintf("BindInterface %s of upstream nameserver does not exist on this host", ns.BindInterface))
+		}
+	}
 	return nil
 }
 
-func isValidRecordName(name string) bool {
-	matched, err := regexp.MatchString(VALID_FQDN_REGEX, name)
-	if err != nil {
-		logging.LogMessage(logging.LogFatal, err.Error())
-		return false
+/*
+*	Checks every fault rule names its domains, a permitted mode and a probability in (0, 1]
+ */
+func validateFaultInjection(fi *FaultInjection) error {
+	if fi.Enabled && len(fi.Rules) == 0 {
+		return errors.New("FaultInjection is enabled but has no Rules")
 	}
-	return matched
-}
-
-func isValidType(parsedType string) bool {
-	for _, v := range PermittedRecordTypes {
-		if parsedType == v {
-			return true
+	for k := range fi.Rules {
+		rule := &fi.Rules[k]
+		rule.Mode = strings.ToLower(rule.Mode)
+		if !isPermitted(PermittedFaultModes, rule.Mode) {
+			return errors.New(fmt.Sprintf("Mode for FaultRule at index %d is invalid, should be one of servfail, delay or drop", k))
+		}
+		if rule.Probability <= 0 || rule.Probability > 1 {
+			return errors.New(fmt.Sprintf("Probability for FaultRule at index %d is invalid, should be greater than 0 and at most 1", k))
+		}
+		if rule.Mode == "delay" && rule.DelayMs == 0 && rule.JitterMs == 0 {
+			return errors.New(fmt.Sprintf("FaultRule at index %d uses mode delay but sets neither DelayMs nor JitterMs", k))
+		}
+		if len(rule.Domains) == 0 {
+			return errors.New(fmt.Sprintf("Domains for FaultRule at index %d must be provided, use \".\" to match every name", k))
+		}
+		for i, d := range rule.Domains {
+			if d == "." {
+				continue
+			}
+			if err := canonicalizeName(&rule.Domains[i]); err != nil {
+				return errors.New(fmt.Sprintf("Domain at index %d of FaultRule at index %d is invalid (%v), should follow pattern domain.name.", i, k, err))
+			}
 		}
 	}
-	return false
+	return nil
 }
 
 /*
-*	Note: Poor approximation of what is actually a valid FQDN for a CNAME records
+*	A NULL record answers every query type for its name, so the name cannot have other records as well
  */
-func isValidTarget(parsedType string, parsedTarget string) bool {
-	runes := []rune(parsedTarget)
-	switch parsedType {
-	case "A":
-		return net.ParseIP(parsedTarget).To4() != nil
-	case "AAAA":
-		return net.ParseIP(parsedTarget).To16() != nil
-	case "CNAME":
-		matched, err := regexp.MatchString(VALID_FQDN_REGEX, parsedTarget)
-		if err != nil {
-			logging.LogMessage(logging.LogFatal, err.Error())
-			return false
+func validateNullRecords(records []LocalDNSRecord) error {
+	null := make(map[string]bool)
+	for _, r := range records {
+		if r.Type == "NULL" {
+			null[r.Name] = true
 		}
-		for i := 0; i < len(runes)-1; i++ {
-			if runes[i+1] == runes[i] {
-				return false
-			}
+	}
+	for k, r := range records {
+		if r.Type != "NULL" && null[r.Name] {
+			return errors.New(fmt.Sprintf("LocalRecord at index %d shares the name %s with a NULL record, which already answers every type for it", k, r.Name))
+		}
+	}
+	return nil
+}
+
+/*
+*	Checks the webhook is an http(s) URL and applies the default delay and cooldown
+ */
+func validateAlerting(a *Alerting) error {
+	if a.WebhookURL != "" {
+		u, err := url.Parse(a.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New(fmt.Sprintf("WebhookURL of Alerting is invalid, should be an http:// or https:// URL: %v", a.WebhookURL))
+		}
+	}
+	if a.Command != nil && (len(a.Command) == 0 || a.Command[0] == "") {
+		return errors.New("Command of Alerting is invalid, should list the program followed by its arguments")
+	}
+	if a.AfterSeconds == 0 {
+		a.AfterSeconds = DEFAULT_ALERT_AFTER_SECONDS
+	}
+	if a.CooldownSeconds == 0 {
+		a.CooldownSeconds = DEFAULT_ALERT_COOLDOWN_SECONDS
+	}
+	return nil
+}
+
+/*
+*	Checks every rule lists valid clients and a known capability, clients matching no rule get DefaultCapability
+*	which is admin-read unless set
+ */
+func validateAdminAccess(a *AdminAccess) error {
+	if !isPermitted(PermittedCapabilities, a.DefaultCapability) {
+		return errors.New("DefaultCapability of AdminAccess is invalid, should be one of dns-only, admin-read or admin-write")
+	}
+	if a.DefaultCapability == "" {
+		a.DefaultCapability = "admin-read"
+	}
+	for k, rule := range a.Rules {
+		if rule.Capability == "" || !isPermitted(PermittedCapabilities, rule.Capability) {
+			return errors.New(fmt.Sprintf("Capability of AdminAccess rule %d is invalid, should be one of dns-only, admin-read or admin-write", k))
+		}
+		if len(rule.Clients) == 0 {
+			return errors.New(fmt.Sprintf("AdminAccess rule %d must list at least one client", k))
+		}
+		for _, client := range rule.Clients {
+			if _, err := ParseClientAddress(client); err != nil {
+				return errors.New(fmt.Sprintf("Client %s of AdminAccess rule %d is invalid, should be an IP or CIDR", client, k))
+			}
+		}
+	}
+	return nil
+}
+
+/*
+*	Checks the probe ports and applies the default interval, ports, threshold and probe pacing
+ */
+func validateRecordAudit(a *RecordAudit) error {
+	for _, port := range a.Ports {
+		if port == 0 {
+			return errors.New("Ports of RecordAudit is invalid, port 0 can't be probed")
+		}
+	}
+	if a.IntervalMinutes == 0 {
+		a.IntervalMinutes = DEFAULT_RECORD_AUDIT_INTERVAL_MINUTES
+	}
+	if len(a.Ports) == 0 {
+		a.Ports = append([]uint16{}, DEFAULT_RECORD_AUDIT_PORTS...)
+	}
+	if a.FailAfter == 0 {
+		a.FailAfter = DEFAULT_RECORD_AUDIT_FAIL_AFTER
+	}
+	if a.ProbeIntervalMs == 0 {
+		a.ProbeIntervalMs = DEFAULT_RECORD_AUDIT_PROBE_INTERVAL_MS
+	}
+	if a.ProbeIntervalMs < MIN_RECORD_AUDIT_PROBE_INTERVAL_MS {
+		return errors.New(fmt.Sprintf("ProbeIntervalMs of RecordAudit is invalid, should be at least %d: %d", MIN_RECORD_AUDIT_PROBE_INTERVAL_MS, a.ProbeIntervalMs))
+	}
+	return nil
+}
+
+/*
+*	Applies the default goroutine cap and warning thresholds, the warning has to come before the cap
+ */
+func validateResourceLimits(l *ResourceLimits) error {
+	if l.MaxGoroutines == 0 {
+		l.MaxGoroutines = DEFAULT_MAX_GOROUTINES
+	}
+	if l.WarnGoroutines == 0 {
+		l.WarnGoroutines = DEFAULT_WARN_GOROUTINES
+	}
+	if l.WarnOpenFilesPercent == 0 {
+		l.WarnOpenFilesPercent = DEFAULT_WARN_OPEN_FILES_PERCENT
+	}
+	if l.WarnOpenFilesPercent > 100 {
+		return errors.New(fmt.Sprintf("WarnOpenFilesPercent of ResourceLimits is invalid, should be at most 100: %d", l.WarnOpenFilesPercent))
+	}
+	if l.WarnGoroutines >= l.MaxGoroutines {
+		return errors.New(fmt.Sprintf("WarnGoroutines of ResourceLimits is invalid, should be below MaxGoroutines (%d): %d", l.MaxGoroutines, l.WarnGoroutines))
+	}
+	return nil
+}
+
+/*
+*	Checks the mirror address isn't labns itself and applies the default sample rate, mirroring every name when no
+*	Domains are given
+ */
+func validateMirrorTo(config *Configuration) error {
+	m := &config.MirrorTo
+	if m.Address == "" {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(m.Address)
+	ip := net.ParseIP(host)
+	number, portErr := strconv.ParseUint(port, 10, 16)
+	if err != nil || ip == nil || portErr != nil || number == 0 {
+		return errors.New(fmt.Sprintf("Address of MirrorTo is invalid, should be an IP address and port e.g. 192.168.1.53:53: %v", m.Address))
+	}
+	ns := Nameserver{Port: uint16(number)}
+	if ip.To4() != nil {
+		ns.IPv4 = ip.String()
+	} else {
+		ns.IPv6 = ip.String()
+	}
+	if isOwnListener(&ns, config) {
+		return errors.New(fmt.Sprintf("Address of MirrorTo is labns itself, mirrored queries would loop: %v", m.Address))
+	}
+	if m.SampleRate == 0 {
+		m.SampleRate = 1
+	}
+	if m.SampleRate < 0 || m.SampleRate > 1 {
+		return errors.New(fmt.Sprintf("SampleRate of MirrorTo is invalid, should be greater than 0 and at most 1: %v", m.SampleRate))
+	}
+	if len(m.Domains) == 0 {
+		m.Domains = []string{"."}
+	}
+	for i, d := range m.Domains {
+		if d == "." {
+			continue
+		}
+		if err := canonicalizeName(&m.Domains[i]); err != nil {
+			return errors.New(fmt.Sprintf("Domain at index %d of MirrorTo is invalid (%v), should follow pattern domain.name.", i, err))
+		}
+	}
+	return nil
+}
+
+/*
+*	Checks the hook names a program and the names it applies to, applying the default timeout
+ */
+func validateResponseHook(h *ResponseHook) error {
+	if h.Command == nil {
+		return nil
+	}
+	if len(h.Command) == 0 || h.Command[0] == "" {
+		return errors.New("Command of ResponseHook is invalid, should list the program followed by its arguments")
+	}
+	if len(h.Domains) == 0 {
+		return errors.New("Domains of ResponseHook must list at least one domain, use \".\" to run the hook for every name")
+	}
+	for i, d := range h.Domains {
+		if d == "." {
+			continue
+		}
+		if err := canonicalizeName(&h.Domains[i]); err != nil {
+			return errors.New(fmt.Sprintf("Domain at index %d of ResponseHook is invalid (%v), should follow pattern domain.name.", i, err))
+		}
+	}
+	if h.TimeoutMs == 0 {
+		h.TimeoutMs = DEFAULT_RESPONSE_HOOK_TIMEOUT_MS
+	}
+	if h.TimeoutMs > MAX_RESPONSE_HOOK_TIMEOUT_MS {
+		return errors.New(fmt.Sprintf("TimeoutMs of ResponseHook is invalid, should be at most %d: %d", MAX_RESPONSE_HOOK_TIMEOUT_MS, h.TimeoutMs))
+	}
+	return nil
+}
+
+/*
+*	Checks the rewrite names its domains and that the matched and replacement addresses are of the same family
+ */
+func validateAnswerRewrite(index int, r *AnswerRewrite) error {
+	if len(r.Domains) == 0 {
+		return errors.New(fmt.Sprintf("AnswerRewrite at index %d must list at least one domain", index))
+	}
+	for k := range r.Domains {
+		if err := canonicalizeName(&r.Domains[k]); err != nil {
+			return errors.New(fmt.Sprintf("Domain at index %d of AnswerRewrite %d is invalid (%v), should follow pattern domain.name.", k, index, err))
+		}
+	}
+	match, err := ParseClientAddress(r.Match)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Match of AnswerRewrite %d is invalid, should be an IP or CIDR: %v", index, r.Match))
+	}
+	replace := net.ParseIP(r.Replace)
+	if replace == nil {
+		return errors.New(fmt.Sprintf("Replace of AnswerRewrite %d is invalid, should be an IP: %v", index, r.Replace))
+	}
+	if (match.IP.To4() != nil) != (replace.To4() != nil) {
+		return errors.New(fmt.Sprintf("AnswerRewrite %d replaces %s with %s, Match and Replace should both be IPv4 or both be IPv6", index, r.Match, r.Replace))
+	}
+	return nil
+}
+
+/*
+*	Rejects settings that need a part of labns this build was compiled without
+ */
+func validateFeatures(config *Configuration) error {
+	required := []struct {
+		set     bool
+		feature string
+		setting string
+	}{
+		{config.AdminListen != "", "admin", "AdminListen"},
+		{config.AdminSocket != "", "admin", "AdminSocket"},
+		{len(config.ResponseHook.Command) > 0, "integrations", "ResponseHook"},
+		{config.Alerting.WebhookURL != "" || len(config.Alerting.Command) > 0, "integrations", "Alerting"},
+	}
+	for _, r := range required {
+		if !r.set {
+			continue
+		}
+		if err := feature.Require(r.feature, r.setting); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+*	Canonicalizes the names of the overrides and checks none is listed twice, TTLs over a day are allowed but
+*	logged since clients would keep a changed address that long
+ */
+func validateTTLOverrides(overrides []TTLOverride) error {
+	seen := make(map[string]bool)
+	for k := range overrides {
+		o := &overrides[k]
+		name := strings.TrimPrefix(o.Name, "*.")
+		if err := canonicalizeName(&name); err != nil {
+			return errors.New(fmt.Sprintf("Name of TTLOverride %d is invalid (%v), should follow pattern domain.name. or *.domain.name.", k, err))
+		}
+		o.Name = o.Name[:len(o.Name)-len(strings.TrimPrefix(o.Name, "*."))] + name
+		if seen[strings.ToLower(o.Name)] {
+			return errors.New(fmt.Sprintf("Name of TTLOverride %d is listed more than once: %s", k, o.Name))
+		}
+		seen[strings.ToLower(o.Name)] = true
+		if o.TTL == 0 {
+			return errors.New(fmt.Sprintf("TTL of TTLOverride %d is invalid, should be at least 1 second", k))
+		}
+		if o.TTL > TTL_OVERRIDE_WARN_SECONDS {
+			logging.LogMessage(logging.LogWarning, fmt.Sprintf("TTLOverride for %s pins answers to %d seconds, clients will keep a changed address for more than a day", o.Name, o.TTL))
+		}
+	}
+	return nil
+}
+
+/*
+*	Checks profile names are unique, every listener references a defined profile and no address is listened on twice
+ */
+func validateProfiles(config *Configuration, groups map[string]bool) error {
+	profiles := make(map[string]bool)
+	for k := range config.Profiles {
+		p := &config.Profiles[k]
+		if p.Name == "" || profiles[p.Name] {
+			return errors.New(fmt.Sprintf("Name for Profile at index %d must be provided and unique", k))
+		}
+		profiles[p.Name] = true
+		// the lists of a profile replace the global ones and share the same 64 bits of a match
+		if len(p.Blocklists) > 64 {
+			return errors.New(fmt.Sprintf("At most 64 Blocklists may be configured for Profile %s", p.Name))
+		}
+		if err := validateBlocklists(p.Blocklists, groups, " of Profile "+p.Name); err != nil {
+			return err
+		}
+		for i := range p.Allowlist {
+			if err := canonicalizeName(&p.Allowlist[i]); err != nil {
+				return errors.New(fmt.Sprintf("Allowlist entry at index %d of Profile %s is invalid (%v), should follow pattern domain.name.", i, p.Name, err))
+			}
+		}
+		if p.Cache != nil {
+			if p.Cache.MaxEntries == 0 {
+				p.Cache.MaxEntries = DEFAULT_CACHE_MAX_ENTRIES
+			}
+			if p.Cache.MaxTTL == 0 {
+				p.Cache.MaxTTL = DEFAULT_CACHE_MAX_TTL
+			}
+			if p.Cache.MaxEntryBytes == 0 {
+				p.Cache.MaxEntryBytes = DEFAULT_CACHE_MAX_ENTRY_BYTES
+			}
+		}
+	}
+	port := SERVICE_DNS_PORT
+	if port == 0 {
+		port = 53
+	}
+	addresses := make(map[string]bool)
+	values := config.ListenAddresses
+	if config.ListenAddress != "" {
+		values = append([]string{config.ListenAddress}, values...)
+	}
+	for _, v := range values {
+		if addr, err := ParseListenAddress(v, port); err == nil {
+			if addresses[addr.String()] {
+				return errors.New(fmt.Sprintf("ListenAddress %s is listed more than once", v))
+			}
+			addresses[addr.String()] = true
+		}
+	}
+	for k, l := range config.Listeners {
+		if !profiles[l.Profile] {
+			return errors.New(fmt.Sprintf("Listener at index %d references undefined Profile %s", k, l.Profile))
+		}
+		addr, err := ParseListenAddress(l.Address, port)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Address of Listener at index %d is invalid, should be an IP or IP:port such as [fd00::53]:53", k))
+		}
+		if addresses[addr.String()] {
+			return errors.New(fmt.Sprintf("Address %s of Listener at index %d is already listened on", l.Address, k))
+		}
+		addresses[addr.String()] = true
+	}
+	return nil
+}
+
+/*
+*	Checks the blocklists of the configuration or, with owner set, of a profile
+ */
+func validateBlocklists(lists []Blocklist, groups map[string]bool, owner string) error {
+	names := make(map[string]bool)
+	for k, v := range lists {
+		if v.Name != "" {
+			if names[v.Name] {
+				return errors.New(fmt.Sprintf("Name for Blocklist at index %d%s must be unique: %s", k, owner, v.Name))
+			}
+			names[v.Name] = true
+		}
+		if v.Path == "" && len(v.Domains) == 0 {
+			return errors.New(fmt.Sprintf("Path or Domains for Blocklist at index %d%s must be provided", k, owner))
+		}
+		for i, d := range v.Domains {
+			if err := canonicalizeName(&v.Domains[i]); err != nil {
+				return errors.New(fmt.Sprintf("Domain %s for Blocklist at index %d%s is invalid (%v), should follow pattern domain.name.", d, k, owner, err))
+			}
+		}
+		for _, g := range v.Groups {
+			if !groups[g] {
+				return errors.New(fmt.Sprintf("Blocklist at index %d%s references undefined ClientGroup %s", k, owner, g))
+			}
+		}
+		for _, sch := range v.Schedules {
+			if err := ValidateSchedule(&sch); err != nil {
+				return errors.New(fmt.Sprintf("Schedule for Blocklist at index %d%s is invalid: %v", k, owner, err))
+			}
+		}
+		if !isPermitted(PermittedBlocklistFormats, v.Format) {
+			return errors.New(fmt.Sprintf("Format for Blocklist at index %d%s is invalid, should be one of auto, domains, hosts or adguard", k, owner))
+		}
+		if v.BlockResponse != nil {
+			if err := ValidateBlockResponse(v.BlockResponse); err != nil {
+				return errors.New(fmt.Sprintf("BlockResponse for Blocklist at index %d%s is invalid: %v", k, owner, err))
+			}
+		}
+	}
+	return nil
+}
+
+/*
+*	Fills unset rule settings from the global upstream settings and checks them against the same bounds
+ */
+func validateForwardingRule(index int, rule *ForwardingRule, global *UpstreamNameservers) error {
+	if len(rule.Domains) == 0 {
+		return errors.New(fmt.Sprintf("ForwardingRule at index %d must list at least one domain", index))
+	}
+	for k := range rule.Domains {
+		if err := canonicalizeName(&rule.Domains[k]); err != nil {
+			return errors.New(fmt.Sprintf("Domain at index %d of ForwardingRule %d is invalid (%v), should follow pattern domain.name.", k, index, err))
+		}
+	}
+	if len(rule.Nameservers) == 0 {
+		return errors.New(fmt.Sprintf("ForwardingRule at index %d must list at least one nameserver", index))
+	}
+	for k := range rule.Nameservers {
+		if err := ValidateNameserver(&rule.Nameservers[k]); err != nil {
+			return errors.New(fmt.Sprintf("Nameserver at index %d of ForwardingRule %d is invalid: %v", k, index, err))
+		}
+	}
+	if rule.TimeoutMs == 0 {
+		rule.TimeoutMs = global.TimeoutMs
+	}
+	if rule.Strategy == "" {
+		rule.Strategy = global.Strategy
+	}
+	if rule.Retries == nil {
+		retries := global.Retries
+		rule.Retries = &retries
+	}
+	return validateUpstreamSettings(fmt.Sprintf("ForwardingRule at index %d", index), rule.TimeoutMs, rule.Strategy, *rule.Retries)
+}
+
+/*
+*	Checks the range is not too large to name, the template has exactly one placeholder that fits the range and
+*	renders valid names, and applies DefaultLocalTTL and the both direction when unset
+ */
+func validateGeneratedRange(index int, g *GeneratedRange, defaultTTL uint32) error {
+	_, network, err := net.ParseCIDR(g.CIDR)
+	if err != nil {
+		return errors.New(fmt.Sprintf("CIDR of GeneratedRange %d is invalid: %v", index, g.CIDR))
+	}
+	ones, bits := network.Mask.Size()
+	if bits == 128 && ones < MIN_GENERATED_IPV6_PREFIX {
+		return errors.New(fmt.Sprintf("CIDR of GeneratedRange %d is too large, IPv6 ranges should be /%d or smaller: %v", index, MIN_GENERATED_IPV6_PREFIX, g.CIDR))
+	}
+	g.Template = strings.ToLower(g.Template)
+	placeholders := strings.Count(g.Template, GENERATED_IP_DASHED) + strings.Count(g.Template, GENERATED_LAST_OCTET)
+	if placeholders != 1 {
+		return errors.New(fmt.Sprintf("Template of GeneratedRange %d is invalid, should contain exactly one of %s or %s: %v", index, GENERATED_IP_DASHED, GENERATED_LAST_OCTET, g.Template))
+	}
+	if strings.Contains(g.Template, GENERATED_LAST_OCTET) && (bits != 32 || ones < 24) {
+		return errors.New(fmt.Sprintf("Template of GeneratedRange %d uses %s, which only names addresses uniquely in IPv4 ranges of /24 or smaller", index, GENERATED_LAST_OCTET))
+	}
+	// a sample with the longest IPv6 rendering checks the labels stay within bounds
+	sample := strings.NewReplacer(GENERATED_IP_DASHED, "ffff-ffff-ffff-ffff-ffff-ffff-ffff-ffff", GENERATED_LAST_OCTET, "255").Replace(g.Template)
+	if err := validateRecordName(sample); err != nil {
+		return errors.New(fmt.Sprintf("Template of GeneratedRange %d is invalid (%v), should render names following pattern domain.name.", index, err))
+	}
+	if !isPermitted(PermittedDirections, g.Direction) {
+		return errors.New(fmt.Sprintf("Direction of GeneratedRange %d is invalid, should be one of forward, reverse or both", index))
+	}
+	if g.Direction == "" {
+		g.Direction = "both"
+	}
+	if g.TTL == nil {
+		ttl := defaultTTL
+		g.TTL = &ttl
+	}
+	return nil
+}
+
+/*
+*	Secondary may be left out, a zero Nameserver, for a single global upstream
+ */
+func (u *UpstreamNameservers) HasSecondary() bool {
+	return u.Secondary != Nameserver{}
+}
+
+/*
+*	The global upstreams in order, Primary alone when there is no Secondary
+ */
+func (u *UpstreamNameservers) Nameservers() []Nameserver {
+	if !u.HasSecondary() {
+		return []Nameserver{u.Primary}
+	}
+	return []Nameserver{u.Primary, u.Secondary}
+}
+
+/*
+*	The glue addresses of every nameserver of the delegation, where a recursing delegation sends its queries
+ */
+func (d *Delegation) Upstreams() []Nameserver {
+	var out []Nameserver
+	for _, ns := range d.Nameservers {
+		for _, addr := range ns.Addresses {
+			if ip := net.ParseIP(addr); ip.To4() != nil {
+				out = append(out, Nameserver{IPv4: addr, Port: d.Port})
+			} else {
+				out = append(out, Nameserver{IPv6: addr, Port: d.Port})
+			}
+		}
+	}
+	return out
+}
+
+/*
+*	Checks every delegated zone is named once with at least one nameserver, that nameservers inside their zone have
+*	glue addresses and that recursing delegations have an address to send to that isn't labns itself
+ */
+func validateDelegations(config *Configuration) error {
+	seen := make(map[string]bool)
+	for k := range config.Delegations {
+		d := &config.Delegations[k]
+		if err := canonicalizeName(&d.Zone); err != nil || d.Zone == "." {
+			return errors.New(fmt.Sprintf("Zone of Delegation at index %d is invalid, should follow pattern domain.name.: %v", k, d.Zone))
+		}
+		if seen[d.Zone] {
+			return errors.New(fmt.Sprintf("Delegation at index %d repeats the zone %s", k, d.Zone))
+		}
+		seen[d.Zone] = true
+		if len(d.Nameservers) == 0 {
+			return errors.New(fmt.Sprintf("Delegation of %s must list at least one nameserver", d.Zone))
+		}
+		if d.TTL == 0 {
+			d.TTL = DEFAULT_DELEGATION_TTL
+		}
+		if d.Port == 0 {
+			d.Port = 53
+		}
+		for i := range d.Nameservers {
+			ns := &d.Nameservers[i]
+			if err := canonicalizeName(&ns.Name); err != nil {
+				return errors.New(fmt.Sprintf("Name of nameserver %d of Delegation %s is invalid (%v), should follow pattern domain.name.", i, d.Zone, err))
+			}
+			if len(ns.Addresses) == 0 && (ns.Name == d.Zone || strings.HasSuffix(ns.Name, "."+d.Zone)) {
+				return errors.New(fmt.Sprintf("Nameserver %s of Delegation %s is inside the delegated zone and needs glue Addresses", ns.Name, d.Zone))
+			}
+			for _, addr := range ns.Addresses {
+				if net.ParseIP(addr) == nil {
+					return errors.New(fmt.Sprintf("Address %s of nameserver %s of Delegation %s is invalid, should be an IPv4 or IPv6 address", addr, ns.Name, d.Zone))
+				}
+			}
+		}
+		upstreams := d.Upstreams()
+		for i := range upstreams {
+			if d.Recurse && isOwnListener(&upstreams[i], config) {
+				return errors.New(fmt.Sprintf("Nameserver %s of Delegation %s is labns itself, queries would loop", nameserverAddress(&upstreams[i]), d.Zone))
+			}
+		}
+		if d.Recurse && len(upstreams) == 0 {
+			return errors.New(fmt.Sprintf("Delegation of %s has Recurse set but none of its nameservers list Addresses to send queries to", d.Zone))
+		}
+	}
+	return nil
+}
+
+/*
+*	Rejects a strategy labns doesn't have and logs a warning for each setting that works but is unlikely to be meant
+ */
+func validateUpstreamSettings(name string, timeoutMs uint16, strategy string, retries uint8) error {
+	if !isPermitted(PermittedStrategies, strategy) {
+		return errors.New(fmt.Sprintf("Strategy of %s is invalid, should be one of failover or race", name))
+	}
+	for _, warning := range upstreamSettingWarnings(name, timeoutMs, retries) {
+		logging.LogMessage(logging.LogWarning, warning)
+	}
+	return nil
+}
+
+/*
+*	The advisory checks of upstream settings, a timeout outside the range upstreams answer in and more retries than
+*	a client waits for are accepted as configured
+ */
+func upstreamSettingWarnings(name string, timeoutMs uint16, retries uint8) []string {
+	var warnings []string
+	if timeoutMs < MIN_UPSTREAM_TIMEOUT_MS {
+		warnings = append(warnings, fmt.Sprintf("TimeoutMs of %s is %d, upstreams further than the local network rarely answer within %dms and queries will fail over or time out", name, timeoutMs, MIN_UPSTREAM_TIMEOUT_MS))
+	}
+	if timeoutMs > MAX_UPSTREAM_TIMEOUT_MS {
+		warnings = append(warnings, fmt.Sprintf("TimeoutMs of %s is %d, clients give up long before an upstream that slow answers", name, timeoutMs))
+	}
+	if retries > MAX_UPSTREAM_RETRIES {
+		warnings = append(warnings, fmt.Sprintf("Retries of %s is %d, more than %d repeats keeps a failing query open long after the client gave up", name, retries, MAX_UPSTREAM_RETRIES))
+	}
+	return warnings
+}
+
+/*
+*	Reports whether ns is one of the addresses labns answers on, queries forwarded there would come straight back
+ */
+func isOwnListener(ns *Nameserver, config *Configuration) bool {
+	if SERVICE_DNS_PORT == 0 {
+		return false
+	}
+	ip := net.ParseIP(ns.IPv4)
+	if ip == nil {
+		ip = net.ParseIP(ns.IPv6)
+	}
+	values := config.ListenAddresses
+	if config.ListenAddress != "" {
+		values = append([]string{config.ListenAddress}, values...)
+	}
+	if len(values) == 0 && len(config.ListenInterfaces) == 0 && len(config.Listeners) == 0 {
+		values = []string{"::"}
+	}
+	for _, l := range config.Listeners {
+		values = append(values, l.Address)
+	}
+	for _, v := range values {
+		addr, err := ParseListenAddress(v, SERVICE_DNS_PORT)
+		if err != nil || addr.Port != int(ns.Port) {
+			continue
+		}
+		if addr.IP.Equal(ip) || (addr.IP.IsUnspecified() && isHostAddress(ip, "")) {
+			return true
+		}
+	}
+	if int(ns.Port) == int(SERVICE_DNS_PORT) {
+		for _, name := range config.ListenInterfaces {
+			if isHostAddress(ip, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+*	Reports whether ip is assigned to the named interface, or to any interface when name is empty
+ */
+func isHostAddress(ip net.IP, name string) bool {
+	if ip.IsLoopback() && name == "" {
+		return true
+	}
+	var addrs []net.Addr
+	var err error
+	if name == "" {
+		addrs, err = net.InterfaceAddrs()
+	} else {
+		var iface *net.Interface
+		if iface, err = net.InterfaceByName(name); err == nil {
+			addrs, err = iface.Addrs()
+		}
+	}
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+*	Reports whether a and b send queries to the same IP and port from the same source, however the addresses are written
+ */
+func sameNameserver(a *Nameserver, b *Nameserver) bool {
+	if a.Port != b.Port || a.BindAddress != b.BindAddress || a.BindInterface != b.BindInterface {
+		return false
+	}
+	ip := func(ns *Nameserver) net.IP {
+		if ns.IPv4 != "" {
+			return net.ParseIP(ns.IPv4)
+		}
+		return net.ParseIP(ns.IPv6)
+	}
+	return ip(a) != nil && ip(a).Equal(ip(b))
+}
+
+func nameserverAddress(ns *Nameserver) string {
+	if ns.IPv4 != "" {
+		return net.JoinHostPort(ns.IPv4, strconv.Itoa(int(ns.Port)))
+	}
+	return net.JoinHostPort(ns.IPv6, strconv.Itoa(int(ns.Port)))
+}
+
+/*
+*	Checks the source address parses, matches the upstream address family and is assigned to this host
+ */
+func validateBindAddress(ns *Nameserver) error {
+	bind := net.ParseIP(ns.BindAddress)
+	if bind == nil {
+		return errors.New(fmt.Sprintf("BindAddress of upstream nameserver is invalid: %v", ns.BindAddress))
+	}
+	if (bind.To4() != nil) != (ns.IPv4 != "") {
+		return errors.New(fmt.Sprintf("BindAddress %v of upstream nameserver is not the same address family as the nameserver", ns.BindAddress))
+	}
+	local, err := net.InterfaceAddrs()
+	if err != nil {
+		return err
+	}
+	for _, a := range local {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(bind) {
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprintf("BindAddress %v of upstream nameserver is not assigned to any interface on this host", ns.BindAddress))
+}
+
+/*
+*	Tracks whether TTL was present in the JSON so a missing TTL can take DefaultLocalTTL while an explicit 0 is kept
+ */
+func (r *LocalDNSRecord) UnmarshalJSON(data []byte) error {
+	type plain LocalDNSRecord
+	aux := struct {
+		*plain
+		TTL *uint32
+	}{plain: (*plain)(r)}
+	err := json.Unmarshal(data, &aux)
+	if err != nil {
+		return err
+	}
+	if aux.TTL != nil {
+		r.TTL = *aux.TTL
+		r.ttlSet = true
+	}
+	return nil
+}
+
+/*
+*	The wire type of a record, RAW records carry their own numeric type
+ */
+func (r *LocalDNSRecord) QueryType() dnsmessage.Type {
+	if r.Type == "RAW" {
+		return dnsmessage.Type(r.RRType)
+	}
+	return RecordTypeMap[r.Type]
+}
+
+func ValidateRawRecord(r *LocalDNSRecord) error {
+	switch {
+	case r.RRType == 0:
+		return errors.New("RRType must be provided")
+	case r.RRType == uint16(dnsmessage.TypeOPT) || (r.RRType >= 128 && r.RRType <= 255):
+		return errors.New(fmt.Sprintf("RRType %d is a meta or query type and cannot be served", r.RRType))
+	}
+	data, err := DecodeRData(r.RData)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 || len(data) > MAX_RAW_RDATA_LENGTH {
+		return errors.New(fmt.Sprintf("RData must decode to between 1 and %d bytes, got %d", MAX_RAW_RDATA_LENGTH, len(data)))
+	}
+	return nil
+}
+
+/*
+*	RDATA is hex by default (spaces and colons ignored) or base64 when prefixed with "base64:"
+ */
+func DecodeRData(value string) ([]byte, error) {
+	if strings.HasPrefix(value, "base64:") {
+		data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, "base64:"))
+		if err != nil {
+			return nil, errors.New("RData is not valid base64: " + err.Error())
+		}
+		return data, nil
+	}
+	data, err := hex.DecodeString(strings.NewReplacer(" ", "", ":", "").Replace(strings.TrimPrefix(value, "hex:")))
+	if err != nil {
+		return nil, errors.New("RData is not valid hex: " + err.Error())
+	}
+	return data, nil
+}
+
+func ValidateSvcParams(svc *SvcParams) error {
+	if svc == nil || svc.Priority == 0 {
+		if svc != nil && (len(svc.Alpn) > 0 || svc.Port != 0 || len(svc.IPv4Hint) > 0 || len(svc.IPv6Hint) > 0) {
+			return errors.New("AliasMode (Priority 0) records cannot have parameters")
+		}
+		return nil
+	}
+	for _, a := range svc.Alpn {
+		if a == "" || len(a) > 255 {
+			return errors.New(fmt.Sprintf("alpn value %q is invalid", a))
+		}
+	}
+	for _, ip := range svc.IPv4Hint {
+		if net.ParseIP(ip).To4() == nil {
+			return errors.New("ipv4hint is invalid: " + ip)
+		}
+	}
+	for _, ip := range svc.IPv6Hint {
+		if net.ParseIP(ip) == nil || net.ParseIP(ip).To4() != nil {
+			return errors.New("ipv6hint is invalid: " + ip)
+		}
+	}
+	return nil
+}
+
+/*
+*	Checks the SSHFP, TLSA and HINFO fields are set on, and only on, records of their own type
+ */
+func validateTypedRecord(r *LocalDNSRecord) error {
+	if (r.SSHFP != nil) != (r.Type == "SSHFP") || (r.TLSA != nil) != (r.Type == "TLSA") || (r.HINFO != nil) != (r.Type == "HINFO") {
+		return errors.New("SSHFP, TLSA and HINFO fields are required on, and only permitted on, records of that type")
+	}
+	switch r.Type {
+	case "SSHFP":
+		if r.SSHFP.Algorithm == 0 || r.SSHFP.Algorithm > 6 || r.SSHFP.Algorithm == 5 {
+			return errors.New(fmt.Sprintf("SSHFP Algorithm %d is invalid, should be 1 (RSA), 2 (DSA), 3 (ECDSA), 4 (Ed25519) or 6 (Ed448)", r.SSHFP.Algorithm))
+		}
+		sizes := map[uint8]int{1: 20, 2: 32}
+		size, ok := sizes[r.SSHFP.FingerprintType]
+		if !ok {
+			return errors.New(fmt.Sprintf("SSHFP FingerprintType %d is invalid, should be 1 (SHA-1) or 2 (SHA-256)", r.SSHFP.FingerprintType))
+		}
+		data, err := DecodeHex(r.SSHFP.Fingerprint)
+		if err != nil {
+			return errors.New("SSHFP Fingerprint " + err.Error())
+		}
+		if len(data) != size {
+			return errors.New(fmt.Sprintf("SSHFP Fingerprint should be %d bytes for FingerprintType %d, got %d", size, r.SSHFP.FingerprintType, len(data)))
+		}
+	case "TLSA":
+		if r.TLSA.Usage > 3 {
+			return errors.New(fmt.Sprintf("TLSA Usage %d is invalid, should be between 0 and 3", r.TLSA.Usage))
+		}
+		if r.TLSA.Selector > 1 {
+			return errors.New(fmt.Sprintf("TLSA Selector %d is invalid, should be 0 or 1", r.TLSA.Selector))
+		}
+		if r.TLSA.MatchingType > 2 {
+			return errors.New(fmt.Sprintf("TLSA MatchingType %d is invalid, should be between 0 and 2", r.TLSA.MatchingType))
+		}
+		data, err := DecodeHex(r.TLSA.CertData)
+		if err != nil {
+			return errors.New("TLSA CertData " + err.Error())
+		}
+		sizes := map[uint8]int{1: 32, 2: 64}
+		if size, ok := sizes[r.TLSA.MatchingType]; ok && len(data) != size {
+			return errors.New(fmt.Sprintf("TLSA CertData should be %d bytes for MatchingType %d, got %d", size, r.TLSA.MatchingType, len(data)))
+		}
+		if len(data) == 0 || len(data) > MAX_RAW_RDATA_LENGTH {
+			return errors.New(fmt.Sprintf("TLSA CertData should be between 1 and %d bytes, got %d", MAX_RAW_RDATA_LENGTH, len(data)))
+		}
+	case "HINFO":
+		if len(r.HINFO.CPU) > 255 || len(r.HINFO.OS) > 255 {
+			return errors.New("HINFO CPU and OS should be at most 255 characters")
+		}
+	}
+	return nil
+}
+
+/*
+*	Decodes a hex string, spaces and colons between bytes are ignored
+ */
+func DecodeHex(value string) ([]byte, error) {
+	data, err := hex.DecodeString(strings.NewReplacer(" ", "", ":", "").Replace(value))
+	if err != nil {
+		return nil, errors.New("is not valid hex: " + err.Error())
+	}
+	return data, nil
+}
+
+func ValidateBlockResponse(br *BlockResponse) error {
+	if !isPermitted(PermittedBlockModes, br.Mode) {
+		return errors.New(fmt.Sprintf("BlockResponse mode %s is invalid, should be one of nxdomain, null, refused or custom", br.Mode))
+	}
+	if br.Mode == "custom" && br.IPv4 == "" && br.IPv6 == "" {
+		return errors.New("BlockResponse mode custom requires at least one of IPv4 or IPv6")
+	}
+	if br.IPv4 != "" && net.ParseIP(br.IPv4).To4() == nil {
+		return errors.New(fmt.Sprintf("BlockResponse IPv4 is invalid: %v", br.IPv4))
+	}
+	if br.IPv6 != "" && (net.ParseIP(br.IPv6) == nil || net.ParseIP(br.IPv6).To4() != nil) {
+		return errors.New(fmt.Sprintf("BlockResponse IPv6 is invalid: %v", br.IPv6))
+	}
+	return nil
+}
+
+func ValidateSchedule(sch *Schedule) error {
+	for _, d := range sch.Days {
+		if _, ok := DayMap[strings.ToLower(d)]; !ok {
+			return errors.New(fmt.Sprintf("day %s is invalid, should be one of mon, tue, wed, thu, fri, sat or sun", d))
+		}
+	}
+	start, err := ParseClock(sch.Start)
+	if err != nil {
+		return err
+	}
+	end, err := ParseClock(sch.End)
+	if err != nil {
+		return err
+	}
+	if start == end {
+		return errors.New("start and end must differ")
+	}
+	return nil
+}
+
+/*
+*	Parses a 24 hour HH:MM local time into minutes past midnight
+ */
+func ParseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("time %s is invalid, should be HH:MM", value))
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func ParseClientAddress(value string) (*net.IPNet, error) {
+	if strings.Contains(value, "/") {
+		_, ipNet, err := net.ParseCIDR(value)
+		return ipNet, err
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, errors.New("invalid IP " + value)
+	}
+	if ip.To4() != nil {
+		return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+/*
+*	Parses "::", "10.0.0.2", "10.0.0.2:53" or "[fd00::53]:53", using defaultPort when none is given
+ */
+func ParseListenAddress(value string, defaultPort uint16) (*net.UDPAddr, error) {
+	if ip := net.ParseIP(value); ip != nil {
+		return &net.UDPAddr{IP: ip, Port: int(defaultPort)}, nil
+	}
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil && host != "" {
+		return nil, errors.New("invalid IP " + host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: ip, Port: int(p)}, nil
+}
+
+/*
+*	Replaces name with its canonical form, the error says why it cannot be canonicalized or is not a valid name
+ */
+func canonicalizeName(name *string) error {
+	canonical, err := dnsname.Canonical(*name)
+	if err != nil {
+		return err
+	}
+	if err := validateRecordName(canonical); err != nil {
+		return err
+	}
+	*name = canonical
+	return nil
+}
+
+/*
+*	Same as canonicalizeName for settings that name a domain below the root, where "." is not accepted
+ */
+func canonicalizeDomain(name *string) error {
+	if err := canonicalizeName(name); err != nil {
+		return err
+	}
+	if *name == "." {
+		return errors.New("the root . is not permitted here")
+	}
+	return nil
+}
+
+/*
+*	Checks name against VALID_FQDN_REGEX, a name that does not match is scanned again to report what is wrong with it
+ */
+func validateRecordName(name string) error {
+	if validFQDN.MatchString(name) {
+		return nil
+	}
+	if !strings.HasSuffix(name, ".") {
+		return errors.New("missing trailing dot")
+	}
+	for i, c := range name {
+		if !isNameCharacter(c) {
+			return errors.New(fmt.Sprintf("illegal character %q at position %d", c, i))
+		}
+	}
+	return errors.New("name does not match " + VALID_FQDN_REGEX)
+}
+
+func isNameCharacter(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == '.' || c == '-'
+}
+
+func isValidType(parsedType string) bool {
+	return isPermitted(PermittedRecordTypes, parsedType)
+}
+
+/*
+*	Accepts name as a Nameserver Protocol, for transports registered by programs embedding labns
+ */
+func RegisterProtocol(name string) {
+	if !isPermitted(PermittedProtocols, name) {
+		PermittedProtocols = append(PermittedProtocols, name)
+	}
+}
+
+func isPermitted(permitted []string, value string) bool {
+	for _, v := range permitted {
+		if value == v {
+			return true
 		}
-		return matched
 	}
 	return false
 }
+
+/*
+*	Checks the target suits the record type, the error says what is wrong with it.
+*	Note: Poor approximation of what is actually a valid FQDN for a CNAME records
+ */
+func validateTarget(parsedType string, parsedTarget string) error {
+	switch parsedType {
+	case "RAW", "HINFO", "SSHFP", "TLSA", "NULL":
+		if parsedTarget != "" {
+			return errors.New(fmt.Sprintf("%s records take no Target", parsedType))
+		}
+	case "A":
+		if net.ParseIP(parsedTarget).To4() == nil {
+			return errors.New(fmt.Sprintf("%q is not an IPv4 address", parsedTarget))
+		}
+	case "AAAA":
+		if net.ParseIP(parsedTarget).To16() == nil {
+			return errors.New(fmt.Sprintf("%q is not an IPv6 address", parsedTarget))
+		}
+	case "SVCB", "HTTPS":
+		return validateRecordName(parsedTarget)
+	case "CNAME":
+		if err := validateRecordName(parsedTarget); err != nil {
+			return err
+		}
+		if i := strings.Index(parsedTarget, ".."); i >= 0 {
+			return errors.New(fmt.Sprintf("empty label at position %d", i+1))
+		}
+	default:
+		return errors.New(fmt.Sprintf("type %s takes no Target", parsedType))
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/TasSM/labns/internal/logging"
+)
+
+func TestMain(m *testing.M) {
+	go logging.InitLogging(os.DevNull)
+	os.Exit(m.Run())
+}
+
+/*
+*	A configuration with a primary and secondary upstream and the given settings of UpstreamNameservers
+ */
+func upstreamConfig(settings string) string {
+	return `{"UpstreamNameservers": {"Primary": {"IPv4": "198.51.100.1"}, "Secondary": {"IPv4": "198.51.100.2"}` + settings + `}}`
+}
+
+func TestUpstreamSettingWarnings(t *testing.T) {
+	cases := []struct {
+		name      string
+		timeoutMs uint16
+		retries   uint8
+		want      string
+	}{
+		{"in range", 2000, 1, ""},
+		{"the lowest advised timeout", MIN_UPSTREAM_TIMEOUT_MS, 0, ""},
+		{"the highest advised timeout", MAX_UPSTREAM_TIMEOUT_MS, MAX_UPSTREAM_RETRIES, ""},
+		{"timeout too short", MIN_UPSTREAM_TIMEOUT_MS - 1, 0, "TimeoutMs of test is 49"},
+		{"timeout too long", MAX_UPSTREAM_TIMEOUT_MS + 1, 0, "TimeoutMs of test is 30001"},
+		{"too many retries", 2000, MAX_UPSTREAM_RETRIES + 1, "Retries of test is 6"},
+	}
+	for _, c := range cases {
+		warnings := upstreamSettingWarnings("test", c.timeoutMs, c.retries)
+		if c.want == "" {
+			if len(warnings) != 0 {
+				t.Errorf("%s: got warnings %q, want none", c.name, warnings)
+			}
+			continue
+		}
+		if len(warnings) != 1 || !strings.HasPrefix(warnings[0], c.want) {
+			t.Errorf("%s: got warnings %q, want one starting %q", c.name, warnings, c.want)
+		}
+	}
+	if warnings := upstreamSettingWarnings("test", 10, 200); len(warnings) != 2 {
+		t.Errorf("short timeout and too many retries gave %d warnings, want 2", len(warnings))
+	}
+}
+
+/*
+*	Settings that only draw a warning still load, a config that worked before the checks existed keeps starting
+ */
+func TestAdvisoryUpstreamSettingsLoad(t *testing.T) {
+	for _, settings := range []string{`, "TimeoutMs": 20`, `, "TimeoutMs": 60000`, `, "Retries": 9`} {
+		conf, err := ReadConfig(strings.NewReader(upstreamConfig(settings)))
+		if err != nil {
+			t.Errorf("config with %s was rejected: %v", settings, err)
+			continue
+		}
+		if settings == `, "TimeoutMs": 20` && conf.UpstreamNameservers.TimeoutMs != 20 {
+			t.Errorf("TimeoutMs was changed to %d, want it kept at 20", conf.UpstreamNameservers.TimeoutMs)
+		}
+	}
+}
+
+func TestUnknownStrategyIsRejected(t *testing.T) {
+	_, err := ReadConfig(strings.NewReader(upstreamConfig(`, "Strategy": "roundrobin"`)))
+	if err == nil || !strings.Contains(err.Error(), "Strategy of UpstreamNameservers is invalid") {
+		t.Fatalf("got %v, want the strategy rejected", err)
+	}
+	rule := `{"UpstreamNameservers": {"Primary": {"IPv4": "198.51.100.1"}, "Secondary": {"IPv4": "198.51.100.2"}},
+		"ForwardingRules": [{"Domains": ["corp.example.com."], "Nameservers": [{"IPv4": "10.8.0.1"}], "Strategy": "random"}]}`
+	if _, err := ReadConfig(strings.NewReader(rule)); err == nil || !strings.Contains(err.Error(), "ForwardingRule at index 0") {
+		t.Fatalf("got %v, want the rule's strategy rejected", err)
+	}
+}
+
+func TestSameNameserver(t *testing.T) {
+	cases := []struct {
+		a, b Nameserver
+		same bool
+	}{
+		{Nameserver{IPv4: "198.51.100.1"}, Nameserver{IPv4: "198.51.100.1"}, true},
+		{Nameserver{IPv6: "2001:db8::1"}, Nameserver{IPv6: "2001:0db8:0:0::1"}, true},
+		{Nameserver{IPv4: "198.51.100.1"}, Nameserver{IPv4: "198.51.100.2"}, false},
+		{Nameserver{IPv4: "198.51.100.1", Port: 53}, Nameserver{IPv4: "198.51.100.1", Port: 5353}, false},
+		{Nameserver{IPv4: "198.51.100.1", BindInterface: "eth0"}, Nameserver{IPv4: "198.51.100.1", BindInterface: "wg0"}, false},
+	}
+	for _, c := range cases {
+		if got := sameNameserver(&c.a, &c.b); got != c.same {
+			t.Errorf("sameNameserver(%+v, %+v) = %t, want %t", c.a, c.b, got, c.same)
+		}
+	}
+	// identical upstreams are worth a warning, not a failed start
+	same := `{"UpstreamNameservers": {"Primary": {"IPv4": "198.51.100.1"}, "Secondary": {"IPv4": "198.51.100.1"}}}`
+	if _, err := ReadConfig(strings.NewReader(same)); err != nil {
+		t.Fatalf("config with identical upstreams was rejected: %v", err)
+	}
+}
+
+func TestAdminAccessDefaultsToRead(t *testing.T) {
+	a := AdminAccess{}
+	if err := validateAdminAccess(&a); err != nil {
+		t.Fatal(err)
+	}
+	if a.DefaultCapability != "admin-read" {
+		t.Fatalf("DefaultCapability defaults to %q, want admin-read", a.DefaultCapability)
+	}
+	a = AdminAccess{DefaultCapability: "admin-write"}
+	if err := validateAdminAccess(&a); err != nil || a.DefaultCapability != "admin-write" {
+		t.Fatalf("explicit admin-write became %q (%v)", a.DefaultCapability, err)
+	}
+}
+
+func TestSecondaryIsOptional(t *testing.T) {
+	conf, err := ReadConfig(strings.NewReader(`{"UpstreamNameservers": {"Primary": {"IPv4": "198.51.100.1"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.UpstreamNameservers.HasSecondary() {
+		t.Fatalf("secondary %+v is set, want it left out", conf.UpstreamNameservers.Secondary)
+	}
+	if got := conf.UpstreamNameservers.Nameservers(); len(got) != 1 || got[0].IPv4 != "198.51.100.1" || got[0].Port != 53 {
+		t.Fatalf("global upstreams are %+v, want the primary alone on port 53", got)
+	}
+
+	conf, err = ReadConfig(strings.NewReader(upstreamConfig("")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := conf.UpstreamNameservers.Nameservers(); len(got) != 2 || got[1].IPv4 != "198.51.100.2" {
+		t.Fatalf("global upstreams are %+v, want the primary and secondary", got)
+	}
+
+	// a secondary with settings but no address is a mistake rather than left out
+	_, err = ReadConfig(strings.NewReader(`{"UpstreamNameservers": {"Primary": {"IPv4": "198.51.100.1"}, "Secondary": {"Port": 5353}}}`))
+	if err == nil || !strings.Contains(err.Error(), "must be provided") {
+		t.Fatalf("secondary without an address loaded with error %v", err)
+	}
+	if _, err := ReadConfig(strings.NewReader(`{"UpstreamNameservers": {"Secondary": {"IPv4": "198.51.100.2"}}}`)); err == nil {
+		t.Fatal("configuration without a primary loaded")
+	}
+}
+
+func TestBlocklistNamesAreUnique(t *testing.T) {
+	upstreams := `"UpstreamNameservers": {"Primary": {"IPv4": "198.51.100.1"}}`
+	dup := `{` + upstreams + `, "Blocklists": [{"Name": "ads", "Domains": ["a.example."]}, {"Domains": ["b.example."]}, {"Name": "ads", "Domains": ["c.example."]}]}`
+	if _, err := ReadConfig(strings.NewReader(dup)); err == nil || !strings.Contains(err.Error(), "Name for Blocklist at index 2 must be unique") {
+		t.Fatalf("got %v, want the repeated name rejected", err)
+	}
+	// a profile replacing the lists may reuse a name, pausing it pauses both
+	profile := `{` + upstreams + `, "Blocklists": [{"Name": "ads", "Domains": ["a.example."]}, {"Domains": ["b.example."]}],
+		"Profiles": [{"Name": "kids", "Blocklists": [{"Name": "ads", "Domains": ["a.example.", "c.example."]}]}]}`
+	if _, err := ReadConfig(strings.NewReader(profile)); err != nil {
+		t.Fatalf("config with a profile list sharing a name was rejected: %v", err)
+	}
+}
+
+func TestAtMost64Blocklists(t *testing.T) {
+	lists := func(n int) string {
+		out := make([]string, n)
+		for k := range out {
+			out[k] = fmt.Sprintf(`{"Domains": ["list%d.example."]}`, k)
+		}
+		return "[" + strings.Join(out, ", ") + "]"
+	}
+	upstreams := `"UpstreamNameservers": {"Primary": {"IPv4": "198.51.100.1"}}`
+	cases := []struct {
+		conf string
+		err  string
+	}{
+		{`{` + upstreams + `, "Blocklists": ` + lists(64) + `, "Profiles": [{"Name": "kids", "Blocklists": ` + lists(64) + `}]}`, ""},
+		{`{` + upstreams + `, "Blocklists": ` + lists(65) + `}`, "At most 64 Blocklists may be configured"},
+		{`{` + upstreams + `, "Profiles": [{"Name": "kids", "Blocklists": ` + lists(65) + `}]}`, "At most 64 Blocklists may be configured for Profile kids"},
+	}
+	for _, c := range cases {
+		_, err := ReadConfig(strings.NewReader(c.conf))
+		if c.err == "" && err != nil {
+			t.Errorf("64 blocklists were rejected: %v", err)
+		}
+		if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("got %v, want %q", err, c.err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/*
+*	Reads StrictConfig from the top level of the file before the full decode, a missing or unreadable value means on
+ */
+func strictConfig(data []byte) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return true
+	}
+	for key, value := range fields {
+		if strings.EqualFold(key, "StrictConfig") {
+			var strict bool
+			if err := json.Unmarshal(value, &strict); err == nil {
+				return strict
+			}
+		}
+	}
+	return true
+}
+
+/*
+*	Walks data alongside the type it decodes into and reports the first key that matches no field, with its path
+*	such as LocalRecords[3].Ttl. Keys match fields case-insensitively, as encoding/json matches them. Values of the
+*	wrong type are left for the decoder to report
+ */
+func findUnknownField(data json.RawMessage, t reflect.Type, path string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil
+		}
+		for _, key := range sortedKeys(fields) {
+			field, ok := jsonField(t, key)
+			if !ok {
+				if path == "" {
+					return errors.New(fmt.Sprintf("unknown field %q at the top level", key))
+				}
+				return errors.New(fmt.Sprintf("unknown field %q in %s", key, path))
+			}
+			next := field.Name
+			if path != "" {
+				next = path + "." + field.Name
+			}
+			if err := findUnknownField(fields[key], field.Type, next); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil
+		}
+		for i, item := range items {
+			if err := findUnknownField(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		var items map[string]json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil
+		}
+		for _, key := range sortedKeys(items) {
+			if err := findUnknownField(items[key], t.Elem(), fmt.Sprintf("%s[%q]", path, key)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func sortedKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,85 @@
+package dnsname
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	MAX_LABEL_LENGTH = 63
+	MAX_NAME_LENGTH  = 253
+)
+
+/*
+*	Returns the canonical form of a domain name: Unicode labels as IDNA2008 A-labels (xn--), lowercase and with a
+*	trailing dot, so "NAS.Lab.Home", "nas.lab.home." and "nas.lab.home" are all "nas.lab.home."
+ */
+func Canonical(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "." {
+		return name, nil
+	}
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return "", errors.New("name is empty")
+	}
+	if !isASCII(name) {
+		ascii, err := idna.Lookup.ToASCII(name)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a valid internationalized name: %v", name, err)
+		}
+		name = ascii
+	}
+	name = strings.ToLower(name)
+	if len(name) > MAX_NAME_LENGTH {
+		return "", fmt.Errorf("%q is longer than %d characters", name, MAX_NAME_LENGTH)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			return "", fmt.Errorf("%q has an empty label", name)
+		}
+		if len(label) > MAX_LABEL_LENGTH {
+			return "", fmt.Errorf("label %q is longer than %d characters", label, MAX_LABEL_LENGTH)
+		}
+	}
+	return name + ".", nil
+}
+
+/*
+*	Returns the lookup key of a name that is already in wire form, such as a query name: lowercase with a trailing dot.
+*	Unlike Canonical it does no validation so it is cheap enough for every query
+ */
+func Key(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+/*
+*	Returns the Unicode form of a canonical name for display, names without A-labels are returned unchanged
+ */
+func Display(name string) string {
+	if !strings.Contains(name, "xn--") {
+		return name
+	}
+	unicode, err := idna.Lookup.ToUnicode(name)
+	if err != nil {
+		return name
+	}
+	return unicode
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package dnsname
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCanonical(t *testing.T) {
+	long := strings.Repeat("a", MAX_LABEL_LENGTH)
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"nas.lab.home.", "nas.lab.home."},
+		{"nas.lab.home", "nas.lab.home."},
+		{"NAS.Lab.Home", "nas.lab.home."},
+		{"  nas.lab.home.\n", "nas.lab.home."},
+		{".", "."},
+		{"home", "home."},
+		{"bücher.example", "xn--bcher-kva.example."},
+		{"BÜCHER.example.", "xn--bcher-kva.example."},
+		{"xn--bcher-kva.example.", "xn--bcher-kva.example."},
+		{"XN--BCHER-KVA.example", "xn--bcher-kva.example."},
+		{"_dmarc.Example.com", "_dmarc.example.com."},
+		{long + ".example.", long + ".example."},
+	}
+	for _, c := range cases {
+		got, err := Canonical(c.in)
+		if err != nil || got != c.want {
+			t.Errorf("Canonical(%q) = %q, %v, want %q", c.in, got, err, c.want)
+		}
+	}
+}
+
+func TestCanonicalRejects(t *testing.T) {
+	label := strings.Repeat("a", 50)
+	cases := []struct {
+		in     string
+		reason string
+	}{
+		{"", "name is empty"},
+		{"   ", "name is empty"},
+		{"nas..lab.home.", "empty label"},
+		{".nas.lab.home.", "empty label"},
+		{"nas.lab.home..", "empty label"},
+		{strings.Repeat("a", MAX_LABEL_LENGTH+1) + ".example.", "longer than 63"},
+		{strings.Join([]string{label, label, label, label, label, label}, "."), "longer than 253"},
+		{"a\u0601b.example.", "disallowed rune"},
+		{"\u0300ab.example.", "invalid label"},
+	}
+	for _, c := range cases {
+		got, err := Canonical(c.in)
+		if err == nil || !strings.Contains(err.Error(), c.reason) {
+			t.Errorf("Canonical(%q) = %q, %v, want an error about %q", c.in, got, err, c.reason)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	for in, want := range map[string]string{
+		"nas.lab.home.": "nas.lab.home.",
+		"NAS.Lab.Home.": "nas.lab.home.",
+		"nas.lab.home":  "nas.lab.home.",
+		".":             ".",
+		"":              ".",
+	} {
+		if got := Key(in); got != want {
+			t.Errorf("Key(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+/*
+*	The three spellings a user types must give one name everywhere, whether it went through Canonical in the
+*	config or Key on the query path
+ */
+func TestCanonicalAndKeyAgree(t *testing.T) {
+	for _, in := range []string{"NAS.Lab.Home", "nas.lab.home.", "nas.lab.home", "xn--bcher-kva.Example"} {
+		canonical, err := Canonical(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Key(in) != canonical {
+			t.Errorf("Key(%q) = %q but Canonical gives %q", in, Key(in), canonical)
+		}
+	}
+}
+
+func TestDisplay(t *testing.T) {
+	for in, want := range map[string]string{
+		"xn--bcher-kva.example.": "bücher.example.",
+		"nas.lab.home.":          "nas.lab.home.",
+		// not a valid A-label, shown as it is
+		"xn--zz.example.": "xn--zz.example.",
+	} {
+		if got := Display(in); got != want {
+			t.Errorf("Display(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+//go:build go1.18
+// +build go1.18
+
+package dnsname
+
+import "testing"
+
+/*
+*	Canonical must be idempotent and agree with Key on its own output, or a name configured in one spelling and
+*	queried in another could miss
+ */
+func FuzzCanonical(f *testing.F) {
+	for _, seed := range []string{"nas.lab.home.", "NAS.Lab.Home", ".", "bücher.example", "xn--bcher-kva.example.", "a..b", " x. "} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		once, err := Canonical(name)
+		if err != nil {
+			return
+		}
+		twice, err := Canonical(once)
+		if err != nil {
+			t.Fatalf("Canonical(%q) = %q, which is rejected: %v", name, once, err)
+		}
+		if twice != once {
+			t.Fatalf("Canonical(%q) = %q but Canonical(%q) = %q", name, once, once, twice)
+		}
+		if Key(once) != once {
+			t.Fatalf("Key(%q) = %q, want the canonical name unchanged", once, Key(once))
+		}
+	})
+}
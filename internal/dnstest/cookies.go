@@ -0,0 +1,53 @@
+package dnstest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	optionCookie   = 10
+	rcodeBadCookie = dnsmessage.RCode(23)
+)
+
+/*
+*	Makes the server enforce DNS cookies (RFC 7873): queries without a client cookie are REFUSED, queries without
+*	the right server cookie get BADCOOKIE with one, and every other answer carries the server cookie back
+ */
+func (s *Server) RequireCookies() {
+	secret := make([]byte, 16)
+	rand.Read(secret)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cookies = secret
+}
+
+func checkCookie(query *dnsmessage.Message, r Response, secret []byte) Response {
+	var cookie []byte
+	for _, res := range query.Additionals {
+		if opt, ok := res.Body.(*dnsmessage.OPTResource); ok {
+			for _, o := range opt.Options {
+				if o.Code == optionCookie {
+					cookie = o.Data
+				}
+			}
+		}
+	}
+	if len(cookie) < 8 {
+		return Response{RCode: dnsmessage.RCodeRefused}
+	}
+	sum := sha256.Sum256(append(append([]byte{}, secret...), cookie[:8]...))
+	server := sum[:8]
+	if !bytes.Equal(cookie[8:], server) {
+		r = Response{RCode: rcodeBadCookie}
+	}
+	var h dnsmessage.ResourceHeader
+	h.SetEDNS0(1232, r.RCode, false)
+	r.opt = &dnsmessage.Resource{Header: h, Body: &dnsmessage.OPTResource{
+		Options: []dnsmessage.Option{{Code: optionCookie, Data: append(append([]byte{}, cookie[:8]...), server...)}}}}
+	r.RCode &= 0xF
+	return r
+}
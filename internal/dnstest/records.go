@@ -0,0 +1,38 @@
+package dnstest
+
+import (
+	"net"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	Resource builders for scripting answers, they panic on invalid input since they are only used with fixed values
+ */
+func A(name string, ttl uint32, ip string) dnsmessage.Resource {
+	var a [4]byte
+	copy(a[:], net.ParseIP(ip).To4())
+	return dnsmessage.Resource{Header: header(name, dnsmessage.TypeA, ttl), Body: &dnsmessage.AResource{A: a}}
+}
+
+func AAAA(name string, ttl uint32, ip string) dnsmessage.Resource {
+	var aaaa [16]byte
+	copy(aaaa[:], net.ParseIP(ip).To16())
+	return dnsmessage.Resource{Header: header(name, dnsmessage.TypeAAAA, ttl), Body: &dnsmessage.AAAAResource{AAAA: aaaa}}
+}
+
+func CNAME(name string, ttl uint32, target string) dnsmessage.Resource {
+	return dnsmessage.Resource{Header: header(name, dnsmessage.TypeCNAME, ttl), Body: &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName(target)}}
+}
+
+func TXT(name string, ttl uint32, txt ...string) dnsmessage.Resource {
+	return dnsmessage.Resource{Header: header(name, dnsmessage.TypeTXT, ttl), Body: &dnsmessage.TXTResource{TXT: txt}}
+}
+
+func NS(name string, ttl uint32, host string) dnsmessage.Resource {
+	return dnsmessage.Resource{Header: header(name, dnsmessage.TypeNS, ttl), Body: &dnsmessage.NSResource{NS: dnsmessage.MustNewName(host)}}
+}
+
+func header(name string, qtype dnsmessage.Type, ttl uint32) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: qtype, Class: dnsmessage.ClassINET, TTL: ttl}
+}
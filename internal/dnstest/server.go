@@ -0,0 +1,307 @@
+package dnstest
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/TasSM/labns/internal/dnsname"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	How the server answers a question. The zero value answers NOERROR with no records, Drop sends nothing at all
+ */
+type Response struct {
+	RCode       dnsmessage.RCode
+	Answers     []dnsmessage.Resource
+	Authorities []dnsmessage.Resource
+	Additionals []dnsmessage.Resource
+	// held back before answering, each query waits on its own so a slow answer doesn't block others
+	Delay time.Duration
+	// replaces Delay for queries over TCP
+	TCPDelay time.Duration
+	// sets TC and leaves out the answers over UDP, TCP still gets the full answer
+	Truncate bool
+	// answers with an ID that doesn't match the query
+	WrongID bool
+	// echoes this question in place of the query's, as an upstream answering another query would
+	Question *dnsmessage.Question
+	// sets AA, as an authoritative server would
+	Authoritative bool
+	Drop          bool
+	// the OPT record added when cookies are required
+	opt *dnsmessage.Resource
+}
+
+/*
+*	A query the server received, kept in arrival order
+ */
+type Query struct {
+	Network  string
+	From     net.Addr
+	ID       uint16
+	Question dnsmessage.Question
+	Time     time.Time
+	// the query as it arrived on the wire
+	Raw []byte
+}
+
+/*
+*	A scriptable upstream for exercising the resolver, listening on UDP and TCP on the same port. Questions without
+*	a registered response get the default, REFUSED unless changed with Default
+ */
+type Server struct {
+	lock      sync.Mutex
+	udp       *net.UDPConn
+	tcp       *net.TCPListener
+	responses map[key]Response
+	fallback  Response
+	queries   []Query
+	closed    bool
+	cookies   []byte
+	wg        sync.WaitGroup
+}
+
+type key struct {
+	name  string
+	qtype dnsmessage.Type
+}
+
+/*
+*	Starts a server on addr, e.g. "127.0.0.1:0" for an ephemeral port. With port 0 the UDP port is picked first and
+*	TCP is bound to the same number, retried on a new port if TCP can't have it
+ */
+func NewServer(addr string) (*Server, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	var udp *net.UDPConn
+	var tcp *net.TCPListener
+	for attempt := 0; ; attempt++ {
+		udp, err = net.ListenUDP("udp", udpAddr)
+		if err != nil {
+			return nil, err
+		}
+		bound := udp.LocalAddr().(*net.UDPAddr)
+		tcp, err = net.ListenTCP("tcp", &net.TCPAddr{IP: bound.IP, Port: bound.Port, Zone: bound.Zone})
+		if err == nil {
+			break
+		}
+		udp.Close()
+		if udpAddr.Port != 0 || attempt == 10 {
+			return nil, err
+		}
+	}
+	s := &Server{udp: udp, tcp: tcp, responses: make(map[key]Response), fallback: Response{RCode: dnsmessage.RCodeRefused}}
+	s.wg.Add(2)
+	go s.serveUDP()
+	go s.serveTCP()
+	return s, nil
+}
+
+/*
+*	The address queries should be sent to, the same for UDP and TCP
+ */
+func (s *Server) Addr() string {
+	return s.udp.LocalAddr().String()
+}
+
+/*
+*	Sets the response for a name and type, names match case-insensitively with or without the trailing dot
+ */
+func (s *Server) Handle(name string, qtype dnsmessage.Type, r Response) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.responses[key{dnsname.Key(name), qtype}] = r
+}
+
+/*
+*	Sets the response for questions without a registered one
+ */
+func (s *Server) Default(r Response) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.fallback = r
+}
+
+/*
+*	Returns a copy of the queries received so far
+ */
+func (s *Server) Queries() []Query {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Query{}, s.queries...)
+}
+
+/*
+*	Forgets the received queries, the registered responses are kept
+ */
+func (s *Server) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.queries = nil
+}
+
+/*
+*	Stops both listeners and waits for queries being answered, delayed answers still pending are not sent
+ */
+func (s *Server) Close() error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.lock.Unlock()
+	err := s.udp.Close()
+	if tcpErr := s.tcp.Close(); err == nil {
+		err = tcpErr
+	}
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) isClosed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.closed
+}
+
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := s.udp.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		query := append([]byte{}, buf[:n]...)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if res := s.answer("udp", from, query); res != nil && !s.isClosed() {
+				s.udp.WriteToUDP(res, from)
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCP() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			for !s.isClosed() {
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				query, err := readTCP(conn)
+				if err != nil {
+					if ne, ok := err.(net.Error); ok && ne.Timeout() {
+						continue
+					}
+					return
+				}
+				res := s.answer("tcp", conn.RemoteAddr(), query)
+				if res == nil {
+					continue
+				}
+				out := make([]byte, 2, 2+len(res))
+				binary.BigEndian.PutUint16(out, uint16(len(res)))
+				if _, err := conn.Write(append(out, res...)); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func readTCP(conn net.Conn) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	query := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, query); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+/*
+*	Records the query and builds its answer, nil when nothing should be sent
+ */
+func (s *Server) answer(network string, from net.Addr, query []byte) []byte {
+	var m dnsmessage.Message
+	if err := m.Unpack(query); err != nil || len(m.Questions) != 1 {
+		return nil
+	}
+	q := m.Questions[0]
+	s.lock.Lock()
+	s.queries = append(s.queries, Query{Network: network, From: from, ID: m.Header.ID, Question: q, Time: time.Now(), Raw: query})
+	r, ok := s.responses[key{dnsname.Key(q.Name.String()), q.Type}]
+	if !ok {
+		r = s.fallback
+	}
+	secret := s.cookies
+	s.lock.Unlock()
+	if secret != nil {
+		r = checkCookie(&m, r, secret)
+	}
+	delay := r.Delay
+	if network == "tcp" && r.TCPDelay > 0 {
+		delay = r.TCPDelay
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	if r.Drop {
+		return nil
+	}
+	res, err := Build(m, r, network == "udp")
+	if err != nil {
+		return nil
+	}
+	return res
+}
+
+/*
+*	Packs the reply to query that r describes, truncated when udp is set and r asks for it
+ */
+func Build(query dnsmessage.Message, r Response, udp bool) ([]byte, error) {
+	if len(query.Questions) != 1 {
+		return nil, errors.New("query must have exactly one question")
+	}
+	m := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: query.Header.ID, Response: true, OpCode: query.Header.OpCode,
+			Authoritative: r.Authoritative, RecursionDesired: query.Header.RecursionDesired, RecursionAvailable: true, RCode: r.RCode},
+		Questions: query.Questions,
+		// packing writes each record's length into its header, so concurrent answers get copies of the sections
+		Answers:     append([]dnsmessage.Resource(nil), r.Answers...),
+		Authorities: append([]dnsmessage.Resource(nil), r.Authorities...),
+		Additionals: append([]dnsmessage.Resource(nil), r.Additionals...),
+	}
+	if r.opt != nil {
+		m.Additionals = append(m.Additionals, *r.opt)
+	}
+	if r.WrongID {
+		m.Header.ID = ^query.Header.ID
+	}
+	if r.Question != nil {
+		m.Questions = []dnsmessage.Question{*r.Question}
+	}
+	if r.Truncate && udp {
+		m.Header.Truncated = true
+		m.Answers = nil
+	}
+	return m.Pack()
+}
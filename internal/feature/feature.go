@@ -0,0 +1,53 @@
+package feature
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+/*
+*	The optional parts of labns a build can leave out and the build tag that does so, the minimal tag leaves out
+*	all of them. Every part is compiled in by default
+ */
+var tags = map[string]string{
+	"admin":        "noadmin",
+	"webui":        "nowebui",
+	"integrations": "nointegrations",
+	"tools":        "notools",
+}
+
+var omitted = make(map[string]bool)
+
+/*
+*	Records that name was left out of this build, called from the init function of the file that replaces it
+ */
+func Omit(name string) {
+	omitted[name] = true
+}
+
+func Compiled(name string) bool {
+	return !omitted[name]
+}
+
+/*
+*	Returns the optional parts left out of this build, sorted
+ */
+func Omitted() []string {
+	out := make([]string, 0, len(omitted))
+	for name := range omitted {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+/*
+*	Returns an error naming setting when the feature it needs was left out of this build
+ */
+func Require(name, setting string) error {
+	if !omitted[name] {
+		return nil
+	}
+	return errors.New(fmt.Sprintf("%s is set but this labns was built without %s (build tag %s or minimal), remove the setting or use a full build", setting, name, tags[name]))
+}
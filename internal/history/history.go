@@ -0,0 +1,299 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+	"github.com/TasSM/labns/internal/stats"
+)
+
+const (
+	batchSize     = 256
+	flushInterval = time.Second
+)
+
+type Entry struct {
+	Time   time.Time
+	Client string
+	Name   string
+	Type   string
+	RCode  string
+	Source string
+	// the name actually answered when a search domain rewrote the query
+	Effective string `json:",omitempty"`
+	// set when a TTLOverride replaced the TTLs of the answer
+	TTLPinned bool `json:",omitempty"`
+}
+
+type Filter struct {
+	Client string
+	Name   string
+	Type   string
+	RCode  string
+	Since  time.Time
+	Until  time.Time
+	Offset int
+	Limit  int
+}
+
+/*
+*	Fixed size ring of the most recent answered queries, written in batches by a single goroutine
+ */
+type store struct {
+	lock     sync.Mutex
+	entries  []Entry
+	next     int
+	full     bool
+	maxAge   time.Duration
+	path     string
+	appended int
+}
+
+var (
+	current *store
+	queue   chan Entry
+)
+
+/*
+*	Enables the history when conf.Enabled is set and loads entries persisted to Path by a previous run
+ */
+func Configure(conf *config.QueryHistory) error {
+	if !conf.Enabled {
+		return nil
+	}
+	s := &store{entries: make([]Entry, conf.MaxEntries), maxAge: time.Duration(conf.MaxAgeMinutes) * time.Minute, path: conf.Path}
+	if s.path != "" {
+		if err := s.load(); err != nil {
+			return err
+		}
+	}
+	current = s
+	queue = make(chan Entry, 4*batchSize)
+	go s.write(queue)
+	return nil
+}
+
+/*
+*	Queues an entry without blocking, entries are dropped and counted when the writer falls behind
+ */
+func Record(e Entry) {
+	if current == nil {
+		return
+	}
+	select {
+	case queue <- e:
+	default:
+		stats.Increment(stats.HistoryDropped)
+	}
+}
+
+func Enabled() bool {
+	return current != nil
+}
+
+/*
+*	Returns the entries matching f, newest first, along with the total number of matches before paging
+ */
+func Search(f Filter) ([]Entry, int) {
+	if current == nil {
+		return nil, 0
+	}
+	return current.search(f, time.Now())
+}
+
+func (s *store) write(input chan Entry) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	batch := make([]Entry, 0, batchSize)
+	for {
+		select {
+		case e := <-input:
+			batch = append(batch, e)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		s.add(batch)
+		if s.path != "" {
+			if err := s.persist(batch); err != nil {
+				logging.LogMessage(logging.LogError, "Failed to write query history: "+err.Error())
+			}
+		}
+		batch = batch[:0]
+	}
+}
+
+func (s *store) add(batch []Entry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, e := range batch {
+		s.entries[s.next] = e
+		s.next = (s.next + 1) % len(s.entries)
+		if s.next == 0 {
+			s.full = true
+		}
+	}
+}
+
+/*
+*	Visits the stored entries from newest to oldest until fn returns false
+ */
+func (s *store) each(fn func(e *Entry) bool) {
+	count := s.next
+	if s.full {
+		count = len(s.entries)
+	}
+	for i := 0; i < count; i++ {
+		idx := (s.next - 1 - i + len(s.entries)) % len(s.entries)
+		if !fn(&s.entries[idx]) {
+			return
+		}
+	}
+}
+
+func (s *store) search(f Filter, now time.Time) ([]Entry, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	oldest := now.Add(-s.maxAge)
+	var out []Entry
+	total := 0
+	s.each(func(e *Entry) bool {
+		if e.Time.Before(oldest) {
+			return false
+		}
+		if !f.matches(e) {
+			return true
+		}
+		if total >= f.Offset && len(out) < f.Limit {
+			out = append(out, *e)
+		}
+		total++
+		return true
+	})
+	return out, total
+}
+
+func (f *Filter) matches(e *Entry) bool {
+	if f.Client != "" && f.Client != e.Client {
+		return false
+	}
+	if f.Type != "" && !strings.EqualFold(f.Type, e.Type) {
+		return false
+	}
+	if f.RCode != "" && !strings.EqualFold(f.RCode, e.RCode) {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	return f.Name == "" || matchName(f.Name, e.Name)
+}
+
+/*
+*	Matches name exactly, or any name under the suffix with a leading *. pattern, ignoring case and the trailing dot
+ */
+func matchName(pattern string, name string) bool {
+	pattern = strings.TrimSuffix(strings.ToLower(pattern), ".")
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(name, pattern[1:])
+	}
+	return name == pattern
+}
+
+/*
+*	Appends batch to the history file, rewriting it from memory once it holds more than twice the ring size
+ */
+func (s *store) persist(batch []Entry) error {
+	s.appended += len(batch)
+	if s.appended > 2*len(s.entries) {
+		return s.compact()
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	for _, e := range batch {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *store) compact() error {
+	s.lock.Lock()
+	var kept []Entry
+	oldest := time.Now().Add(-s.maxAge)
+	s.each(func(e *Entry) bool {
+		if e.Time.Before(oldest) {
+			return false
+		}
+		kept = append(kept, *e)
+		return true
+	})
+	s.lock.Unlock()
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for i := len(kept) - 1; i >= 0; i-- {
+		if err := enc.Encode(kept[i]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	s.appended = len(kept)
+	return os.Rename(tmp, s.path)
+}
+
+/*
+*	Reads the history file left by a previous run, keeping the newest entries that are within MaxAge
+ */
+func (s *store) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	oldest := time.Now().Add(-s.maxAge)
+	var loaded []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil || e.Time.Before(oldest) {
+			continue
+		}
+		loaded = append(loaded, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	s.add(loaded)
+	s.appended = len(loaded)
+	logging.LogMessage(logging.LogInfo, "Loaded query history from "+s.path)
+	return nil
+}
@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	DedupWindow = 30 * time.Second
+	// distinct messages tracked at once, messages past it are written without being collapsed
+	dedupMaxEntries    = 1024
+	dedupCheckInterval = time.Second
+)
+
+type dedupKey struct {
+	Category LogCategory
+	Key      string
+}
+
+type dedupEntry struct {
+	message string
+	similar bool
+	opened  time.Time
+	repeats uint64
+}
+
+/*
+*	Messages written in the current window of each key. A repeat within DedupWindow of the first message is only
+*	counted, and when the window ends a single line with the count is written and a new window opened. A key that
+*	saw no repeats in its window is forgotten
+ */
+var dedup = struct {
+	lock    sync.Mutex
+	entries map[dedupKey]*dedupEntry
+}{entries: make(map[dedupKey]*dedupEntry)}
+
+/*
+*	Reports whether msg should be written now, false when it repeats a message of the same key in its window
+ */
+func firstInWindow(lc LogCategory, key string, msg string, similar bool, now time.Time) bool {
+	if lc == LogFatal {
+		return true
+	}
+	k := dedupKey{Category: lc, Key: key}
+	dedup.lock.Lock()
+	defer dedup.lock.Unlock()
+	if e := dedup.entries[k]; e != nil {
+		e.repeats++
+		return false
+	}
+	if len(dedup.entries) < dedupMaxEntries {
+		dedup.entries[k] = &dedupEntry{message: msg, similar: similar, opened: now}
+	}
+	return true
+}
+
+/*
+*	Writes the repeat counts of the windows that ended, runs for as long as the log writer does
+ */
+func flushRepeats() {
+	for now := range time.Tick(dedupCheckInterval) {
+		for _, entry := range endedWindows(now) {
+			logStream <- entry
+		}
+	}
+}
+
+func endedWindows(now time.Time) []logEntry {
+	dedup.lock.Lock()
+	defer dedup.lock.Unlock()
+	var out []logEntry
+	for k, e := range dedup.entries {
+		if now.Sub(e.opened) < DedupWindow {
+			continue
+		}
+		if e.repeats == 0 {
+			delete(dedup.entries, k)
+			continue
+		}
+		msg := fmt.Sprintf("%s (repeated %d times in the last %s)", e.message, e.repeats, DedupWindow)
+		if e.similar {
+			msg = fmt.Sprintf("%s (%d similar messages in the last %s)", e.message, e.repeats, DedupWindow)
+		}
+		out = append(out, logEntry{Category: k.Category, Message: msg})
+		e.opened, e.repeats = now, 0
+	}
+	return out
+}
@@ -1,27 +1,105 @@
 package logging
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"sync"
+	"time"
 )
 
 type LogCategory string
 
 const (
-	LogInfo  LogCategory = "INFO"
-	LogDebug LogCategory = "DEBUG"
-	LogError LogCategory = "ERROR"
-	LogFatal LogCategory = "FATAL"
+	LogInfo    LogCategory = "INFO"
+	LogDebug   LogCategory = "DEBUG"
+	LogWarning LogCategory = "WARNING"
+	LogError   LogCategory = "ERROR"
+	LogFatal   LogCategory = "FATAL"
 )
 
-var logStream = make(chan string, 32)
+const (
+	TargetStdout = "stdout"
+	TargetFile   = "file"
+	TargetSyslog = "syslog"
+)
+
+type logEntry struct {
+	Category LogCategory
+	Message  string
+}
+
+var (
+	logStream = make(chan logEntry, 32)
+	targetMu  sync.Mutex
+	override  *log.Logger
+	sink      *syslogSink
+)
 
+/*
+*	Logs msg, an identical message of the same category within DedupWindow is collapsed into a repeat count
+ */
 func LogMessage(lc LogCategory, msg string) {
+	if firstInWindow(lc, msg, msg, false, time.Now()) {
+		write(lc, msg)
+	}
+}
+
+/*
+*	Logs msg and collapses the messages logged with the same category and key within DedupWindow, for failures
+*	whose messages differ in a detail such as the name queried. Only the first message of a window is written
+ */
+func LogSimilar(lc LogCategory, key string, msg string) {
+	if firstInWindow(lc, key, msg, true, time.Now()) {
+		write(lc, msg)
+	}
+}
+
+/*
+*	Logs msg without collapsing repeats, for the query log where every query is expected to appear and for lines
+*	repeated on purpose such as the rules framing a notice
+ */
+func LogEvery(lc LogCategory, msg string) {
+	write(lc, msg)
+}
+
+func write(lc LogCategory, msg string) {
 	if logStream == nil {
 		log.Fatalf("%s - Log stream not initialised, InitLogging() has not been called", msg)
 		return
 	}
-	logStream <- string(lc) + " - " + msg
+	logStream <- logEntry{Category: lc, Message: msg}
+	if lc == LogFatal {
+		// the logging goroutine exits the process once the message is written
+		select {}
+	}
+}
+
+/*
+*	Selects where log lines go once the configuration is loaded, syslog is written alongside the log file when one is set
+ */
+func ConfigureTarget(target string, logPath string, opts SyslogOptions) error {
+	targetMu.Lock()
+	defer targetMu.Unlock()
+	switch target {
+	case "":
+	case TargetStdout:
+		override = log.New(os.Stdout, "", log.LstdFlags)
+	case TargetFile:
+		if logPath == "" {
+			return errors.New("LogTarget file requires LABNS_LOG_PATH to be set")
+		}
+	case TargetSyslog:
+		s, err := newSyslogSink(opts)
+		if err != nil {
+			return err
+		}
+		sink = s
+	default:
+		return errors.New(fmt.Sprintf("unknown log target %s", target))
+	}
+	return nil
 }
 
 func InitLogging(logPath string) {
@@ -33,19 +111,30 @@ func InitLogging(logPath string) {
 		defer f.Close()
 		log.SetOutput(f)
 	}
+	go flushRepeats()
 	for {
 		select {
-		case msg, ok := <-logStream:
+		case entry, ok := <-logStream:
 			if !ok {
 				log.Fatalf("%s - Log stream channel was killed, exiting", LogFatal)
 				return
 			}
-			log.Println(msg)
+			msg := string(entry.Category) + " - " + entry.Message
+			targetMu.Lock()
+			out, s := override, sink
+			targetMu.Unlock()
+			if s != nil {
+				s.Send(entry)
+			}
+			if out != nil {
+				out.Println(msg)
+			} else if s == nil || logToFile {
+				log.Println(msg)
+			}
 			if logToFile {
 				f.Sync()
 			}
-			switch msg {
-			case string(LogFatal):
+			if entry.Category == LogFatal {
 				os.Exit(1)
 			}
 		}
@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strconv"
+)
+
+const (
+	PrivacyFull            = "full"
+	PrivacyAnonymizeClient = "anonymize-client"
+	PrivacyHashNames       = "hash-names"
+)
+
+var (
+	privacyMode = PrivacyFull
+	privacyKey  []byte
+)
+
+/*
+*	Sets how client addresses and query names are written, a random HMAC key is generated when key is empty
+ */
+func SetPrivacy(mode string, key []byte) error {
+	if mode == "" {
+		mode = PrivacyFull
+	}
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+	}
+	privacyMode = mode
+	privacyKey = key
+	return nil
+}
+
+/*
+*	Returns the client IP as it may be logged, with the host part zeroed in anonymize-client mode
+ */
+func Client(ip net.IP) string {
+	if privacyMode != PrivacyAnonymizeClient || ip == nil {
+		return ip.String()
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+func Addr(addr *net.UDPAddr) string {
+	if addr == nil {
+		return "<nil>"
+	}
+	return net.JoinHostPort(Client(addr.IP), strconv.Itoa(addr.Port))
+}
+
+/*
+*	Returns the query name as it may be logged, replaced by a keyed hash in hash-names mode so repeats still correlate
+ */
+func Name(name string) string {
+	if privacyMode != PrivacyHashNames {
+		return name
+	}
+	mac := hmac.New(sha256.New, privacyKey)
+	mac.Write([]byte(name))
+	return "h-" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
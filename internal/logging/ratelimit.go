@@ -0,0 +1,29 @@
+package logging
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+/*
+*	Logs at most one message per Interval, the next message logged reports how many were suppressed in between
+ */
+type RateLimited struct {
+	Interval   time.Duration
+	last       int64
+	suppressed uint64
+}
+
+func (r *RateLimited) LogMessage(lc LogCategory, msg string) {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&r.last)
+	if now-last < int64(r.Interval) || !atomic.CompareAndSwapInt64(&r.last, last, now) {
+		atomic.AddUint64(&r.suppressed, 1)
+		return
+	}
+	if suppressed := atomic.SwapUint64(&r.suppressed, 0); suppressed > 0 {
+		msg = fmt.Sprintf("%s (%d similar messages suppressed)", msg, suppressed)
+	}
+	LogMessage(lc, msg)
+}
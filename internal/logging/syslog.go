@@ -0,0 +1,99 @@
+package logging
+
+import (
+	"time"
+
+	"github.com/TasSM/labns/internal/stats"
+)
+
+const (
+	syslogBuffer     = 256
+	syslogRetryDelay = 5 * time.Second
+)
+
+type SyslogOptions struct {
+	Network  string
+	Address  string
+	Facility string
+	Tag      string
+}
+
+type syslogWriter interface {
+	Debug(m string) error
+	Info(m string) error
+	Warning(m string) error
+	Err(m string) error
+	Crit(m string) error
+	Close() error
+}
+
+/*
+*	Delivers log entries to syslog from its own goroutine so a slow or lost connection never blocks the caller
+ */
+type syslogSink struct {
+	opts    SyslogOptions
+	entries chan logEntry
+}
+
+func newSyslogSink(opts SyslogOptions) (*syslogSink, error) {
+	if opts.Tag == "" {
+		opts.Tag = "labns"
+	}
+	if opts.Facility == "" {
+		opts.Facility = "daemon"
+	}
+	w, err := dialSyslog(opts)
+	if err != nil {
+		return nil, err
+	}
+	s := &syslogSink{opts: opts, entries: make(chan logEntry, syslogBuffer)}
+	go s.run(w)
+	return s, nil
+}
+
+func (s *syslogSink) Send(entry logEntry) {
+	select {
+	case s.entries <- entry:
+	default:
+		stats.Increment(stats.SyslogDropped)
+	}
+}
+
+func (s *syslogSink) run(w syslogWriter) {
+	var retryAt time.Time
+	for entry := range s.entries {
+		if w == nil {
+			if time.Now().Before(retryAt) {
+				stats.Increment(stats.SyslogDropped)
+				continue
+			}
+			var err error
+			if w, err = dialSyslog(s.opts); err != nil {
+				retryAt = time.Now().Add(syslogRetryDelay)
+				stats.Increment(stats.SyslogDropped)
+				continue
+			}
+		}
+		if err := writeSyslog(w, entry); err != nil {
+			w.Close()
+			w = nil
+			retryAt = time.Now().Add(syslogRetryDelay)
+			stats.Increment(stats.SyslogDropped)
+		}
+	}
+}
+
+func writeSyslog(w syslogWriter, entry logEntry) error {
+	switch entry.Category {
+	case LogDebug:
+		return w.Debug(entry.Message)
+	case LogWarning:
+		return w.Warning(entry.Message)
+	case LogError:
+		return w.Err(entry.Message)
+	case LogFatal:
+		return w.Crit(entry.Message)
+	default:
+		return w.Info(entry.Message)
+	}
+}
@@ -0,0 +1,33 @@
+package logging
+
+import "testing"
+
+type recordingWriter struct {
+	level string
+}
+
+func (w *recordingWriter) Debug(m string) error   { w.level = "debug"; return nil }
+func (w *recordingWriter) Info(m string) error    { w.level = "info"; return nil }
+func (w *recordingWriter) Warning(m string) error { w.level = "warning"; return nil }
+func (w *recordingWriter) Err(m string) error     { w.level = "err"; return nil }
+func (w *recordingWriter) Crit(m string) error    { w.level = "crit"; return nil }
+func (w *recordingWriter) Close() error           { return nil }
+
+func TestSyslogSeverity(t *testing.T) {
+	want := map[LogCategory]string{
+		LogDebug:   "debug",
+		LogInfo:    "info",
+		LogWarning: "warning",
+		LogError:   "err",
+		LogFatal:   "crit",
+	}
+	for category, level := range want {
+		w := &recordingWriter{}
+		if err := writeSyslog(w, logEntry{Category: category, Message: "test"}); err != nil {
+			t.Fatal(err)
+		}
+		if w.level != level {
+			t.Errorf("%s was written at syslog %s, want %s", category, w.level, level)
+		}
+	}
+}
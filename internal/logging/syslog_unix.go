@@ -0,0 +1,42 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"log/syslog"
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":   syslog.LOG_KERN,
+	"user":   syslog.LOG_USER,
+	"mail":   syslog.LOG_MAIL,
+	"daemon": syslog.LOG_DAEMON,
+	"auth":   syslog.LOG_AUTH,
+	"syslog": syslog.LOG_SYSLOG,
+	"local0": syslog.LOG_LOCAL0,
+	"local1": syslog.LOG_LOCAL1,
+	"local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3,
+	"local4": syslog.LOG_LOCAL4,
+	"local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6,
+	"local7": syslog.LOG_LOCAL7,
+}
+
+/*
+*	Connects to the local syslog socket when Address is empty, otherwise to the remote Network/Address
+ */
+func dialSyslog(opts SyslogOptions) (syslogWriter, error) {
+	facility, ok := syslogFacilities[opts.Facility]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unknown syslog facility %s", opts.Facility))
+	}
+	network := opts.Network
+	if opts.Address == "" {
+		network = ""
+	}
+	return syslog.Dial(network, opts.Address, facility|syslog.LOG_INFO, opts.Tag)
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+)
+
+const (
+	alertCheckInterval  = time.Second
+	alertSendTimeout    = 10 * time.Second
+	alertCommandTimeout = 30 * time.Second
+	alertRetryBase      = 5 * time.Second
+	alertMaxBackoff     = 5 * time.Minute
+	alertMaxAttempts    = 6
+	alertQueueSize      = 16
+)
+
+var (
+	alerting       atomic.Value
+	upstreamHealth *healthMonitor
+	alertQueue     = make(chan alertPayload, alertQueueSize)
+)
+
+/*
+*	Health of the default upstreams judged from live queries, an upstream is unhealthy from its first timeout until
+*	it answers again. Written by the state worker and read by the alert check, so it is guarded by a mutex
+ */
+type healthMonitor struct {
+	lock        sync.Mutex
+	upstreams   []string
+	nameservers []config.Nameserver
+	health      map[string]*upstreamState
+}
+
+type upstreamState struct {
+	failures    uint64
+	since       time.Time
+	lastAnswer  time.Time
+	lastFailure time.Time
+}
+
+type alertPayload struct {
+	Event     string
+	Time      time.Time
+	Since     time.Time
+	Upstreams []UpstreamStatus
+}
+
+type UpstreamStatus struct {
+	Address     string
+	Healthy     bool
+	Failures    uint64
+	LastAnswer  *time.Time `json:",omitempty"`
+	LastFailure *time.Time `json:",omitempty"`
+}
+
+/*
+*	Reports the health of the default upstreams, nil before the service has started
+ */
+func UpstreamHealth() []UpstreamStatus {
+	if atomic.LoadInt32(&running) == 0 {
+		return nil
+	}
+	_, _, upstreams := upstreamHealth.snapshot()
+	return upstreams
+}
+
+func newHealthMonitor(conf *config.Configuration) *healthMonitor {
+	m := &healthMonitor{health: make(map[string]*upstreamState)}
+	for _, ns := range conf.UpstreamNameservers.Nameservers() {
+		key := upstreamKey(&ns)
+		if m.health[key] == nil {
+			m.upstreams = append(m.upstreams, key)
+			m.nameservers = append(m.nameservers, ns)
+			m.health[key] = &upstreamState{}
+		}
+	}
+	return m
+}
+
+/*
+*	Loads the alerting settings, a reload replaces them without resetting the health of the upstreams
+ */
+func SetAlerting(a *config.Alerting) {
+	alerting.Store(a)
+}
+
+func (m *healthMonitor) failed(key string, now time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h := m.health[key]
+	if h == nil {
+		return
+	}
+	if h.failures == 0 {
+		h.since = now
+	}
+	h.failures++
+	h.lastFailure = now
+}
+
+func (m *healthMonitor) answered(key string, now time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if h := m.health[key]; h != nil {
+		h.failures = 0
+		h.lastAnswer = now
+	}
+}
+
+/*
+*	Reports whether every upstream is unhealthy and since when, which is when the last of them stopped answering
+ */
+func (m *healthMonitor) snapshot() (bool, time.Time, []UpstreamStatus) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	down := true
+	var since time.Time
+	var out []UpstreamStatus
+	for _, key := range m.upstreams {
+		h := m.health[key]
+		status := UpstreamStatus{Address: key, Healthy: h.failures == 0, Failures: h.failures}
+		if !h.lastAnswer.IsZero() {
+			t := h.lastAnswer
+			status.LastAnswer = &t
+		}
+		if !h.lastFailure.IsZero() {
+			t := h.lastFailure
+			status.LastFailure = &t
+		}
+		if status.Healthy {
+			down = false
+		} else if h.since.After(since) {
+			since = h.since
+		}
+		out = append(out, status)
+	}
+	return down, since, out
+}
+
+/*
+*	Marks the upstreams of the most recent round of a pending request as failed, a race round sends to all of them
+ */
+func upstreamsTimedOut(p *pendingRequest) {
+	count := 1
+	if p.Plan.Strategy == "race" {
+		count = len(p.Plan.Upstreams)
+	}
+	now := serviceClock.Now()
+	for i := len(p.Attempts) - 1; i >= 0 && i >= len(p.Attempts)-count; i-- {
+		upstreamHealth.failed(p.Attempts[i].Key, now)
+	}
+}
+
+/*
+*	Checks the upstream health every interval and queues an alert once all upstreams have been unhealthy for
+*	AfterSeconds, and again when one of them answers. Down alerts are at least CooldownSeconds apart, a recovery is
+*	only sent after a down alert so flapping upstreams produce at most one pair per cooldown
+ */
+func watchUpstreamHealth(m *healthMonitor) {
+	var alerted bool
+	var lastAlert, downSince time.Time
+	for {
+		<-serviceClock.After(alertCheckInterval)
+		a, _ := alerting.Load().(*config.Alerting)
+		down, since, upstreams := m.snapshot()
+		now := serviceClock.Now()
+		switch {
+		case down && !alerted && a != nil && now.Sub(since) >= time.Duration(a.AfterSeconds)*time.Second:
+			if !lastAlert.IsZero() && now.Sub(lastAlert) < time.Duration(a.CooldownSeconds)*time.Second {
+				continue
+			}
+			alerted = true
+			lastAlert, downSince = now, since
+			logging.LogMessage(logging.LogError, fmt.Sprintf("All upstream nameservers have been unhealthy since %s", since.Format(time.RFC3339)))
+			queueAlert(a, alertPayload{Event: "down", Time: now, Since: since, Upstreams: upstreams})
+		case !down && alerted:
+			alerted = false
+			logging.LogMessage(logging.LogInfo, "Upstream nameservers recovered")
+			queueAlert(a, alertPayload{Event: "recovered", Time: now, Since: downSince, Upstreams: upstreams})
+		}
+	}
+}
+
+func queueAlert(a *config.Alerting, p alertPayload) {
+	if a == nil || (a.WebhookURL == "" && len(a.Command) == 0) {
+		return
+	}
+	select {
+	case alertQueue <- p:
+	default:
+		logging.LogMessage(logging.LogError, "Alert queue is full, dropping "+p.Event+" alert")
+	}
+}
@@ -0,0 +1,84 @@
+//go:build !nointegrations && !minimal
+// +build !nointegrations,!minimal
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+)
+
+/*
+*	Delivers queued alerts one at a time so they arrive in order. Failed webhooks are retried with backoff, the
+*	command is run once with the payload on stdin
+ */
+func sendAlerts() {
+	for p := range alertQueue {
+		a, _ := alerting.Load().(*config.Alerting)
+		if a == nil {
+			continue
+		}
+		body, err := json.Marshal(p)
+		if err != nil {
+			logging.LogMessage(logging.LogError, "Unable to encode alert: "+err.Error())
+			continue
+		}
+		if len(a.Command) > 0 {
+			if err := runAlertCommand(a.Command, p.Event, body); err != nil {
+				logging.LogMessage(logging.LogError, "Alert command failed: "+err.Error())
+			}
+		}
+		if a.WebhookURL == "" {
+			continue
+		}
+		for attempt := 1; ; attempt++ {
+			err := postAlert(a.WebhookURL, body)
+			if err == nil {
+				logging.LogMessage(logging.LogInfo, "Sent "+p.Event+" alert to webhook")
+				break
+			}
+			if attempt == alertMaxAttempts {
+				logging.LogMessage(logging.LogError, fmt.Sprintf("Giving up on %s alert after %d attempts: %v", p.Event, attempt, err))
+				break
+			}
+			wait := retryDelay(attempt, alertRetryBase, alertMaxBackoff)
+			logging.LogMessage(logging.LogError, fmt.Sprintf("Alert webhook failed, retrying in %s: %v", wait.Round(time.Second), err))
+			time.Sleep(wait)
+		}
+	}
+}
+
+func postAlert(url string, body []byte) error {
+	client := http.Client{Timeout: alertSendTimeout}
+	res, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return errors.New("webhook returned " + res.Status)
+	}
+	return nil
+}
+
+func runAlertCommand(command []string, event string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), alertCommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(), "LABNS_ALERT_EVENT="+event)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.New(fmt.Sprintf("%v: %s", err, bytes.TrimSpace(out)))
+	}
+	return nil
+}
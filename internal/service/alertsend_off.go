@@ -0,0 +1,12 @@
+//go:build nointegrations || minimal
+// +build nointegrations minimal
+
+package service
+
+/*
+*	Alerting was left out of this build and configurations setting it are rejected, so nothing is ever queued
+ */
+func sendAlerts() {
+	for range alertQueue {
+	}
+}
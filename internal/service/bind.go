@@ -0,0 +1,29 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/TasSM/labns/internal/config"
+)
+
+/*
+*	Opens a socket dedicated to one upstream, bound to its BindAddress and/or BindInterface
+ */
+func openUpstreamSocket(ns *config.Nameserver) (*net.UDPConn, error) {
+	network := "udp4"
+	if ns.IPv4 == "" {
+		network = "udp6"
+	}
+	lc := net.ListenConfig{}
+	if ns.BindInterface != "" {
+		lc.Control = bindToDevice(ns.BindInterface)
+	}
+	pc, err := lc.ListenPacket(context.Background(), network, net.JoinHostPort(ns.BindAddress, "0"))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to open source socket for upstream %s: %v", upstreamKey(ns), err))
+	}
+	return pc.(*net.UDPConn), nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package service
+
+import (
+	"errors"
+	"syscall"
+)
+
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return errors.New("BindInterface is only supported on Linux")
+	}
+}
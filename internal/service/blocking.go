@@ -0,0 +1,155 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/TasSM/labns/internal/blocklist"
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type BlockPolicy struct {
+	Name     string
+	Clients  []*net.IPNet
+	Schedule *blocklist.Schedule
+}
+
+type Blocker struct {
+	Set       *blocklist.Set
+	Responses []config.BlockResponse
+	Policies  []BlockPolicy
+	TTL       uint32
+}
+
+func CreateBlocker(conf *config.Configuration) (*Blocker, error) {
+	b := &Blocker{Set: blocklist.NewSet(), TTL: config.DEFAULT_BLOCKED_RESPONSE_TTL}
+	if conf.BlockedResponseTTL != nil {
+		b.TTL = *conf.BlockedResponseTTL
+	}
+	groups := make(map[string][]*net.IPNet)
+	for _, g := range conf.ClientGroups {
+		for _, c := range g.Clients {
+			ipNet, err := config.ParseClientAddress(c)
+			if err != nil {
+				return nil, err
+			}
+			groups[g.Name] = append(groups[g.Name], ipNet)
+		}
+	}
+	for k, v := range conf.Blocklists {
+		if v.Path != "" {
+			file, err := os.Open(v.Path)
+			if err != nil {
+				return nil, err
+			}
+			res, err := blocklist.Parse(file, blocklist.Format(v.Format))
+			file.Close()
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse blocklist %s: %v", v.Path, err)
+			}
+			b.Set.AddRules(res.Rules, k)
+			logging.LogMessage(logging.LogInfo, fmt.Sprintf("Loaded blocklist %s: %d rules accepted, %d lines skipped", v.Path, res.Accepted, res.Skipped))
+		}
+		for _, d := range v.Domains {
+			b.Set.Block.Add(d, true, k)
+		}
+		br := conf.BlockResponse
+		if v.BlockResponse != nil {
+			br = *v.BlockResponse
+		}
+		b.Responses = append(b.Responses, br)
+		policy := BlockPolicy{Name: v.Name}
+		for _, g := range v.Groups {
+			policy.Clients = append(policy.Clients, groups[g]...)
+		}
+		if len(v.Schedules) > 0 {
+			var windows []blocklist.Window
+			for _, sch := range v.Schedules {
+				w := blocklist.Window{}
+				for _, d := range sch.Days {
+					w.Days = append(w.Days, config.DayMap[strings.ToLower(d)])
+				}
+				w.StartMin, _ = config.ParseClock(sch.Start)
+				w.EndMin, _ = config.ParseClock(sch.End)
+				windows = append(windows, w)
+			}
+			policy.Schedule = blocklist.NewSchedule(windows)
+		}
+		b.Policies = append(b.Policies, policy)
+	}
+	for _, v := range conf.Allowlist {
+		b.Set.Allow.Add(v, true, -1)
+	}
+	return b, nil
+}
+
+func (p *BlockPolicy) Applies(client net.IP, now time.Time) bool {
+	if p.Schedule != nil && !p.Schedule.Active(now) {
+		return false
+	}
+	if len(p.Clients) == 0 {
+		return true
+	}
+	for _, c := range p.Clients {
+		if c.Contains(client) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+*	Deny wins, the first blocklist matching name whose group and schedule apply and that is not paused decides the
+*	response
+ */
+func (b *Blocker) Check(name string, client net.IP, now time.Time) (*config.BlockResponse, bool) {
+	mask, ok := b.Set.Lookup(name)
+	if !ok {
+		return nil, false
+	}
+	for k := range b.Policies {
+		if mask&(1<<uint(k)) != 0 && b.Policies[k].Applies(client, now) && !blockingPaused(b.Policies[k].Name, now) {
+			return &b.Responses[k], true
+		}
+	}
+	return nil, false
+}
+
+/*
+*	Null and custom modes answer A and AAAA with the configured address and NODATA for anything else
+ */
+func BuildBlockedResponse(query []byte, question dnsmessage.Question, br *config.BlockResponse, ttl uint32) ([]byte, error) {
+	var ipv4, ipv6 string
+	switch br.Mode {
+	case "", "nxdomain":
+		return BuildErrorResponse(query, dnsmessage.RCodeNameError)
+	case "refused":
+		return BuildErrorResponse(query, dnsmessage.RCodeRefused)
+	case "null":
+		ipv4, ipv6 = "0.0.0.0", "::"
+	case "custom":
+		ipv4, ipv6 = br.IPv4, br.IPv6
+	}
+	b, err := newResponseBuilder(query)
+	if err != nil {
+		return nil, err
+	}
+	var answers []dnsmessage.Resource
+	header := dnsmessage.ResourceHeader{Name: question.Name, Type: question.Type, Class: dnsmessage.ClassINET, TTL: ttl}
+	switch {
+	case question.Type == dnsmessage.TypeA && ipv4 != "":
+		res := dnsmessage.AResource{}
+		copy(res.A[:], net.ParseIP(ipv4).To4())
+		answers = append(answers, dnsmessage.Resource{Header: header, Body: &res})
+	case question.Type == dnsmessage.TypeAAAA && ipv6 != "":
+		res := dnsmessage.AAAAResource{}
+		copy(res.AAAA[:], net.ParseIP(ipv6).To16())
+		answers = append(answers, dnsmessage.Resource{Header: header, Body: &res})
+	}
+	return b.Answer(answers, false)
+}
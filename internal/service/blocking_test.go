@@ -0,0 +1,157 @@
+package service
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// a Monday
+var blockingMonday = time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC)
+
+/*
+*	Video sites blocked for the kids group from 21:00 to 07:00 on Mondays, and tiktok.com. blocked for everyone by
+*	a second list without a name
+ */
+func kidsBlocker(t *testing.T) *Blocker {
+	t.Helper()
+	b, err := CreateBlocker(&config.Configuration{
+		ClientGroups: []config.ClientGroup{{Name: "kids", Clients: []string{"10.0.0.0/24", "10.0.1.7"}}},
+		Blocklists: []config.Blocklist{
+			{
+				Name:      "kids-video",
+				Domains:   []string{"youtube.com.", "tiktok.com."},
+				Groups:    []string{"kids"},
+				Schedules: []config.Schedule{{Days: []string{"mon"}, Start: "21:00", End: "07:00"}},
+			},
+			{Domains: []string{"tiktok.com."}, BlockResponse: &config.BlockResponse{Mode: "refused"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func clearBlockingPauses(t *testing.T) {
+	t.Cleanup(func() { blockingPauses.Store(map[string]time.Time{}) })
+}
+
+func TestBlockPolicyScopes(t *testing.T) {
+	b := kidsBlocker(t)
+	kid, adult := net.ParseIP("10.0.0.20"), net.ParseIP("10.0.2.20")
+	at := func(day int, hour int, minute int) time.Time {
+		return blockingMonday.Add(time.Duration(day*24*60+hour*60+minute) * time.Minute)
+	}
+	cases := []struct {
+		name    string
+		client  net.IP
+		now     time.Time
+		blocked bool
+		// the mode of the list that decided, empty for the default NXDOMAIN
+		mode string
+	}{
+		{"www.youtube.com.", kid, at(0, 21, 0), true, ""},
+		{"youtube.com.", net.ParseIP("10.0.1.7"), at(0, 23, 59), true, ""},
+		// the window runs past midnight into Tuesday morning
+		{"youtube.com.", kid, at(1, 6, 59), true, ""},
+		{"youtube.com.", kid, at(1, 7, 0), false, ""},
+		{"youtube.com.", kid, at(0, 20, 59), false, ""},
+		// Tuesday evening is outside the schedule
+		{"youtube.com.", kid, at(1, 22, 0), false, ""},
+		{"youtube.com.", adult, at(0, 22, 0), false, ""},
+		// both lists match, the first one that applies decides
+		{"tiktok.com.", kid, at(0, 22, 0), true, ""},
+		{"tiktok.com.", kid, at(0, 12, 0), true, "refused"},
+		{"tiktok.com.", adult, at(0, 22, 0), true, "refused"},
+	}
+	for _, c := range cases {
+		br, blocked := b.Check(c.name, c.client, c.now)
+		if blocked != c.blocked {
+			t.Errorf("%s for %s at %s blocked %t, want %t", c.name, c.client, c.now.Format("Mon 15:04"), blocked, c.blocked)
+			continue
+		}
+		if blocked && br.Mode != c.mode {
+			t.Errorf("%s for %s at %s answered with block mode %q, want %q", c.name, c.client, c.now.Format("Mon 15:04"), br.Mode, c.mode)
+		}
+	}
+}
+
+func TestBlockingPause(t *testing.T) {
+	clearBlockingPauses(t)
+	b := kidsBlocker(t)
+	kid := net.ParseIP("10.0.0.20")
+	night := blockingMonday.Add(22 * time.Hour)
+
+	setBlockingPause("kids-video", night.Add(15*time.Minute))
+	if _, blocked := b.Check("youtube.com.", kid, night.Add(14*time.Minute)); blocked {
+		t.Fatal("youtube.com. blocked while its list is paused")
+	}
+	// the list without a name keeps blocking
+	if br, blocked := b.Check("tiktok.com.", kid, night); !blocked || br.Mode != "refused" {
+		t.Fatalf("tiktok.com. answered with %v, blocked %t, want the second list to block it", br, blocked)
+	}
+	if _, blocked := b.Check("youtube.com.", kid, night.Add(15*time.Minute)); !blocked {
+		t.Fatal("youtube.com. not blocked once the pause ran out")
+	}
+
+	setBlockingPause("", night.Add(time.Hour))
+	if _, blocked := b.Check("tiktok.com.", kid, night); blocked {
+		t.Fatal("tiktok.com. blocked while all blocking is paused")
+	}
+	setBlockingPause("", time.Time{})
+	if _, blocked := b.Check("tiktok.com.", kid, night); !blocked {
+		t.Fatal("tiktok.com. not blocked after all blocking was resumed")
+	}
+}
+
+func TestPauseBlockingOverDNS(t *testing.T) {
+	clearBlockingPauses(t)
+	conf := testConfig(t)
+	conf.Blocklists = append(conf.Blocklists, config.Blocklist{Name: "test-ads", Domains: []string{"ads.pause.test."}})
+	up := newUpstream(t)
+	up.Handle("ads.pause.test.", dnsmessage.TypeA, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.A("ads.pause.test.", 60, "192.0.2.80")}})
+	forwardTo(conf, "pause.test.", up)
+	reload(t, conf)
+
+	if res := lookup(t, "ads.pause.test.", dnsmessage.TypeA, 0); res.Header.RCode != dnsmessage.RCodeNameError {
+		t.Fatalf("ads.pause.test. answered %s before the pause, want NXDOMAIN", res.Header.RCode)
+	}
+	if _, err := PauseBlocking("missing", time.Minute); err == nil {
+		t.Fatal("pausing a blocklist that does not exist succeeded")
+	}
+	if _, err := PauseBlocking("test-ads", 25*time.Hour); err == nil {
+		t.Fatal("pause longer than a day succeeded")
+	}
+	until, err := PauseBlocking("test-ads", 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pauses := BlockingPauses(); len(pauses) != 1 || pauses[0].List != "test-ads" || !pauses[0].Until.Equal(until) {
+		t.Fatalf("running pauses are %+v, want test-ads until %s", pauses, until)
+	}
+	if res := lookup(t, "ads.pause.test.", dnsmessage.TypeA, 0); answerAddress(t, res) != "192.0.2.80" {
+		t.Fatalf("ads.pause.test. answered %s with %v while paused, want the upstream answer", res.Header.RCode, res.Answers)
+	}
+	// the base list is not paused along with the named one
+	if res := lookup(t, "ads.example.net.", dnsmessage.TypeA, 0); res.Header.RCode != dnsmessage.RCodeNameError {
+		t.Fatalf("ads.example.net. answered %s while another list is paused, want NXDOMAIN", res.Header.RCode)
+	}
+
+	// the pause outlasts a reload
+	reload(t, conf)
+	if res := lookup(t, "ads.pause.test.", dnsmessage.TypeA, 0); res.Header.RCode != dnsmessage.RCodeSuccess {
+		t.Fatalf("ads.pause.test. answered %s after a reload, want the pause kept", res.Header.RCode)
+	}
+	ResumeBlocking("test-ads")
+	if res := lookup(t, "ads.pause.test.", dnsmessage.TypeA, 0); res.Header.RCode != dnsmessage.RCodeNameError {
+		t.Fatalf("ads.pause.test. answered %s after resuming, want NXDOMAIN ahead of the cached answer", res.Header.RCode)
+	}
+	if pauses := BlockingPauses(); len(pauses) != 0 {
+		t.Fatalf("running pauses after resuming are %+v", pauses)
+	}
+}
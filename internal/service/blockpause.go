@@ -0,0 +1,136 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TasSM/labns/internal/logging"
+)
+
+const maxBlockingPause = 24 * time.Hour
+
+/*
+*	A pause of one named blocklist, or of all blocking when List is empty
+ */
+type BlockingPause struct {
+	List  string `json:",omitempty"`
+	Until time.Time
+}
+
+var (
+	pauseLock sync.Mutex
+	// map[string]time.Time of list name to the end of its pause, "" pauses every list. Replaced rather than
+	// changed so queries read it without the lock
+	blockingPauses atomic.Value
+)
+
+/*
+*	Stops list, or every list when it is empty, from blocking for d. Pauses are kept across reloads and pausing a
+*	list again replaces its pause
+ */
+func PauseBlocking(list string, d time.Duration) (time.Time, error) {
+	if d <= 0 || d > maxBlockingPause {
+		return time.Time{}, errors.New(fmt.Sprintf("pause must be positive and at most %s", maxBlockingPause))
+	}
+	if list != "" && !blocklistNamed(list) {
+		return time.Time{}, errors.New(fmt.Sprintf("no blocklist is named %s", list))
+	}
+	until := serviceClock.Now().Add(d)
+	setBlockingPause(list, until)
+	logging.LogMessage(logging.LogInfo, fmt.Sprintf("Blocking by %s paused until %s", pauseSubject(list), until.Format(time.RFC3339)))
+	return until, nil
+}
+
+/*
+*	Ends the pause of list, or of all blocking when it is empty, before it runs out
+ */
+func ResumeBlocking(list string) {
+	setBlockingPause(list, time.Time{})
+	logging.LogMessage(logging.LogInfo, fmt.Sprintf("Blocking by %s resumed", pauseSubject(list)))
+}
+
+/*
+*	Returns the pauses still running, sorted by list name with the pause of all blocking first
+ */
+func BlockingPauses() []BlockingPause {
+	now := serviceClock.Now()
+	pauses, _ := blockingPauses.Load().(map[string]time.Time)
+	out := []BlockingPause{}
+	for list, until := range pauses {
+		if now.Before(until) {
+			out = append(out, BlockingPause{List: list, Until: until})
+		}
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].List < out[b].List })
+	return out
+}
+
+func setBlockingPause(list string, until time.Time) {
+	pauseLock.Lock()
+	defer pauseLock.Unlock()
+	now := serviceClock.Now()
+	prev, _ := blockingPauses.Load().(map[string]time.Time)
+	next := make(map[string]time.Time, len(prev)+1)
+	for k, v := range prev {
+		// pauses that ran out are dropped here rather than on the query path
+		if now.Before(v) {
+			next[k] = v
+		}
+	}
+	if until.IsZero() {
+		delete(next, list)
+	} else {
+		next[list] = until
+	}
+	blockingPauses.Store(next)
+}
+
+/*
+*	Reports whether blocking by the list named list is paused at now, lists without a name are only paused with
+*	all blocking
+ */
+func blockingPaused(list string, now time.Time) bool {
+	pauses, _ := blockingPauses.Load().(map[string]time.Time)
+	if len(pauses) == 0 {
+		return false
+	}
+	if until, ok := pauses[""]; ok && now.Before(until) {
+		return true
+	}
+	until, ok := pauses[list]
+	return list != "" && ok && now.Before(until)
+}
+
+/*
+*	Reports whether the loaded configuration or one of its profiles has a blocklist named list
+ */
+func blocklistNamed(list string) bool {
+	s := loadedSnapshot()
+	if s == nil {
+		return false
+	}
+	for _, b := range s.conf.Blocklists {
+		if b.Name == list {
+			return true
+		}
+	}
+	for _, p := range s.conf.Profiles {
+		for _, b := range p.Blocklists {
+			if b.Name == list {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func pauseSubject(list string) string {
+	if list == "" {
+		return "all blocklists"
+	}
+	return "blocklist " + list
+}
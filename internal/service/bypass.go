@@ -0,0 +1,32 @@
+package service
+
+import (
+	"net"
+
+	"github.com/TasSM/labns/internal/config"
+)
+
+/*
+*	Client networks whose queries never read the cache, so troubleshooting from them sees live upstream answers.
+*	Their answers are still cached for every other client
+ */
+type cacheBypassClients []*net.IPNet
+
+func newCacheBypassClients(clients []string) cacheBypassClients {
+	var out cacheBypassClients
+	for _, c := range clients {
+		if network, err := config.ParseClientAddress(c); err == nil {
+			out = append(out, network)
+		}
+	}
+	return out
+}
+
+func (c cacheBypassClients) Contains(client net.IP) bool {
+	for _, network := range c {
+		if network.Contains(client) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,395 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnsname"
+	"github.com/TasSM/labns/internal/logging"
+	"github.com/TasSM/labns/internal/stats"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type cacheEntry struct {
+	Packet  []byte
+	Stored  time.Time
+	Expires time.Time
+	// fast path entries are kept after they expire so they can be served stale
+	Fast       bool
+	Refreshing bool
+}
+
+/*
+*	Positive answers from upstreams, owned by the state worker so it needs no locking.
+*	Entries live for the lowest TTL in the response, capped at MaxTTL
+ */
+type responseCache struct {
+	entries  map[string]*cacheEntry
+	max      int
+	maxTTL   uint32
+	maxBytes int
+}
+
+func newResponseCache(conf *config.Cache) *responseCache {
+	if conf.Disabled {
+		return nil
+	}
+	return &responseCache{entries: make(map[string]*cacheEntry), max: int(conf.MaxEntries), maxTTL: conf.MaxTTL, maxBytes: int(conf.MaxEntryBytes)}
+}
+
+/*
+*	The parts of a client query that change what an upstream answers: DO asks for DNSSEC records and CD for answers
+*	that failed validation, so clients differing in either never share an entry
+ */
+type cacheVariant struct {
+	DNSSECOK         bool
+	CheckingDisabled bool
+}
+
+func queryVariant(query []byte) cacheVariant {
+	var v cacheVariant
+	var m dnsmessage.Message
+	if err := m.Unpack(query); err != nil {
+		return v
+	}
+	v.CheckingDisabled = len(query) > 3 && query[3]&flagCheckingDisabled != 0
+	for _, r := range m.Additionals {
+		if r.Header.Type == dnsmessage.TypeOPT {
+			v.DNSSECOK = r.Header.DNSSECAllowed()
+		}
+	}
+	return v
+}
+
+func cacheKey(name string, qtype dnsmessage.Type, v cacheVariant) string {
+	key := dnsname.Key(name) + "/" + qtype.String()
+	if v.DNSSECOK {
+		key += "/do"
+	}
+	if v.CheckingDisabled {
+		key += "/cd"
+	}
+	return key
+}
+
+/*
+*	Returns a copy of the cached response with its TTLs reduced by the time it has been held
+ */
+func (c *responseCache) Get(name string, qtype dnsmessage.Type, v cacheVariant, now time.Time) []byte {
+	if c == nil {
+		return nil
+	}
+	key := cacheKey(name, qtype, v)
+	entry := c.entries[key]
+	if entry == nil {
+		return nil
+	}
+	if !now.Before(entry.Expires) {
+		// kept while offline, when expired entries are all there is to answer from
+		if !entry.Fast && !offlineActive() {
+			delete(c.entries, key)
+		}
+		return nil
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(entry.Packet); err != nil {
+		delete(c.entries, key)
+		return nil
+	}
+	elapsed := uint32(now.Sub(entry.Stored) / time.Second)
+	for _, section := range [][]dnsmessage.Resource{m.Answers, m.Authorities, m.Additionals} {
+		for k := range section {
+			if section[k].Header.Type == dnsmessage.TypeOPT {
+				continue
+			}
+			if section[k].Header.TTL > elapsed {
+				section[k].Header.TTL -= elapsed
+			} else {
+				section[k].Header.TTL = 0
+			}
+		}
+	}
+	packed, err := m.Pack()
+	if err != nil {
+		return nil
+	}
+	return packed
+}
+
+/*
+*	Reports whether a fast path entry is close enough to expiry to be refreshed, at most once per entry
+ */
+func (c *responseCache) NeedsRefresh(name string, qtype dnsmessage.Type, v cacheVariant, now time.Time) bool {
+	if c == nil {
+		return false
+	}
+	entry := c.entries[cacheKey(name, qtype, v)]
+	if entry == nil || !entry.Fast || entry.Refreshing {
+		return false
+	}
+	if entry.Expires.Sub(now) >= entry.Expires.Sub(entry.Stored)/fastPathRefreshFraction {
+		return false
+	}
+	entry.Refreshing = true
+	return true
+}
+
+/*
+*	Returns a copy of a fast path entry, or of any entry while offline, with every TTL set to fastPathStaleTTL,
+*	however long ago it expired
+ */
+func (c *responseCache) GetStale(name string, qtype dnsmessage.Type, v cacheVariant) []byte {
+	if c == nil {
+		return nil
+	}
+	entry := c.entries[cacheKey(name, qtype, v)]
+	if entry == nil || (!entry.Fast && !offlineActive()) {
+		return nil
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(entry.Packet); err != nil {
+		return nil
+	}
+	setTTLs(&m, fastPathStaleTTL, false)
+	packed, err := m.Pack()
+	if err != nil {
+		return nil
+	}
+	if entry.Fast {
+		stats.Increment(stats.FastPathStale)
+	}
+	return packed
+}
+
+/*
+*	Sets the TTL of every record except OPT to ttl, or only raises those below it when raiseOnly is set
+ */
+func setTTLs(m *dnsmessage.Message, ttl uint32, raiseOnly bool) {
+	for _, section := range [][]dnsmessage.Resource{m.Answers, m.Authorities, m.Additionals} {
+		for k := range section {
+			if section[k].Header.Type == dnsmessage.TypeOPT || (raiseOnly && section[k].Header.TTL >= ttl) {
+				continue
+			}
+			section[k].Header.TTL = ttl
+		}
+	}
+}
+
+/*
+*	Stores a NOERROR response with answers, responses with a zero TTL or that fail to parse are not cached. Only
+*	records in bailiwick of name are kept, the client that triggered the query still receives the response unchanged.
+*	A response still larger than MaxEntryBytes once those records are dropped is not cached either
+*	Fast path names are cached for at least the fast path MinTTL, with their TTLs raised to match. Pinned responses
+*	already carry the TTL of their TTLOverride, which is kept as it is rather than capped at MaxTTL
+ */
+func (c *responseCache) Put(name string, qtype dnsmessage.Type, v cacheVariant, packet []byte, now time.Time, pinned bool) {
+	if c == nil {
+		return
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(packet); err != nil || m.Header.RCode != dnsmessage.RCodeSuccess || len(m.Answers) == 0 || m.Header.Truncated {
+		return
+	}
+	dropped := restrictToBailiwick(&m, name)
+	if dropped > 0 {
+		stats.Add(stats.CacheBailiwick, uint64(dropped))
+		logging.LogMessage(logging.LogDebug, fmt.Sprintf("Dropped %d out of bailiwick records from the response for %s before caching", dropped, logging.Name(name)))
+		if len(m.Answers) == 0 {
+			return
+		}
+	}
+	// the OPT record belongs to the client that filled the entry, each hit gets one built for its own query
+	stripped := removeOPT(&m)
+	if dropped > 0 || stripped {
+		packed, err := m.Pack()
+		if err != nil {
+			return
+		}
+		// dnsmessage drops AD and CD, the entry keeps the upstream's
+		packed[3] |= packet[3] & (flagCheckingDisabled | flagAuthenticData)
+		packet = packed
+	}
+	ttl := c.maxTTL
+	if pinned {
+		ttl = math.MaxUint32
+	}
+	for _, section := range [][]dnsmessage.Resource{m.Answers, m.Authorities, m.Additionals} {
+		for _, r := range section {
+			if r.Header.Type != dnsmessage.TypeOPT && r.Header.TTL < ttl {
+				ttl = r.Header.TTL
+			}
+		}
+	}
+	fast := fastPath.zones.Contains(name)
+	if fast && !pinned && ttl < fastPath.minTTL {
+		ttl = fastPath.minTTL
+		setTTLs(&m, ttl, true)
+		var err error
+		if packet, err = m.Pack(); err != nil {
+			return
+		}
+	}
+	if ttl == 0 {
+		return
+	}
+	if c.maxBytes > 0 && len(packet) > c.maxBytes {
+		stats.Increment(stats.CacheTooLarge)
+		logging.LogMessage(logging.LogDebug, fmt.Sprintf("Not caching the %d byte response for %s, above MaxEntryBytes (%d)", len(packet), logging.Name(name), c.maxBytes))
+		return
+	}
+	key := cacheKey(name, qtype, v)
+	if c.entries[key] == nil && len(c.entries) >= c.max {
+		c.evict(now)
+	}
+	stored := make([]byte, len(packet))
+	copy(stored, packet)
+	c.entries[key] = &cacheEntry{Packet: stored, Stored: now, Expires: now.Add(time.Duration(ttl) * time.Second), Fast: fast}
+}
+
+/*
+*	Removes records that don't belong to name from m and returns how many were removed. Answers must be owned by name
+*	or by a name the CNAME chain from it reaches, authority records by one of those names or a parent zone of them,
+*	and every additional record except OPT is dropped since the cache never needs them
+ */
+func restrictToBailiwick(m *dnsmessage.Message, name string) int {
+	chain := map[string]bool{dnsname.Key(name): true}
+	kept := make([]bool, len(m.Answers))
+	// CNAMEs may arrive in any order, so keep passing over the answers until the chain stops growing
+	for grown := true; grown; {
+		grown = false
+		for k, r := range m.Answers {
+			if kept[k] || !chain[dnsname.Key(r.Header.Name.String())] {
+				continue
+			}
+			kept[k] = true
+			if cname, ok := r.Body.(*dnsmessage.CNAMEResource); ok {
+				chain[dnsname.Key(cname.CNAME.String())] = true
+				grown = true
+			}
+		}
+	}
+	dropped := 0
+	answers := m.Answers[:0]
+	for k, r := range m.Answers {
+		if kept[k] {
+			answers = append(answers, r)
+		} else {
+			dropped++
+		}
+	}
+	m.Answers = answers
+	authorities := m.Authorities[:0]
+	for _, r := range m.Authorities {
+		if coversChain(dnsname.Key(r.Header.Name.String()), chain) {
+			authorities = append(authorities, r)
+		} else {
+			dropped++
+		}
+	}
+	m.Authorities = authorities
+	additionals := m.Additionals[:0]
+	for _, r := range m.Additionals {
+		if r.Header.Type == dnsmessage.TypeOPT {
+			additionals = append(additionals, r)
+		} else {
+			dropped++
+		}
+	}
+	m.Additionals = additionals
+	return dropped
+}
+
+func coversChain(zone string, chain map[string]bool) bool {
+	for name := range chain {
+		if zone == "." || name == zone || strings.HasSuffix(name, "."+zone) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+*	Drops expired entries, or an arbitrary one when nothing has expired
+ */
+func (c *responseCache) evict(now time.Time) {
+	// expired fast path entries are still useful stale, so they only go when no other entry has expired
+	for _, fast := range []bool{false, true} {
+		removed := false
+		for k, v := range c.entries {
+			if v.Fast == fast && !now.Before(v.Expires) {
+				delete(c.entries, k)
+				removed = true
+			}
+		}
+		if removed {
+			return
+		}
+	}
+	for k := range c.entries {
+		delete(c.entries, k)
+		return
+	}
+}
+
+/*
+*	Drops every entry, returning how many there were
+ */
+func (c *responseCache) Flush() int {
+	if c == nil {
+		return 0
+	}
+	n := len(c.entries)
+	c.entries = make(map[string]*cacheEntry)
+	return n
+}
+
+func (c *responseCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.entries)
+}
+
+func removeOPT(m *dnsmessage.Message) bool {
+	additionals := m.Additionals[:0]
+	for _, r := range m.Additionals {
+		if r.Header.Type != dnsmessage.TypeOPT {
+			additionals = append(additionals, r)
+		}
+	}
+	removed := len(additionals) != len(m.Additionals)
+	m.Additionals = additionals
+	return removed
+}
+
+/*
+*	Turns a cached packet into the answer to the client query with ID id, adding an OPT record advertising
+*	outboundUDPSize with the query's DO bit when the query had EDNS
+ */
+func cachedAnswer(packet []byte, query []byte, id uint16, name string, recursionDesired bool) []byte {
+	packet[0], packet[1] = byte(id>>8), byte(id)
+	restoreQuestionCase(packet, name)
+	SetForwardedFlags(packet, recursionDesired)
+	b, err := newResponseBuilder(query)
+	if err != nil || !b.edns {
+		return packet
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(packet); err != nil {
+		return packet
+	}
+	var h dnsmessage.ResourceHeader
+	if err := h.SetEDNS0(outboundUDPSize, dnsmessage.RCodeSuccess, b.dnssecOK); err != nil {
+		return packet
+	}
+	m.Additionals = append(m.Additionals, dnsmessage.Resource{Header: h, Body: &dnsmessage.OPTResource{}})
+	packed, err := m.Pack()
+	if err != nil {
+		return packet
+	}
+	packed[3] = packet[3]
+	return packed
+}
@@ -0,0 +1,259 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnstest"
+	"github.com/TasSM/labns/internal/stats"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestOversizedResponseIsNotCached(t *testing.T) {
+	c := newResponseCache(&config.Cache{MaxEntries: 10, MaxTTL: 3600, MaxEntryBytes: 512})
+	var answers []dnsmessage.Resource
+	for k := 1; k <= 40; k++ {
+		answers = append(answers, dnstest.A("big.cache.test.", 60, fmt.Sprintf("192.0.2.%d", k)))
+	}
+	big := upstreamAnswer(t, "big.cache.test.", 60, answers...)
+	if len(big) <= 512 {
+		t.Fatalf("test response is %d bytes, it must exceed MaxEntryBytes", len(big))
+	}
+	small := upstreamAnswer(t, "small.cache.test.", 60)
+	before := stats.Get(stats.CacheTooLarge)
+
+	c.Put("big.cache.test.", dnsmessage.TypeA, cacheVariant{}, big, clockStart, false)
+	c.Put("small.cache.test.", dnsmessage.TypeA, cacheVariant{}, small, clockStart, false)
+	if c.Get("big.cache.test.", dnsmessage.TypeA, cacheVariant{}, clockStart) != nil {
+		t.Fatal("response above MaxEntryBytes was cached")
+	}
+	if c.Get("small.cache.test.", dnsmessage.TypeA, cacheVariant{}, clockStart) == nil {
+		t.Fatal("response within MaxEntryBytes was not cached")
+	}
+	if got := stats.Get(stats.CacheTooLarge) - before; got != 1 {
+		t.Fatalf("%s went up by %d, want 1", stats.CacheTooLarge, got)
+	}
+	if c.Len() != 1 {
+		t.Fatalf("cache holds %d entries, want 1", c.Len())
+	}
+}
+
+/*
+*	The limit applies to what would be stored, a response brought under it by dropping out of bailiwick records
+*	is cached
+ */
+func TestMaxEntryBytesAppliesAfterBailiwick(t *testing.T) {
+	c := newResponseCache(&config.Cache{MaxEntries: 10, MaxTTL: 3600, MaxEntryBytes: 200})
+	answers := []dnsmessage.Resource{dnstest.A("host.cache.test.", 60, "192.0.2.1")}
+	for k := 1; k <= 20; k++ {
+		answers = append(answers, dnstest.A(fmt.Sprintf("unrelated-%d.example.com.", k), 60, "198.51.100.1"))
+	}
+	packet := upstreamAnswer(t, "host.cache.test.", 60, answers...)
+	if len(packet) <= 200 {
+		t.Fatalf("test response is %d bytes, it must exceed MaxEntryBytes", len(packet))
+	}
+	c.Put("host.cache.test.", dnsmessage.TypeA, cacheVariant{}, packet, clockStart, false)
+	cached := c.Get("host.cache.test.", dnsmessage.TypeA, cacheVariant{}, clockStart)
+	if cached == nil || len(cached) > 200 {
+		t.Fatalf("response brought within MaxEntryBytes by the bailiwick check was not cached (%d bytes)", len(cached))
+	}
+}
+
+func TestConfiguredCacheEntryLimit(t *testing.T) {
+	conf := testConfig(t)
+	conf.Cache.MaxEntryBytes = 512
+	up := newUpstream(t)
+	var answers []dnsmessage.Resource
+	for k := 1; k <= 40; k++ {
+		answers = append(answers, dnstest.A("big.limit.test.", 60, fmt.Sprintf("192.0.2.%d", k)))
+	}
+	up.Handle("big.limit.test.", dnsmessage.TypeA, dnstest.Response{Answers: answers})
+	forwardTo(conf, "limit.test.", up)
+	reload(t, conf)
+
+	for k := 0; k < 2; k++ {
+		// the client still gets the full answer each time
+		if res := lookup(t, "big.limit.test.", dnsmessage.TypeA, 4096); len(res.Answers) != 40 {
+			t.Fatalf("answer %d has %d records, want 40", k+1, len(res.Answers))
+		}
+	}
+	if got := len(up.Queries()); got != 2 {
+		t.Fatalf("upstream received %d queries, want both since the answer is too large to cache", got)
+	}
+}
+
+func TestRestrictToBailiwick(t *testing.T) {
+	m := dnsmessage.Message{
+		Answers: []dnsmessage.Resource{
+			// the chain arrives out of order
+			dnstest.A("edge.cdn.test.", 60, "192.0.2.10"),
+			dnstest.CNAME("www.foo.test.", 60, "Edge.CDN.test."),
+			dnstest.A("example.com.", 60, "198.51.100.66"),
+			dnstest.CNAME("Foo.test.", 60, "www.foo.test."),
+		},
+		Authorities: []dnsmessage.Resource{
+			dnstest.NS("cdn.test.", 60, "ns.cdn.test."),
+			dnstest.NS("test.", 60, "ns.test."),
+			dnstest.NS("example.com.", 60, "ns.example.com."),
+			dnstest.NS("other.foo.test.", 60, "ns.other.foo.test."),
+		},
+		Additionals: []dnsmessage.Resource{
+			dnstest.A("ns.cdn.test.", 60, "192.0.2.53"),
+			dnstest.A("example.com.", 60, "198.51.100.66"),
+			{Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("."), Type: dnsmessage.TypeOPT, Class: 1232}, Body: &dnsmessage.OPTResource{}},
+		},
+	}
+	if dropped := restrictToBailiwick(&m, "foo.test."); dropped != 5 {
+		t.Errorf("%d records dropped, want the example.com. answer, two authorities and two additionals", dropped)
+	}
+	names := func(section []dnsmessage.Resource) []string {
+		var out []string
+		for _, r := range section {
+			out = append(out, r.Header.Type.String()+" "+r.Header.Name.String())
+		}
+		return out
+	}
+	if got := fmt.Sprint(names(m.Answers)); got != "[TypeA edge.cdn.test. TypeCNAME www.foo.test. TypeCNAME Foo.test.]" {
+		t.Errorf("answers kept are %s, want the chain from foo.test.", got)
+	}
+	if got := fmt.Sprint(names(m.Authorities)); got != "[TypeNS cdn.test. TypeNS test.]" {
+		t.Errorf("authorities kept are %s, want the zones of names on the chain", got)
+	}
+	if got := fmt.Sprint(names(m.Additionals)); got != "[TypeOPT .]" {
+		t.Errorf("additionals kept are %s, want OPT alone", got)
+	}
+}
+
+/*
+*	An upstream sneaking an A record for example.com. into the answer for foo.test. reaches the client that asked,
+*	but later queries for example.com. are never answered from it
+ */
+func TestOutOfBailiwickRecordIsNeverCached(t *testing.T) {
+	conf := testConfig(t)
+	up := newUpstream(t)
+	up.Handle("foo.test.", dnsmessage.TypeA, dnstest.Response{
+		Answers:     []dnsmessage.Resource{dnstest.A("foo.test.", 300, "192.0.2.15"), dnstest.A("example.com.", 300, "198.51.100.66")},
+		Additionals: []dnsmessage.Resource{dnstest.A("example.com.", 300, "198.51.100.66")},
+	})
+	up.Handle("example.com.", dnsmessage.TypeA, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.A("example.com.", 300, "192.0.2.99")}})
+	forwardTo(conf, "foo.test.", up)
+	forwardTo(conf, "example.com.", up)
+	reload(t, conf)
+	before := stats.Get(stats.CacheBailiwick)
+
+	res := lookup(t, "foo.test.", dnsmessage.TypeA, 0)
+	if len(res.Answers) != 2 {
+		t.Fatalf("client that triggered the query got %d answers, want the upstream response unchanged", len(res.Answers))
+	}
+	if got := stats.Get(stats.CacheBailiwick) - before; got != 2 {
+		t.Fatalf("%s went up by %d, want the answer and the additional", stats.CacheBailiwick, got)
+	}
+	res = lookup(t, "foo.test.", dnsmessage.TypeA, 0)
+	if len(res.Answers) != 1 || answerAddress(t, res) != "192.0.2.15" || len(res.Additionals) > 1 {
+		t.Fatalf("cached answer for foo.test. is %v with %d additionals, want its own record alone", res.Answers, len(res.Additionals))
+	}
+	if got := queriesFor(up, "foo.test."); got != 1 {
+		t.Fatalf("foo.test. reached the upstream %d times, want the second lookup from the cache", got)
+	}
+	if res := lookup(t, "example.com.", dnsmessage.TypeA, 0); answerAddress(t, res) != "192.0.2.99" {
+		t.Fatalf("example.com. answered %v, want the upstream's own answer and not the injected record", res.Answers)
+	}
+	if got := queriesFor(up, "example.com."); got != 1 {
+		t.Fatalf("example.com. reached the upstream %d times, want 1", got)
+	}
+}
+
+/*
+*	A query for name with an OPT record when edns is set, carrying DO and the CD flag as asked
+ */
+func variantQuery(t *testing.T, name string, edns, do, cd bool) []byte {
+	t.Helper()
+	m := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 0x4343, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+	}
+	if edns {
+		var h dnsmessage.ResourceHeader
+		h.SetEDNS0(1232, dnsmessage.RCodeSuccess, do)
+		m.Additionals = []dnsmessage.Resource{{Header: h, Body: &dnsmessage.OPTResource{}}}
+	}
+	query, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cd {
+		query[3] |= flagCheckingDisabled
+	}
+	return query
+}
+
+func responseOPT(t *testing.T, packet []byte) *dnsmessage.Resource {
+	t.Helper()
+	res := unpack(t, packet)
+	for k := range res.Additionals {
+		if res.Additionals[k].Header.Type == dnsmessage.TypeOPT {
+			return &res.Additionals[k]
+		}
+	}
+	return nil
+}
+
+/*
+*	The OPT record of a cached answer is built for the client asking, not kept from the client that filled the entry
+ */
+func TestCachedAnswerCarriesTheClientsOPT(t *testing.T) {
+	conf := testConfig(t)
+	up := newUpstream(t)
+	var h dnsmessage.ResourceHeader
+	h.SetEDNS0(1232, dnsmessage.RCodeSuccess, true)
+	up.Handle("host.edns.cache.test.", dnsmessage.TypeA, dnstest.Response{
+		Answers:     []dnsmessage.Resource{dnstest.A("host.edns.cache.test.", 300, "192.0.2.20")},
+		Additionals: []dnsmessage.Resource{{Header: h, Body: &dnsmessage.OPTResource{}}},
+	})
+	forwardTo(conf, "edns.cache.test.", up)
+	reload(t, conf)
+
+	exchange(t, variantQuery(t, "host.edns.cache.test.", true, false, false))
+	if opt := responseOPT(t, exchange(t, variantQuery(t, "host.edns.cache.test.", false, false, false))); opt != nil {
+		t.Fatal("client without EDNS got an OPT record from the cache")
+	}
+	opt := responseOPT(t, exchange(t, variantQuery(t, "host.edns.cache.test.", true, false, false)))
+	if opt == nil {
+		t.Fatal("client with EDNS got no OPT record from the cache")
+	}
+	if int(opt.Header.Class) != outboundUDPSize || opt.Header.DNSSECAllowed() {
+		t.Fatalf("cached answer OPT advertises %d bytes do=%t, want %d without DO like the query", opt.Header.Class, opt.Header.DNSSECAllowed(), outboundUDPSize)
+	}
+	if got := queriesFor(up, "host.edns.cache.test."); got != 1 {
+		t.Fatalf("upstream received %d queries, want the later ones from the cache", got)
+	}
+}
+
+/*
+*	Clients asking with DO or CD get answers of their own, an entry filled for one never answers the others
+ */
+func TestDOAndCDQueriesAreCachedApart(t *testing.T) {
+	conf := testConfig(t)
+	up := newUpstream(t)
+	up.Handle("host.variant.cache.test.", dnsmessage.TypeA, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.A("host.variant.cache.test.", 300, "192.0.2.21")}})
+	forwardTo(conf, "variant.cache.test.", up)
+	reload(t, conf)
+
+	variants := []struct{ edns, do, cd bool }{{true, false, false}, {true, true, false}, {true, false, true}, {false, false, true}}
+	for round := 0; round < 2; round++ {
+		for _, v := range variants {
+			res := unpack(t, exchange(t, variantQuery(t, "host.variant.cache.test.", v.edns, v.do, v.cd)))
+			if len(res.Answers) != 1 {
+				t.Fatalf("query with edns=%t do=%t cd=%t got %d answers, want 1", v.edns, v.do, v.cd, len(res.Answers))
+			}
+			if opt := responseOPT(t, exchange(t, variantQuery(t, "host.variant.cache.test.", v.edns, v.do, v.cd))); opt != nil && opt.Header.DNSSECAllowed() != v.do {
+				t.Fatalf("query with do=%t got an OPT with do=%t", v.do, opt.Header.DNSSECAllowed())
+			}
+		}
+	}
+	// the CD queries with and without EDNS share an entry, the rest each fill their own
+	if got := queriesFor(up, "host.variant.cache.test."); got != 3 {
+		t.Fatalf("upstream received %d queries, want one for each of plain, DO and CD", got)
+	}
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+	"github.com/TasSM/labns/internal/stats"
+)
+
+const maxCaseMismatches = 5
+
+/*
+*	DNS 0x20: randomizes qname letter case toward upstreams and checks the response echoes it exactly,
+*	upstreams that repeatedly normalize case are downgraded to sending the name unmodified
+ */
+type caseRandomizer struct {
+	disabled   bool
+	mismatches map[string]int
+	downgraded map[string]bool
+}
+
+func newCaseRandomizer(disabled bool) *caseRandomizer {
+	return &caseRandomizer{disabled: disabled, mismatches: make(map[string]int), downgraded: make(map[string]bool)}
+}
+
+func upstreamKey(ns *config.Nameserver) string {
+	ip := ns.IPv4
+	if ip == "" {
+		ip = ns.IPv6
+	}
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+	return net.JoinHostPort(ip, fmt.Sprint(ns.Port))
+}
+
+/*
+*	Returns the query to send to ns and the exact qname expected in the response, empty when case is not randomized.
+*	Only plain UDP upstreams get a randomized name, stream and encrypted transports can't be spoofed off-path and
+*	some strict ones reject it
+ */
+func (c *caseRandomizer) Prepare(query []byte, ns *config.Nameserver) ([]byte, string) {
+	if c.disabled || protocolOf(ns) != "udp" || c.downgraded[upstreamKey(ns)] {
+		return query, ""
+	}
+	out := make([]byte, len(query))
+	copy(out, query)
+	name, ok := rewriteQuestionName(out, func(b byte) byte {
+		if rand.Intn(2) == 0 {
+			return toggleCase(b)
+		}
+		return b
+	})
+	if !ok {
+		return query, ""
+	}
+	return out, name
+}
+
+/*
+*	Reports whether response carries the expected casing, counting case-only mismatches against the upstream
+ */
+func (c *caseRandomizer) Verify(response []byte, upstream string, sentName string) (bool, bool) {
+	if sentName == "" {
+		return true, false
+	}
+	got, ok := questionName(response)
+	if !ok {
+		return false, false
+	}
+	if got == sentName {
+		return true, false
+	}
+	if !strings.EqualFold(got, sentName) {
+		return false, false
+	}
+	stats.Increment(stats.CaseMismatch)
+	c.mismatches[upstream]++
+	if c.mismatches[upstream] >= maxCaseMismatches && !c.downgraded[upstream] {
+		c.downgraded[upstream] = true
+		logging.LogMessage(logging.LogError, fmt.Sprintf("Upstream %s failed 0x20 case verification %d times, disabling case randomization for it", upstream, c.mismatches[upstream]))
+	}
+	return false, true
+}
+
+/*
+*	Overwrites the question name of a packet with the casing of name, lengths always match as only case differs
+ */
+func restoreQuestionCase(packet []byte, name string) {
+	i := 0
+	rewriteQuestionName(packet, func(b byte) byte {
+		for i < len(name) && name[i] == '.' {
+			i++
+		}
+		if i >= len(name) {
+			return b
+		}
+		out := name[i]
+		i++
+		if strings.EqualFold(string(out), string(b)) {
+			return out
+		}
+		return b
+	})
+}
+
+func questionName(packet []byte) (string, bool) {
+	copied := make([]byte, len(packet))
+	copy(copied, packet)
+	return rewriteQuestionName(copied, func(b byte) byte { return b })
+}
+
+/*
+*	Walks the uncompressed labels of the first question in place, applying fn to every name byte
+ */
+func rewriteQuestionName(packet []byte, fn func(byte) byte) (string, bool) {
+	off := 12
+	var sb strings.Builder
+	for {
+		if off >= len(packet) {
+			return "", false
+		}
+		length := int(packet[off])
+		if length == 0 {
+			break
+		}
+		if length&0xC0 != 0 || off+1+length > len(packet) {
+			return "", false
+		}
+		for j := off + 1; j <= off+length; j++ {
+			packet[j] = fn(packet[j])
+			sb.WriteByte(packet[j])
+		}
+		sb.WriteByte('.')
+		off += length + 1
+	}
+	if sb.Len() == 0 {
+		return ".", true
+	}
+	return sb.String(), true
+}
+
+func toggleCase(b byte) byte {
+	switch {
+	case b >= 'a' && b <= 'z':
+		return b - 32
+	case b >= 'A' && b <= 'Z':
+		return b + 32
+	}
+	return b
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/TasSM/labns/internal/config"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// long enough that a randomized name equal to the original is out of the question
+const caseTestName = "randomized-letter-case.upstream.casing.test."
+
+func TestCaseRandomizedForUDPOnly(t *testing.T) {
+	query := rawQuery(t, 0x1401, 0x0100, question(caseTestName, dnsmessage.TypeA))
+	c := newCaseRandomizer(false)
+	for _, protocol := range []string{"", "udp"} {
+		ns := &config.Nameserver{IPv4: "192.0.2.1", Port: 53, Protocol: protocol}
+		out, sentName := c.Prepare(query, ns)
+		if sentName == "" || sentName == caseTestName || !strings.EqualFold(sentName, caseTestName) {
+			t.Fatalf("query to a %q upstream was sent as %q, want %s in random case", protocol, sentName, caseTestName)
+		}
+		if name, _ := questionName(out); name != sentName {
+			t.Fatalf("query to a %q upstream carries %q, want the reported %q", protocol, name, sentName)
+		}
+		if name, _ := questionName(query); name != caseTestName {
+			t.Fatalf("preparing the query changed the original to %q", name)
+		}
+	}
+	for _, protocol := range []string{"tcp", "tls", "https"} {
+		ns := &config.Nameserver{IPv4: "192.0.2.1", Port: 53, Protocol: protocol}
+		if out, sentName := c.Prepare(query, ns); sentName != "" || !bytes.Equal(out, query) {
+			t.Errorf("query to a %s upstream was sent as %q, want it unmodified", protocol, sentName)
+		}
+	}
+	if out, sentName := newCaseRandomizer(true).Prepare(query, &config.Nameserver{IPv4: "192.0.2.1", Port: 53}); sentName != "" || !bytes.Equal(out, query) {
+		t.Errorf("disabled 0x20 sent the name as %q", sentName)
+	}
+}
+
+func TestCaseMismatchDowngradesUpstream(t *testing.T) {
+	query := rawQuery(t, 0x1402, 0x0100, question(caseTestName, dnsmessage.TypeA))
+	ns := &config.Nameserver{IPv4: "192.0.2.2", Port: 53}
+	key := upstreamKey(ns)
+	c := newCaseRandomizer(false)
+
+	out, sentName := c.Prepare(query, ns)
+	if ok, _ := c.Verify(out, key, sentName); !ok {
+		t.Fatal("response echoing the sent case failed verification")
+	}
+	if ok, caseOnly := c.Verify(rawQuery(t, 0x1402, 0x8180, question("other.casing.test.", dnsmessage.TypeA)), key, sentName); ok || caseOnly {
+		t.Fatalf("response for another name verified %t (case only %t), want a plain mismatch", ok, caseOnly)
+	}
+	for i := 0; i < maxCaseMismatches; i++ {
+		_, sentName = c.Prepare(query, ns)
+		if sentName == "" {
+			t.Fatalf("upstream downgraded after %d case mismatches, want %d", i, maxCaseMismatches)
+		}
+		// an upstream normalizing the name answers in lower case
+		if ok, caseOnly := c.Verify(query, key, sentName); ok || !caseOnly {
+			t.Fatalf("lower-cased response verified %t (case only %t), want a case-only mismatch", ok, caseOnly)
+		}
+	}
+	if _, sentName := c.Prepare(query, ns); sentName != "" {
+		t.Fatalf("upstream still gets %q after %d case mismatches", sentName, maxCaseMismatches)
+	}
+	if _, sentName := c.Prepare(query, &config.Nameserver{IPv4: "192.0.2.3", Port: 53}); sentName == "" {
+		t.Fatal("downgrading one upstream disabled 0x20 for another")
+	}
+}
+
+func TestRestoreQuestionCase(t *testing.T) {
+	packet := rawQuery(t, 0x1403, 0x0100, question(caseTestName, dnsmessage.TypeA))
+	sent := "RaNdOmIzEd-LeTtEr-CaSe.UpStReAm.CaSiNg.TeSt."
+	restoreQuestionCase(packet, sent)
+	if name, _ := questionName(packet); name != sent {
+		t.Fatalf("restored name is %q, want %q", name, sent)
+	}
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnsname"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	Follows CNAMEs from start using next, returning every name on the chain including start and the final target.
+*	A name seen twice is reported as a loop, and a chain of more than maxDepth CNAMEs as too deep
+ */
+func followChain(start string, next func(name string) (string, bool), maxDepth int) ([]string, error) {
+	chain := []string{start}
+	visited := map[string]bool{dnsname.Key(start): true}
+	name := start
+	for {
+		target, ok := next(name)
+		if !ok {
+			return chain, nil
+		}
+		chain = append(chain, target)
+		if visited[dnsname.Key(target)] {
+			return chain, errors.New("CNAME loop " + strings.Join(chain, " -> "))
+		}
+		if len(chain)-1 > maxDepth {
+			return chain, errors.New(fmt.Sprintf("CNAME chain from %s is longer than %d", start, maxDepth))
+		}
+		visited[dnsname.Key(target)] = true
+		name = target
+	}
+}
+
+/*
+*	Local CNAME records by lowercased owner name, used to answer other query types for an alias from local data
+ */
+type localCNAMEs map[string]*config.LocalDNSRecord
+
+func newLocalCNAMEs(records []config.LocalDNSRecord) localCNAMEs {
+	c := make(localCNAMEs)
+	for k := range records {
+		if strings.ToUpper(records[k].Type) == "CNAME" {
+			c[dnsname.Key(records[k].Name)] = &records[k]
+		}
+	}
+	return c
+}
+
+func (c localCNAMEs) Target(name string) (string, bool) {
+	if r := c[dnsname.Key(name)]; r != nil {
+		return r.Target, true
+	}
+	return "", false
+}
+
+/*
+*	Answers query with the CNAME records along chain followed by final, the local answer for the last name if it has one.
+*	Chains ending outside local data are answered with the CNAMEs alone for the client to follow
+ */
+func BuildChainResponse(query []byte, chain []string, cnames localCNAMEs, final []byte) ([]byte, error) {
+	b, err := newResponseBuilder(query)
+	if err != nil {
+		return nil, err
+	}
+	var answers []dnsmessage.Resource
+	for _, name := range chain[:len(chain)-1] {
+		r := cnames[dnsname.Key(name)]
+		owner, err := dnsmessage.NewName(r.Name)
+		if err != nil {
+			return nil, err
+		}
+		target, err := dnsmessage.NewName(r.Target)
+		if err != nil {
+			return nil, err
+		}
+		answers = append(answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: owner, Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET, TTL: r.TTL},
+			Body:   &dnsmessage.CNAMEResource{CNAME: target},
+		})
+	}
+	if final != nil {
+		var f dnsmessage.Message
+		if err := f.Unpack(final); err != nil {
+			return nil, err
+		}
+		answers = append(answers, f.Answers...)
+	}
+	return b.Answer(answers, true)
+}
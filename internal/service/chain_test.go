@@ -0,0 +1,148 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestFollowChain(t *testing.T) {
+	links := map[string]string{"a.": "b.", "b.": "C.", "c.": "d.", "loop.": "Back.", "back.": "LOOP."}
+	next := func(name string) (string, bool) {
+		target, ok := links[strings.ToLower(name)]
+		return target, ok
+	}
+	cases := []struct {
+		start    string
+		maxDepth int
+		chain    string
+		err      string
+	}{
+		{"a.", 8, "a. b. C. d.", ""},
+		{"a.", 3, "a. b. C. d.", ""},
+		{"a.", 2, "a. b. C. d.", "CNAME chain from a. is longer than 2"},
+		{"d.", 8, "d.", ""},
+		{"loop.", 8, "loop. Back. LOOP.", "CNAME loop loop. -> Back. -> LOOP."},
+	}
+	for _, c := range cases {
+		chain, err := followChain(c.start, next, c.maxDepth)
+		if got := strings.Join(chain, " "); got != c.chain {
+			t.Errorf("chain from %s with depth %d is %q, want %q", c.start, c.maxDepth, got, c.chain)
+		}
+		if got := fmt.Sprint(err); (c.err == "" && err != nil) || (c.err != "" && got != c.err) {
+			t.Errorf("chain from %s with depth %d failed with %v, want %q", c.start, c.maxDepth, err, c.err)
+		}
+	}
+}
+
+/*
+*	Adds a local CNAME for each link, from the first name to the last
+ */
+func localChain(conf *config.Configuration, names ...string) {
+	for i := 0; i+1 < len(names); i++ {
+		conf.LocalRecords = append(conf.LocalRecords, config.LocalDNSRecord{Name: names[i], Type: "CNAME", TTL: 300, Target: names[i+1]})
+	}
+}
+
+func TestLocalCNAMELoopIsServfail(t *testing.T) {
+	conf := testConfig(t)
+	localChain(conf, "a.loop.test.", "b.loop.test.", "a.loop.test.")
+	reload(t, conf)
+
+	res := lookup(t, "a.loop.test.", dnsmessage.TypeA, 0)
+	if res.Header.RCode != dnsmessage.RCodeServerFailure || len(res.Answers) != 0 {
+		t.Fatalf("local CNAME loop answered %s with %d answers, want SERVFAIL", res.Header.RCode, len(res.Answers))
+	}
+	// the CNAME itself is still answered as a record
+	if res := lookup(t, "a.loop.test.", dnsmessage.TypeCNAME, 0); res.Header.RCode != dnsmessage.RCodeSuccess || len(res.Answers) != 1 {
+		t.Fatalf("CNAME query inside a loop answered %s with %d answers, want the record alone", res.Header.RCode, len(res.Answers))
+	}
+}
+
+/*
+*	A local CNAME into forwarded data ends the local answer, the client follows the rest. An upstream chain that
+*	comes back to the local name is caught by the upstream response check
+ */
+func TestLocalToUpstreamToLocalLoop(t *testing.T) {
+	conf := testConfig(t)
+	localChain(conf, "start.loop.test.", "hop.upstream-loop.test.")
+	up := newUpstream(t)
+	up.Handle("hop.upstream-loop.test.", dnsmessage.TypeA, dnstest.Response{Answers: []dnsmessage.Resource{
+		dnstest.CNAME("hop.upstream-loop.test.", 60, "start.loop.test."),
+		dnstest.CNAME("start.loop.test.", 60, "hop.upstream-loop.test."),
+	}})
+	forwardTo(conf, "upstream-loop.test.", up)
+	reload(t, conf)
+
+	res := lookup(t, "start.loop.test.", dnsmessage.TypeA, 0)
+	if res.Header.RCode != dnsmessage.RCodeSuccess || len(res.Answers) != 1 {
+		t.Fatalf("local CNAME into forwarded data answered %s with %d answers, want the CNAME alone", res.Header.RCode, len(res.Answers))
+	}
+	if cname, ok := res.Answers[0].Body.(*dnsmessage.CNAMEResource); !ok || cname.CNAME.String() != "hop.upstream-loop.test." {
+		t.Fatalf("answer is %v, want start.loop.test. CNAME hop.upstream-loop.test.", res.Answers[0])
+	}
+	if got := len(up.Queries()); got != 0 {
+		t.Fatalf("the tail of a local chain was forwarded %d times", got)
+	}
+
+	res = lookup(t, "hop.upstream-loop.test.", dnsmessage.TypeA, 0)
+	if res.Header.RCode != dnsmessage.RCodeServerFailure || len(res.Answers) != 0 {
+		t.Fatalf("upstream chain back into the local name answered %s with %d answers, want SERVFAIL", res.Header.RCode, len(res.Answers))
+	}
+}
+
+/*
+*	Returns the names of a chain of n CNAMEs ending at an A record, added to conf as local records
+ */
+func deepChain(conf *config.Configuration, n int) []string {
+	names := make([]string, n+1)
+	for i := range names {
+		names[i] = fmt.Sprintf("hop%d.deep.test.", i)
+	}
+	localChain(conf, names...)
+	conf.LocalRecords = append(conf.LocalRecords, config.LocalDNSRecord{Name: names[n], Type: "A", TTL: 300, Target: "192.0.2.70"})
+	return names
+}
+
+func TestDeepLocalChain(t *testing.T) {
+	conf := testConfig(t)
+	names := deepChain(conf, int(config.DEFAULT_MAX_CHAIN_DEPTH))
+	reload(t, conf)
+
+	res := lookup(t, names[0], dnsmessage.TypeA, 0)
+	if res.Header.RCode != dnsmessage.RCodeSuccess || len(res.Answers) != len(names) {
+		t.Fatalf("chain of %d CNAMEs answered %s with %d answers, want every CNAME and the A record", len(names)-1, res.Header.RCode, len(res.Answers))
+	}
+	for i, a := range res.Answers[:len(names)-1] {
+		if cname, ok := a.Body.(*dnsmessage.CNAMEResource); !ok || a.Header.Name.String() != names[i] || cname.CNAME.String() != names[i+1] {
+			t.Fatalf("answer %d is %v, want %s CNAME %s", i, a, names[i], names[i+1])
+		}
+	}
+	if answerAddress(t, dnsmessage.Message{Answers: res.Answers[len(names)-1:]}) != "192.0.2.70" {
+		t.Fatalf("last answer is %v, want the A record of %s", res.Answers[len(names)-1], names[len(names)-1])
+	}
+}
+
+func TestMaxChainDepth(t *testing.T) {
+	conf := testConfig(t)
+	names := deepChain(conf, int(config.DEFAULT_MAX_CHAIN_DEPTH)+1)
+	reload(t, conf)
+	if res := lookup(t, names[0], dnsmessage.TypeA, 0); res.Header.RCode != dnsmessage.RCodeServerFailure {
+		t.Fatalf("chain one longer than the default depth answered %s, want SERVFAIL", res.Header.RCode)
+	}
+
+	conf = testConfig(t)
+	conf.MaxChainDepth = 2
+	names = deepChain(conf, 3)
+	reload(t, conf)
+	if res := lookup(t, names[0], dnsmessage.TypeA, 0); res.Header.RCode != dnsmessage.RCodeServerFailure {
+		t.Fatalf("chain of 3 with MaxChainDepth 2 answered %s, want SERVFAIL", res.Header.RCode)
+	}
+	if res := lookup(t, names[1], dnsmessage.TypeA, 0); res.Header.RCode != dnsmessage.RCodeSuccess || len(res.Answers) != 3 {
+		t.Fatalf("chain of 2 with MaxChainDepth 2 answered %s with %d answers, want NOERROR with 3", res.Header.RCode, len(res.Answers))
+	}
+}
@@ -0,0 +1,18 @@
+package service
+
+import "golang.org/x/net/dns/dnsmessage"
+
+/*
+*	The rcode a question outside class IN is answered with before it reaches the state worker, so local records,
+*	the cache and the upstreams only ever see IN. CHAOS is REFUSED since labns serves no version.bind or
+*	hostname.bind names, ANY and every other class is NOTIMP. The bool is false for IN queries
+ */
+func classRcode(class dnsmessage.Class) (dnsmessage.RCode, bool) {
+	switch class {
+	case dnsmessage.ClassINET:
+		return dnsmessage.RCodeSuccess, false
+	case dnsmessage.ClassCHAOS:
+		return dnsmessage.RCodeRefused, true
+	}
+	return dnsmessage.RCodeNotImplemented, true
+}
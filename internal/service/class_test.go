@@ -0,0 +1,76 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/TasSM/labns/internal/stats"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const classNONE dnsmessage.Class = 254
+
+func TestClassRcode(t *testing.T) {
+	cases := map[dnsmessage.Class]dnsmessage.RCode{
+		dnsmessage.ClassINET:   dnsmessage.RCodeSuccess,
+		dnsmessage.ClassCHAOS:  dnsmessage.RCodeRefused,
+		dnsmessage.ClassHESIOD: dnsmessage.RCodeNotImplemented,
+		dnsmessage.ClassCSNET:  dnsmessage.RCodeNotImplemented,
+		dnsmessage.ClassANY:    dnsmessage.RCodeNotImplemented,
+		classNONE:              dnsmessage.RCodeNotImplemented,
+		4242:                   dnsmessage.RCodeNotImplemented,
+	}
+	for class, want := range cases {
+		rcode, settled := classRcode(class)
+		if rcode != want || settled != (class != dnsmessage.ClassINET) {
+			t.Errorf("classRcode(%s) = %s, %t, want %s", class, rcode, settled, want)
+		}
+	}
+}
+
+/*
+*	Queries outside IN are answered in the listener with the question echoed, never from local records, the cache
+*	or the upstreams
+ */
+func TestQueryOutsideClassIN(t *testing.T) {
+	cases := []struct {
+		name  string
+		class dnsmessage.Class
+		rcode dnsmessage.RCode
+	}{
+		{"nas.lab.home.", dnsmessage.ClassCHAOS, dnsmessage.RCodeRefused},
+		{"version.bind.", dnsmessage.ClassCHAOS, dnsmessage.RCodeRefused},
+		{"nas.lab.home.", dnsmessage.ClassANY, dnsmessage.RCodeNotImplemented},
+		{"nas.lab.home.", classNONE, dnsmessage.RCodeNotImplemented},
+		{"www.example.com.", dnsmessage.ClassHESIOD, dnsmessage.RCodeNotImplemented},
+		{"www.example.com.", 4242, dnsmessage.RCodeNotImplemented},
+	}
+	for k, c := range cases {
+		before := stats.Get(stats.ClassNotIN)
+		sent := queriesFor(primary, c.name) + queriesFor(secondary, c.name)
+		q := dnsmessage.Question{Name: dnsmessage.MustNewName(c.name), Type: dnsmessage.TypeA, Class: c.class}
+		res := unpack(t, exchange(t, rawQuery(t, uint16(0x1900+k), 0x0100, q)))
+		if res.Header.RCode != c.rcode || len(res.Answers) != 0 || res.Header.Authoritative {
+			t.Errorf("%s in class %s answered %s with %d answers and AA %t, want %s without answers",
+				c.name, c.class, res.Header.RCode, len(res.Answers), res.Header.Authoritative, c.rcode)
+		}
+		if len(res.Questions) != 1 || res.Questions[0] != q {
+			t.Errorf("%s in class %s echoed the questions %v", c.name, c.class, res.Questions)
+		}
+		if got := stats.Get(stats.ClassNotIN) - before; got != 1 {
+			t.Errorf("%s in class %s raised %s by %d, want 1", c.name, c.class, stats.ClassNotIN, got)
+		}
+		if got := queriesFor(primary, c.name) + queriesFor(secondary, c.name) - sent; got != 0 {
+			t.Errorf("%s in class %s reached the upstreams %d times", c.name, c.class, got)
+		}
+	}
+
+	// the IN record is still answered after the CHAOS query for the same name
+	before := stats.Get(stats.ClassNotIN)
+	res := unpack(t, exchange(t, rawQuery(t, 0x19ff, 0x0100, question("nas.lab.home.", dnsmessage.TypeA))))
+	if res.Header.RCode != dnsmessage.RCodeSuccess || answerAddress(t, res) != "192.168.1.10" {
+		t.Fatalf("nas.lab.home. in class IN answered %s with %v, want its local record", res.Header.RCode, res.Answers)
+	}
+	if got := stats.Get(stats.ClassNotIN) - before; got != 0 {
+		t.Fatalf("query in class IN raised %s by %d", stats.ClassNotIN, got)
+	}
+}
@@ -0,0 +1,14 @@
+package service
+
+import "github.com/TasSM/labns/internal/clock"
+
+// the time source of query handling, caching, upstream timeouts and upstream health
+var serviceClock = clock.Real
+
+/*
+*	Replaces the clock the service reads time from, for tests and tools that simulate time with a clock.Fake. Must be
+*	called before StartDNSService
+ */
+func SetClock(c clock.Clock) {
+	serviceClock = c
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/TasSM/labns/internal/clock"
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var clockStart = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+/*
+*	A NOERROR upstream answer for name with one A record of ttl
+ */
+func upstreamAnswer(t *testing.T, name string, ttl uint32, answers ...dnsmessage.Resource) []byte {
+	t.Helper()
+	if len(answers) == 0 {
+		answers = []dnsmessage.Resource{dnstest.A(name, ttl, "192.0.2.50")}
+	}
+	m := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 1, Response: true, RecursionDesired: true, RecursionAvailable: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+		Answers:   answers,
+	}
+	packet, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+func answerTTL(t *testing.T, packet []byte) uint32 {
+	t.Helper()
+	var m dnsmessage.Message
+	if err := m.Unpack(packet); err != nil || len(m.Answers) == 0 {
+		t.Fatalf("cached packet has no answers (%v)", err)
+	}
+	return m.Answers[0].Header.TTL
+}
+
+func TestCacheExpiresAtMaxTTL(t *testing.T) {
+	c := newResponseCache(&config.Cache{MaxEntries: 10, MaxTTL: 3600})
+	fake := clock.NewFake(clockStart)
+	c.Put("day.clock.test.", dnsmessage.TypeA, cacheVariant{}, upstreamAnswer(t, "day.clock.test.", 86400), fake.Now(), false)
+
+	fake.Advance(3599 * time.Second)
+	res := c.Get("day.clock.test.", dnsmessage.TypeA, cacheVariant{}, fake.Now())
+	if res == nil {
+		t.Fatal("entry gone a second before MaxTTL")
+	}
+	if ttl := answerTTL(t, res); ttl != 86400-3599 {
+		t.Fatalf("TTL after 3599s is %d, want the upstream TTL counted down to %d", ttl, 86400-3599)
+	}
+	// an entry expiring exactly at lookup time is gone
+	fake.Advance(time.Second)
+	if c.Get("day.clock.test.", dnsmessage.TypeA, cacheVariant{}, fake.Now()) != nil {
+		t.Fatal("entry still served at MaxTTL")
+	}
+	if c.Len() != 0 {
+		t.Fatal("expired entry was not removed on lookup")
+	}
+}
+
+func TestCacheCountsDownToZeroAtExpiry(t *testing.T) {
+	c := newResponseCache(&config.Cache{MaxEntries: 10, MaxTTL: 3600})
+	fake := clock.NewFake(clockStart)
+	c.Put("short.clock.test.", dnsmessage.TypeA, cacheVariant{}, upstreamAnswer(t, "short.clock.test.", 30), fake.Now(), false)
+	fake.Advance(29*time.Second + 999*time.Millisecond)
+	res := c.Get("short.clock.test.", dnsmessage.TypeA, cacheVariant{}, fake.Now())
+	if res == nil || answerTTL(t, res) != 1 {
+		t.Fatal("entry with a TTL of 30 is not served with a TTL of 1 just before it expires")
+	}
+	fake.Advance(time.Millisecond)
+	if c.Get("short.clock.test.", dnsmessage.TypeA, cacheVariant{}, fake.Now()) != nil {
+		t.Fatal("entry still served once its TTL ran out")
+	}
+}
+
+/*
+*	A TTLOverride pins the answer above MaxTTL, ten hours in it is still cached and counting down from the pin
+ */
+func TestPinnedTTLOutlivesMaxTTL(t *testing.T) {
+	c := newResponseCache(&config.Cache{MaxEntries: 10, MaxTTL: 3600})
+	fake := clock.NewFake(clockStart)
+	c.Put("pinned.clock.test.", dnsmessage.TypeA, cacheVariant{}, upstreamAnswer(t, "pinned.clock.test.", 86400), fake.Now(), true)
+	c.Put("unpinned.clock.test.", dnsmessage.TypeA, cacheVariant{}, upstreamAnswer(t, "unpinned.clock.test.", 86400), fake.Now(), false)
+
+	fake.Advance(10 * time.Hour)
+	res := c.Get("pinned.clock.test.", dnsmessage.TypeA, cacheVariant{}, fake.Now())
+	if res == nil {
+		t.Fatal("pinned entry expired at MaxTTL")
+	}
+	if ttl := answerTTL(t, res); ttl != 86400-10*3600 {
+		t.Fatalf("pinned TTL after 10h is %d, want %d", ttl, 86400-10*3600)
+	}
+	if c.Get("unpinned.clock.test.", dnsmessage.TypeA, cacheVariant{}, fake.Now()) != nil {
+		t.Fatal("unpinned entry outlived MaxTTL")
+	}
+}
+
+/*
+*	Waits until something is waiting on the fake clock, so advancing it can't race the timer being created
+ */
+func waitForTimer(t *testing.T, fake *clock.Fake) {
+	t.Helper()
+	for end := time.Now().Add(time.Second); fake.Waiters() == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(end) {
+			t.Fatal("awaitUpstream never started its timer")
+		}
+	}
+}
+
+func TestAwaitUpstreamFiresAtTimeout(t *testing.T) {
+	fake := clock.NewFake(clockStart)
+	input := make(chan StateOperation, 1)
+	next := StateOperation{Operation: OpCallback, RequestId: 7}
+	go awaitUpstream(context.Background(), fake, input, 500*time.Millisecond, next)
+	waitForTimer(t, fake)
+
+	fake.Advance(499 * time.Millisecond)
+	select {
+	case op := <-input:
+		t.Fatalf("operation %d queued before the timeout", op.Operation)
+	case <-time.After(20 * time.Millisecond):
+	}
+	fake.Advance(time.Millisecond)
+	select {
+	case op := <-input:
+		if op.Operation != OpCallback || op.RequestId != 7 {
+			t.Fatalf("queued operation %d for request %d, want the callback for 7", op.Operation, op.RequestId)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nothing queued once the timeout passed")
+	}
+	if fake.Waiters() != 0 {
+		t.Fatal("timer still waiting after it fired")
+	}
+}
+
+func TestAwaitUpstreamExpiresAtDeadline(t *testing.T) {
+	fake := clock.NewFake(clockStart)
+	input := make(chan StateOperation, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	go awaitUpstream(ctx, fake, input, time.Hour, StateOperation{Operation: OpCallback, RequestId: 8})
+	select {
+	case op := <-input:
+		if op.Operation != OpExpire || op.RequestId != 8 {
+			t.Fatalf("queued operation %d for request %d, want OpExpire for 8", op.Operation, op.RequestId)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nothing queued once the query deadline passed")
+	}
+	for end := time.Now().Add(time.Second); fake.Waiters() != 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(end) {
+			t.Fatal("timer was not stopped when the deadline passed")
+		}
+	}
+}
+
+func TestAwaitUpstreamQueuesNothingWhenCancelled(t *testing.T) {
+	fake := clock.NewFake(clockStart)
+	input := make(chan StateOperation, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		awaitUpstream(ctx, fake, input, time.Hour, StateOperation{Operation: OpCallback, RequestId: 9})
+		close(done)
+	}()
+	waitForTimer(t, fake)
+	// answered by an upstream, the pending request is cancelled
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("awaitUpstream did not return when cancelled")
+	}
+	if len(input) != 0 {
+		t.Fatal("an operation was queued for a cancelled request")
+	}
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	ednsOptionCookie = 10
+	clientCookieSize = 8
+	rcodeBadCookie   = dnsmessage.RCode(23)
+)
+
+type cookieResult int
+
+const (
+	cookieOK cookieResult = iota
+	cookieMismatch
+	cookieBad
+)
+
+/*
+*	DNS cookies toward upstreams (RFC 7873): each upstream gets its own random client cookie for the life of the
+*	process, and the server cookie it returns is echoed on later queries. Only used by the state worker
+ */
+type upstreamCookies struct {
+	disabled bool
+	client   map[string][]byte
+	server   map[string][]byte
+}
+
+func newUpstreamCookies(disabled bool) *upstreamCookies {
+	return &upstreamCookies{disabled: disabled, client: make(map[string][]byte), server: make(map[string][]byte)}
+}
+
+func (c *upstreamCookies) clientCookie(upstream string) []byte {
+	cookie := c.client[upstream]
+	if cookie == nil {
+		cookie = make([]byte, clientCookieSize)
+		if _, err := rand.Read(cookie); err != nil {
+			return nil
+		}
+		c.client[upstream] = cookie
+	}
+	return cookie
+}
+
+/*
+*	Returns the query with our cookie for upstream in its OPT record, replacing any cookie the client sent. A query
+*	without EDNS gets an OPT record added, the response's OPT is removed again before it is relayed
+ */
+func (c *upstreamCookies) Prepare(query []byte, upstream string) []byte {
+	if c.disabled {
+		return query
+	}
+	cookie := c.clientCookie(upstream)
+	if cookie == nil {
+		return query
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(query); err != nil {
+		return query
+	}
+	option := dnsmessage.Option{Code: ednsOptionCookie, Data: append(append([]byte{}, cookie...), c.server[upstream]...)}
+	found := false
+	for k := range m.Additionals {
+		opt, ok := m.Additionals[k].Body.(*dnsmessage.OPTResource)
+		if !ok {
+			continue
+		}
+		options := []dnsmessage.Option{option}
+		for _, o := range opt.Options {
+			if o.Code != ednsOptionCookie {
+				options = append(options, o)
+			}
+		}
+		opt.Options = options
+		found = true
+	}
+	if !found {
+		var h dnsmessage.ResourceHeader
+		if err := h.SetEDNS0(outboundUDPSize, dnsmessage.RCodeSuccess, false); err != nil {
+			return query
+		}
+		m.Additionals = append(m.Additionals, dnsmessage.Resource{Header: h, Body: &dnsmessage.OPTResource{Options: []dnsmessage.Option{option}}})
+	}
+	packed, err := m.Pack()
+	if err != nil {
+		return query
+	}
+	packed[3] |= query[3] & (flagCheckingDisabled | flagAuthenticData)
+	return packed
+}
+
+/*
+*	Checks the cookie in a response from upstream and remembers its server cookie. A response echoing a different
+*	client cookie is reported as a mismatch so it can be dropped as forged, and BADCOOKIE as bad so the query can be
+*	sent again with the server cookie the response carried. Upstreams that don't return a cookie are accepted
+ */
+func (c *upstreamCookies) Verify(response []byte, upstream string) cookieResult {
+	if c.disabled {
+		return cookieOK
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(response); err != nil {
+		return cookieOK
+	}
+	for _, r := range m.Additionals {
+		opt, ok := r.Body.(*dnsmessage.OPTResource)
+		if !ok {
+			continue
+		}
+		for _, o := range opt.Options {
+			if o.Code != ednsOptionCookie {
+				continue
+			}
+			// a server cookie is 8 to 32 bytes
+			if len(o.Data) < clientCookieSize+8 || len(o.Data) > clientCookieSize+32 || !bytes.Equal(o.Data[:clientCookieSize], c.client[upstream]) {
+				return cookieMismatch
+			}
+			c.server[upstream] = append([]byte{}, o.Data[clientCookieSize:]...)
+		}
+		if r.Header.ExtendedRCode(m.Header.RCode) == rcodeBadCookie {
+			return cookieBad
+		}
+	}
+	return cookieOK
+}
+
+/*
+*	Removes the cookie from a response before it is cached or relayed, and the whole OPT record when the client
+*	didn't send one, reporting whether anything changed
+ */
+func stripUpstreamCookie(packet []byte, clientEDNS bool) ([]byte, bool) {
+	var m dnsmessage.Message
+	if err := m.Unpack(packet); err != nil {
+		return packet, false
+	}
+	changed := false
+	additionals := m.Additionals[:0]
+	for _, r := range m.Additionals {
+		opt, ok := r.Body.(*dnsmessage.OPTResource)
+		if !ok {
+			additionals = append(additionals, r)
+			continue
+		}
+		if !clientEDNS {
+			changed = true
+			continue
+		}
+		options := opt.Options[:0]
+		for _, o := range opt.Options {
+			if o.Code == ednsOptionCookie {
+				changed = true
+				continue
+			}
+			options = append(options, o)
+		}
+		opt.Options = options
+		additionals = append(additionals, r)
+	}
+	m.Additionals = additionals
+	if !changed {
+		return packet, false
+	}
+	packed, err := m.Pack()
+	if err != nil {
+		return packet, false
+	}
+	return packed, true
+}
+
+func hasEDNS(packet []byte) bool {
+	var m dnsmessage.Message
+	if err := m.Unpack(packet); err != nil {
+		return false
+	}
+	for _, r := range m.Additionals {
+		if r.Header.Type == dnsmessage.TypeOPT {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/TasSM/labns/internal/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	Sends a raw query straight to an upstream, bypassing the service
+ */
+func exchangeUpstreamUDP(t *testing.T, addr string, query []byte) []byte {
+	t.Helper()
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(exchangeTimeout))
+	if _, err := conn.Write(query); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no answer from upstream: %v", err)
+	}
+	return buf[:n]
+}
+
+func cookieOption(t *testing.T, packet []byte) []byte {
+	t.Helper()
+	var m dnsmessage.Message
+	if err := m.Unpack(packet); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range m.Additionals {
+		if opt, ok := r.Body.(*dnsmessage.OPTResource); ok {
+			for _, o := range opt.Options {
+				if o.Code == ednsOptionCookie {
+					return o.Data
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func TestCookieRoundTripWithEnforcingUpstream(t *testing.T) {
+	up := newUpstream(t)
+	up.RequireCookies()
+	up.Handle("host.cookie.test.", dnsmessage.TypeA, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.A("host.cookie.test.", 60, "192.0.2.10")}})
+	c := newUpstreamCookies(false)
+	query, err := BuildQuery("host.cookie.test.", dnsmessage.TypeA, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first query only carries our client cookie, the upstream answers BADCOOKIE with its server cookie
+	first := c.Prepare(query, up.Addr())
+	if size := clientPayloadSize(first); size != outboundUDPSize {
+		t.Fatalf("OPT added to a query without EDNS advertises %d bytes, want %d", size, outboundUDPSize)
+	}
+	if sent := cookieOption(t, first); len(sent) != clientCookieSize {
+		t.Fatalf("first query carries a %d byte cookie, want the %d byte client cookie alone", len(sent), clientCookieSize)
+	}
+	if got := c.Verify(exchangeUpstreamUDP(t, up.Addr(), first), up.Addr()); got != cookieBad {
+		t.Fatalf("Verify of the first answer = %d, want cookieBad", got)
+	}
+
+	// the retry echoes the server cookie and is answered
+	retry := c.Prepare(query, up.Addr())
+	sent := cookieOption(t, retry)
+	if len(sent) <= clientCookieSize || !bytes.Equal(sent[:clientCookieSize], cookieOption(t, first)) {
+		t.Fatalf("retry cookie %x does not keep the client cookie and add the server cookie", sent)
+	}
+	res := exchangeUpstreamUDP(t, up.Addr(), retry)
+	if got := c.Verify(res, up.Addr()); got != cookieOK {
+		t.Fatalf("Verify of the answer to the retry = %d, want cookieOK", got)
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(res); err != nil || m.Header.RCode != dnsmessage.RCodeSuccess || len(m.Answers) != 1 {
+		t.Fatalf("answer to the retry is %s with %d answers, want NOERROR with 1", m.Header.RCode, len(m.Answers))
+	}
+
+	// a later query reuses both cookies and needs no retry
+	again := c.Prepare(query, up.Addr())
+	if !bytes.Equal(cookieOption(t, again), sent) {
+		t.Fatal("a later query does not reuse the stored server cookie")
+	}
+}
+
+func TestCookieFromAnotherClientIsAMismatch(t *testing.T) {
+	up := newUpstream(t)
+	up.RequireCookies()
+	ours, theirs := newUpstreamCookies(false), newUpstreamCookies(false)
+	query, err := BuildQuery("host.cookie.test.", dnsmessage.TypeA, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ours.Prepare(query, up.Addr())
+	res := exchangeUpstreamUDP(t, up.Addr(), theirs.Prepare(query, up.Addr()))
+	if got := ours.Verify(res, up.Addr()); got != cookieMismatch {
+		t.Fatalf("Verify of an answer echoing another client cookie = %d, want cookieMismatch", got)
+	}
+}
+
+func TestPrepareReplacesClientCookie(t *testing.T) {
+	var h dnsmessage.ResourceHeader
+	h.SetEDNS0(1232, dnsmessage.RCodeSuccess, false)
+	clientCookie := []byte{9, 9, 9, 9, 9, 9, 9, 9}
+	m := dnsmessage.Message{
+		Header:      dnsmessage.Header{ID: 9, RecursionDesired: true},
+		Questions:   []dnsmessage.Question{{Name: dnsmessage.MustNewName("host.cookie.test."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+		Additionals: []dnsmessage.Resource{{Header: h, Body: &dnsmessage.OPTResource{Options: []dnsmessage.Option{{Code: ednsOptionCookie, Data: clientCookie}}}}},
+	}
+	query, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newUpstreamCookies(false)
+	sent := cookieOption(t, c.Prepare(query, "192.0.2.1:53"))
+	if bytes.Equal(sent, clientCookie) || !bytes.Equal(sent, c.client["192.0.2.1:53"]) {
+		t.Fatalf("prepared query carries cookie %x, want our own cookie instead of the client's", sent)
+	}
+	if disabled := newUpstreamCookies(true).Prepare(query, "192.0.2.1:53"); !bytes.Equal(disabled, query) {
+		t.Fatal("Prepare changed the query with cookies disabled")
+	}
+}
+
+func TestStripUpstreamCookie(t *testing.T) {
+	var h dnsmessage.ResourceHeader
+	h.SetEDNS0(1232, dnsmessage.RCodeSuccess, false)
+	m := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 10, Response: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName("host.cookie.test."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+		Answers:   []dnsmessage.Resource{dnstest.A("host.cookie.test.", 60, "192.0.2.10")},
+		Additionals: []dnsmessage.Resource{{Header: h, Body: &dnsmessage.OPTResource{Options: []dnsmessage.Option{
+			{Code: ednsOptionCookie, Data: bytes.Repeat([]byte{1}, 24)},
+			{Code: 12, Data: make([]byte, 16)},
+		}}}},
+	}
+	response, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kept, changed := stripUpstreamCookie(response, true)
+	if !changed || cookieOption(t, kept) != nil {
+		t.Fatal("cookie was not removed for a client that sent EDNS")
+	}
+	var out dnsmessage.Message
+	if err := out.Unpack(kept); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Additionals) != 1 || len(out.Additionals[0].Body.(*dnsmessage.OPTResource).Options) != 1 || len(out.Answers) != 1 {
+		t.Fatal("stripping the cookie also removed the OPT record, its other options or the answers")
+	}
+
+	plain, changed := stripUpstreamCookie(response, false)
+	if err := out.Unpack(plain); err != nil {
+		t.Fatal(err)
+	}
+	if !changed || len(out.Additionals) != 0 {
+		t.Fatalf("response for a client without EDNS keeps %d additional records, want the OPT removed", len(out.Additionals))
+	}
+
+	if _, changed := stripUpstreamCookie(plain, true); changed {
+		t.Fatal("a response without a cookie was reported as changed")
+	}
+}
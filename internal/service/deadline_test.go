@@ -0,0 +1,81 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	A slow primary, an upstream whose truncated UDP answer sends labns to a slow TCP retry and a slow secondary each
+*	take their full timeout, together they run past QueryDeadlineMs and the client gets SERVFAIL on time
+ */
+func TestQueryDeadlineCapsStackedRetries(t *testing.T) {
+	conf := testConfig(t)
+	name := "host.deadline.test."
+	answer := []dnsmessage.Resource{dnstest.A(name, 60, "192.0.2.30")}
+	slowPrimary, truncating, slowSecondary := newUpstream(t), newUpstream(t), newUpstream(t)
+	slow := queryDeadline + 200*time.Millisecond
+	slowPrimary.Handle(name, dnsmessage.TypeA, dnstest.Response{Answers: answer, Delay: slow})
+	slowSecondary.Handle(name, dnsmessage.TypeA, dnstest.Response{Answers: answer, Delay: slow})
+	truncating.Handle(name, dnsmessage.TypeA, dnstest.Response{Answers: answer, Truncate: true, TCPDelay: slow})
+	rule := forwardTo(conf, "deadline.test.", slowPrimary, truncating, slowSecondary)
+	// the three timeouts add up to more than the deadline, the third is cut short by it
+	rule.TimeoutMs = uint16(queryDeadline / time.Millisecond * 2 / 5)
+	reload(t, conf)
+
+	start := time.Now()
+	res := lookup(t, name, dnsmessage.TypeA, 0)
+	elapsed := time.Since(start)
+	if res.Header.RCode != dnsmessage.RCodeServerFailure || len(res.Answers) != 0 {
+		t.Fatalf("query past its deadline answered %s with %d answers, want SERVFAIL", res.Header.RCode, len(res.Answers))
+	}
+	if elapsed < queryDeadline || elapsed > queryDeadline+300*time.Millisecond {
+		t.Fatalf("SERVFAIL after %s, want it at the %s deadline", elapsed.Round(time.Millisecond), queryDeadline)
+	}
+	for _, up := range []*dnstest.Server{slowPrimary, truncating, slowSecondary} {
+		if queriesFor(up, name) == 0 {
+			t.Fatalf("upstream %s was never tried, the retries did not stack", up.Addr())
+		}
+	}
+	var tcp bool
+	for _, q := range truncating.Queries() {
+		tcp = tcp || q.Network == "tcp"
+	}
+	if !tcp {
+		t.Fatal("truncated answer was not retried over TCP before the deadline")
+	}
+}
+
+/*
+*	An injected delay longer than the deadline hands the query to the state worker after it expired, which still
+*	answers SERVFAIL rather than leaving the client to time out
+ */
+func TestQueryExpiredBeforeProcessingIsServfail(t *testing.T) {
+	conf := testConfig(t)
+	name := "host.expired.test."
+	up := newUpstream(t)
+	up.Handle(name, dnsmessage.TypeA, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.A(name, 60, "192.0.2.31")}})
+	forwardTo(conf, "expired.test.", up)
+	delay := queryDeadline + 200*time.Millisecond
+	conf.FaultInjection = config.FaultInjection{Enabled: true, Rules: []config.FaultRule{
+		{Domains: []string{name}, Mode: "delay", Probability: 1, DelayMs: uint32(delay / time.Millisecond)},
+	}}
+	reload(t, conf)
+
+	start := time.Now()
+	res := lookup(t, name, dnsmessage.TypeA, 0)
+	elapsed := time.Since(start)
+	if res.Header.RCode != dnsmessage.RCodeServerFailure || len(res.Answers) != 0 {
+		t.Fatalf("query expired before processing answered %s with %d answers, want SERVFAIL", res.Header.RCode, len(res.Answers))
+	}
+	if elapsed < delay || elapsed > delay+300*time.Millisecond {
+		t.Fatalf("SERVFAIL after %s, want it once the %s delay ends", elapsed.Round(time.Millisecond), delay)
+	}
+	if got := queriesFor(up, name); got != 0 {
+		t.Fatalf("expired query reached the upstream %d times", got)
+	}
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnsname"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	A child zone handed to other nameservers. Names under it without a local answer get a referral to them, or with
+*	Recurse are forwarded to their glue addresses
+ */
+type delegation struct {
+	zone string
+	ns   []dnsmessage.Resource
+	glue []dnsmessage.Resource
+	plan *forwardPlan
+}
+
+type delegations struct {
+	zones  localZones
+	byZone map[string]*delegation
+}
+
+func newDelegations(conf *config.Configuration) (*delegations, error) {
+	d := &delegations{byZone: make(map[string]*delegation)}
+	var zones []string
+	for k := range conf.Delegations {
+		c := &conf.Delegations[k]
+		zone, err := dnsmessage.NewName(c.Zone)
+		if err != nil {
+			return nil, err
+		}
+		entry := &delegation{zone: dnsname.Key(c.Zone)}
+		for _, ns := range c.Nameservers {
+			name, err := dnsmessage.NewName(ns.Name)
+			if err != nil {
+				return nil, err
+			}
+			entry.ns = append(entry.ns, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: zone, Type: dnsmessage.TypeNS, Class: dnsmessage.ClassINET, TTL: c.TTL},
+				Body:   &dnsmessage.NSResource{NS: name},
+			})
+			for _, addr := range ns.Addresses {
+				header := dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: c.TTL}
+				ip := net.ParseIP(addr)
+				if ip4 := ip.To4(); ip4 != nil {
+					res := dnsmessage.AResource{}
+					copy(res.A[:], ip4)
+					entry.glue = append(entry.glue, dnsmessage.Resource{Header: header, Body: &res})
+				} else {
+					res := dnsmessage.AAAAResource{}
+					copy(res.AAAA[:], ip.To16())
+					entry.glue = append(entry.glue, dnsmessage.Resource{Header: header, Body: &res})
+				}
+			}
+		}
+		if c.Recurse {
+			entry.plan = &forwardPlan{
+				Rule:      fmt.Sprintf("delegation %s", c.Zone),
+				Upstreams: c.Upstreams(),
+				Strategy:  "failover",
+				Timeout:   time.Duration(conf.UpstreamNameservers.TimeoutMs) * time.Millisecond,
+				Retries:   int(conf.UpstreamNameservers.Retries),
+			}
+		}
+		d.byZone[entry.zone] = entry
+		zones = append(zones, c.Zone)
+	}
+	d.zones = newLocalZones(zones)
+	return d, nil
+}
+
+/*
+*	Returns the most specific delegation name falls under, nil when there is none
+ */
+func (d *delegations) Match(name string) *delegation {
+	zone, ok := d.zones.Match(name)
+	if !ok {
+		return nil
+	}
+	return d.byZone[zone]
+}
+
+func (d *delegation) Referral(query []byte) ([]byte, error) {
+	b, err := newResponseBuilder(query)
+	if err != nil {
+		return nil, err
+	}
+	return b.Referral(d.ns, d.glue)
+}
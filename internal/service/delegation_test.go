@@ -0,0 +1,95 @@
+package service
+
+import (
+	"net"
+	"testing"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func k8sDelegation(recurse bool, port uint16) config.Delegation {
+	return config.Delegation{
+		Zone: "k8s.lab.home.",
+		Nameservers: []config.DelegatedNameserver{
+			{Name: "ns.k8s.lab.home.", Addresses: []string{"127.0.0.1"}},
+			{Name: "ns1.example.net."},
+		},
+		TTL:     600,
+		Recurse: recurse,
+		Port:    port,
+	}
+}
+
+/*
+*	A name under a delegated child of a local zone gets a referral, neither NXDOMAIN from the zone nor an upstream
+*	answer, while local records under the child are still answered directly
+ */
+func TestDelegationReferral(t *testing.T) {
+	conf := testConfig(t)
+	conf.Delegations = []config.Delegation{k8sDelegation(false, 53)}
+	conf.LocalRecords = append(conf.LocalRecords, config.LocalDNSRecord{Name: "ingress.k8s.lab.home.", Type: "A", TTL: 60, Target: "192.168.1.80"})
+	reload(t, conf)
+	before := queriesFor(primary, "web.default.k8s.lab.home.")
+
+	res := lookup(t, "web.default.k8s.lab.home.", dnsmessage.TypeA, 0)
+	if res.Header.RCode != dnsmessage.RCodeSuccess || res.Header.Authoritative || len(res.Answers) != 0 {
+		t.Fatalf("name under the delegation answered %s with AA %t and %d answers, want a non-authoritative referral",
+			res.Header.RCode, res.Header.Authoritative, len(res.Answers))
+	}
+	if len(res.Authorities) != 2 {
+		t.Fatalf("referral has %d authority records, want the two NS records", len(res.Authorities))
+	}
+	for k, want := range []string{"ns.k8s.lab.home.", "ns1.example.net."} {
+		ns, ok := res.Authorities[k].Body.(*dnsmessage.NSResource)
+		if !ok || res.Authorities[k].Header.Name.String() != "k8s.lab.home." || ns.NS.String() != want || res.Authorities[k].Header.TTL != 600 {
+			t.Fatalf("authority record %d is %v, want k8s.lab.home. 600 NS %s", k, res.Authorities[k], want)
+		}
+	}
+	var glue []string
+	for _, r := range res.Additionals {
+		if a, ok := r.Body.(*dnsmessage.AResource); ok {
+			glue = append(glue, r.Header.Name.String()+" "+net.IP(a.A[:]).String())
+		}
+	}
+	if len(glue) != 1 || glue[0] != "ns.k8s.lab.home. 127.0.0.1" {
+		t.Fatalf("referral glue is %v, want the address of ns.k8s.lab.home. alone", glue)
+	}
+	if got := queriesFor(primary, "web.default.k8s.lab.home.") - before; got != 0 {
+		t.Fatalf("name under the delegation was forwarded %d times", got)
+	}
+
+	if res := lookup(t, "ingress.k8s.lab.home.", dnsmessage.TypeA, 0); answerAddress(t, res) != "192.168.1.80" || !res.Header.Authoritative {
+		t.Fatalf("local record under the delegation answered %v, want it authoritatively", res.Answers)
+	}
+	// the parent zone is still answered as before
+	if res := lookup(t, "missing.lab.home.", dnsmessage.TypeA, 0); res.Header.RCode != dnsmessage.RCodeNameError {
+		t.Fatalf("missing name in the parent zone answered %s, want NXDOMAIN", res.Header.RCode)
+	}
+}
+
+func TestDelegationRecurse(t *testing.T) {
+	conf := testConfig(t)
+	child := newUpstream(t)
+	child.Handle("web.default.k8s.lab.home.", dnsmessage.TypeA, dnstest.Response{Authoritative: true, Answers: []dnsmessage.Resource{dnstest.A("web.default.k8s.lab.home.", 30, "10.96.0.15")}})
+	conf.Delegations = []config.Delegation{k8sDelegation(true, nameserverFor(child).Port)}
+	// a forwarding rule for the parent zone loses to the more specific delegation
+	parent := newUpstream(t)
+	forwardTo(conf, "lab.home.", parent)
+	reload(t, conf)
+
+	res := lookup(t, "web.default.k8s.lab.home.", dnsmessage.TypeA, 0)
+	if res.Header.RCode != dnsmessage.RCodeSuccess || answerAddress(t, res) != "10.96.0.15" || res.Header.Authoritative {
+		t.Fatalf("recursing delegation answered %s with %v, want the delegated server's answer without AA", res.Header.RCode, res.Answers)
+	}
+	if len(res.Authorities) != 0 {
+		t.Fatalf("recursed answer carries %d authority records, want none of the referral", len(res.Authorities))
+	}
+	if got := queriesFor(child, "web.default.k8s.lab.home."); got != 1 {
+		t.Fatalf("delegated server received %d queries, want 1", got)
+	}
+	if got := len(parent.Queries()); got != 0 {
+		t.Fatalf("forwarding rule for the parent zone received %d queries, want the delegation to win", got)
+	}
+}
@@ -1,14 +1,21 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
-	"sync"
+	"runtime"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/TasSM/labns/internal/audit"
+	"github.com/TasSM/labns/internal/clock"
 	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnsname"
 	"github.com/TasSM/labns/internal/logging"
+	"github.com/TasSM/labns/internal/stats"
 	"golang.org/x/net/dns/dnsmessage"
 )
 
@@ -20,59 +27,201 @@ type StateOperation struct {
 	RequestorAddr *net.UDPAddr
 	ByteData      []byte
 	RequestId     uint16
+	Question      dnsmessage.Question
+	Header        dnsmessage.Header
+	Ctx           context.Context
+	Cancel        context.CancelFunc
+	Received      time.Time
+	Summary       string
+	Conn          *net.UDPConn
+	Dst           net.IP
+	Config        *config.Configuration
+	Trace         *queryTrace
+	Reply         func([]byte)
+	Rewritten     string
+	// set when the answer served from the cache had its TTLs pinned by a TTLOverride
+	TTLPinned bool
+	// set for fast path refreshes, which skip the cache so the answer comes from upstream
+	Refresh bool
+}
+
+type pendingRequest struct {
+	RequestorAddr *net.UDPAddr
+	Conn          *net.UDPConn
+	Dst           net.IP
+	Reply         func([]byte)
+	Ctx           context.Context
+	Cancel        context.CancelFunc
+	Query         []byte
+	Outbound      []byte
+	ClientID      uint16
+	ClientKey     clientQueryKey
+	ClientEDNS    bool
+	ClientName    string
+	ClientRD      bool
+	QueryType     dnsmessage.Type
+	Received      time.Time
+	Attempts      []upstreamAttempt
+	Retries       int
+	Trace         *queryTrace
+	Plan          *forwardPlan
+	Round         int
+	TTLPinned     bool
+	// answered from upstream without a cache read, recorded with the source bypass
+	CacheBypass bool
+}
+
+type upstreamAttempt struct {
+	Upstream config.Nameserver
+	Key      string
+	SentName string
+	// set once the query has been sent again after a BADCOOKIE answer
+	CookieRetried bool
+	// set once the query has been sent again over TCP after a truncated UDP answer
+	TCPFallback bool
 }
 
 const (
 	OpCallback Operation = 1
 	OpAdd      Operation = 2
 	OpRespond  Operation = 3
-	OpDelete   Operation = 4
+	OpExpire   Operation = 5
+	OpReload   Operation = 6
+	OpFlush    Operation = 7
 )
 
 var (
-	lock            sync.Mutex
-	conn            *net.UDPConn
-	stateMap        map[uint16]*net.UDPAddr
-	currentUpstream string
+	listeners       []*net.UDPConn
+	upstreamSockets map[string]*net.UDPConn
+	reqChan         chan StateOperation
+	queryDeadline   time.Duration
+	stateMap        map[uint16]*pendingRequest
+	forwardedIds    map[clientQueryKey]uint16
+	upstreamLimiter *Semaphore
+	caseRandom      *caseRandomizer
+	cookies         *upstreamCookies
 )
 
-func requestUpstream(ns *config.Nameserver, payload []byte) error {
-	var target net.UDPAddr
-	if ns.IPv4 == "" && ns.IPv6 == "" {
-		return errors.New("cannot forward to invalid upstream: neither IPv4 or IPv6 specified")
-	}
-	if ns.IPv4 != "" {
-		ipv4 := [4]byte{}
-		ip := net.ParseIP(ns.IPv4).To4()
-		copy(ipv4[:], ip)
-		target = net.UDPAddr{IP: ip, Port: int(ns.Port)}
-	} else {
-		ipv6 := [16]byte{}
-		ip := net.ParseIP(ns.IPv6).To16()
-		copy(ipv6[:], ip)
-		target = net.UDPAddr{IP: ip, Port: int(ns.Port)}
-	}
-	go conn.WriteToUDP(payload, &target)
+/*
+*	Sends payload to ns over the transport its Protocol names, the response reaches the state worker as an OpRespond
+ */
+func requestUpstream(ctx context.Context, ns *config.Nameserver, payload []byte) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	t, err := transportFor(ns)
+	if err != nil {
+		return err
+	}
+	if d, ok := t.(datagramTransport); ok {
+		return d.Send(payload)
+	}
+	exchangeUpstream(ctx, t, ns, payload)
 	return nil
 }
 
+/*
+*	Sends the pending query to ns, randomizing the qname case when 0x20 applies to that upstream
+ */
+func forwardPending(pending *pendingRequest, ns *config.Nameserver) error {
+	key := upstreamKey(ns)
+	payload, sentName := caseRandom.Prepare(cookies.Prepare(pending.Outbound, key), ns)
+	pending.Attempts = append(pending.Attempts, upstreamAttempt{Upstream: *ns, Key: key, SentName: sentName})
+	pending.Trace.Step("forwarding to upstream %s (attempt %d, sent name %q)", key, len(pending.Attempts), sentName)
+	return requestUpstream(pending.Ctx, ns, payload)
+}
+
+/*
+*	Sends res to the client, either on the socket the query arrived on or through Reply for in-process queries
+ */
+func (op *StateOperation) respond(res []byte, source string) {
+	rcode := responseRCode(res)
+	countAnswer(rcode, source, op.Question.Name.String())
+	recordHistory(op.RequestorAddr.IP, op.Question.Name.String(), op.Question.Type, stats.RCodeBucket(rcode), source, op.Rewritten, op.TTLPinned)
+	if op.Reply != nil {
+		op.Reply(res)
+		return
+	}
+	res = fitClientPayload(res, op.ByteData)
+	mirrorAnswer(op.RequestorAddr, op.RequestId, res)
+	spawnSend(routineReply, func() { writeReply(op.Conn, res, op.RequestorAddr, op.Dst) })
+}
+
+func (p *pendingRequest) respond(res []byte, source string) {
+	rcode := responseRCode(res)
+	countAnswer(rcode, source, p.ClientName)
+	recordHistory(p.RequestorAddr.IP, p.ClientName, p.QueryType, stats.RCodeBucket(rcode), source, "", p.TTLPinned)
+	if p.Reply != nil {
+		p.Reply(res)
+		return
+	}
+	res = fitClientPayload(res, p.Query)
+	mirrorAnswer(p.RequestorAddr, p.ClientID, res)
+	spawnSend(routineReply, func() { writeReply(p.Conn, res, p.RequestorAddr, p.Dst) })
+}
+
+func countAnswer(rcode dnsmessage.RCode, source string, name string) {
+	stats.CountResponse(rcode)
+	stats.CountAnswer(source)
+	if rcode == dnsmessage.RCodeServerFailure {
+		stats.RecordServfail(logging.Name(name), source, serviceClock.Now())
+	}
+}
+
+/*
+*	Finds the most recent attempt sent to the address a response arrived from
+ */
+func (p *pendingRequest) attemptFrom(addr *net.UDPAddr) *upstreamAttempt {
+	if addr == nil {
+		return nil
+	}
+	key := net.JoinHostPort(addr.IP.String(), fmt.Sprint(addr.Port))
+	for i := len(p.Attempts) - 1; i >= 0; i-- {
+		if p.Attempts[i].Key == key {
+			return &p.Attempts[i]
+		}
+	}
+	return nil
+}
+
+/*
+*	Picks the listener upstream queries to ip are sent from, preferring one of the same address family
+ */
+func upstreamConn(ip net.IP) *net.UDPConn {
+	for _, l := range listeners {
+		local := l.LocalAddr().(*net.UDPAddr)
+		if (local.IP.To4() != nil) == (ip.To4() != nil) {
+			return l
+		}
+	}
+	return listeners[0]
+}
+
 func switchNameservers(conf *config.Configuration) {
-	lock.Lock()
-	defer lock.Unlock()
+	if !conf.UpstreamNameservers.HasSecondary() {
+		return
+	}
 	tmp := conf.UpstreamNameservers.Primary
-	currentUpstream = conf.UpstreamNameservers.Secondary.IPv4
 	conf.UpstreamNameservers.Primary = conf.UpstreamNameservers.Secondary
 	conf.UpstreamNameservers.Secondary = tmp
 }
 
 func startStateWorker(input chan StateOperation, conf *config.Configuration) {
-	locConf := *conf
-	currentUpstream = locConf.UpstreamNameservers.Primary.IPv4
-	stateMap = make(map[uint16]*net.UDPAddr)
-	localRecords, err := CreateLocalRecords(&locConf)
+	stateMap = make(map[uint16]*pendingRequest)
+	forwardedIds = make(map[clientQueryKey]uint16)
+	upstreamLimiter = NewSemaphore(int64(conf.MaxConcurrentUpstreamQueries))
+	clientsInflight.SetMax(conf.MaxInflightPerClient)
+	caseRandom = newCaseRandomizer(conf.UpstreamNameservers.DisableCaseRandomization)
+	cookies = newUpstreamCookies(conf.UpstreamNameservers.DisableCookies)
+	s, err := newSnapshot(conf, nil)
 	if err != nil {
-		logging.LogMessage(logging.LogFatal, "Failed to create local record: "+err.Error())
+		logging.LogMessage(logging.LogFatal, "Failed to load "+err.Error())
 	}
+	s.install()
+	// the snapshot's configuration with the upstream order failovers have switched to
+	locConf := s.conf
+	logForwardingSettings(&locConf)
+	orderer := &answerOrderer{mode: locConf.AnswerOrdering}
 	for {
 		select {
 		case op, ok := <-input:
@@ -80,119 +229,940 @@ func startStateWorker(input chan StateOperation, conf *config.Configuration) {
 				logging.LogMessage(logging.LogFatal, "Command channel closed, killing state worker")
 				return
 			}
+			if op.Operation == OpReload {
+				if op.Config == nil {
+					logging.LogMessage(logging.LogError, "Bad OpReload (missing configuration), continuing...")
+					continue
+				}
+				next := *op.Config
+				// upstream sockets and ordering are bound at startup, so the current upstream state is kept
+				next.UpstreamNameservers = locConf.UpstreamNameservers
+				reloaded, err := newSnapshot(&next, s)
+				if err != nil {
+					ReloadFailed(err)
+					continue
+				}
+				changes := audit.DiffRecords(locConf.LocalRecords, op.Config.LocalRecords, audit.SourceReload)
+				audit.Record(changes, audit.SourceReload)
+				before := locConf
+				reloaded.install()
+				s, locConf = reloaded, reloaded.conf
+				setAcceptedUpstreams(&locConf)
+				retainTransports(&locConf)
+				logForwardingSettings(&locConf)
+				orderer.mode = locConf.AnswerOrdering
+				clientsInflight.SetMax(locConf.MaxInflightPerClient)
+				logReloadSummary(&before, op.Config, changes)
+				continue
+			}
+			if op.Operation == OpFlush {
+				flushed := 0
+				seen := make(map[*responseCache]bool)
+				for _, profile := range s.profiles {
+					if !seen[profile.cache] {
+						seen[profile.cache] = true
+						flushed += profile.cache.Flush()
+					}
+				}
+				logging.LogMessage(logging.LogInfo, fmt.Sprintf("Flushed %d cached answers", flushed))
+				continue
+			}
 			if op.Operation == 0 || (op.RequestHash == "" && op.RequestId == 0) {
 				logging.LogMessage(logging.LogError, "Received invalid state operation, continuing...")
 				continue
 			}
 			switch op.Operation {
 			case OpAdd:
-				if op.RequestorAddr == nil || op.ByteData == nil || op.RequestHash == "" || op.RequestId == 0 {
+				if op.RequestorAddr == nil || (op.Conn == nil && op.Reply == nil) || op.ByteData == nil || op.RequestHash == "" || op.RequestId == 0 || op.Ctx == nil {
 					logging.LogMessage(logging.LogError, "Bad OpAdd (missing required data), continuing...")
 					continue
 				}
-				if localRecords[op.RequestHash] != nil {
+				if err := op.Ctx.Err(); err != nil {
+					op.Cancel()
+					// an in-process caller that gave up has no one left to answer
+					if err != context.DeadlineExceeded {
+						op.Trace.Step("query cancelled before processing, dropped")
+						continue
+					}
+					logging.LogSimilar(logging.LogError, "query-deadline", fmt.Sprintf("Query deadline of %dms exceeded for %s before processing, answering SERVFAIL",
+						locConf.QueryDeadlineMs, logging.Name(op.Question.Name.String())))
+					op.Trace.Step("query deadline of %dms exceeded before processing, answering SERVFAIL", locConf.QueryDeadlineMs)
+					observeLatency("timeout", op.Question.Type, op.Received)
+					res, err := BuildErrorResponse(op.ByteData, dnsmessage.RCodeServerFailure)
+					if err != nil {
+						logging.LogMessage(logging.LogError, err.Error())
+						continue
+					}
+					op.respond(res, "timeout")
+					continue
+				}
+				if ips, ok := s.overrides.Lookup(op.Question.Name.String(), op.Received); ok {
+					op.Trace.Step("overrides file match, answering from %d addresses", len(ips))
+					op.Cancel()
+					res, err := BuildAddressResponse(op.ByteData, op.Question, ips, 0)
+					if err != nil {
+						logging.LogMessage(logging.LogError, err.Error())
+						continue
+					}
+					op.respond(orderer.Apply(res, op.RequestorAddr.IP), "override")
+					observeLatency("override", op.Question.Type, op.Received)
+					continue
+				}
+				if s.health.Contains(op.Question.Name.String()) {
+					op.Cancel()
+					res, step, err := s.health.Answer(op.ByteData, op.Question)
+					if err != nil {
+						logging.LogMessage(logging.LogError, err.Error())
+						continue
+					}
+					op.Trace.Step(step)
+					op.respond(res, "local")
+					observeLatency("local", op.Question.Type, op.Received)
+					continue
+				}
+				if ttl, ok := s.nullRoutes[dnsname.Key(op.Question.Name.String())]; ok {
+					logging.LogEvery(logging.LogInfo, "Null-routed "+logging.Name(op.Question.Name.String()))
+					op.Trace.Step("NULL record, answering with the unspecified address")
+					op.Cancel()
+					res, err := BuildAddressResponse(op.ByteData, op.Question, nullAddresses, ttl)
+					if err != nil {
+						logging.LogMessage(logging.LogError, err.Error())
+						continue
+					}
+					op.respond(res, "null-routed")
+					observeLatency("local", op.Question.Type, op.Received)
+					continue
+				}
+				if s.records[op.RequestHash] != nil {
 					logging.LogMessage(logging.LogInfo, "Found local record with matching key: "+op.RequestHash)
-					res, err := SetResponseId(localRecords[op.RequestHash], op.RequestId)
+					op.Trace.Step("local record hit, answering NOERROR")
+					res, err := BuildLocalResponse(op.ByteData, s.ages.Apply(s.records[op.RequestHash], op.Received))
 					if err != nil {
-						logging.LogMessage(logging.LogFatal, err.Error())
+						logging.LogMessage(logging.LogError, err.Error())
 						continue
 					}
-					go conn.WriteToUDP(res, op.RequestorAddr)
+					res = orderer.Apply(res, op.RequestorAddr.IP)
+					op.respond(res, "local")
+					op.Cancel()
+					observeLatency("local", op.Question.Type, op.Received)
 					continue
 				}
-				//TODO: caching
-				stateMap[op.RequestId] = op.RequestorAddr
-				err := requestUpstream(&locConf.UpstreamNameservers.Primary, op.ByteData)
-				if err != nil {
-					logging.LogMessage(logging.LogError, "Unable to forward request to upstream: "+err.Error())
+				if _, ok := s.cnames.Target(op.Question.Name.String()); ok && op.Question.Type != dnsmessage.TypeCNAME {
+					op.Cancel()
+					chain, err := followChain(op.Question.Name.String(), s.cnames.Target, int(locConf.MaxChainDepth))
+					if err != nil {
+						logging.LogMessage(logging.LogError, fmt.Sprintf("Local CNAME chain for %s is broken, answering SERVFAIL: %s", logging.Name(op.Question.Name.String()), err.Error()))
+						op.Trace.Step("local CNAME chain broken (%s), answering SERVFAIL", err.Error())
+						if res, err := BuildErrorResponse(op.ByteData, dnsmessage.RCodeServerFailure); err == nil {
+							op.respond(res, "local")
+						}
+						observeLatency("local", op.Question.Type, op.Received)
+						continue
+					}
+					op.Trace.Step("local CNAME chain %s", strings.Join(chain, " -> "))
+					res, err := BuildChainResponse(op.ByteData, chain, s.cnames, s.records[questionKey(chain[len(chain)-1], op.Question.Type)])
+					if err != nil {
+						logging.LogMessage(logging.LogError, err.Error())
+						continue
+					}
+					op.respond(orderer.Apply(s.ages.Apply(res, op.Received), op.RequestorAddr.IP), "local")
+					observeLatency("local", op.Question.Type, op.Received)
+					continue
+				}
+				if s.selfNames.IsHostname(op.Question.Name.String()) || s.selfNames.IsReverse(op.Question.Name.String()) {
+					op.Cancel()
+					var res []byte
+					var err error
+					switch {
+					case s.selfNames.IsHostname(op.Question.Name.String()):
+						op.Trace.Step("SelfHostname, answering with the listen addresses")
+						res, err = BuildAddressResponse(op.ByteData, op.Question, s.selfNames.addrs, locConf.DefaultLocalTTL)
+					case s.selfNames.hostname != "":
+						op.Trace.Step("reverse name of a listen address, answering PTR %s", s.selfNames.hostname)
+						res, err = BuildPTRResponse(op.ByteData, op.Question, s.selfNames.hostname, locConf.DefaultLocalTTL)
+					default:
+						op.Trace.Step("reverse name of a listen address, answering NXDOMAIN")
+						res, err = BuildEmptyResponse(op.ByteData, dnsmessage.RCodeNameError, true)
+					}
+					if err != nil {
+						logging.LogMessage(logging.LogError, err.Error())
+						continue
+					}
+					op.respond(res, "local")
+					observeLatency("local", op.Question.Type, op.Received)
+					continue
+				}
+				if !s.localNames[dnsname.Key(op.Question.Name.String())] {
+					// explicit records of any type win over generated names
+					var res []byte
+					var err error
+					var found bool
+					if ip, ttl, ok := s.generated.Forward(op.Question.Name.String()); ok {
+						op.Trace.Step("generated name for %s", ip)
+						res, err = BuildAddressResponse(op.ByteData, op.Question, []net.IP{ip}, ttl)
+						found = true
+					} else if target, ttl, ok := s.generated.Reverse(op.Question.Name.String()); ok {
+						op.Trace.Step("reverse name in a generated range, answering PTR %s", target)
+						res, err = BuildPTRResponse(op.ByteData, op.Question, target, ttl)
+						found = true
+					}
+					if found {
+						op.Cancel()
+						if err != nil {
+							logging.LogMessage(logging.LogError, err.Error())
+							continue
+						}
+						op.respond(res, "local")
+						observeLatency("local", op.Question.Type, op.Received)
+						continue
+					}
+				}
+				if domain := s.clientSearch.Match(op.RequestorAddr.IP); domain != "" && !s.zones.Contains(op.Question.Name.String()) {
+					// only a local answer is taken from the rewrite, anything else continues with the name as asked
+					expanded := op.Question.Name.String() + domain
+					if record := s.records[questionKey(expanded, op.Question.Type)]; record != nil {
+						op.Trace.Step("client search domain rewrite found %s, answering NOERROR", expanded)
+						logging.LogEvery(logging.LogInfo, fmt.Sprintf("Rewrote %s to %s for %s", logging.Name(op.Question.Name.String()), logging.Name(expanded), logging.Client(op.RequestorAddr.IP)))
+						res, err := BuildSearchDomainResponse(op.ByteData, op.Question, expanded, s.ages.Apply(record, op.Received))
+						op.Cancel()
+						if err != nil {
+							logging.LogMessage(logging.LogError, err.Error())
+							continue
+						}
+						op.Rewritten = expanded
+						op.respond(res, "local")
+						observeLatency("local", op.Question.Type, op.Received)
+						continue
+					}
+					op.Trace.Step("no local record for %s, continuing with the original name", expanded)
+				}
+				if isSingleLabel(op.Question.Name.String()) {
+					if locConf.SearchDomain != "" {
+						expanded := op.Question.Name.String() + locConf.SearchDomain
+						if record := s.records[questionKey(expanded, op.Question.Type)]; record != nil {
+							op.Trace.Step("single-label name found as %s, answering NOERROR", expanded)
+							res, err := BuildSearchDomainResponse(op.ByteData, op.Question, expanded, s.ages.Apply(record, op.Received))
+							op.Cancel()
+							if err != nil {
+								logging.LogMessage(logging.LogError, err.Error())
+								continue
+							}
+							op.Rewritten = expanded
+							op.respond(res, "local")
+							observeLatency("local", op.Question.Type, op.Received)
+							continue
+						}
+					}
+					if *locConf.NeverForwardSingleLabel {
+						logging.LogMessage(logging.LogDebug, "Not forwarding single-label query for "+logging.Name(op.Question.Name.String()))
+						op.Trace.Step("single-label name not forwarded, answering NXDOMAIN")
+						op.Cancel()
+						res, err := BuildEmptyResponse(op.ByteData, dnsmessage.RCodeNameError, false)
+						if err != nil {
+							logging.LogMessage(logging.LogError, err.Error())
+							continue
+						}
+						op.respond(res, "local")
+						observeLatency("local", op.Question.Type, op.Received)
+						continue
+					}
+				}
+				profile := profileFor(s.profiles, op.Conn)
+				if br, ok := profile.blocker.Check(op.Question.Name.String(), op.RequestorAddr.IP, serviceClock.Now()); ok {
+					stats.Increment(stats.Blocked)
+					if profile.name != "" {
+						stats.Increment(stats.ProfileBlocked(profile.name))
+					}
+					op.Trace.Step("blocklist match, answering with block mode %q", br.Mode)
+					logging.LogEvery(logging.LogInfo, "Blocked request for "+logging.Name(op.Question.Name.String()))
+					op.Cancel()
+					res, err := BuildBlockedResponse(op.ByteData, op.Question, br, profile.blocker.TTL)
+					if err != nil {
+						logging.LogMessage(logging.LogError, err.Error())
+						continue
+					}
+					op.respond(res, "blocked")
+					observeLatency("blocked", op.Question.Type, op.Received)
+					continue
+				}
+				op.Trace.Step("no local record, blocklist allowed")
+				ruleDomain, plan := s.rules.Match(op.Question.Name.String())
+				isPinned := s.pinned.Contains(op.Question.Name.String())
+				if d := s.delegated.Match(op.Question.Name.String()); d != nil && !isPinned && len(d.zone) >= len(ruleDomain) {
+					if zone, ok := s.zones.Match(op.Question.Name.String()); !ok || len(zone) <= len(d.zone) {
+						if d.plan == nil {
+							op.Trace.Step("delegated zone %s, answering with a referral", d.zone)
+							op.Cancel()
+							res, err := d.Referral(op.ByteData)
+							if err != nil {
+								logging.LogMessage(logging.LogError, err.Error())
+								continue
+							}
+							op.respond(res, "local")
+							observeLatency("local", op.Question.Type, op.Received)
+							continue
+						}
+						op.Trace.Step("delegated zone %s, forwarding to its nameservers", d.zone)
+						ruleDomain, plan = d.zone, d.plan
+					}
+				}
+				if zone, ok := s.zones.Match(op.Question.Name.String()); isPinned || (ok && len(zone) >= len(ruleDomain)) {
+					// names inside a local zone or pinned are never leaked upstream, existing names get NODATA and the rest NXDOMAIN
+					rcode := dnsmessage.RCodeNameError
+					if s.localNames[dnsname.Key(op.Question.Name.String())] {
+						rcode = dnsmessage.RCodeSuccess
+					}
+					where := "inside local zone"
+					if isPinned {
+						where = "pinned name"
+					}
+					op.Trace.Step("%s, answering %s", where, rcode)
+					logging.LogMessage(logging.LogDebug, "No local record for "+logging.Name(op.Question.Name.String())+" ("+where+"), answering "+rcode.String())
+					op.Cancel()
+					res, err := BuildEmptyResponse(op.ByteData, rcode, true)
+					if err != nil {
+						logging.LogMessage(logging.LogError, err.Error())
+						continue
+					}
+					op.respond(res, "local")
+					observeLatency("local", op.Question.Type, op.Received)
+					continue
 				}
-				go func() {
-					time.Sleep(time.Duration(locConf.UpstreamNameservers.TimeoutMs) * time.Millisecond)
-					input <- StateOperation{Operation: OpCallback, ByteData: op.ByteData, RequestId: op.RequestId, RequestorAddr: op.RequestorAddr}
-				}()
+				if zone, ok := privateReverseZones.Match(op.Question.Name.String()); ok && plan == nil && !locConf.PrivateReverseForwarding {
+					op.Trace.Step("private reverse zone %s, answering locally", zone)
+					op.Cancel()
+					res, err := BuildPrivateReverseResponse(op.ByteData, op.Question, zone)
+					if err != nil {
+						logging.LogMessage(logging.LogError, err.Error())
+						continue
+					}
+					op.respond(res, "local")
+					observeLatency("local", op.Question.Type, op.Received)
+					continue
+				}
+				if op.Question.Type == config.TypeHTTPS && s.localNames[dnsname.Key(op.Question.Name.String())] {
+					// clients resolving HTTPS before A/AAAA must get a fast NODATA for local names rather than wait on upstream
+					op.Trace.Step("HTTPS query for local name, answering NODATA")
+					op.Cancel()
+					res, err := BuildEmptyResponse(op.ByteData, dnsmessage.RCodeSuccess, true)
+					if err != nil {
+						logging.LogMessage(logging.LogError, err.Error())
+						continue
+					}
+					op.respond(res, "local")
+					observeLatency("local", op.Question.Type, op.Received)
+					continue
+				}
+				if !op.Header.RecursionDesired && s.localNames[dnsname.Key(op.Question.Name.String())] {
+					logging.LogMessage(logging.LogDebug, "Non-recursive query for local name "+logging.Name(op.Question.Name.String())+", answering from local data only")
+					op.Trace.Step("non-recursive query for local name, answering NODATA")
+					op.Cancel()
+					res, err := BuildEmptyResponse(op.ByteData, dnsmessage.RCodeSuccess, true)
+					if err != nil {
+						logging.LogMessage(logging.LogError, err.Error())
+						continue
+					}
+					op.respond(res, "local")
+					observeLatency("local", op.Question.Type, op.Received)
+					continue
+				}
+				bypass := s.bypass.Contains(op.RequestorAddr.IP)
+				if bypass {
+					stats.Increment(stats.CacheBypass)
+					op.Trace.Step("client is in CacheBypassClients, not answering from the cache")
+				}
+				variant := queryVariant(op.ByteData)
+				if res := profile.cache.Get(op.Question.Name.String(), op.Question.Type, variant, op.Received); res != nil && !op.Refresh && !bypass {
+					stats.Increment(stats.CacheHit)
+					op.Trace.Step("cache hit, answering %s", responseRCode(res))
+					if fastPath.zones.Contains(op.Question.Name.String()) {
+						stats.Increment(stats.FastPathHit)
+					}
+					if profile.cache.NeedsRefresh(op.Question.Name.String(), op.Question.Type, variant, op.Received) && !overloaded() {
+						op.Trace.Step("fast path entry close to expiry, refreshing it from upstream")
+						query, name, conn := append([]byte(nil), op.ByteData...), op.Question.Name.String(), op.Conn
+						spawn(routineRefresh, func() { refreshFastPath(query, name, conn) })
+					}
+					res = cachedAnswer(res, op.ByteData, op.RequestId, op.Question.Name.String(), op.Header.RecursionDesired)
+					if locConf.OrderUpstreamAnswers {
+						res = orderer.Apply(res, op.RequestorAddr.IP)
+					}
+					_, op.TTLPinned = s.ttlPins.Lookup(op.Question.Name.String())
+					op.respond(res, "cache")
+					op.Cancel()
+					observeLatency("cache", op.Question.Type, op.Received)
+					continue
+				}
+				if profile.cache != nil && !bypass {
+					stats.Increment(stats.CacheMiss)
+				}
+				if offlineActive() {
+					op.respondOffline(profile.cache)
+					continue
+				}
+				op.Trace.Step("not cached, forwarding upstream")
+				key := clientQueryKey{Addr: op.RequestorAddr.String(), ID: op.RequestId, Question: op.RequestHash}
+				var outboundId uint16
+				var prev *pendingRequest
+				// a retransmission from the same client socket keeps the ID it was forwarded with so a late answer to
+				// the first copy still matches, in-process queries each have their own reply and are never one
+				if op.Reply == nil {
+					if id, ok := forwardedIds[key]; ok {
+						outboundId, prev = id, stateMap[id]
+					}
+				}
+				if prev == nil {
+					outboundId = freshRequestId()
+				}
+				outbound := op.ByteData
+				if *locConf.SanitizeOutbound {
+					var err error
+					if outbound, err = sanitizeQuery(op.ByteData, outboundId); err != nil {
+						logging.LogMessage(logging.LogError, "Unable to rebuild query for upstream: "+err.Error())
+						op.Cancel()
+						continue
+					}
+				} else if outboundId != op.RequestId {
+					outbound = append([]byte{}, op.ByteData...)
+					outbound[0], outbound[1] = byte(outboundId>>8), byte(outboundId)
+				}
+				if prev == nil && overloaded() {
+					stats.Increment(stats.LoadShed)
+					overloadWarnings.LogMessage(logging.LogError, fmt.Sprintf("%d goroutines running, at the MaxGoroutines cap, answering SERVFAIL instead of forwarding", runtime.NumGoroutine()))
+					op.Trace.Step("goroutine cap reached, answering SERVFAIL")
+					op.Cancel()
+					res, err := BuildErrorResponse(op.ByteData, dnsmessage.RCodeServerFailure)
+					if err != nil {
+						logging.LogMessage(logging.LogError, err.Error())
+						continue
+					}
+					op.respond(res, "rejected")
+					observeLatency("rejected", op.Question.Type, op.Received)
+					continue
+				}
+				// a retransmission replaces the client's own pending query and is not counted again
+				if prev == nil && !clientsInflight.Acquire(op.RequestorAddr.IP) {
+					stats.Increment(stats.InflightRejected)
+					inflightWarnings.LogMessage(logging.LogError, fmt.Sprintf("Client %s reached the limit of %d outstanding queries, rejecting further queries (%s)",
+						logging.Client(op.RequestorAddr.IP), locConf.MaxInflightPerClient, locConf.InflightLimitAction))
+					op.Trace.Step("client in-flight limit reached, %s", locConf.InflightLimitAction)
+					op.Cancel()
+					if locConf.InflightLimitAction == "drop" {
+						continue
+					}
+					res, err := BuildErrorResponse(op.ByteData, dnsmessage.RCodeServerFailure)
+					if err != nil {
+						logging.LogMessage(logging.LogError, err.Error())
+						continue
+					}
+					op.respond(res, "rejected")
+					observeLatency("rejected", op.Question.Type, op.Received)
+					continue
+				}
+				if prev == nil && !upstreamLimiter.TryAcquire(1) {
+					clientsInflight.Release(op.RequestorAddr.IP)
+					stats.Increment(stats.UpstreamRejected)
+					logging.LogSimilar(logging.LogError, "upstream-limit", fmt.Sprintf("Upstream query limit (%d) reached, answering SERVFAIL for key %s", locConf.MaxConcurrentUpstreamQueries, op.RequestHash))
+					op.Trace.Step("upstream query limit reached, answering SERVFAIL")
+					op.Cancel()
+					res, err := BuildErrorResponse(op.ByteData, dnsmessage.RCodeServerFailure)
+					if err != nil {
+						logging.LogMessage(logging.LogError, err.Error())
+						continue
+					}
+					op.respond(res, "rejected")
+					observeLatency("rejected", op.Question.Type, op.Received)
+					continue
+				}
+				if prev != nil {
+					prev.Cancel()
+				}
+				if plan == nil {
+					plan = globalPlan(&locConf)
+				} else {
+					op.Trace.Step("matched forwarding rule %s", plan.Rule)
+				}
+				pending := &pendingRequest{RequestorAddr: op.RequestorAddr, Conn: op.Conn, Dst: op.Dst, Reply: op.Reply, Ctx: op.Ctx, Cancel: op.Cancel, Query: op.ByteData, Outbound: op.ByteData, ClientID: op.RequestId, ClientEDNS: hasEDNS(op.ByteData), ClientName: op.Question.Name.String(), ClientRD: op.Header.RecursionDesired, QueryType: op.Question.Type, Received: op.Received, Trace: op.Trace, Plan: plan, CacheBypass: bypass}
+				pending.Outbound = outbound
+				pending.ClientKey = key
+				stateMap[outboundId] = pending
+				if op.Reply == nil {
+					forwardedIds[key] = outboundId
+				}
+				pending.forwardNext()
+				callback := StateOperation{Operation: OpCallback, ByteData: op.ByteData, RequestId: outboundId, RequestorAddr: op.RequestorAddr, Ctx: op.Ctx}
+				spawn(routineUpstreamWait, func() { awaitUpstream(callback.Ctx, serviceClock, input, plan.Timeout, callback) })
 			case OpCallback:
 				if op.ByteData == nil || op.RequestorAddr == nil || op.RequestId == 0 {
 					logging.LogMessage(logging.LogError, "Bad OpCallback (missing required data), continuing...")
 					continue
 				}
-				if stateMap[op.RequestId] == nil {
+				pending := stateMap[op.RequestId]
+				if pending == nil || pending.Ctx != op.Ctx {
 					continue
 				}
-				err := requestUpstream(&locConf.UpstreamNameservers.Secondary, op.ByteData)
-				if err != nil {
-					logging.LogMessage(logging.LogError, "Unable to forward request to upstream: "+err.Error())
-				}
-				logging.LogMessage(logging.LogInfo, "Primary upstream timed out, switching primary ("+locConf.UpstreamNameservers.Primary.IPv4+") and secondary ("+locConf.UpstreamNameservers.Secondary.IPv4+")")
-				switchNameservers(&locConf)
-				go func() {
-					time.Sleep(time.Duration(locConf.UpstreamNameservers.TimeoutMs) * time.Millisecond)
-					input <- StateOperation{Operation: OpDelete, RequestId: op.RequestId}
-				}()
+				upstreamsTimedOut(pending)
+				if pending.Plan.Rule == "" && pending.Plan.Strategy == "failover" && locConf.UpstreamNameservers.HasSecondary() {
+					logging.LogMessage(logging.LogInfo, "Primary upstream timed out, switching primary ("+locConf.UpstreamNameservers.Primary.IPv4+") and secondary ("+locConf.UpstreamNameservers.Secondary.IPv4+")")
+					switchNameservers(&locConf)
+				}
+				pending.Trace.Step("no response after %dms", pending.Plan.Timeout.Milliseconds())
+				if pending.forwardNext() {
+					callback := StateOperation{Operation: OpCallback, ByteData: op.ByteData, RequestId: op.RequestId, RequestorAddr: op.RequestorAddr, Ctx: pending.Ctx}
+					timeout := pending.Plan.Timeout
+					spawn(routineUpstreamWait, func() { awaitUpstream(callback.Ctx, serviceClock, input, timeout, callback) })
+					continue
+				}
+				logging.LogSimilar(logging.LogError, "upstream-timeout", "Request for key "+op.RequestHash+" has timed out on all upstream nameservers")
+				pending.Cancel()
+				removePending(op.RequestId)
+				upstreamLimiter.Release(1)
+				clientsInflight.Release(pending.RequestorAddr.IP)
+				if pending.respondStale(profileFor(s.profiles, pending.Conn).cache) {
+					observeLatency("stale", pending.QueryType, pending.Received)
+					continue
+				}
+				pending.Trace.Step("timed out on all upstreams, no answer sent")
+				recordHistory(pending.RequestorAddr.IP, pending.ClientName, pending.QueryType, "TIMEOUT", "timeout", "", false)
+				observeLatency("timeout", pending.QueryType, pending.Received)
 			case OpRespond:
 				if op.ByteData == nil || op.RequestId == 0 {
 					logging.LogMessage(logging.LogError, "Bad OpRespond (missing required data), continuing...")
 					continue
 				}
-				if stateMap[op.RequestId] == nil {
+				pending := stateMap[op.RequestId]
+				if pending == nil {
 					logging.LogMessage(logging.LogDebug, "OpRespond ignored for missing key "+op.RequestHash)
 					continue
 				}
-				go conn.WriteToUDP(op.ByteData, stateMap[op.RequestId])
-				delete(stateMap, op.RequestId)
-			case OpDelete:
-				if op.RequestId == 0 {
-					logging.LogMessage(logging.LogError, "Bad OpDelete (missing required data), continuing...")
+				attempt := pending.attemptFrom(op.RequestorAddr)
+				if attempt == nil {
+					logging.LogMessage(logging.LogDebug, fmt.Sprintf("Dropping response for request %d from %v, no query was sent there", op.RequestId, logging.Addr(op.RequestorAddr)))
+					continue
+				}
+				if !answersQuestion(op.ByteData, pending.ClientName, pending.QueryType) {
+					logging.LogMessage(logging.LogDebug, fmt.Sprintf("Dropping upstream response for request %d from %v, its question is not the one forwarded", op.RequestId, logging.Addr(op.RequestorAddr)))
+					pending.Trace.Step("response from %s answers another question, dropped", attempt.Key)
+					continue
+				}
+				if ok, caseOnly := caseRandom.Verify(op.ByteData, attempt.Key, attempt.SentName); !ok {
+					logging.LogMessage(logging.LogDebug, fmt.Sprintf("Dropping upstream response for request %d, question name does not match query (0x20)", op.RequestId))
+					pending.Trace.Step("response from %s failed 0x20 check (case only: %t)", attempt.Key, caseOnly)
+					if caseOnly {
+						attempt.SentName = ""
+						pending.Retries++
+						pending.Trace.Step("retrying %s with original case", attempt.Key)
+						if err := requestUpstream(pending.Ctx, &attempt.Upstream, cookies.Prepare(pending.Outbound, attempt.Key)); err != nil {
+							logging.LogMessage(logging.LogError, "Unable to retry request to upstream: "+err.Error())
+						}
+					}
+					continue
+				}
+				cookie := cookies.Verify(op.ByteData, attempt.Key)
+				if cookie == cookieMismatch {
+					logging.LogMessage(logging.LogDebug, fmt.Sprintf("Dropping upstream response for request %d, cookie does not match the one sent to %s", op.RequestId, attempt.Key))
+					pending.Trace.Step("response from %s failed the cookie check", attempt.Key)
 					continue
 				}
-				if stateMap[op.RequestId] == nil {
+				upstreamHealth.answered(attempt.Key, serviceClock.Now())
+				if cookie == cookieBad && !attempt.CookieRetried {
+					attempt.CookieRetried = true
+					pending.Retries++
+					pending.Trace.Step("BADCOOKIE from %s, retrying with its server cookie", attempt.Key)
+					payload := cookies.Prepare(pending.Outbound, attempt.Key)
+					if attempt.SentName != "" {
+						restoreQuestionCase(payload, attempt.SentName)
+					}
+					if err := requestUpstream(pending.Ctx, &attempt.Upstream, payload); err != nil {
+						logging.LogMessage(logging.LogError, "Unable to retry request to upstream: "+err.Error())
+					}
 					continue
 				}
-				logging.LogMessage(logging.LogError, "Request for key "+op.RequestHash+" has timed out on both upstream nameservers")
-				switchNameservers(&locConf)
-				delete(stateMap, op.RequestId)
+				if isTruncated(op.ByteData) && protocolOf(&attempt.Upstream) == "udp" && !attempt.TCPFallback {
+					// the TCP answer arrives from the same address and is matched to this attempt again, a failed
+					// exchange leaves the attempt to its timeout so the next upstream is tried as for a lost answer
+					attempt.TCPFallback = true
+					pending.Retries++
+					stats.Increment(stats.TCPFallback)
+					pending.Trace.Step("truncated response from %s, retrying over tcp", attempt.Key)
+					tcp := attempt.Upstream
+					tcp.Protocol = "tcp"
+					payload := cookies.Prepare(pending.Outbound, attempt.Key)
+					if attempt.SentName != "" {
+						restoreQuestionCase(payload, attempt.SentName)
+					}
+					if err := requestUpstream(pending.Ctx, &tcp, payload); err != nil {
+						logging.LogMessage(logging.LogError, "Unable to retry request to upstream over tcp: "+err.Error())
+					}
+					continue
+				}
+				err := validateUpstreamResponse(op.ByteData, &locConf.UpstreamResponseLimits)
+				if err == nil && cookie == cookieBad {
+					err = errors.New("BADCOOKIE after retrying with the server cookie")
+				}
+				if err != nil {
+					stats.Increment(stats.UpstreamInvalid)
+					logging.LogMessage(logging.LogError, fmt.Sprintf("Rejected response from upstream %s for %s: %s, answering SERVFAIL", attempt.Key, logging.Name(pending.ClientName), err.Error()))
+					pending.Trace.Step("response from %s rejected: %s", attempt.Key, err.Error())
+					res, err := BuildErrorResponse(pending.Query, dnsmessage.RCodeServerFailure)
+					if err != nil {
+						logging.LogMessage(logging.LogError, err.Error())
+						continue
+					}
+					op.ByteData = res
+					op.Summary = ": rejected"
+				} else {
+					var stripped, rewritten bool
+					var pinned uint32
+					op.ByteData, _ = stripUpstreamCookie(op.ByteData, pending.ClientEDNS)
+					if op.ByteData, stripped = stripPadding(op.ByteData); stripped {
+						pending.Trace.Step("removed EDNS padding from response")
+					}
+					if locConf.StripECH && (pending.QueryType == config.TypeHTTPS || pending.QueryType == config.TypeSVCB) && !s.echExempt.Contains(pending.ClientName) {
+						if op.ByteData, stripped = stripECH(op.ByteData); stripped {
+							pending.Trace.Step("removed ech SvcParam from response")
+						}
+					}
+					if op.ByteData, rewritten = s.rewrites.apply(op.ByteData, pending.ClientName); rewritten {
+						pending.Trace.Step("rewrote addresses in response")
+						op.Summary += ", addresses rewritten"
+					}
+					if op.ByteData, pinned, pending.TTLPinned = s.ttlPins.apply(op.ByteData, pending.ClientName); pending.TTLPinned {
+						pending.Trace.Step("pinned TTLs to %ds", pinned)
+						op.Summary += fmt.Sprintf(", TTL pinned to %ds", pinned)
+					}
+					profileFor(s.profiles, pending.Conn).cache.Put(pending.ClientName, pending.QueryType, queryVariant(pending.Query), op.ByteData, serviceClock.Now(), pending.TTLPinned)
+				}
+				restoreQuestionCase(op.ByteData, pending.ClientName)
+				SetForwardedFlags(op.ByteData, pending.ClientRD)
+				op.ByteData[0], op.ByteData[1] = byte(pending.ClientID>>8), byte(pending.ClientID)
+				removePending(op.RequestId)
+				upstreamLimiter.Release(1)
+				clientsInflight.Release(pending.RequestorAddr.IP)
+				if pending.Ctx.Err() != nil {
+					logging.LogMessage(logging.LogDebug, fmt.Sprintf("Discarding late upstream response for request %d, client deadline passed", op.RequestId))
+					pending.Trace.Step("response from %s arrived after the deadline, discarded", attempt.Key)
+					continue
+				}
+				pending.Cancel()
+				if locConf.OrderUpstreamAnswers {
+					op.ByteData = orderer.Apply(op.ByteData, pending.RequestorAddr.IP)
+				}
+				source := "upstream"
+				if pending.CacheBypass {
+					source = "bypass"
+				}
+				pending.respond(op.ByteData, source)
+				if err == nil {
+					checkDrift(&locConf, attempt, pending, op.ByteData)
+				}
+				pending.Trace.Step("response from %s%s, answering %s", attempt.Key, op.Summary, responseRCode(op.ByteData))
+				elapsed := serviceClock.Now().Sub(pending.Received)
+				observeLatency("upstream", pending.QueryType, pending.Received)
+				stats.ObserveLatency(fmt.Sprintf("upstream=%s qtype=%s", attempt.Key, stats.QTypeBucket(pending.QueryType)), elapsed)
+				logging.LogEvery(logging.LogInfo, fmt.Sprintf("Received %s response from upstream %s for %s%s (%dms of %dms budget, failovers=%d, retries=%d)",
+					stats.QTypeBucket(pending.QueryType), attempt.Key, logging.Name(pending.ClientName), op.Summary, elapsed.Milliseconds(), locConf.QueryDeadlineMs, len(pending.Attempts)-1, pending.Retries))
+			case OpExpire:
+				pending := stateMap[op.RequestId]
+				if pending == nil || pending.Ctx != op.Ctx {
+					continue
+				}
+				logging.LogSimilar(logging.LogError, "query-deadline", fmt.Sprintf("Query deadline of %dms exceeded for %s after %dms (failovers=%d, retries=%d), answering SERVFAIL",
+					locConf.QueryDeadlineMs, logging.Name(pending.ClientName), serviceClock.Now().Sub(pending.Received).Milliseconds(), len(pending.Attempts)-1, pending.Retries))
+				upstreamsTimedOut(pending)
+				removePending(op.RequestId)
+				upstreamLimiter.Release(1)
+				clientsInflight.Release(pending.RequestorAddr.IP)
+				if pending.respondStale(profileFor(s.profiles, pending.Conn).cache) {
+					observeLatency("stale", pending.QueryType, pending.Received)
+					continue
+				}
+				pending.Trace.Step("query deadline of %dms exceeded, answering SERVFAIL", locConf.QueryDeadlineMs)
+				observeLatency("timeout", pending.QueryType, pending.Received)
+				res, err := BuildErrorResponse(pending.Query, dnsmessage.RCodeServerFailure)
+				if err != nil {
+					logging.LogMessage(logging.LogError, err.Error())
+					continue
+				}
+				pending.respond(res, "timeout")
 			}
 		}
 	}
 }
 
-func StartDNSService(c *net.UDPConn, conf *config.Configuration) {
-	conn = c
-	reqChan := make(chan StateOperation, 64)
-	var logMsg string
+func responseRCode(packet []byte) dnsmessage.RCode {
+	var p dnsmessage.Parser
+	h, err := p.Start(packet)
+	if err != nil {
+		return dnsmessage.RCodeServerFailure
+	}
+	return h.RCode
+}
+
+/*
+*	Records the time since received against the answer source and query type
+ */
+func observeLatency(source string, qtype dnsmessage.Type, received time.Time) {
+	stats.ObserveLatency(fmt.Sprintf("source=%s qtype=%s", source, stats.QTypeBucket(qtype)), serviceClock.Now().Sub(received))
+}
+
+/*
+*	Waits for the upstream timeout on c and then queues next, unless the query deadline expires first
+ */
+func awaitUpstream(ctx context.Context, c clock.Clock, input chan StateOperation, timeout time.Duration, next StateOperation) {
+	timer := c.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		input <- next
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			input <- StateOperation{Operation: OpExpire, RequestId: next.RequestId, Ctx: ctx}
+		}
+	}
+}
+
+/*
+*	Queues a reload of local records, zones and blocklists, the state worker keeps its current state if building them fails
+ */
+func Reload(conf *config.Configuration) {
+	SetTraceDomains(conf.TraceDomains)
+	setTraceAllProfiles(conf)
+	SetFaultInjection(&conf.FaultInjection)
+	SetAlerting(&conf.Alerting)
+	SetRecordAudit(&conf.RecordAudit)
+	SetResourceLimits(&conf.ResourceLimits)
+	SetMirror(&conf.MirrorTo)
+	SetOffline(&conf.Offline)
+	SetResponseHook(&conf.ResponseHook)
+	reqChan <- StateOperation{Operation: OpReload, Config: conf}
+}
+
+/*
+*	Queues dropping every cached answer of every profile, local records and blocklists are unaffected
+ */
+func FlushCache() {
+	reqChan <- StateOperation{Operation: OpFlush}
+}
+
+/*
+*	Serves DNS on every listener, answers are always sent from the socket a query arrived on
+ */
+func StartDNSService(conns []*net.UDPConn, conf *config.Configuration) {
+	listeners = conns
+	reqChan = make(chan StateOperation, 64)
+	queryDeadline = time.Duration(conf.QueryDeadlineMs) * time.Millisecond
+	SetTraceDomains(conf.TraceDomains)
+	setTraceAllProfiles(conf)
+	SetFaultInjection(&conf.FaultInjection)
+	setAcceptedUpstreams(conf)
+	upstreamSockets = make(map[string]*net.UDPConn)
+	var nameservers []*config.Nameserver
+	global := conf.UpstreamNameservers.Nameservers()
+	for k := range global {
+		nameservers = append(nameservers, &global[k])
+	}
+	for k := range conf.ForwardingRules {
+		for i := range conf.ForwardingRules[k].Nameservers {
+			nameservers = append(nameservers, &conf.ForwardingRules[k].Nameservers[i])
+		}
+	}
+	for _, ns := range nameservers {
+		if (ns.BindAddress == "" && ns.BindInterface == "") || upstreamSockets[upstreamKey(ns)] != nil {
+			continue
+		}
+		sock, err := openUpstreamSocket(ns)
+		if err != nil {
+			logging.LogMessage(logging.LogFatal, err.Error())
+			return
+		}
+		upstreamSockets[upstreamKey(ns)] = sock
+		go serveListener(sock, reqChan, conf, true)
+	}
+	own := append([]*net.UDPConn{}, conns...)
+	for _, sock := range upstreamSockets {
+		own = append(own, sock)
+	}
+	recordOwnSources(own)
+	SetAlerting(&conf.Alerting)
+	upstreamHealth = newHealthMonitor(conf)
+	go watchUpstreamHealth(upstreamHealth)
+	go sendAlerts()
+	SetRecordAudit(&conf.RecordAudit)
+	SetResourceLimits(&conf.ResourceLimits)
+	go watchResources()
+	SetMirror(&conf.MirrorTo)
+	SetOffline(&conf.Offline)
+	go watchOffline(upstreamHealth)
+	SetResponseHook(&conf.ResponseHook)
+	go stats.SampleRing()
 	go startStateWorker(reqChan, conf)
+	atomic.StoreInt32(&running, 1)
+	go warmUp(conf.WarmupNames)
+	if conf.SelfCheck {
+		go runSelfCheck(conns, conf)
+	}
+	for _, c := range conns[1:] {
+		go serveListener(c, reqChan, conf, false)
+	}
+	serveListener(conns[0], reqChan, conf, false)
+}
+
+/*
+*	Reads packets from one socket, upstreamOnly sockets exist for sending upstream queries and only accept their responses
+ */
+func serveListener(conn *net.UDPConn, reqChan chan StateOperation, conf *config.Configuration, upstreamOnly bool) {
 	logging.LogMessage(logging.LogInfo, "Starting Listener service on port "+conn.LocalAddr().String())
+	counter := stats.ListenerQueries(conn.LocalAddr().String())
+	profile := listenerProfiles[conn]
+	tuneSocket(conn, conf)
+	if !upstreamOnly {
+		enablePacketInfo(conn)
+	}
+	broadcasts := interfaceBroadcasts()
+	// upstream answers can be as large as the payload size forwarded queries advertise, and nothing keeps a
+	// reference to the buffer once a packet is handled, so one buffer of the largest UDP payload is reused
+	buf := make([]byte, 65535)
 	for {
-		buf := make([]byte, 512)
-		_, addr, _ := conn.ReadFromUDP(buf)
+		n, addr, dst, err := readPacket(conn, buf)
+		received := serviceClock.Now()
+		if errors.Is(err, net.ErrClosed) {
+			logging.LogMessage(logging.LogInfo, "Listener on "+conn.LocalAddr().String()+" closed")
+			return
+		}
+		if err != nil {
+			logging.LogMessage(logging.LogError, "Failed to read from UDP listener: "+err.Error())
+			continue
+		}
+		if reason := bogusSource(addr, broadcasts); reason != "" {
+			stats.Increment(stats.BogusSource)
+			sourceWarnings.LogMessage(logging.LogDebug, fmt.Sprintf("Dropping packet from %v on %s, %s", logging.Addr(addr), conn.LocalAddr(), reason))
+			continue
+		}
+		if looksLikeHTTP(buf[:n]) {
+			stats.Increment(stats.Malformed)
+			httpWarnings.LogMessage(logging.LogError, fmt.Sprintf("HTTP request received on DNS listener %s from %s, dropping (the admin API is served on AdminListen)", conn.LocalAddr(), logging.Addr(addr)))
+			continue
+		}
 		var m dnsmessage.Message
-		err := m.Unpack(buf)
+		err = m.Unpack(buf[:n])
 		if err != nil {
-			logging.LogMessage(logging.LogError, fmt.Sprintf("Invalid DNS message received from %v - skipping", addr))
+			logging.LogMessage(logging.LogError, fmt.Sprintf("Invalid DNS message received from %s - skipping", logging.Addr(addr)))
+			stats.Increment(stats.Malformed)
+			continue
+		}
+		if m.Header.Response {
+			// responses are only expected from our upstreams, anything else sent to the query port is dropped
+			if !isAcceptedUpstream(net.JoinHostPort(addr.IP.String(), fmt.Sprint(addr.Port))) || len(m.Questions) == 0 {
+				logging.LogMessage(logging.LogDebug, fmt.Sprintf("Dropping unexpected response packet from %v", addr))
+				continue
+			}
+			reqChan <- upstreamResponse(&m, addr, conn)
+			continue
+		}
+		if upstreamOnly {
+			logging.LogMessage(logging.LogDebug, fmt.Sprintf("Dropping query sent to upstream source socket from %s", logging.Addr(addr)))
+			continue
+		}
+		if len(m.Questions) == 0 {
+			logging.LogMessage(logging.LogDebug, fmt.Sprintf("Query without a question from %s, answering FORMERR", logging.Addr(addr)))
+			stats.Increment(stats.Malformed)
+			if res, err := BuildErrorResponse(buf[:n], dnsmessage.RCodeFormatError); err == nil {
+				stats.CountResponse(dnsmessage.RCodeFormatError)
+				spawnSend(routineReply, func() { writeReply(conn, res, addr, dst) })
+			}
+			continue
+		}
+		if len(m.Questions) > 1 {
+			if conf.MultipleQuestions == "formerr" {
+				logging.LogMessage(logging.LogDebug, fmt.Sprintf("Query with %d questions from %s, answering FORMERR", len(m.Questions), logging.Addr(addr)))
+				stats.Increment(stats.Malformed)
+				if res, err := BuildErrorResponse(buf[:n], dnsmessage.RCodeFormatError); err == nil {
+					stats.CountResponse(dnsmessage.RCodeFormatError)
+					spawnSend(routineReply, func() { writeReply(conn, res, addr, dst) })
+				}
+				continue
+			}
+			m.Questions = m.Questions[:1]
+		}
+		if rcode, ok := classRcode(m.Questions[0].Class); ok {
+			logging.LogMessage(logging.LogDebug, fmt.Sprintf("Query for %s in class %s from %s, answering %s", logging.Name(m.Questions[0].Name.String()), m.Questions[0].Class, logging.Addr(addr), rcode))
+			stats.Increment(stats.ClassNotIN)
+			if res, err := BuildErrorResponse(buf[:n], rcode); err == nil {
+				stats.CountResponse(rcode)
+				spawnSend(routineReply, func() { writeReply(conn, res, addr, dst) })
+			}
+			continue
+		}
+		if isOwnSource(addr) {
+			warnLoop(m.Questions[0].Name.String())
+			if res, err := BuildErrorResponse(buf[:n], dnsmessage.RCodeServerFailure); err == nil {
+				stats.CountResponse(dnsmessage.RCodeServerFailure)
+				spawnSend(routineReply, func() { writeReply(conn, res, addr, dst) })
+			}
 			continue
 		}
+		// repacking drops the reserved Z bit, so it is never echoed or forwarded. dnsmessage has no CD or AD flags
+		// either, they are copied back so the query still asks for what the client did
 		packed, _ := m.Pack()
+		packed[3] |= buf[3] & (flagCheckingDisabled | flagAuthenticData)
 		key, err := HashMessageFields(&packed)
 		if err != nil {
-			logging.LogMessage(logging.LogFatal, err.Error())
+			logging.LogMessage(logging.LogError, err.Error())
+			continue
 		}
-		if m.Header.Response {
-			lock.Lock()
-			logMsg = fmt.Sprintf("Received %s response from upstream %s for %s", m.Questions[0].Type, currentUpstream, m.Questions[0].Name)
-			lock.Unlock()
-			if len(m.Answers) > 0 {
-				logMsg = logMsg + GetAddressFromResource(m.Answers[0])
-			} else {
-				logMsg = logMsg + ": empty "
+		logging.LogEvery(logging.LogInfo, fmt.Sprintf("Received resource request for %s from %s", logging.Name(m.Questions[0].Name.String()), logging.Client(addr.IP)))
+		stats.Increment(counter)
+		stats.CountQuery(m.Questions[0].Type)
+		stats.TopClients.Add(logging.Client(addr.IP), received)
+		stats.TopDomains.Add(logging.Name(stats.RegisteredDomain(m.Questions[0].Name.String(), conf.TopDomainDepth)), received)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(conf.QueryDeadlineMs)*time.Millisecond)
+		trace := startTrace(m.Questions[0].Name.String(), m.ID, received)
+		if profile != "" {
+			stats.Increment(stats.ProfileQueries(profile))
+			if trace == nil && tracesAll(profile) {
+				trace = &queryTrace{id: m.ID, name: m.Questions[0].Name.String(), start: received}
+			}
+		}
+		trace.Step("query %s %s from %s rd=%t", m.Questions[0].Type, m.Questions[0].Class, logging.Client(addr.IP), m.Header.RecursionDesired)
+		mirrorQuery(packed, m.Questions[0], addr, m.ID)
+		op := StateOperation{Operation: OpAdd, RequestHash: key, RequestorAddr: addr, RequestId: m.ID, Question: m.Questions[0], Header: m.Header, ByteData: packed, Ctx: ctx, Cancel: cancel, Received: received, Conn: conn, Dst: dst, Trace: trace}
+		switch fault, delay := pickFault(m.Questions[0].Name.String()); fault {
+		case "servfail":
+			stats.Increment(stats.FaultServfail)
+			trace.Step("fault injection, answering SERVFAIL")
+			cancel()
+			if res, err := BuildErrorResponse(packed, dnsmessage.RCodeServerFailure); err == nil {
+				op.respond(res, "fault")
 			}
-			logging.LogMessage(logging.LogInfo, logMsg)
-			reqChan <- StateOperation{Operation: OpRespond, RequestId: m.ID, ByteData: packed}
 			continue
-		} else {
-			if len(m.Questions) == 0 {
+		case "drop":
+			stats.Increment(stats.FaultDrop)
+			trace.Step("fault injection, dropping query")
+			cancel()
+			continue
+		case "delay":
+			stats.Increment(stats.FaultDelay)
+			trace.Step("fault injection, delaying query by %dms", delay.Milliseconds())
+			if overloaded() {
+				stats.Increment(stats.LoadShed)
+				cancel()
+				if res, err := BuildErrorResponse(packed, dnsmessage.RCodeServerFailure); err == nil {
+					op.respond(res, "rejected")
+				}
 				continue
 			}
-			logging.LogMessage(logging.LogInfo, fmt.Sprintf("Received resource request for %v", m.Questions[0].Name))
-			reqChan <- StateOperation{Operation: OpAdd, RequestHash: key, RequestorAddr: addr, RequestId: m.ID, ByteData: packed}
+			spawn(routineFaultDelay, func() {
+				<-serviceClock.After(delay)
+				if !hookQuery(reqChan, op) {
+					reqChan <- op
+				}
+			})
+			continue
+		}
+		if hookQuery(reqChan, op) {
+			continue
 		}
+		reqChan <- op
 	}
 }
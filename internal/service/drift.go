@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+	"github.com/TasSM/labns/internal/stats"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	Asks the other global upstream the same question for a sampled share of answered queries and reports when the
+*	two answers differ materially. The check runs on its own goroutine and exchange after the client has its answer,
+*	and takes a slot of the upstream limiter like any forwarded query, skipping the check when none is free
+ */
+func checkDrift(conf *config.Configuration, answered *upstreamAttempt, pending *pendingRequest, res []byte) {
+	if conf.DriftDetection.SampleRate == 0 || pending.Plan.Rule != "" || !conf.UpstreamNameservers.HasSecondary() || offlineActive() || rand.Float64() >= conf.DriftDetection.SampleRate {
+		return
+	}
+	other := conf.UpstreamNameservers.Secondary
+	if answered.Key == upstreamKey(&other) {
+		other = conf.UpstreamNameservers.Primary
+	} else if answered.Key != upstreamKey(&conf.UpstreamNameservers.Primary) {
+		return
+	}
+	if upstreamKey(&other) == answered.Key {
+		return
+	}
+	limiter := upstreamLimiter
+	if overloaded() || !limiter.TryAcquire(1) {
+		stats.Increment(stats.DriftSkipped)
+		return
+	}
+	query, answer := append([]byte{}, pending.Outbound...), append([]byte{}, res...)
+	key, name, qtype, timeout := answered.Key, pending.ClientName, pending.QueryType, pending.Plan.Timeout
+	spawn(routineDrift, func() {
+		defer limiter.Release(1)
+		compareUpstreams(key, answer, &other, query, name, qtype, timeout)
+	})
+}
+
+func compareUpstreams(answeredKey string, answer []byte, other *config.Nameserver, query []byte, name string, qtype dnsmessage.Type, timeout time.Duration) {
+	otherKey := upstreamKey(other)
+	var res []byte
+	query[0], query[1] = byte(rand.Intn(256)), byte(rand.Intn(256))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	t, err := transportFor(other)
+	if err == nil {
+		res, err = t.Exchange(ctx, query)
+	}
+	if err != nil {
+		stats.Increment(stats.DriftFailed)
+		logging.LogMessage(logging.LogDebug, fmt.Sprintf("Drift check of %s against %s failed: %v", logging.Name(name), otherKey, err))
+		return
+	}
+	if res[2]&0x02 != 0 {
+		// a truncated answer carries no records to compare
+		return
+	}
+	stats.Increment(stats.DriftChecked)
+	first, second := driftSignature(answer), driftSignature(res)
+	if first == second {
+		return
+	}
+	stats.Increment(stats.DriftMismatched)
+	logging.LogMessage(logging.LogInfo, fmt.Sprintf("Upstreams disagree on %s %s: %s answered %s, %s answered %s",
+		logging.Name(name), stats.QTypeBucket(qtype), answeredKey, first, otherKey, second))
+}
+
+/*
+*	The parts of an answer that make a material difference: the rcode and the set of addresses in the answer
+*	section. TTLs, ordering, duplicates and the CNAMEs leading to the addresses are left out
+ */
+func driftSignature(res []byte) string {
+	var m dnsmessage.Message
+	if err := m.Unpack(res); err != nil {
+		return "unparseable"
+	}
+	seen := make(map[string]bool)
+	addresses := []string{}
+	for _, r := range m.Answers {
+		var ip net.IP
+		switch body := r.Body.(type) {
+		case *dnsmessage.AResource:
+			ip = net.IP(body.A[:])
+		case *dnsmessage.AAAAResource:
+			ip = net.IP(body.AAAA[:])
+		default:
+			continue
+		}
+		if !seen[ip.String()] {
+			seen[ip.String()] = true
+			addresses = append(addresses, ip.String())
+		}
+	}
+	sort.Strings(addresses)
+	return stats.RCodeBucket(m.Header.RCode) + " [" + strings.Join(addresses, ", ") + "]"
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"github.com/TasSM/labns/internal/config"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	Removes the ech SvcParam from every SVCB/HTTPS record in packet, reporting whether anything changed.
+*	Records that can't be parsed are left as they are
+ */
+func stripECH(packet []byte) ([]byte, bool) {
+	var m dnsmessage.Message
+	if err := m.Unpack(packet); err != nil {
+		return packet, false
+	}
+	changed := false
+	for _, section := range [][]dnsmessage.Resource{m.Answers, m.Additionals} {
+		for k := range section {
+			if section[k].Header.Type != config.TypeSVCB && section[k].Header.Type != config.TypeHTTPS {
+				continue
+			}
+			raw, ok := section[k].Body.(*dnsmessage.UnknownResource)
+			if !ok {
+				continue
+			}
+			if data, ok := removeSvcParam(raw.Data, SvcParamECH); ok {
+				section[k].Body = &dnsmessage.UnknownResource{Type: raw.Type, Data: data}
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return packet, false
+	}
+	packed, err := m.Pack()
+	if err != nil {
+		return packet, false
+	}
+	return packed, true
+}
+
+/*
+*	Re-encodes SVCB RDATA without the SvcParam key, the target name is never compressed (RFC 9460 section 2.2).
+*	The key is also taken out of the mandatory list, which may not name a key the record lacks (section 8), and
+*	a mandatory list left empty is removed with it
+ */
+func removeSvcParam(data []byte, key uint16) ([]byte, bool) {
+	i := 2
+	for {
+		if i >= len(data) {
+			return nil, false
+		}
+		l := int(data[i])
+		if l&0xC0 != 0 {
+			return nil, false
+		}
+		i++
+		if l == 0 {
+			break
+		}
+		i += l
+	}
+	out := append([]byte{}, data[:i]...)
+	var params []svcParam
+	found := false
+	for i < len(data) {
+		if i+4 > len(data) {
+			return nil, false
+		}
+		k := uint16(data[i])<<8 | uint16(data[i+1])
+		end := i + 4 + (int(data[i+2])<<8 | int(data[i+3]))
+		if end > len(data) {
+			return nil, false
+		}
+		if k == key {
+			found = true
+		} else {
+			params = append(params, svcParam{k, data[i+4 : end]})
+		}
+		i = end
+	}
+	if !found {
+		return nil, false
+	}
+	for _, p := range params {
+		if p.Key == SvcParamMandatory {
+			if p.Value = withoutKey(p.Value, key); len(p.Value) == 0 {
+				continue
+			}
+		}
+		out = append(out, byte(p.Key>>8), byte(p.Key), byte(len(p.Value)>>8), byte(len(p.Value)))
+		out = append(out, p.Value...)
+	}
+	return out, true
+}
+
+/*
+*	Removes key from the list of two byte keys a mandatory SvcParam holds
+ */
+func withoutKey(keys []byte, key uint16) []byte {
+	var out []byte
+	for i := 0; i+1 < len(keys); i += 2 {
+		if uint16(keys[i])<<8|uint16(keys[i+1]) != key {
+			out = append(out, keys[i], keys[i+1])
+		}
+	}
+	return out
+}
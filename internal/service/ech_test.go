@@ -0,0 +1,83 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/TasSM/labns/internal/config"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	Encodes SVCB RDATA with priority 1, the root as target and params in the order given
+ */
+func svcbData(params ...svcParam) []byte {
+	data := []byte{0, 1, 0}
+	for _, p := range params {
+		data = append(data, byte(p.Key>>8), byte(p.Key), byte(len(p.Value)>>8), byte(len(p.Value)))
+		data = append(data, p.Value...)
+	}
+	return data
+}
+
+func TestRemoveSvcParam(t *testing.T) {
+	alpn := svcParam{SvcParamAlpn, []byte{2, 'h', '2'}}
+	ech := svcParam{SvcParamECH, []byte{1, 2, 3, 4}}
+	hint := svcParam{SvcParamIPv4Hint, []byte{192, 0, 2, 1}}
+	cases := []struct {
+		name  string
+		in    []byte
+		want  []byte
+		found bool
+	}{
+		{"ech alone", svcbData(alpn, ech, hint), svcbData(alpn, hint), true},
+		{"ech in mandatory", svcbData(svcParam{SvcParamMandatory, []byte{0, 1, 0, 5}}, alpn, ech), svcbData(svcParam{SvcParamMandatory, []byte{0, 1}}, alpn), true},
+		{"mandatory left empty", svcbData(svcParam{SvcParamMandatory, []byte{0, 5}}, alpn, ech, hint), svcbData(alpn, hint), true},
+		{"mandatory without ech", svcbData(svcParam{SvcParamMandatory, []byte{0, 1}}, alpn, ech), svcbData(svcParam{SvcParamMandatory, []byte{0, 1}}, alpn), true},
+		{"no ech", svcbData(svcParam{SvcParamMandatory, []byte{0, 1}}, alpn), nil, false},
+		{"alias form", []byte{0, 0, 3, 'c', 'd', 'n', 0}, nil, false},
+		{"truncated param", svcbData(alpn, ech)[:12], nil, false},
+		{"compressed target", []byte{0, 1, 0xC0, 12}, nil, false},
+	}
+	for _, c := range cases {
+		got, found := removeSvcParam(c.in, SvcParamECH)
+		if found != c.found || !bytes.Equal(got, c.want) {
+			t.Errorf("%s: removeSvcParam = %x, %t, want %x, %t", c.name, got, found, c.want, c.found)
+		}
+	}
+}
+
+func TestStripECHFromHTTPSAnswer(t *testing.T) {
+	name := dnsmessage.MustNewName("www.ech.test.")
+	record := func(data []byte) dnsmessage.Resource {
+		return dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: name, Type: config.TypeHTTPS, Class: dnsmessage.ClassINET, TTL: 300},
+			Body:   &dnsmessage.UnknownResource{Type: config.TypeHTTPS, Data: data},
+		}
+	}
+	pack := func(data []byte) []byte {
+		m := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: 3, Response: true},
+			Questions: []dnsmessage.Question{{Name: name, Type: config.TypeHTTPS, Class: dnsmessage.ClassINET}},
+			Answers:   []dnsmessage.Resource{record(data)},
+		}
+		packet, err := m.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return packet
+	}
+	ech := svcParam{SvcParamECH, []byte{1, 2, 3, 4}}
+	alpn := svcParam{SvcParamAlpn, []byte{2, 'h', '3'}}
+	in := pack(svcbData(svcParam{SvcParamMandatory, []byte{0, 5}}, alpn, ech))
+	out, changed := stripECH(in)
+	if !changed {
+		t.Fatal("ech was not stripped")
+	}
+	if want := pack(svcbData(alpn)); !bytes.Equal(out, want) {
+		t.Fatalf("stripped answer is %x, want %x", out, want)
+	}
+	if _, changed := stripECH(out); changed {
+		t.Fatal("an answer without ech was changed")
+	}
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"net"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+	"github.com/TasSM/labns/internal/stats"
+)
+
+const (
+	// the TTL of stale answers suggested by RFC 8767
+	fastPathStaleTTL = 30
+	// an entry is refreshed once less than this fraction of its lifetime remains
+	fastPathRefreshFraction = 10
+)
+
+/*
+*	Connectivity check names that devices probe constantly. Their cached answers live at least MinTTL, are
+*	refreshed from upstream before they expire and are served stale when every upstream times out, so a flaky
+*	upstream doesn't make devices report the network as down. Set by the state worker and read by its caches
+ */
+type fastPathNames struct {
+	zones  localZones
+	minTTL uint32
+}
+
+var fastPath fastPathNames
+
+func newFastPath(conf *config.FastPath) fastPathNames {
+	domains := conf.Domains
+	if !conf.DisableDefaults {
+		domains = append(append([]string{}, config.DEFAULT_FAST_PATH_DOMAINS...), domains...)
+	}
+	return fastPathNames{zones: newLocalZones(domains), minTTL: conf.MinTTL}
+}
+
+/*
+*	Resolves the client query for name again through the pipeline without answering from the cache, so the answer
+*	replaces the cached one for the same DO and CD bits. conn selects the listener profile whose cache is refreshed
+ */
+func refreshFastPath(query []byte, name string, conn *net.UDPConn) {
+	stats.Increment(stats.FastPathRefresh)
+	if _, err := resolve(context.Background(), query, conn, true); err != nil {
+		logging.LogMessage(logging.LogDebug, "Refresh of fast path name "+logging.Name(name)+" failed: "+err.Error())
+	}
+}
+
+/*
+*	Answers a request every upstream failed to answer from a fast path entry that has expired, reports whether it did
+ */
+func (p *pendingRequest) respondStale(cache *responseCache) bool {
+	res := cache.GetStale(p.ClientName, p.QueryType, queryVariant(p.Query))
+	if res == nil {
+		return false
+	}
+	res = cachedAnswer(res, p.Query, p.ClientID, p.ClientName, p.ClientRD)
+	p.Trace.Step("answering with a stale fast path entry")
+	p.respond(res, "stale")
+	return true
+}
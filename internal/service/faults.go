@@ -0,0 +1,105 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+)
+
+var faultInjection atomic.Value
+
+/*
+*	Fault injection state, replaced as a whole so listeners read it without locking
+ */
+type faultInjector struct {
+	enabled bool
+	rules   []faultRule
+}
+
+type faultRule struct {
+	zones       localZones
+	all         bool
+	mode        string
+	probability float64
+	delay       time.Duration
+	jitter      time.Duration
+}
+
+/*
+*	Loads the fault rules of fi, a reload also resets any toggle made through the admin API
+ */
+func SetFaultInjection(fi *config.FaultInjection) {
+	f := &faultInjector{enabled: fi.Enabled}
+	for _, r := range fi.Rules {
+		rule := faultRule{zones: newLocalZones(r.Domains), mode: r.Mode, probability: r.Probability,
+			delay: time.Duration(r.DelayMs) * time.Millisecond, jitter: time.Duration(r.JitterMs) * time.Millisecond}
+		for _, d := range r.Domains {
+			if d == "." {
+				rule.all = true
+			}
+		}
+		f.rules = append(f.rules, rule)
+	}
+	faultInjection.Store(f)
+	if f.enabled {
+		logFaultsEnabled(len(f.rules))
+	}
+}
+
+/*
+*	Turns the configured fault rules on or off, enabling fails when the configuration has no rules
+ */
+func SetFaultInjectionEnabled(enabled bool) bool {
+	current, _ := faultInjection.Load().(*faultInjector)
+	if current == nil || (enabled && len(current.rules) == 0) {
+		return false
+	}
+	faultInjection.Store(&faultInjector{enabled: enabled, rules: current.rules})
+	if enabled {
+		logFaultsEnabled(len(current.rules))
+	} else {
+		logging.LogMessage(logging.LogInfo, "Fault injection disabled")
+	}
+	return true
+}
+
+func FaultInjectionEnabled() (bool, int) {
+	current, _ := faultInjection.Load().(*faultInjector)
+	if current == nil {
+		return false, 0
+	}
+	return current.enabled, len(current.rules)
+}
+
+func logFaultsEnabled(rules int) {
+	logging.LogMessage(logging.LogError, fmt.Sprintf("FAULT INJECTION IS ENABLED with %d rules, matching queries will be answered SERVFAIL, delayed or dropped on purpose", rules))
+}
+
+/*
+*	Picks the fault for a query, the first rule covering name whose roll succeeds wins. Faults are applied before
+*	the state worker sees the query, so an injected answer is never cached and cached answers are faulted as well
+ */
+func pickFault(name string) (string, time.Duration) {
+	current, _ := faultInjection.Load().(*faultInjector)
+	if current == nil || !current.enabled {
+		return "", 0
+	}
+	for _, r := range current.rules {
+		if !r.all && !r.zones.Contains(name) {
+			continue
+		}
+		if rand.Float64() >= r.probability {
+			continue
+		}
+		delay := r.delay
+		if r.jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(r.jitter)))
+		}
+		return r.mode, delay
+	}
+	return "", 0
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnsname"
+	"github.com/TasSM/labns/internal/logging"
+)
+
+/*
+*	The upstreams a query is forwarded to and how, failover sends to one upstream per timeout
+*	while race sends to all of them at once, either repeats Retries more times before giving up
+ */
+type forwardPlan struct {
+	Rule      string
+	Upstreams []config.Nameserver
+	Strategy  string
+	Timeout   time.Duration
+	Retries   int
+}
+
+type forwardingRules map[string]*forwardPlan
+
+var acceptedUpstreams atomic.Value
+
+func newForwardingRules(rules []config.ForwardingRule) forwardingRules {
+	f := make(forwardingRules)
+	for k, v := range rules {
+		plan := &forwardPlan{
+			Rule:      fmt.Sprintf("%d (%s)", k, strings.Join(v.Domains, ", ")),
+			Upstreams: v.Nameservers,
+			Strategy:  v.Strategy,
+			Timeout:   time.Duration(v.TimeoutMs) * time.Millisecond,
+			Retries:   int(*v.Retries),
+		}
+		for _, d := range v.Domains {
+			f[dnsname.Key(d)] = plan
+		}
+	}
+	return f
+}
+
+/*
+*	Returns the rule for the most specific domain name falls under, along with that domain
+ */
+func (f forwardingRules) Match(name string) (string, *forwardPlan) {
+	if len(f) == 0 {
+		return "", nil
+	}
+	name = dnsname.Key(name)
+	for {
+		if plan := f[name]; plan != nil {
+			return name, plan
+		}
+		idx := strings.Index(name, ".")
+		if idx < 0 || idx == len(name)-1 {
+			return "", nil
+		}
+		name = name[idx+1:]
+	}
+}
+
+func globalPlan(conf *config.Configuration) *forwardPlan {
+	return &forwardPlan{
+		Upstreams: conf.UpstreamNameservers.Nameservers(),
+		Strategy:  conf.UpstreamNameservers.Strategy,
+		Timeout:   time.Duration(conf.UpstreamNameservers.TimeoutMs) * time.Millisecond,
+		Retries:   int(conf.UpstreamNameservers.Retries),
+	}
+}
+
+func logForwardingSettings(conf *config.Configuration) {
+	logging.LogMessage(logging.LogInfo, fmt.Sprintf("Default upstreams: strategy=%s timeout=%dms retries=%d",
+		conf.UpstreamNameservers.Strategy, conf.UpstreamNameservers.TimeoutMs, conf.UpstreamNameservers.Retries))
+	for k, v := range conf.ForwardingRules {
+		names := make([]string, len(v.Nameservers))
+		for i := range v.Nameservers {
+			names[i] = upstreamKey(&v.Nameservers[i])
+		}
+		logging.LogMessage(logging.LogInfo, fmt.Sprintf("Forwarding rule %d for %s: upstreams=%s strategy=%s timeout=%dms retries=%d",
+			k, strings.Join(v.Domains, ", "), strings.Join(names, ", "), v.Strategy, v.TimeoutMs, *v.Retries))
+	}
+}
+
+/*
+*	Sends the next step of the pending request's plan, reporting false once every round has been sent
+ */
+func (p *pendingRequest) forwardNext() bool {
+	plan := p.Plan
+	if plan.Strategy == "race" {
+		if p.Round > plan.Retries {
+			return false
+		}
+		p.Round++
+		for k := range plan.Upstreams {
+			if err := forwardPending(p, &plan.Upstreams[k]); err != nil {
+				logging.LogMessage(logging.LogError, "Unable to forward request to upstream: "+err.Error())
+			}
+		}
+		return true
+	}
+	if p.Round >= len(plan.Upstreams)*(plan.Retries+1) {
+		return false
+	}
+	ns := &plan.Upstreams[p.Round%len(plan.Upstreams)]
+	p.Round++
+	if err := forwardPending(p, ns); err != nil {
+		logging.LogMessage(logging.LogError, "Unable to forward request to upstream: "+err.Error())
+	}
+	return true
+}
+
+/*
+*	Records every upstream address a response may legitimately arrive from
+ */
+func setAcceptedUpstreams(conf *config.Configuration) {
+	accepted := map[string]bool{}
+	global := conf.UpstreamNameservers.Nameservers()
+	for k := range global {
+		accepted[upstreamKey(&global[k])] = true
+	}
+	for _, rule := range conf.ForwardingRules {
+		for k := range rule.Nameservers {
+			accepted[upstreamKey(&rule.Nameservers[k])] = true
+		}
+	}
+	for k := range conf.Delegations {
+		if !conf.Delegations[k].Recurse {
+			continue
+		}
+		upstreams := conf.Delegations[k].Upstreams()
+		for i := range upstreams {
+			accepted[upstreamKey(&upstreams[i])] = true
+		}
+	}
+	acceptedUpstreams.Store(accepted)
+}
+
+func isAcceptedUpstream(key string) bool {
+	accepted, _ := acceptedUpstreams.Load().(map[string]bool)
+	return accepted[key]
+}
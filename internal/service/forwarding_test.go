@@ -0,0 +1,28 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/TasSM/labns/internal/config"
+)
+
+func TestSingleGlobalUpstream(t *testing.T) {
+	conf := testConfig(t)
+	conf.UpstreamNameservers.Secondary = config.Nameserver{}
+	if plan := globalPlan(conf); len(plan.Upstreams) != 1 || plan.Upstreams[0] != conf.UpstreamNameservers.Primary {
+		t.Fatalf("global plan forwards to %+v, want the primary alone", plan.Upstreams)
+	}
+	primary := conf.UpstreamNameservers.Primary
+	switchNameservers(conf)
+	if conf.UpstreamNameservers.Primary != primary || conf.UpstreamNameservers.HasSecondary() {
+		t.Fatal("failing over without a secondary replaced the primary")
+	}
+	if m := newHealthMonitor(conf); len(m.upstreams) != 1 {
+		t.Fatalf("health is tracked for %d upstreams, want 1", len(m.upstreams))
+	}
+	for _, ns := range configuredNameservers(conf) {
+		if ns == (config.Nameserver{}) {
+			t.Fatal("the missing secondary is among the configured nameservers")
+		}
+	}
+}
@@ -0,0 +1,74 @@
+//go:build go1.18
+// +build go1.18
+
+package service
+
+import (
+	"testing"
+
+	"github.com/TasSM/labns/internal/wirecorpus"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	Feeds arbitrary packets to what the listener does with one before it reaches the state worker: parsing, the
+*	error responses, hashing, rebuilding it for upstream, and the cookie, padding and size handling applied to
+*	upstream answers. Nothing may panic, and every packet built from a parsed one must parse again. Seeded with the
+*	queries and responses of the wire corpus
+ */
+func FuzzParseMessage(f *testing.F) {
+	cases, err := wirecorpus.Load(corpusDir)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, c := range cases {
+		f.Add(c.Query)
+		if len(c.Response) > 0 {
+			f.Add(c.Response)
+		}
+	}
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		var m dnsmessage.Message
+		if err := m.Unpack(packet); err != nil {
+			return
+		}
+		reparses := func(what string, out []byte) {
+			var r dnsmessage.Message
+			if err := r.Unpack(out); err != nil {
+				t.Fatalf("%s built from a valid packet does not parse: %v", what, err)
+			}
+		}
+		if res, err := BuildErrorResponse(packet, dnsmessage.RCodeFormatError); err == nil {
+			var r dnsmessage.Message
+			if err := r.Unpack(res); err != nil || r.Header.ID != m.Header.ID || !r.Header.Response {
+				t.Fatalf("FORMERR response does not parse as a response with the query ID: %v", err)
+			}
+		}
+		if size := clientPayloadSize(packet); size < 512 {
+			t.Fatalf("clientPayloadSize = %d, below the 512 bytes every client accepts", size)
+		}
+		if len(m.Questions) == 0 {
+			return
+		}
+		packed, err := m.Pack()
+		if err != nil {
+			return
+		}
+		if _, err := HashMessageFields(&packed); err != nil {
+			t.Fatalf("HashMessageFields of a repacked message failed: %v", err)
+		}
+		if out, err := sanitizeQuery(packet, 0x1234); err == nil {
+			reparses("sanitized query", out)
+		}
+		if out, changed := stripUpstreamCookie(packet, true); changed {
+			reparses("response without the upstream cookie", out)
+		}
+		if out, changed := stripUpstreamCookie(packet, false); changed {
+			reparses("response without OPT", out)
+		}
+		if out, changed := stripPadding(packet); changed {
+			reparses("response without padding", out)
+		}
+		reparses("response fitted to the client", fitClientPayload(packet, packed))
+	})
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnsname"
+)
+
+/*
+*	A range of addresses named by a template such as host-{ip-dashed}.lab.home. The names are never listed, a query
+*	is matched against the template and the address parsed back out of it, and a reverse query the other way round
+ */
+type generatedRange struct {
+	network     *net.IPNet
+	prefix      string
+	suffix      string
+	placeholder string
+	ttl         uint32
+	forward     bool
+	reverse     bool
+}
+
+type generatedRanges []generatedRange
+
+func newGeneratedRanges(ranges []config.GeneratedRange) generatedRanges {
+	var out generatedRanges
+	for _, g := range ranges {
+		_, network, err := net.ParseCIDR(g.CIDR)
+		if err != nil {
+			continue
+		}
+		placeholder := config.GENERATED_IP_DASHED
+		if !strings.Contains(g.Template, placeholder) {
+			placeholder = config.GENERATED_LAST_OCTET
+		}
+		parts := strings.SplitN(g.Template, placeholder, 2)
+		out = append(out, generatedRange{network: network, prefix: parts[0], suffix: parts[1], placeholder: placeholder,
+			ttl: *g.TTL, forward: g.Direction != "reverse", reverse: g.Direction != "forward"})
+	}
+	return out
+}
+
+/*
+*	Returns the address a generated name stands for, the first range that names it wins
+ */
+func (g generatedRanges) Forward(name string) (net.IP, uint32, bool) {
+	name = dnsname.Key(name)
+	for k := range g {
+		r := &g[k]
+		if !r.forward || !strings.HasPrefix(name, r.prefix) || !strings.HasSuffix(name, r.suffix) || len(name) <= len(r.prefix)+len(r.suffix) {
+			continue
+		}
+		if ip := r.parse(name[len(r.prefix) : len(name)-len(r.suffix)]); ip != nil {
+			return ip, r.ttl, true
+		}
+	}
+	return nil, 0, false
+}
+
+/*
+*	Returns the generated name for the address of a reverse name, the first range containing the address wins
+ */
+func (g generatedRanges) Reverse(name string) (string, uint32, bool) {
+	if len(g) == 0 {
+		return "", 0, false
+	}
+	ip := reverseAddress(dnsname.Key(name))
+	if ip == nil {
+		return "", 0, false
+	}
+	for k := range g {
+		r := &g[k]
+		if r.reverse && r.named(ip) {
+			return r.prefix + r.render(ip) + r.suffix, r.ttl, true
+		}
+	}
+	return "", 0, false
+}
+
+// IPv4 ranges larger than a /31 leave out their network and broadcast addresses
+func (r *generatedRange) named(ip net.IP) bool {
+	if !r.network.Contains(ip) {
+		return false
+	}
+	ones, bits := r.network.Mask.Size()
+	if bits != 32 || ones >= 31 {
+		return true
+	}
+	v4 := ip.To4()
+	broadcast := make(net.IP, 4)
+	for i := range v4 {
+		broadcast[i] = r.network.IP[i] | ^r.network.Mask[i]
+	}
+	return !v4.Equal(r.network.IP) && !v4.Equal(broadcast)
+}
+
+func (r *generatedRange) render(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		if r.placeholder == config.GENERATED_LAST_OCTET {
+			return strconv.Itoa(int(v4[3]))
+		}
+		return fmt.Sprintf("%d-%d-%d-%d", v4[0], v4[1], v4[2], v4[3])
+	}
+	groups := make([]string, 8)
+	for i := range groups {
+		groups[i] = strconv.FormatUint(uint64(ip[2*i])<<8|uint64(ip[2*i+1]), 16)
+	}
+	return strings.Join(groups, "-")
+}
+
+/*
+*	Parses the part of a name the placeholder stood for, only the exact rendering of an address in the range is
+*	accepted so every address has one name
+ */
+func (r *generatedRange) parse(token string) net.IP {
+	var ip net.IP
+	switch {
+	case r.placeholder == config.GENERATED_LAST_OCTET:
+		octet, err := strconv.ParseUint(token, 10, 8)
+		if err != nil {
+			return nil
+		}
+		ip = append(net.IP{}, r.network.IP.To4()...)
+		ip[3] = byte(octet)
+	case r.network.IP.To4() != nil:
+		ip = net.ParseIP(strings.Replace(token, "-", ".", -1)).To4()
+	default:
+		groups := strings.Split(token, "-")
+		if len(groups) != 8 {
+			return nil
+		}
+		ip = make(net.IP, net.IPv6len)
+		for i, group := range groups {
+			v, err := strconv.ParseUint(group, 16, 16)
+			if err != nil {
+				return nil
+			}
+			ip[2*i], ip[2*i+1] = byte(v>>8), byte(v)
+		}
+	}
+	if ip == nil || !r.named(ip) || r.render(ip) != token {
+		return nil
+	}
+	return ip
+}
+
+/*
+*	Parses an in-addr.arpa. or ip6.arpa. name of a full address back into the address, nil for anything else
+ */
+func reverseAddress(name string) net.IP {
+	switch {
+	case strings.HasSuffix(name, ".in-addr.arpa."):
+		labels := strings.Split(strings.TrimSuffix(name, ".in-addr.arpa."), ".")
+		if len(labels) != 4 {
+			return nil
+		}
+		ip := make(net.IP, 4)
+		for i, label := range labels {
+			v, err := strconv.ParseUint(label, 10, 8)
+			if err != nil || strconv.Itoa(int(v)) != label {
+				return nil
+			}
+			ip[3-i] = byte(v)
+		}
+		return ip
+	case strings.HasSuffix(name, ".ip6.arpa."):
+		labels := strings.Split(strings.TrimSuffix(name, ".ip6.arpa."), ".")
+		if len(labels) != 32 {
+			return nil
+		}
+		ip := make(net.IP, net.IPv6len)
+		for i, label := range labels {
+			v, err := strconv.ParseUint(label, 16, 4)
+			if err != nil || len(label) != 1 {
+				return nil
+			}
+			ip[15-i/2] |= byte(v) << (4 * uint(i%2))
+		}
+		return ip
+	}
+	return nil
+}
@@ -1,31 +1,33 @@
 package service
 
 import (
-	"crypto/md5"
-	"encoding/hex"
-	"sort"
-
+	"github.com/TasSM/labns/internal/dnsname"
 	"golang.org/x/net/dns/dnsmessage"
 )
 
+/*
+*	Returns the key local records are stored under and queries are looked up by, built from the first question
+*	alone so the rest of the message is never unpacked
+ */
 func HashMessageFields(msgSerial *[]byte) (string, error) {
-	hf := md5.New()
-	defer hf.Reset()
-	var m dnsmessage.Message
-	var arr []string
-	var sortedString = ""
-	err := m.Unpack(*msgSerial)
-	if err != nil {
+	var p dnsmessage.Parser
+	if _, err := p.Start(*msgSerial); err != nil {
 		return "", err
 	}
-	for _, v := range m.Questions {
-		arr = append(arr, v.Name.String())
-		arr = append(arr, v.Type.String())
-	}
-	sort.Strings(arr)
-	for _, v := range arr {
-		sortedString += v
+	q, err := p.Question()
+	if err != nil {
+		return "", err
 	}
-	hf.Write([]byte(sortedString))
-	return string([]byte(hex.EncodeToString(hf.Sum(nil))[15:31])), nil
+	return recordKey(q.Name.String(), q.Type, q.Class), nil
+}
+
+/*
+*	Returns the local record key for a name and type without an incoming message to read it from
+ */
+func questionKey(name string, qtype dnsmessage.Type) string {
+	return recordKey(name, qtype, dnsmessage.ClassINET)
+}
+
+func recordKey(name string, qtype dnsmessage.Type, class dnsmessage.Class) string {
+	return dnsname.Key(name) + "/" + qtype.String() + "/" + class.String()
 }
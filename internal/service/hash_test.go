@@ -0,0 +1,66 @@
+package service
+
+import (
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestMessageKey(t *testing.T) {
+	key := func(name string, qtype dnsmessage.Type, class dnsmessage.Class, header dnsmessage.Header) string {
+		t.Helper()
+		m := dnsmessage.Message{Header: header, Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: qtype, Class: class}}}
+		packet, err := m.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := HashMessageFields(&packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	base := key("nas.lab.home.", dnsmessage.TypeA, dnsmessage.ClassINET, dnsmessage.Header{ID: 1})
+	if base != questionKey("nas.lab.home.", dnsmessage.TypeA) {
+		t.Fatalf("key of a query %q differs from questionKey %q", base, questionKey("nas.lab.home.", dnsmessage.TypeA))
+	}
+	if got := key("NaS.Lab.HOME.", dnsmessage.TypeA, dnsmessage.ClassINET, dnsmessage.Header{ID: 2, RecursionDesired: true}); got != base {
+		t.Errorf("key depends on the case, ID or flags: %q, want %q", got, base)
+	}
+	if got := questionKey("NAS.lab.home", dnsmessage.TypeA); got != base {
+		t.Errorf("questionKey of an unqualified mixed case name is %q, want %q", got, base)
+	}
+	for _, other := range []string{
+		key("nas.lab.home.", dnsmessage.TypeAAAA, dnsmessage.ClassINET, dnsmessage.Header{}),
+		key("nas.lab.home.", dnsmessage.TypeA, dnsmessage.ClassCHAOS, dnsmessage.Header{}),
+		key("nas2.lab.home.", dnsmessage.TypeA, dnsmessage.ClassINET, dnsmessage.Header{}),
+	} {
+		if other == base {
+			t.Errorf("a different type, class or name shares the key %q", base)
+		}
+	}
+
+	empty, err := (&dnsmessage.Message{Header: dnsmessage.Header{ID: 3}}).Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := HashMessageFields(&empty); err == nil {
+		t.Error("a message without a question has a key")
+	}
+}
+
+/*
+*	Local records are stored under the key of the message built for them, so every lookup path must find them
+ */
+func TestLocalRecordsAreFoundByQuestionKey(t *testing.T) {
+	conf := testConfig(t)
+	records, err := CreateLocalRecords(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range conf.LocalRecords {
+		if records[questionKey(r.Name, r.QueryType())] == nil {
+			t.Errorf("local record %s %s is not found by questionKey", r.Name, r.Type)
+		}
+	}
+}
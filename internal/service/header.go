@@ -0,0 +1,79 @@
+package service
+
+import "golang.org/x/net/dns/dnsmessage"
+
+const (
+	flagAuthoritative      = 0x0400
+	flagTruncated          = 0x0200
+	flagRecursionDesired   = 0x0100
+	flagRecursionAvailable = 0x0080
+)
+
+/*
+*	Every response path builds its header here: RD is copied from the query, RA is always set as labns
+*	recurses via its upstreams and AA is only set for answers from local data
+ */
+func ResponseHeader(query dnsmessage.Header, rcode dnsmessage.RCode, authoritative bool) dnsmessage.Header {
+	return dnsmessage.Header{
+		ID:                 query.ID,
+		Response:           true,
+		OpCode:             query.OpCode,
+		Authoritative:      authoritative,
+		RecursionDesired:   query.RecursionDesired,
+		RecursionAvailable: true,
+		RCode:              rcode,
+	}
+}
+
+/*
+*	Applies the ResponseHeader flag rules to a relayed upstream response in place, leaving its rcode intact
+ */
+func SetForwardedFlags(packet []byte, recursionDesired bool) {
+	if len(packet) < 12 {
+		return
+	}
+	flags := uint16(packet[2])<<8 | uint16(packet[3])
+	flags &^= flagAuthoritative | flagRecursionDesired
+	flags |= flagRecursionAvailable
+	if recursionDesired {
+		flags |= flagRecursionDesired
+	}
+	packet[2], packet[3] = byte(flags>>8), byte(flags)
+}
+
+func isTruncated(packet []byte) bool {
+	return len(packet) >= 12 && (uint16(packet[2])<<8|uint16(packet[3]))&flagTruncated != 0
+}
+
+/*
+*	Replaces a response larger than the client can receive over UDP with an empty one that has TC set, so the client
+*	asks again over TCP rather than getting a packet cut short. The limit is the payload size in the query's OPT
+*	record, or 512 for a query without one
+ */
+func fitClientPayload(res []byte, query []byte) []byte {
+	if len(res) <= 512 || len(res) <= clientPayloadSize(query) {
+		return res
+	}
+	b, err := newResponseBuilder(query)
+	if err != nil {
+		return res
+	}
+	truncated, err := b.Truncated((uint16(res[2])<<8|uint16(res[3]))&flagAuthoritative != 0)
+	if err != nil {
+		return res
+	}
+	return truncated
+}
+
+func clientPayloadSize(query []byte) int {
+	var m dnsmessage.Message
+	if err := m.Unpack(query); err != nil {
+		return 512
+	}
+	for _, r := range m.Additionals {
+		if r.Header.Type == dnsmessage.TypeOPT && int(r.Header.Class) > 512 {
+			return int(r.Header.Class)
+		}
+	}
+	return 512
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const flagZ = 0x0040
+
+/*
+*	Packs a query for questions and sets the raw header flags, which dnsmessage can't express for the Z bit
+ */
+func rawQuery(t *testing.T, id uint16, flags uint16, questions ...dnsmessage.Question) []byte {
+	t.Helper()
+	m := dnsmessage.Message{Header: dnsmessage.Header{ID: id}, Questions: questions}
+	packet, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet[2], packet[3] = byte(flags>>8), byte(flags)
+	return packet
+}
+
+func question(name string, qtype dnsmessage.Type) dnsmessage.Question {
+	return dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: qtype, Class: dnsmessage.ClassINET}
+}
+
+func headerFlags(packet []byte) uint16 {
+	return uint16(packet[2])<<8 | uint16(packet[3])
+}
+
+/*
+*	Sends packet to addr and returns the answer, nil when none arrives within wait
+ */
+func sendExpecting(t *testing.T, addr string, packet []byte, wait time.Duration) []byte {
+	t.Helper()
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(wait))
+	if _, err := conn.Write(packet); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil
+	}
+	return buf[:n]
+}
+
+func unpack(t *testing.T, packet []byte) dnsmessage.Message {
+	t.Helper()
+	var m dnsmessage.Message
+	if err := m.Unpack(packet); err != nil {
+		t.Fatalf("response does not parse: %v", err)
+	}
+	return m
+}
+
+func TestResponseSentToQueryPortIsDropped(t *testing.T) {
+	packet := rawQuery(t, 0x1201, 0x8180, question("nas.lab.home.", dnsmessage.TypeA))
+	if res := sendExpecting(t, listenAddr, packet, 300*time.Millisecond); res != nil {
+		t.Fatalf("a response packet from a client was answered with %d bytes, want it dropped", len(res))
+	}
+	// the service still answers the client afterwards
+	if res := lookup(t, "nas.lab.home.", dnsmessage.TypeA, 0); len(res.Answers) != 1 {
+		t.Fatalf("query after the dropped response has %d answers, want 1", len(res.Answers))
+	}
+}
+
+func TestQueryWithoutQuestionIsFormerr(t *testing.T) {
+	res := unpack(t, exchange(t, rawQuery(t, 0x1202, 0x0100)))
+	if res.Header.RCode != dnsmessage.RCodeFormatError || res.Header.ID != 0x1202 || !res.Header.Response {
+		t.Fatalf("query without a question answered %s with ID %#x, want a FORMERR response with ID 0x1202", res.Header.RCode, res.Header.ID)
+	}
+}
+
+func TestOnlyTheFirstQuestionIsAnswered(t *testing.T) {
+	packet := rawQuery(t, 0x1203, 0x0100, question("nas.lab.home.", dnsmessage.TypeA), question("printer.lab.home.", dnsmessage.TypeA))
+	res := unpack(t, exchange(t, packet))
+	if res.Header.RCode != dnsmessage.RCodeSuccess || len(res.Questions) != 1 || res.Questions[0].Name.String() != "nas.lab.home." {
+		t.Fatalf("query with two questions answered %s for %v, want NOERROR for nas.lab.home. alone", res.Header.RCode, res.Questions)
+	}
+	if answerAddress(t, res) != "192.168.1.10" {
+		t.Fatalf("query with two questions got answers %v, want the nas.lab.home. record", res.Answers)
+	}
+}
+
+/*
+*	MultipleQuestions is read when a listener starts, so this runs a listener of its own. Queries that pass the
+*	question checks never reach the state worker behind it
+ */
+func TestMultipleQuestionsFormerr(t *testing.T) {
+	conf := testConfig(t)
+	conf.MultipleQuestions = "formerr"
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go serveListener(conn, make(chan StateOperation, 1), conf, false)
+
+	packet := rawQuery(t, 0x1204, 0x0100, question("nas.lab.home.", dnsmessage.TypeA), question("printer.lab.home.", dnsmessage.TypeA))
+	raw := sendExpecting(t, conn.LocalAddr().String(), packet, exchangeTimeout)
+	if raw == nil {
+		t.Fatal("no answer to a query with two questions")
+	}
+	if res := unpack(t, raw); res.Header.RCode != dnsmessage.RCodeFormatError || len(res.Answers) != 0 {
+		t.Fatalf("query with two questions answered %s with %d answers, want FORMERR", res.Header.RCode, len(res.Answers))
+	}
+}
+
+func TestReservedBitIsCleared(t *testing.T) {
+	conf := testConfig(t)
+	up := newUpstream(t)
+	up.Handle("host.zbit.test.", dnsmessage.TypeA, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.A("host.zbit.test.", 60, "192.0.2.40")}})
+	forwardTo(conf, "zbit.test.", up)
+	reload(t, conf)
+
+	for _, name := range []string{"nas.lab.home.", "host.zbit.test."} {
+		res := exchange(t, rawQuery(t, 0x1205, 0x0100|flagZ, question(name, dnsmessage.TypeA)))
+		if headerFlags(res)&flagZ != 0 {
+			t.Errorf("response for %s echoes the Z bit, flags %#04x", name, headerFlags(res))
+		}
+		if m := unpack(t, res); m.Header.RCode != dnsmessage.RCodeSuccess || len(m.Answers) != 1 {
+			t.Errorf("query for %s with the Z bit set answered %s with %d answers, want NOERROR with 1", name, m.Header.RCode, len(m.Answers))
+		}
+	}
+	queries := up.Queries()
+	if len(queries) != 1 {
+		t.Fatalf("upstream received %d queries, want 1", len(queries))
+	}
+	if headerFlags(queries[0].Raw)&flagZ != 0 {
+		t.Fatalf("forwarded query carries the Z bit, flags %#04x", headerFlags(queries[0].Raw))
+	}
+}
+
+func TestResponseHeaderFlags(t *testing.T) {
+	for _, rd := range []bool{true, false} {
+		query := dnsmessage.Header{ID: 9, OpCode: 0, RecursionDesired: rd, Authoritative: true, Truncated: true}
+		h := ResponseHeader(query, dnsmessage.RCodeNameError, false)
+		if !h.Response || h.ID != 9 || h.RecursionDesired != rd || !h.RecursionAvailable || h.Authoritative || h.Truncated || h.RCode != dnsmessage.RCodeNameError {
+			t.Errorf("ResponseHeader for a query with RD %t is %+v", rd, h)
+		}
+		if h := ResponseHeader(query, dnsmessage.RCodeSuccess, true); !h.Authoritative {
+			t.Errorf("ResponseHeader for a local answer does not set AA")
+		}
+	}
+}
+
+/*
+*	RD is copied from the query and RA is set on every path, AA only on answers from local data
+ */
+func TestHeaderFlagsPerPath(t *testing.T) {
+	conf := testConfig(t)
+	up := newUpstream(t)
+	// an authoritative upstream, its AA must not reach the client
+	up.Handle("host.flags.test.", dnsmessage.TypeA, dnstest.Response{Authoritative: true, Answers: []dnsmessage.Resource{dnstest.A("host.flags.test.", 60, "192.0.2.50")}})
+	forwardTo(conf, "flags.test.", up)
+	reload(t, conf)
+
+	cases := []struct {
+		path          string
+		packet        []byte
+		rcode         dnsmessage.RCode
+		authoritative bool
+	}{
+		{"local", rawQuery(t, 0x1301, 0x0100, question("nas.lab.home.", dnsmessage.TypeA)), dnsmessage.RCodeSuccess, true},
+		{"local without RD", rawQuery(t, 0x1302, 0x0000, question("nas.lab.home.", dnsmessage.TypeA)), dnsmessage.RCodeSuccess, true},
+		{"local zone NXDOMAIN", rawQuery(t, 0x1303, 0x0100, question("missing.lab.home.", dnsmessage.TypeA)), dnsmessage.RCodeNameError, true},
+		{"forwarded", rawQuery(t, 0x1304, 0x0100, question("host.flags.test.", dnsmessage.TypeA)), dnsmessage.RCodeSuccess, false},
+		{"cached", rawQuery(t, 0x1305, 0x0100, question("host.flags.test.", dnsmessage.TypeA)), dnsmessage.RCodeSuccess, false},
+		{"cached without RD", rawQuery(t, 0x1306, 0x0000, question("host.flags.test.", dnsmessage.TypeA)), dnsmessage.RCodeSuccess, false},
+		{"blocked", rawQuery(t, 0x1307, 0x0100, question("ads.example.net.", dnsmessage.TypeA)), dnsmessage.RCodeNameError, false},
+		{"error", rawQuery(t, 0x1308, 0x0100), dnsmessage.RCodeFormatError, false},
+		{"error without RD", rawQuery(t, 0x1309, 0x0000), dnsmessage.RCodeFormatError, false},
+	}
+	for _, c := range cases {
+		rd := headerFlags(c.packet)&flagRecursionDesired != 0
+		res := unpack(t, exchange(t, c.packet))
+		h := res.Header
+		if h.RCode != c.rcode || h.RecursionDesired != rd || !h.RecursionAvailable || h.Authoritative != c.authoritative {
+			t.Errorf("%s answer is %s with RD %t RA %t AA %t, want %s with RD %t RA true AA %t",
+				c.path, h.RCode, h.RecursionDesired, h.RecursionAvailable, h.Authoritative, c.rcode, rd, c.authoritative)
+		}
+	}
+	if got := len(up.Queries()); got != 1 {
+		t.Fatalf("upstream received %d queries, want the first forwarded one alone", got)
+	}
+}
+
+/*
+*	A query with RD clear for a local name is answered from local data even for a type only the upstream has
+ */
+func TestNonRecursiveQueryForLocalNameIsNotForwarded(t *testing.T) {
+	conf := testConfig(t)
+	conf.LocalRecords = append(conf.LocalRecords, config.LocalDNSRecord{Name: "host.norecurse.test.", Type: "A", TTL: 60, Target: "192.0.2.60"})
+	up := newUpstream(t)
+	up.Handle("host.norecurse.test.", dnsmessage.TypeAAAA, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.AAAA("host.norecurse.test.", 60, "2001:db8::60")}})
+	forwardTo(conf, "norecurse.test.", up)
+	reload(t, conf)
+
+	res := unpack(t, exchange(t, rawQuery(t, 0x1310, 0x0000, question("host.norecurse.test.", dnsmessage.TypeAAAA))))
+	if res.Header.RCode != dnsmessage.RCodeSuccess || len(res.Answers) != 0 || !res.Header.Authoritative || res.Header.RecursionDesired {
+		t.Fatalf("non-recursive query answered %s with %d answers, AA %t RD %t, want an authoritative NODATA without RD",
+			res.Header.RCode, len(res.Answers), res.Header.Authoritative, res.Header.RecursionDesired)
+	}
+	if got := len(up.Queries()); got != 0 {
+		t.Fatalf("non-recursive query for a local name reached the upstream %d times", got)
+	}
+
+	res = unpack(t, exchange(t, rawQuery(t, 0x1311, 0x0100, question("host.norecurse.test.", dnsmessage.TypeAAAA))))
+	if len(res.Answers) != 1 || res.Header.Authoritative {
+		t.Fatalf("recursive query got %d answers with AA %t, want the upstream answer without AA", len(res.Answers), res.Header.Authoritative)
+	}
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnsname"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	Names under the health suffix that monitoring can query, health. is answered from local code alone and
+*	upstream-health. reports the upstream health state. Nothing under the suffix is forwarded, even when disabled
+ */
+type healthNames struct {
+	enabled bool
+	suffix  string
+}
+
+func newHealthNames(conf *config.HealthRecords) healthNames {
+	return healthNames{enabled: !conf.Disabled, suffix: dnsname.Key(conf.Suffix)}
+}
+
+func (h healthNames) Contains(name string) bool {
+	key := dnsname.Key(name)
+	return key == h.suffix || strings.HasSuffix(key, "."+h.suffix)
+}
+
+/*
+*	Answers a query under the suffix, with TTL 0 so the status is never cached. Other query types for the health
+*	names get NODATA and any other name under the suffix NXDOMAIN
+ */
+func (h healthNames) Answer(query []byte, question dnsmessage.Question) ([]byte, string, error) {
+	key := dnsname.Key(question.Name.String())
+	var txt []string
+	var step string
+	switch {
+	case h.enabled && key == "health."+h.suffix:
+		txt = []string{"ok"}
+		step = "health name, answering ok"
+	case h.enabled && key == "upstream-health."+h.suffix:
+		_, _, upstreams := upstreamHealth.snapshot()
+		for _, u := range upstreams {
+			if u.LastAnswer == nil && u.LastFailure == nil {
+				txt = append(txt, u.Address+" unknown")
+			} else if u.Healthy {
+				txt = append(txt, u.Address+" healthy")
+			} else {
+				txt = append(txt, fmt.Sprintf("%s unhealthy failures=%d", u.Address, u.Failures))
+			}
+		}
+		step = "upstream health name, answering with the upstream status"
+	default:
+		res, err := BuildEmptyResponse(query, dnsmessage.RCodeNameError, true)
+		return res, "name under the health suffix, answering NXDOMAIN", err
+	}
+	b, err := newResponseBuilder(query)
+	if err != nil {
+		return nil, "", err
+	}
+	var answers []dnsmessage.Resource
+	if question.Type == dnsmessage.TypeTXT {
+		answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET},
+			Body:   &dnsmessage.TXTResource{TXT: txt},
+		}}
+	}
+	res, err := b.Answer(answers, true)
+	return res, step, err
+}
@@ -0,0 +1,26 @@
+package service
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/TasSM/labns/internal/history"
+	"github.com/TasSM/labns/internal/logging"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	Queues an answered query for the query history, names and clients follow the QueryLogPrivacy mode. effective is
+*	the rewritten name when a search domain was applied, pinned whether a TTLOverride set the answer's TTLs
+ */
+func recordHistory(client net.IP, name string, qtype dnsmessage.Type, rcode string, source string, effective string, pinned bool) {
+	if !history.Enabled() {
+		return
+	}
+	entry := history.Entry{Time: time.Now(), Client: logging.Client(client), Name: logging.Name(name), Type: strings.TrimPrefix(qtype.String(), "Type"), RCode: rcode, Source: source, TTLPinned: pinned}
+	if effective != "" {
+		entry.Effective = logging.Name(effective)
+	}
+	history.Record(entry)
+}
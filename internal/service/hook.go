@@ -0,0 +1,281 @@
+//go:build !nointegrations && !minimal
+// +build !nointegrations,!minimal
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnsname"
+	"github.com/TasSM/labns/internal/logging"
+	"github.com/TasSM/labns/internal/stats"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// hook programs running at once, queries beyond it are handled as if there were no hook
+	maxHookRuns = 64
+	// cached hook results, once full new results are only kept after expired ones are pruned
+	maxHookResults = 4096
+	maxHookOutput  = 64 * 1024
+)
+
+var (
+	responseHooks atomic.Value
+	hookLock      sync.Mutex
+	hookWarnings  = &logging.RateLimited{Interval: time.Minute}
+)
+
+/*
+*	Runs ResponseHook.Command for queries under its Domains. The program gets a HookQuery as JSON on stdin and
+*	prints a HookResult as JSON on stdout. It runs on its own goroutine so the listener and state worker never
+*	wait on it, and a failure, a timeout or an unknown action hands the query to normal handling
+ */
+type responseHook struct {
+	conf    config.ResponseHook
+	zones   localZones
+	all     bool
+	runs    *Semaphore
+	lock    sync.Mutex
+	results map[hookKey]cachedHookResult
+}
+
+type HookQuery struct {
+	Client string
+	Name   string
+	Type   string
+}
+
+type HookRecord struct {
+	Type   string
+	Target string
+	TTL    uint32
+}
+
+/*
+*	Action is pass to continue with normal handling, block to answer NXDOMAIN or answer to answer with Records,
+*	those of a type other than the question's or CNAME are left out
+ */
+type HookResult struct {
+	Action  string
+	Records []HookRecord `json:",omitempty"`
+}
+
+type hookKey struct {
+	client string
+	name   string
+	qtype  dnsmessage.Type
+}
+
+type cachedHookResult struct {
+	result  HookResult
+	expires time.Time
+}
+
+/*
+*	Applies the ResponseHook settings, the cached results are kept unless the settings changed
+ */
+func SetResponseHook(conf *config.ResponseHook) {
+	hookLock.Lock()
+	defer hookLock.Unlock()
+	current, _ := responseHooks.Load().(*responseHook)
+	if current != nil && reflect.DeepEqual(current.conf, *conf) {
+		return
+	}
+	if len(conf.Command) == 0 {
+		responseHooks.Store((*responseHook)(nil))
+		return
+	}
+	h := &responseHook{conf: *conf, zones: newLocalZones(conf.Domains), runs: NewSemaphore(maxHookRuns), results: make(map[hookKey]cachedHookResult)}
+	for _, d := range conf.Domains {
+		if d == "." {
+			h.all = true
+		}
+	}
+	responseHooks.Store(h)
+	logging.LogMessage(logging.LogInfo, fmt.Sprintf("Running response hook %s for %s", conf.Command[0], strings.Join(conf.Domains, ", ")))
+}
+
+/*
+*	Hands op to the hook when its name is covered, returning false when op should be queued as usual. The hook's
+*	result decides whether op is answered directly or queued once it is known
+ */
+func hookQuery(input chan StateOperation, op StateOperation) bool {
+	h, _ := responseHooks.Load().(*responseHook)
+	if h == nil || (!h.all && !h.zones.Contains(op.Question.Name.String())) {
+		return false
+	}
+	key := hookKey{client: op.RequestorAddr.IP.String(), name: dnsname.Key(op.Question.Name.String()), qtype: op.Question.Type}
+	if result, ok := h.cached(key); ok {
+		stats.Increment(stats.HookCached)
+		op.Trace.Step("cached response hook result %s", result.Action)
+		h.apply(input, op, result)
+		return true
+	}
+	if overloaded() || !h.runs.TryAcquire(1) {
+		stats.Increment(stats.HookSkipped)
+		op.Trace.Step("response hook busy, handling the query without it")
+		return false
+	}
+	spawn(routineHook, func() {
+		result, err := h.run(op)
+		if err != nil {
+			hookWarnings.LogMessage(logging.LogError, fmt.Sprintf("Response hook failed for %s, handling the query without it: %v", logging.Name(op.Question.Name.String()), err))
+			op.Trace.Step("response hook failed (%v), handling the query without it", err)
+			input <- op
+			return
+		}
+		h.store(key, result)
+		op.Trace.Step("response hook answered %s", result.Action)
+		h.apply(input, op, result)
+	})
+	return true
+}
+
+/*
+*	Runs the program for op. Its run slot is released once the program has exited and its output is closed, which
+*	may be after a timeout was already reported if a child process it started keeps the output open
+ */
+func (h *responseHook) run(op StateOperation) (HookResult, error) {
+	var result HookResult
+	stats.Increment(stats.HookRuns)
+	body, _ := json.Marshal(HookQuery{Client: op.RequestorAddr.IP.String(), Name: op.Question.Name.String(), Type: stats.QTypeBucket(op.Question.Type)})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(h.conf.TimeoutMs)*time.Millisecond)
+	cmd := exec.CommandContext(ctx, h.conf.Command[0], h.conf.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Run()
+		cancel()
+		h.runs.Release(1)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		stats.Increment(stats.HookTimedOut)
+		return result, errors.New(fmt.Sprintf("no result within %dms", h.conf.TimeoutMs))
+	}
+	if err != nil {
+		stats.Increment(stats.HookFailed)
+		return result, errors.New(fmt.Sprintf("%v: %s", err, bytes.TrimSpace(stderr.Bytes())))
+	}
+	if stdout.Len() > maxHookOutput {
+		stats.Increment(stats.HookFailed)
+		return result, errors.New(fmt.Sprintf("output is longer than %d bytes", maxHookOutput))
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		stats.Increment(stats.HookFailed)
+		return result, errors.New(fmt.Sprintf("output is not a valid result: %v", err))
+	}
+	result.Action = strings.ToLower(result.Action)
+	if result.Action != "pass" && result.Action != "block" && result.Action != "answer" {
+		stats.Increment(stats.HookFailed)
+		return result, errors.New(fmt.Sprintf("unknown action %q, should be pass, block or answer", result.Action))
+	}
+	return result, nil
+}
+
+/*
+*	Answers op as result says, or queues it for normal handling when the hook passes or its answer can't be built
+ */
+func (h *responseHook) apply(input chan StateOperation, op StateOperation, result HookResult) {
+	var res []byte
+	var err error
+	switch result.Action {
+	case "block":
+		stats.Increment(stats.HookBlocked)
+		logging.LogEvery(logging.LogInfo, "Response hook blocked "+logging.Name(op.Question.Name.String()))
+		res, err = BuildEmptyResponse(op.ByteData, dnsmessage.RCodeNameError, true)
+	case "answer":
+		stats.Increment(stats.HookAnswered)
+		res, err = buildHookAnswer(op.ByteData, op.Question, result.Records)
+	default:
+		input <- op
+		return
+	}
+	if err != nil {
+		stats.Increment(stats.HookFailed)
+		hookWarnings.LogMessage(logging.LogError, fmt.Sprintf("Unable to answer %s from the response hook, handling the query without it: %v", logging.Name(op.Question.Name.String()), err))
+		input <- op
+		return
+	}
+	op.Cancel()
+	op.respond(res, "hook")
+	observeLatency("hook", op.Question.Type, op.Received)
+}
+
+/*
+*	Answers with the records of the question's type and CNAMEs, NODATA when there are none
+ */
+func buildHookAnswer(query []byte, question dnsmessage.Question, records []HookRecord) ([]byte, error) {
+	var answer []byte
+	for _, r := range records {
+		record := config.LocalDNSRecord{Name: question.Name.String(), Type: strings.ToUpper(r.Type), Target: r.Target, TTL: r.TTL}
+		if record.Type != "A" && record.Type != "AAAA" && record.Type != "CNAME" {
+			return nil, errors.New(fmt.Sprintf("record type %s is not supported, should be A, AAAA or CNAME", r.Type))
+		}
+		if record.Type != "CNAME" && record.QueryType() != question.Type {
+			continue
+		}
+		msg, err := BuildDNSMessage(&record)
+		if err != nil {
+			return nil, err
+		}
+		if answer == nil {
+			answer = msg
+		} else if answer, err = mergeAnswers(answer, msg); err != nil {
+			return nil, err
+		}
+	}
+	if answer == nil {
+		return BuildEmptyResponse(query, dnsmessage.RCodeSuccess, true)
+	}
+	return BuildLocalResponse(query, answer)
+}
+
+func (h *responseHook) cached(key hookKey) (HookResult, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	c, ok := h.results[key]
+	if !ok || time.Now().After(c.expires) {
+		return HookResult{}, false
+	}
+	return c.result, true
+}
+
+func (h *responseHook) store(key hookKey, result HookResult) {
+	if h.conf.CacheSeconds == 0 {
+		return
+	}
+	now := time.Now()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.results) >= maxHookResults {
+		for k, c := range h.results {
+			if now.After(c.expires) {
+				delete(h.results, k)
+			}
+		}
+		if len(h.results) >= maxHookResults {
+			return
+		}
+	}
+	h.results[key] = cachedHookResult{result: result, expires: now.Add(time.Duration(h.conf.CacheSeconds) * time.Second)}
+}
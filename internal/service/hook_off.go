@@ -0,0 +1,20 @@
+//go:build nointegrations || minimal
+// +build nointegrations minimal
+
+package service
+
+import (
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/feature"
+)
+
+func init() {
+	feature.Omit("integrations")
+}
+
+// the response hook was left out of this build, configurations setting it are rejected
+func SetResponseHook(conf *config.ResponseHook) {}
+
+func hookQuery(input chan StateOperation, op StateOperation) bool {
+	return false
+}
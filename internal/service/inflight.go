@@ -0,0 +1,93 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TasSM/labns/internal/logging"
+)
+
+var inflightWarnings = &logging.RateLimited{Interval: 10 * time.Second}
+
+/*
+*	Forwarded queries per client that have not been answered yet. Clients are removed as soon as their last query
+*	finishes, so the map only ever holds clients with queries outstanding. Updated by the state worker and read by the
+*	stats dump, so it is guarded by a mutex
+ */
+type inflightClients struct {
+	lock   sync.Mutex
+	max    int
+	counts map[string]int
+}
+
+func newInflightClients(max uint32) *inflightClients {
+	return &inflightClients{max: int(max), counts: make(map[string]int)}
+}
+
+var clientsInflight = newInflightClients(0)
+
+func (c *inflightClients) SetMax(max uint32) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.max = int(max)
+}
+
+/*
+*	Counts a new query for ip, returns false without counting it when the client is already at the limit
+ */
+func (c *inflightClients) Acquire(ip net.IP) bool {
+	key := ip.String()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.counts[key] >= c.max {
+		return false
+	}
+	c.counts[key]++
+	return true
+}
+
+func (c *inflightClients) Release(ip net.IP) {
+	key := ip.String()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.counts[key] <= 1 {
+		delete(c.counts, key)
+		return
+	}
+	c.counts[key]--
+}
+
+/*
+*	Renders the n clients with the most outstanding queries as a log line
+ */
+func DumpInflight(n int) string {
+	c := clientsInflight
+	c.lock.Lock()
+	type entry struct {
+		client string
+		count  int
+	}
+	entries := make([]entry, 0, len(c.counts))
+	for k, v := range c.counts {
+		entries = append(entries, entry{k, v})
+	}
+	c.lock.Unlock()
+	sort.Slice(entries, func(a, b int) bool {
+		if entries[a].count != entries[b].count {
+			return entries[a].count > entries[b].count
+		}
+		return entries[a].client < entries[b].client
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	parts := make([]string, 0, len(entries))
+	for _, e := range entries {
+		parts = append(parts, fmt.Sprintf("%s=%d", logging.Client(net.ParseIP(e.client)), e.count))
+	}
+	return "inflight clients: " + strings.Join(parts, " ")
+}
@@ -0,0 +1,41 @@
+package service
+
+import "sync"
+
+/*
+*	Non-blocking weighted semaphore, callers that fail to acquire are expected to shed the work
+ */
+type Semaphore struct {
+	lock sync.Mutex
+	size int64
+	cur  int64
+}
+
+func NewSemaphore(size int64) *Semaphore {
+	return &Semaphore{size: size}
+}
+
+func (s *Semaphore) TryAcquire(n int64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.cur+n > s.size {
+		return false
+	}
+	s.cur += n
+	return true
+}
+
+func (s *Semaphore) Release(n int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cur -= n
+	if s.cur < 0 {
+		s.cur = 0
+	}
+}
+
+func (s *Semaphore) InUse() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.cur
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TasSM/labns/internal/dnstest"
+	"github.com/TasSM/labns/internal/stats"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestSemaphore(t *testing.T) {
+	s := NewSemaphore(3)
+	if !s.TryAcquire(2) || !s.TryAcquire(1) {
+		t.Fatal("acquiring up to the size failed")
+	}
+	if s.TryAcquire(1) {
+		t.Fatal("acquired past the size")
+	}
+	s.Release(2)
+	if s.InUse() != 1 || !s.TryAcquire(2) {
+		t.Fatalf("after releasing 2, %d in use and acquiring 2 more failed", s.InUse())
+	}
+	s.Release(10)
+	if s.InUse() != 0 {
+		t.Fatalf("releasing more than held left %d in use, want 0", s.InUse())
+	}
+}
+
+/*
+*	Floods an upstream that never answers with more queries than MaxConcurrentUpstreamQueries (100 in the corpus
+*	configuration). The queries past the limit are answered SERVFAIL right away instead of waiting on the upstream.
+*	They come from two clients so the per-client limit is not what rejects them
+ */
+func TestUpstreamLimitAnswersServfailUnderFlood(t *testing.T) {
+	conf := testConfig(t)
+	up := newUpstream(t)
+	up.Default(dnstest.Response{Drop: true})
+	forwardTo(conf, "flood.test.", up)
+	reload(t, conf)
+	limit := int(conf.MaxConcurrentUpstreamQueries)
+	if limit != 100 {
+		t.Fatalf("corpus configuration allows %d upstream queries, the test expects 100", limit)
+	}
+	rejectedBefore, baseline := stats.Get(stats.UpstreamRejected), runtime.NumGoroutine()
+
+	var conns []net.Conn
+	for _, source := range []string{"127.0.0.2", "127.0.0.3"} {
+		conn, err := net.DialUDP("udp", &net.UDPAddr{IP: net.ParseIP(source)}, mustResolveUDP(t, listenAddr))
+		if err != nil {
+			t.Skipf("unable to send from %s: %v", source, err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	sent := limit + 20
+	start := time.Now()
+	for k := 1; k <= sent; k++ {
+		query, err := BuildQuery(fmt.Sprintf("host-%d.flood.test.", k), dnsmessage.TypeA, uint16(k))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conns[k%2].Write(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the rejected queries are answered long before the 1000ms query deadline answers the others
+	answers := make(chan dnsmessage.RCode, sent)
+	var readers sync.WaitGroup
+	for _, conn := range conns {
+		readers.Add(1)
+		go func(conn net.Conn) {
+			defer readers.Done()
+			conn.SetReadDeadline(start.Add(500 * time.Millisecond))
+			buf := make([]byte, 512)
+			for {
+				n, err := conn.Read(buf)
+				if err != nil {
+					return
+				}
+				var m dnsmessage.Message
+				if err := m.Unpack(buf[:n]); err != nil {
+					answers <- 0xfff
+					continue
+				}
+				answers <- m.Header.RCode
+			}
+		}(conn)
+	}
+	readers.Wait()
+	close(answers)
+	servfails := 0
+	for rcode := range answers {
+		if rcode != dnsmessage.RCodeServerFailure {
+			t.Fatalf("early answer is %s, want SERVFAIL", rcode)
+		}
+		servfails++
+	}
+	if servfails != sent-limit {
+		t.Fatalf("%d queries were answered SERVFAIL at once, want the %d past the limit", servfails, sent-limit)
+	}
+	if got := len(up.Queries()); got != limit {
+		t.Fatalf("upstream received %d queries, want %d", got, limit)
+	}
+	if got := stats.Get(stats.UpstreamRejected) - rejectedBefore; got != uint64(sent-limit) {
+		t.Fatalf("%s went up by %d, want %d", stats.UpstreamRejected, got, sent-limit)
+	}
+	if state := waitForIdle(baseline, 5*time.Second); state != "" {
+		t.Fatalf("flood left %s", state)
+	}
+}
+
+func mustResolveUDP(t *testing.T, addr string) *net.UDPAddr {
+	t.Helper()
+	a, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/TasSM/labns/internal/logging"
+	"github.com/TasSM/labns/internal/stats"
+)
+
+var (
+	ownPorts     map[int]bool
+	ownAddrs     []net.IP
+	loopWarnings = &logging.RateLimited{Interval: 10 * time.Second}
+)
+
+/*
+*	Records the ports of every socket labns sends upstream queries from, a query arriving from one of them
+*	on a local address was forwarded by labns to itself
+ */
+func recordOwnSources(socks []*net.UDPConn) {
+	ownPorts = make(map[int]bool)
+	for _, s := range socks {
+		ownPorts[s.LocalAddr().(*net.UDPAddr).Port] = true
+	}
+	ownAddrs = nil
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok {
+				ownAddrs = append(ownAddrs, ipNet.IP)
+			}
+		}
+	}
+}
+
+func isOwnSource(addr *net.UDPAddr) bool {
+	if !ownPorts[addr.Port] {
+		return false
+	}
+	if addr.IP.IsLoopback() {
+		return true
+	}
+	for _, ip := range ownAddrs {
+		if ip.Equal(addr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func warnLoop(name string) {
+	stats.Increment(stats.LoopDetected)
+	loopWarnings.LogMessage(logging.LogError, fmt.Sprintf("Query for %s arrived from labns' own upstream socket, an upstream is forwarding back to labns. Answering SERVFAIL", logging.Name(name)))
+}
@@ -14,6 +14,9 @@ import (
 func CreateLocalRecords(conf *config.Configuration) (map[string][]byte, error) {
 	out := make(map[string][]byte)
 	for _, v := range conf.LocalRecords {
+		if v.Type == "NULL" {
+			continue
+		}
 		msg, err := BuildDNSMessage(&v)
 		if err != nil {
 			return nil, err
@@ -22,11 +25,29 @@ func CreateLocalRecords(conf *config.Configuration) (map[string][]byte, error) {
 		if err != nil {
 			return nil, err
 		}
+		if prev := out[hash]; prev != nil {
+			// several records of the same name and type are served together as one answer set
+			if msg, err = mergeAnswers(prev, msg); err != nil {
+				return nil, err
+			}
+		}
 		out[hash] = msg
 	}
 	return out, nil
 }
 
+func mergeAnswers(first []byte, second []byte) ([]byte, error) {
+	var a, b dnsmessage.Message
+	if err := a.Unpack(first); err != nil {
+		return nil, err
+	}
+	if err := b.Unpack(second); err != nil {
+		return nil, err
+	}
+	a.Answers = append(a.Answers, b.Answers...)
+	return a.Pack()
+}
+
 func GetAddressFromResource(resource dnsmessage.Resource) string {
 	str := resource.Body.GoString()
 	res := ""
@@ -54,7 +75,7 @@ func BuildDNSMessage(record *config.LocalDNSRecord) ([]byte, error) {
 	builder := dnsmessage.NewBuilder(buf, dnsmessage.Header{Response: true})
 	builder.EnableCompression()
 	name, err := dnsmessage.NewName(record.Name)
-	recordType := config.RecordTypeMap[record.Type]
+	recordType := record.QueryType()
 	if recordType == 0 {
 		return nil, errors.New("local records question type was not set to a valid value")
 	}
@@ -102,6 +123,41 @@ func BuildDNSMessage(record *config.LocalDNSRecord) ([]byte, error) {
 		}
 		copy(ipv6[:], ip)
 		err = builder.AAAAResource(header, dnsmessage.AAAAResource{AAAA: ipv6})
+	case "RAW":
+		data, err := config.DecodeRData(record.RData)
+		if err != nil {
+			return nil, err
+		}
+		err = builder.UnknownResource(header, dnsmessage.UnknownResource{Type: recordType, Data: data})
+		if err != nil {
+			return nil, err
+		}
+	case "SSHFP", "TLSA", "HINFO":
+		var data []byte
+		switch record.Type {
+		case "SSHFP":
+			data, err = BuildSSHFPData(record.SSHFP)
+		case "TLSA":
+			data, err = BuildTLSAData(record.TLSA)
+		default:
+			data, err = BuildHINFOData(record.HINFO)
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = builder.UnknownResource(header, dnsmessage.UnknownResource{Type: recordType, Data: data})
+		if err != nil {
+			return nil, err
+		}
+	case "SVCB", "HTTPS":
+		data, err := BuildSvcbData(record.Target, record.Svc)
+		if err != nil {
+			return nil, err
+		}
+		err = builder.UnknownResource(header, dnsmessage.UnknownResource{Type: recordType, Data: data})
+		if err != nil {
+			return nil, err
+		}
 	}
 	if err != nil {
 		logging.LogMessage(logging.LogError, err.Error())
@@ -113,12 +169,54 @@ func BuildDNSMessage(record *config.LocalDNSRecord) ([]byte, error) {
 	return msg[2:], nil
 }
 
-func SetResponseId(serial []byte, Id uint16) ([]byte, error) {
-	var m dnsmessage.Message
-	err := m.Unpack(serial)
+/*
+*	Answers query with the records of a prebuilt local answer, echoing the question as the client sent it
+ */
+func BuildLocalResponse(query []byte, record []byte) ([]byte, error) {
+	var local dnsmessage.Message
+	if err := local.Unpack(record); err != nil {
+		return nil, err
+	}
+	b, err := newResponseBuilder(query)
+	if err != nil {
+		return nil, err
+	}
+	return b.Answer(local.Answers, true)
+}
+
+func BuildErrorResponse(query []byte, rcode dnsmessage.RCode) ([]byte, error) {
+	b, err := newResponseBuilder(query)
 	if err != nil {
 		return nil, err
 	}
-	m.ID = Id
+	return b.Error(rcode)
+}
+
+/*
+*	NXDOMAIN or NODATA without an SOA, other rcodes are answered as errors
+ */
+func BuildEmptyResponse(query []byte, rcode dnsmessage.RCode, authoritative bool) ([]byte, error) {
+	b, err := newResponseBuilder(query)
+	if err != nil {
+		return nil, err
+	}
+	switch rcode {
+	case dnsmessage.RCodeNameError:
+		return b.NXDomain(nil, authoritative)
+	case dnsmessage.RCodeSuccess:
+		return b.NoData(nil, authoritative)
+	}
+	return b.Error(rcode)
+}
+
+func BuildQuery(name string, qtype dnsmessage.Type, id uint16) ([]byte, error) {
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	m := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
 	return m.Pack()
 }
@@ -0,0 +1,277 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+	"github.com/TasSM/labns/internal/stats"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	mirrorQueueSize = 256
+	// how long the real and the mirrored answer of a query are kept waiting for each other
+	mirrorCompareWindow = 5 * time.Second
+)
+
+var (
+	mirroring      atomic.Value
+	mirrorLock     sync.Mutex
+	mirrorWarnings = &logging.RateLimited{Interval: time.Minute}
+)
+
+/*
+*	Copies of sampled client queries sent to MirrorTo. Listeners only queue a copy, a sender goroutine writes them
+*	and a reader goroutine reads the answers, so mirroring never holds up the real answer. A reload with a different
+*	configuration replaces the whole mirror and its socket
+ */
+type queryMirror struct {
+	conf    config.MirrorTo
+	conn    *net.UDPConn
+	zones   localZones
+	all     bool
+	queue   chan mirroredQuery
+	done    chan struct{}
+	lock    sync.Mutex
+	waiting map[uint16]*mirrorComparison
+	clients map[mirrorClient]uint16
+}
+
+type mirroredQuery struct {
+	id    uint16
+	query []byte
+}
+
+type mirrorClient struct {
+	addr string
+	id   uint16
+}
+
+/*
+*	The two answers to one mirrored query, compared once both are in
+ */
+type mirrorComparison struct {
+	client   mirrorClient
+	name     string
+	qtype    dnsmessage.Type
+	sent     time.Time
+	real     string
+	mirrored string
+}
+
+/*
+*	Starts mirroring as conf describes, stopping a mirror running with other settings. An empty Address stops it
+ */
+func SetMirror(conf *config.MirrorTo) {
+	mirrorLock.Lock()
+	defer mirrorLock.Unlock()
+	current, _ := mirroring.Load().(*queryMirror)
+	if current != nil && reflect.DeepEqual(current.conf, *conf) {
+		return
+	}
+	var next *queryMirror
+	if conf.Address != "" {
+		addr, err := net.ResolveUDPAddr("udp", conf.Address)
+		if err == nil {
+			var conn *net.UDPConn
+			if conn, err = net.DialUDP("udp", nil, addr); err == nil {
+				next = &queryMirror{conf: *conf, conn: conn, zones: newLocalZones(conf.Domains), queue: make(chan mirroredQuery, mirrorQueueSize),
+					done: make(chan struct{}), waiting: make(map[uint16]*mirrorComparison), clients: make(map[mirrorClient]uint16)}
+				for _, d := range conf.Domains {
+					if d == "." {
+						next.all = true
+					}
+				}
+			}
+		}
+		if err != nil {
+			logging.LogMessage(logging.LogError, fmt.Sprintf("Unable to mirror queries to %s: %v", conf.Address, err))
+		}
+	}
+	if next != nil {
+		mirroring.Store(next)
+		go next.send()
+		go next.receive()
+		logging.LogMessage(logging.LogInfo, fmt.Sprintf("Mirroring %g of client queries to %s", conf.SampleRate, conf.Address))
+	} else {
+		mirroring.Store((*queryMirror)(nil))
+	}
+	if current != nil {
+		close(current.done)
+		current.conn.Close()
+	}
+}
+
+/*
+*	Queues a copy of a client query for the mirror when its name is covered and the sample roll succeeds. A full
+*	queue drops the copy rather than wait
+ */
+func mirrorQuery(query []byte, question dnsmessage.Question, addr *net.UDPAddr, clientID uint16) {
+	m, _ := mirroring.Load().(*queryMirror)
+	if m == nil || (!m.all && !m.zones.Contains(question.Name.String())) || rand.Float64() >= m.conf.SampleRate {
+		return
+	}
+	client := mirrorClient{addr: addr.String(), id: clientID}
+	m.lock.Lock()
+	id := uint16(rand.Intn(65535) + 1)
+	for m.waiting[id] != nil {
+		id = uint16(rand.Intn(65535) + 1)
+	}
+	if m.conf.Compare {
+		delete(m.waiting, m.clients[client])
+		m.waiting[id] = &mirrorComparison{client: client, name: question.Name.String(), qtype: question.Type, sent: time.Now()}
+		m.clients[client] = id
+	}
+	m.lock.Unlock()
+	select {
+	case m.queue <- mirroredQuery{id: id, query: append([]byte{}, query...)}:
+	default:
+		stats.Increment(stats.MirrorDropped)
+		m.forget(id)
+	}
+}
+
+/*
+*	Hands the real answer to a client query to the mirror for comparison, a no-op unless Compare is set
+ */
+func mirrorAnswer(addr *net.UDPAddr, clientID uint16, res []byte) {
+	m, _ := mirroring.Load().(*queryMirror)
+	if m == nil || !m.conf.Compare || addr == nil {
+		return
+	}
+	client := mirrorClient{addr: addr.String(), id: clientID}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	id, ok := m.clients[client]
+	if !ok {
+		return
+	}
+	c := m.waiting[id]
+	c.real = answerSignature(res)
+	m.compare(id, c)
+}
+
+func (m *queryMirror) send() {
+	for {
+		select {
+		case <-m.done:
+			return
+		case q := <-m.queue:
+			q.query[0], q.query[1] = byte(q.id>>8), byte(q.id)
+			if _, err := m.conn.Write(q.query); err != nil {
+				mirrorWarnings.LogMessage(logging.LogError, fmt.Sprintf("Unable to send a mirrored query to %s: %v", m.conf.Address, err))
+				m.forget(q.id)
+				continue
+			}
+			stats.Increment(stats.MirrorSent)
+		}
+	}
+}
+
+/*
+*	Reads the mirror's answers until the mirror is replaced, giving up on comparisons older than
+*	mirrorCompareWindow along the way
+ */
+func (m *queryMirror) receive() {
+	buf := make([]byte, 65535)
+	for {
+		m.conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := m.conn.Read(buf)
+		select {
+		case <-m.done:
+			return
+		default:
+		}
+		m.expire()
+		if err != nil || n < 2 {
+			continue
+		}
+		stats.Increment(stats.MirrorAnswered)
+		id := uint16(buf[0])<<8 | uint16(buf[1])
+		m.lock.Lock()
+		if c := m.waiting[id]; c != nil {
+			c.mirrored = answerSignature(buf[:n])
+			m.compare(id, c)
+		}
+		m.lock.Unlock()
+	}
+}
+
+func (m *queryMirror) forget(id uint16) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if c := m.waiting[id]; c != nil {
+		delete(m.clients, c.client)
+		delete(m.waiting, id)
+	}
+}
+
+func (m *queryMirror) expire() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for id, c := range m.waiting {
+		if time.Since(c.sent) < mirrorCompareWindow {
+			continue
+		}
+		if c.real != "" && c.mirrored == "" {
+			stats.Increment(stats.MirrorUnanswered)
+		}
+		delete(m.clients, c.client)
+		delete(m.waiting, id)
+	}
+}
+
+/*
+*	Compares the answers once both arrived and forgets the query, called with the lock held
+ */
+func (m *queryMirror) compare(id uint16, c *mirrorComparison) {
+	if c.real == "" || c.mirrored == "" {
+		return
+	}
+	delete(m.clients, c.client)
+	delete(m.waiting, id)
+	if c.real == c.mirrored {
+		stats.Increment(stats.MirrorMatched)
+		return
+	}
+	stats.Increment(stats.MirrorMismatched)
+	logging.LogMessage(logging.LogInfo, fmt.Sprintf("Mirror %s answered %s %s differently: real %s, mirror %s",
+		m.conf.Address, logging.Name(c.name), stats.QTypeBucket(c.qtype), c.real, c.mirrored))
+}
+
+/*
+*	Sums up the rcode and answer records of a response in a form that is equal for equivalent answers, TTLs and
+*	record order are left out
+ */
+func answerSignature(res []byte) string {
+	var m dnsmessage.Message
+	if err := m.Unpack(res); err != nil {
+		return "unparseable"
+	}
+	answers := make([]string, 0, len(m.Answers))
+	for _, r := range m.Answers {
+		var data string
+		switch body := r.Body.(type) {
+		case *dnsmessage.AResource:
+			data = net.IP(body.A[:]).String()
+		case *dnsmessage.AAAAResource:
+			data = net.IP(body.AAAA[:]).String()
+		case *dnsmessage.CNAMEResource:
+			data = strings.ToLower(body.CNAME.String())
+		default:
+			data = r.Body.GoString()
+		}
+		answers = append(answers, strings.TrimPrefix(r.Header.Type.String(), "Type")+" "+data)
+	}
+	sort.Strings(answers)
+	return stats.RCodeBucket(m.Header.RCode) + " [" + strings.Join(answers, ", ") + "]"
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+	"github.com/TasSM/labns/internal/stats"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	offlineProbeInterval = 10 * time.Second
+	offlineProbeTimeout  = 2 * time.Second
+)
+
+var (
+	offlineSettings atomic.Value
+	// why labns is offline: "config", "manual" or "auto", empty while online
+	offlineReason atomic.Value
+	offlineLock   sync.Mutex
+)
+
+/*
+*	Applies the Offline settings, going offline when Enabled is set and back online when the configuration was
+*	the reason for being offline and no longer asks for it
+ */
+func SetOffline(o *config.Offline) {
+	offlineSettings.Store(o)
+	if o.Enabled {
+		setOffline("config")
+	} else if OfflineMode() == "config" {
+		setOffline("")
+	}
+}
+
+/*
+*	Turns offline mode on or off from the admin API or a signal, until the next reload or automatic change
+ */
+func SetOfflineEnabled(enabled bool) {
+	if enabled {
+		setOffline("manual")
+	} else {
+		setOffline("")
+	}
+}
+
+/*
+*	Returns why labns is offline, or an empty string while it forwards queries
+ */
+func OfflineMode() string {
+	reason, _ := offlineReason.Load().(string)
+	return reason
+}
+
+func offlineActive() bool {
+	return OfflineMode() != ""
+}
+
+func setOffline(reason string) {
+	offlineLock.Lock()
+	defer offlineLock.Unlock()
+	previous := OfflineMode()
+	if (previous == "") == (reason == "") {
+		if reason != "" {
+			offlineReason.Store(reason)
+		}
+		return
+	}
+	offlineReason.Store(reason)
+	if reason == "" {
+		stats.Set(stats.Offline, 0)
+		logging.LogMessage(logging.LogInfo, fmt.Sprintf("Offline mode off (was %s), forwarding queries upstream again", previous))
+		return
+	}
+	stats.Set(stats.Offline, 1)
+	logging.LogMessage(logging.LogError, fmt.Sprintf("Offline mode on (%s): no upstream queries are sent, answering from local records and the cache only", reason))
+}
+
+/*
+*	Goes offline once every upstream has been unhealthy for AutoAfterSeconds. While offline for that reason an
+*	upstream is probed every offlineProbeInterval and the first answer brings labns back online
+ */
+func watchOffline(m *healthMonitor) {
+	var lastProbe time.Time
+	for {
+		<-serviceClock.After(alertCheckInterval)
+		o, _ := offlineSettings.Load().(*config.Offline)
+		if o == nil || o.AutoAfterSeconds == 0 {
+			continue
+		}
+		switch OfflineMode() {
+		case "":
+			down, since, _ := m.snapshot()
+			if down && serviceClock.Now().Sub(since) >= time.Duration(o.AutoAfterSeconds)*time.Second {
+				setOffline("auto")
+				lastProbe = serviceClock.Now()
+			}
+		case "auto":
+			if serviceClock.Now().Sub(lastProbe) < offlineProbeInterval {
+				continue
+			}
+			lastProbe = serviceClock.Now()
+			if key, ok := probeUpstreams(m); ok {
+				m.answered(key, serviceClock.Now())
+				logging.LogMessage(logging.LogInfo, fmt.Sprintf("Upstream %s answered a probe", key))
+				setOffline("")
+			}
+		}
+	}
+}
+
+/*
+*	Asks each default upstream for the root NS records, any response counts as the upstream being back
+ */
+func probeUpstreams(m *healthMonitor) (string, bool) {
+	m.lock.Lock()
+	nameservers := append([]config.Nameserver{}, m.nameservers...)
+	m.lock.Unlock()
+	for k := range nameservers {
+		query, err := BuildQuery(".", dnsmessage.TypeNS, uint16(rand.Intn(65536)))
+		if err != nil {
+			return "", false
+		}
+		t, err := transportFor(&nameservers[k])
+		if err != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), offlineProbeTimeout)
+		_, err = t.Exchange(ctx, query)
+		cancel()
+		if err == nil {
+			return upstreamKey(&nameservers[k]), true
+		}
+	}
+	return "", false
+}
+
+/*
+*	Answers a query that would be forwarded while offline, from the cache however long ago the entry expired or
+*	with SERVFAIL when nothing is cached
+ */
+func (op *StateOperation) respondOffline(cache *responseCache) {
+	op.Cancel()
+	if res := cache.GetStale(op.Question.Name.String(), op.Question.Type, queryVariant(op.ByteData)); res != nil {
+		stats.Increment(stats.OfflineStale)
+		res = cachedAnswer(res, op.ByteData, op.RequestId, op.Question.Name.String(), op.Header.RecursionDesired)
+		op.Trace.Step("offline, answering with a stale cache entry")
+		op.respond(res, "stale")
+		observeLatency("stale", op.Question.Type, op.Received)
+		return
+	}
+	stats.Increment(stats.OfflineServfail)
+	op.Trace.Step("offline and not cached, answering SERVFAIL")
+	res, err := BuildErrorResponse(op.ByteData, dnsmessage.RCodeServerFailure)
+	if err != nil {
+		logging.LogMessage(logging.LogError, err.Error())
+		return
+	}
+	op.respond(res, "offline")
+	observeLatency("offline", op.Question.Type, op.Received)
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"math/rand"
+	"net"
+	"sort"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	OrderAsConfigured       = "as-configured"
+	OrderRandom             = "random"
+	OrderRoundRobin         = "round-robin"
+	OrderPreferClientSubnet = "prefer-client-subnet"
+)
+
+/*
+*	Reorders the A/AAAA answers of packet in place of each other, so CNAMEs and RRSIGs keep their positions
+ */
+type answerOrderer struct {
+	mode     string
+	rotation int
+}
+
+func (o *answerOrderer) Apply(packet []byte, client net.IP) []byte {
+	if o.mode == "" || o.mode == OrderAsConfigured {
+		return packet
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(packet); err != nil {
+		return packet
+	}
+	var slots []int
+	for i, a := range m.Answers {
+		if a.Header.Type == dnsmessage.TypeA || a.Header.Type == dnsmessage.TypeAAAA {
+			slots = append(slots, i)
+		}
+	}
+	if len(slots) < 2 {
+		return packet
+	}
+	addrs := make([]dnsmessage.Resource, len(slots))
+	for i, idx := range slots {
+		addrs[i] = m.Answers[idx]
+	}
+	switch o.mode {
+	case OrderRandom:
+		rand.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+	case OrderRoundRobin:
+		o.rotation++
+		shift := o.rotation % len(addrs)
+		addrs = append(addrs[shift:], addrs[:shift]...)
+	case OrderPreferClientSubnet:
+		sort.SliceStable(addrs, func(i, j int) bool {
+			return sameSubnet(resourceIP(addrs[i]), client) && !sameSubnet(resourceIP(addrs[j]), client)
+		})
+	}
+	for i, idx := range slots {
+		m.Answers[idx] = addrs[i]
+	}
+	out, err := m.Pack()
+	if err != nil {
+		return packet
+	}
+	return out
+}
+
+func resourceIP(r dnsmessage.Resource) net.IP {
+	switch body := r.Body.(type) {
+	case *dnsmessage.AResource:
+		return net.IP(body.A[:])
+	case *dnsmessage.AAAAResource:
+		return net.IP(body.AAAA[:])
+	}
+	return nil
+}
+
+/*
+*	Reports whether ip is in the same /24 (IPv4) or /64 (IPv6) as client
+ */
+func sameSubnet(ip net.IP, client net.IP) bool {
+	if ip == nil || client == nil {
+		return false
+	}
+	if ip4, c4 := ip.To4(), client.To4(); ip4 != nil || c4 != nil {
+		return ip4 != nil && c4 != nil && ip4.Mask(net.CIDRMask(24, 32)).Equal(c4.Mask(net.CIDRMask(24, 32)))
+	}
+	mask := net.CIDRMask(64, 128)
+	return ip.Mask(mask).Equal(client.Mask(mask))
+}
@@ -0,0 +1,230 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/TasSM/labns/internal/dnsname"
+	"github.com/TasSM/labns/internal/logging"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	overridesCheckInterval = time.Second
+	overridesReadTimeout   = 5 * time.Second
+	overridesMaxBackoff    = time.Minute
+)
+
+/*
+*	Hosts-format overrides re-read whenever the file's mtime or size changes. The overrides-file background task does
+*	the reading so slow storage never blocks the state worker, which only sees the last successfully read entries
+ */
+type overridesFile struct {
+	path    string
+	storage overridesStorage
+	modTime time.Time
+	size    int64
+	entries atomic.Value
+	// check interval, read timeout and backoff cap, the overrides* constants outside of tests
+	interval    time.Duration
+	readTimeout time.Duration
+	maxBackoff  time.Duration
+}
+
+/*
+*	Where the overrides file is read from, the local filesystem outside of tests
+ */
+type overridesStorage interface {
+	Stat(name string) (os.FileInfo, error)
+	Open(name string) (io.ReadCloser, error)
+}
+
+type osStorage struct{}
+
+func (osStorage) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osStorage) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+var overridesTask = backgroundTask{
+	Name: "overrides-file",
+	Config: func(s *snapshot) interface{} {
+		if s.overrides == nil {
+			return nil
+		}
+		return s.conf.OverridesFile
+	},
+	Run: func(ctx context.Context, s *snapshot) error {
+		s.overrides.watch(ctx)
+		return nil
+	},
+	Restart: restartOnError,
+}
+
+func newOverridesFile(path string) *overridesFile {
+	o := &overridesFile{path: path, storage: osStorage{}, interval: overridesCheckInterval, readTimeout: overridesReadTimeout, maxBackoff: overridesMaxBackoff}
+	o.entries.Store(make(map[string][]net.IP))
+	return o
+}
+
+/*
+*	Returns the override addresses for name, ok is false when the name is not overridden
+ */
+func (o *overridesFile) Lookup(name string, now time.Time) ([]net.IP, bool) {
+	if o == nil {
+		return nil, false
+	}
+	entries, _ := o.entries.Load().(map[string][]net.IP)
+	ips, ok := entries[dnsname.Key(name)]
+	return ips, ok
+}
+
+/*
+*	Checks the file every interval, failed or timed out reads back off exponentially with jitter. A read that is still
+*	blocked is waited on rather than replaced, so a stalled mount never piles up goroutines. Once ctx is cancelled a
+*	read still blocked on storage finishes on its own and is discarded
+ */
+func (o *overridesFile) watch(ctx context.Context) {
+	var failures int
+	var result chan error
+	for {
+		if result == nil {
+			result = make(chan error, 1)
+			go func(done chan error) {
+				done <- o.refresh(ctx)
+			}(result)
+		}
+		wait := o.interval
+		select {
+		case err := <-result:
+			result = nil
+			if err == nil {
+				if failures > 0 {
+					logging.LogMessage(logging.LogInfo, "Overrides file "+o.path+" readable again")
+				}
+				failures = 0
+				break
+			}
+			failures++
+			wait = retryDelay(failures, o.interval, o.maxBackoff)
+			logging.LogMessage(logging.LogError, fmt.Sprintf("Unable to read overrides file, keeping the last good entries and retrying in %s: %v", wait.Round(time.Millisecond), err))
+		case <-time.After(o.readTimeout):
+			failures++
+			wait = retryDelay(failures, o.interval, o.maxBackoff)
+			logging.LogMessage(logging.LogError, fmt.Sprintf("Reading overrides file %s is taking longer than %s, keeping the last good entries", o.path, o.readTimeout))
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+/*
+*	Exponential backoff from base capped at max, plus up to half of it again as jitter
+ */
+func retryDelay(failures int, base time.Duration, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+}
+
+func (o *overridesFile) refresh(ctx context.Context) error {
+	info, err := o.storage.Stat(o.path)
+	if os.IsNotExist(err) {
+		// a missing file simply means no overrides
+		if entries, _ := o.entries.Load().(map[string][]net.IP); len(entries) > 0 {
+			logging.LogMessage(logging.LogInfo, "Overrides file "+o.path+" removed, clearing overrides")
+		}
+		o.entries.Store(make(map[string][]net.IP))
+		o.modTime, o.size = time.Time{}, 0
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(o.modTime) && info.Size() == o.size {
+		return nil
+	}
+	file, err := o.storage.Open(o.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	entries := make(map[string][]net.IP)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			continue
+		}
+		for _, host := range fields[1:] {
+			if name, err := dnsname.Canonical(host); err == nil {
+				entries[name] = append(entries[name], ip)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		// stopped by a reload while this read was blocked
+		return nil
+	}
+	o.entries.Store(entries)
+	o.modTime, o.size = info.ModTime(), info.Size()
+	logging.LogMessage(logging.LogInfo, "Loaded overrides file "+o.path)
+	return nil
+}
+
+/*
+*	Answers question with the addresses of its type from ips, other types get an empty NOERROR
+ */
+func BuildAddressResponse(query []byte, question dnsmessage.Question, ips []net.IP, ttl uint32) ([]byte, error) {
+	b, err := newResponseBuilder(query)
+	if err != nil {
+		return nil, err
+	}
+	var answers []dnsmessage.Resource
+	header := dnsmessage.ResourceHeader{Name: question.Name, Type: question.Type, Class: dnsmessage.ClassINET, TTL: ttl}
+	for _, ip := range ips {
+		switch {
+		case question.Type == dnsmessage.TypeA && ip.To4() != nil:
+			res := dnsmessage.AResource{}
+			copy(res.A[:], ip.To4())
+			answers = append(answers, dnsmessage.Resource{Header: header, Body: &res})
+		case question.Type == dnsmessage.TypeAAAA && ip.To4() == nil:
+			res := dnsmessage.AAAAResource{}
+			copy(res.AAAA[:], ip.To16())
+			answers = append(answers, dnsmessage.Resource{Header: header, Body: &res})
+		}
+	}
+	return b.Answer(answers, true)
+}
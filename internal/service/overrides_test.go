@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*
+*	Delays every read of the real files until the gate is open and counts the reads in flight, a stand-in for a
+*	stalled network mount
+ */
+type slowStorage struct {
+	gate     chan struct{}
+	opens    int32
+	inFlight int32
+	maxConc  int32
+	failStat error
+	stats    int32
+	mu       sync.Mutex
+}
+
+func newSlowStorage() *slowStorage {
+	s := &slowStorage{gate: make(chan struct{})}
+	close(s.gate)
+	return s
+}
+
+func (s *slowStorage) stall() {
+	s.mu.Lock()
+	s.gate = make(chan struct{})
+	s.mu.Unlock()
+}
+
+func (s *slowStorage) release() {
+	s.mu.Lock()
+	select {
+	case <-s.gate:
+	default:
+		close(s.gate)
+	}
+	s.mu.Unlock()
+}
+
+func (s *slowStorage) Stat(name string) (os.FileInfo, error) {
+	atomic.AddInt32(&s.stats, 1)
+	if s.failStat != nil {
+		return nil, s.failStat
+	}
+	return os.Stat(name)
+}
+
+func (s *slowStorage) Open(name string) (io.ReadCloser, error) {
+	atomic.AddInt32(&s.opens, 1)
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	gate := s.gate
+	s.mu.Unlock()
+	return &slowReader{f: f, gate: gate, s: s}, nil
+}
+
+type slowReader struct {
+	f    *os.File
+	gate chan struct{}
+	s    *slowStorage
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	n := atomic.AddInt32(&r.s.inFlight, 1)
+	defer atomic.AddInt32(&r.s.inFlight, -1)
+	for {
+		max := atomic.LoadInt32(&r.s.maxConc)
+		if n <= max || atomic.CompareAndSwapInt32(&r.s.maxConc, max, n) {
+			break
+		}
+	}
+	<-r.gate
+	return r.f.Read(p)
+}
+
+func (r *slowReader) Close() error {
+	return r.f.Close()
+}
+
+/*
+*	An overrides file on storage with test-sized timings
+ */
+func testOverrides(t *testing.T, storage overridesStorage, content string) *overridesFile {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "overrides")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	o := newOverridesFile(path)
+	o.storage = storage
+	o.interval, o.readTimeout, o.maxBackoff = 5*time.Millisecond, 20*time.Millisecond, time.Second
+	return o
+}
+
+func overrideFor(o *overridesFile, name string) string {
+	ips, ok := o.Lookup(name, time.Time{})
+	if !ok || len(ips) == 0 {
+		return ""
+	}
+	return ips[0].String()
+}
+
+func waitForOverride(t *testing.T, o *overridesFile, name string, want string) {
+	t.Helper()
+	for deadline := time.Now().Add(exchangeTimeout); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if overrideFor(o, name) == want {
+			return
+		}
+	}
+	t.Fatalf("override for %s is %q, want %q", name, overrideFor(o, name), want)
+}
+
+func TestOverridesFileRefresh(t *testing.T) {
+	o := testOverrides(t, osStorage{}, "192.0.2.80 tv.lab.home # the living room\n2001:db8::80 tv.lab.home\nbogus line\n")
+	if err := o.refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ips, ok := o.Lookup("TV.lab.home.", time.Time{})
+	if !ok || len(ips) != 2 || !ips[0].Equal(net.ParseIP("192.0.2.80")) || !ips[1].Equal(net.ParseIP("2001:db8::80")) {
+		t.Fatalf("overrides for tv.lab.home. are %v, want both addresses", ips)
+	}
+	if _, ok := o.Lookup("nas.lab.home.", time.Time{}); ok {
+		t.Fatal("a name missing from the file is overridden")
+	}
+
+	// a changed size is picked up even when the mtime resolution hides the write
+	if err := os.WriteFile(o.path, []byte("192.0.2.81 tv.lab.home\n192.0.2.82 radio.lab.home\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if overrideFor(o, "tv.lab.home.") != "192.0.2.81" || overrideFor(o, "radio.lab.home.") != "192.0.2.82" {
+		t.Fatal("rewritten overrides file was not read again")
+	}
+
+	os.Remove(o.path)
+	if err := o.refresh(context.Background()); err != nil {
+		t.Fatalf("removed overrides file failed the refresh: %v", err)
+	}
+	if _, ok := o.Lookup("tv.lab.home.", time.Time{}); ok {
+		t.Fatal("overrides kept after the file was removed")
+	}
+}
+
+/*
+*	A read blocked on storage is waited on alone while the last good entries keep answering, and its result is used
+*	once the storage recovers
+ */
+func TestStalledOverridesReadKeepsLastGoodEntries(t *testing.T) {
+	storage := newSlowStorage()
+	o := testOverrides(t, storage, "192.0.2.90 tv.lab.home\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		o.watch(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		storage.release()
+		<-done
+	})
+	waitForOverride(t, o, "tv.lab.home.", "192.0.2.90")
+
+	storage.stall()
+	if err := os.WriteFile(o.path, []byte("192.0.2.91 tv.lab.home\n192.0.2.92 radio.lab.home\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opens := atomic.LoadInt32(&storage.opens)
+	// many times the read timeout and the check interval
+	time.Sleep(300 * time.Millisecond)
+	if got := overrideFor(o, "tv.lab.home."); got != "192.0.2.90" {
+		t.Fatalf("override during a stalled read is %q, want the last good 192.0.2.90", got)
+	}
+	if got := atomic.LoadInt32(&storage.opens) - opens; got != 1 {
+		t.Fatalf("%d reads started while the storage stalled, want the one blocked read", got)
+	}
+	if got := atomic.LoadInt32(&storage.maxConc); got != 1 {
+		t.Fatalf("%d reads were in flight at once, want 1", got)
+	}
+
+	storage.release()
+	waitForOverride(t, o, "radio.lab.home.", "192.0.2.92")
+	if got := overrideFor(o, "tv.lab.home."); got != "192.0.2.91" {
+		t.Fatalf("override after the storage recovered is %q, want 192.0.2.91", got)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(exchangeTimeout):
+		t.Fatal("watcher did not stop when its context was cancelled")
+	}
+}
+
+func TestFailingOverridesReadsBackOff(t *testing.T) {
+	storage := newSlowStorage()
+	o := testOverrides(t, storage, "192.0.2.95 tv.lab.home\n")
+	if err := o.refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	storage.failStat = errors.New("stale file handle")
+	before := atomic.LoadInt32(&storage.stats)
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	o.watch(ctx)
+	// 5ms doubling to 10, 20, 40, 80 and 160 fits at most six checks in 300ms, against 60 without backoff
+	if got := atomic.LoadInt32(&storage.stats) - before; got > 6 {
+		t.Fatalf("failing storage was checked %d times in 300ms, want backoff to keep it at 6 or fewer", got)
+	}
+	if got := overrideFor(o, "tv.lab.home."); got != "192.0.2.95" {
+		t.Fatalf("override after failed reads is %q, want the last good 192.0.2.95", got)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	base, max := 10*time.Millisecond, 100*time.Millisecond
+	for failures, want := range map[int]time.Duration{1: 10 * time.Millisecond, 2: 20 * time.Millisecond, 4: 80 * time.Millisecond, 5: max, 30: max} {
+		for i := 0; i < 20; i++ {
+			if got := retryDelay(failures, base, max); got < want || got > want+want/2 {
+				t.Fatalf("retryDelay after %d failures is %s, want %s plus up to half as jitter", failures, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// EDNS(0) Padding option code (RFC 7830)
+const ednsOptionPadding = 12
+
+/*
+*	Removes the EDNS(0) padding option from packet, reporting whether anything changed. Padding only hides sizes on
+*	encrypted transports (RFC 8467) and every listener is plain UDP, so an upstream's padding is never passed on
+ */
+func stripPadding(packet []byte) ([]byte, bool) {
+	var m dnsmessage.Message
+	if err := m.Unpack(packet); err != nil {
+		return packet, false
+	}
+	changed := false
+	for k := range m.Additionals {
+		opt, ok := m.Additionals[k].Body.(*dnsmessage.OPTResource)
+		if !ok {
+			continue
+		}
+		options := opt.Options[:0]
+		for _, o := range opt.Options {
+			if o.Code == ednsOptionPadding {
+				changed = true
+				continue
+			}
+			options = append(options, o)
+		}
+		opt.Options = options
+	}
+	if !changed {
+		return packet, false
+	}
+	packed, err := m.Pack()
+	if err != nil {
+		return packet, false
+	}
+	return packed, true
+}
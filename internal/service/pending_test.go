@@ -0,0 +1,177 @@
+package service
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TasSM/labns/internal/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	Sends packet from a socket of its own and returns the first answer, for use off the test goroutine
+ */
+func sendFrom(packet []byte, wait time.Duration) ([]byte, error) {
+	conn, err := net.Dial("udp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(wait))
+	if _, err := conn.Write(packet); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func collideConfig(t *testing.T, addresses map[string]string, delay time.Duration) *dnstest.Server {
+	t.Helper()
+	conf := testConfig(t)
+	up := newUpstream(t)
+	for name, addr := range addresses {
+		up.Handle(name, dnsmessage.TypeA, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.A(name, 60, addr)}, Delay: delay})
+	}
+	forwardTo(conf, "collide.test.", up)
+	reload(t, conf)
+	return up
+}
+
+/*
+*	Two clients picking the same query ID while both queries are pending each get the answer to their own question
+ */
+func TestSameIDFromTwoClients(t *testing.T) {
+	addresses := map[string]string{"one.collide.test.": "192.0.2.91", "two.collide.test.": "192.0.2.92"}
+	up := collideConfig(t, addresses, 100*time.Millisecond)
+
+	var wg sync.WaitGroup
+	answers := make(map[string][]byte)
+	errs := make(map[string]error)
+	var lock sync.Mutex
+	for _, name := range []string{"one.collide.test.", "two.collide.test."} {
+		packet := rawQuery(t, 0x4242, 0x0100, question(name, dnsmessage.TypeA))
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			res, err := sendFrom(packet, exchangeTimeout)
+			lock.Lock()
+			answers[name], errs[name] = res, err
+			lock.Unlock()
+		}(name)
+		time.Sleep(20 * time.Millisecond)
+	}
+	wg.Wait()
+
+	for name, addr := range addresses {
+		if errs[name] != nil {
+			t.Errorf("no answer for %s: %v", name, errs[name])
+			continue
+		}
+		res := unpack(t, answers[name])
+		if res.Header.ID != 0x4242 || len(res.Questions) != 1 || res.Questions[0].Name.String() != name || answerAddress(t, res) != addr {
+			t.Errorf("answer for %s has ID %#x, questions %v and answers %v, want its own record %s", name, res.Header.ID, res.Questions, res.Answers, addr)
+		}
+	}
+	queries := up.Queries()
+	if len(queries) != 2 || queries[0].ID == queries[1].ID {
+		t.Fatalf("upstream received %d queries, want two with their own IDs: %+v", len(queries), queries)
+	}
+}
+
+/*
+*	A repeated query from the same socket, ID and question is a retransmission: forwarded again with the ID of the
+*	first copy and answered once. The same ID with another question is a query of its own
+ */
+func TestRetransmissionFromTheSameSocket(t *testing.T) {
+	addresses := map[string]string{"one.collide.test.": "192.0.2.91", "two.collide.test.": "192.0.2.92"}
+	up := collideConfig(t, addresses, 100*time.Millisecond)
+
+	conn, err := net.Dial("udp", listenAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	one := rawQuery(t, 0x4343, 0x0100, question("one.collide.test.", dnsmessage.TypeA))
+	two := rawQuery(t, 0x4343, 0x0100, question("two.collide.test.", dnsmessage.TypeA))
+	for _, packet := range [][]byte{one, one, two} {
+		if _, err := conn.Write(packet); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	got := make(map[string]int)
+	conn.SetDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			break
+		}
+		res := unpack(t, buf[:n])
+		name := res.Questions[0].Name.String()
+		if res.Header.ID != 0x4343 || answerAddress(t, res) != addresses[name] {
+			t.Errorf("answer for %s has ID %#x and answers %v", name, res.Header.ID, res.Answers)
+		}
+		got[name]++
+	}
+	if got["one.collide.test."] != 1 || got["two.collide.test."] != 1 {
+		t.Fatalf("answers received per name are %v, want one each", got)
+	}
+
+	var ids []uint16
+	for _, q := range up.Queries() {
+		if q.Question.Name.String() == "one.collide.test." {
+			ids = append(ids, q.ID)
+		}
+	}
+	if len(ids) != 2 || ids[0] != ids[1] {
+		t.Fatalf("retransmitted query reached the upstream with IDs %v, want the same ID twice", ids)
+	}
+}
+
+func TestOutboundIDIsFresh(t *testing.T) {
+	names := []string{"a.collide.test.", "b.collide.test.", "c.collide.test.", "d.collide.test."}
+	addresses := make(map[string]string)
+	for _, name := range names {
+		addresses[name] = "192.0.2.93"
+	}
+	up := collideConfig(t, addresses, 0)
+	for _, name := range names {
+		unpack(t, exchange(t, rawQuery(t, 0x4444, 0x0100, question(name, dnsmessage.TypeA))))
+	}
+	for _, q := range up.Queries() {
+		if q.ID != 0x4444 {
+			return
+		}
+	}
+	t.Fatal("every forwarded query kept the client's ID 0x4444")
+}
+
+/*
+*	An upstream answer carrying the pending ID but another question is not delivered, the query fails over to the
+*	next upstream as if no answer had come
+ */
+func TestResponseForAnotherQuestionIsDropped(t *testing.T) {
+	conf := testConfig(t)
+	name := "host.wrongq.test."
+	other := question("other.wrongq.test.", dnsmessage.TypeA)
+	confused, working := newUpstream(t), newUpstream(t)
+	confused.Handle(name, dnsmessage.TypeA, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.A("other.wrongq.test.", 60, "192.0.2.94")}, Question: &other})
+	working.Handle(name, dnsmessage.TypeA, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.A(name, 60, "192.0.2.95")}})
+	forwardTo(conf, "wrongq.test.", confused, working)
+	reload(t, conf)
+
+	res := lookup(t, name, dnsmessage.TypeA, 0)
+	if res.Questions[0].Name.String() != name || answerAddress(t, res) != "192.0.2.95" {
+		t.Fatalf("%s answered for %v with %v, want the next upstream's record", name, res.Questions, res.Answers)
+	}
+	if queriesFor(confused, name) != 1 || queriesFor(working, name) != 1 {
+		t.Fatalf("upstreams received %d and %d queries, want one each", queriesFor(confused, name), queriesFor(working, name))
+	}
+}
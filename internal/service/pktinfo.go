@@ -0,0 +1,108 @@
+package service
+
+import (
+	"net"
+	"sync"
+
+	"github.com/TasSM/labns/internal/logging"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+/*
+*	Packet info for a listener bound to an unspecified address. The kernel picks the source of an unconnected reply
+*	from the routing table, which on a multi-homed host can differ from the address the query was sent to, so the
+*	destination of each query is read with IP_PKTINFO/IPV6_RECVPKTINFO and set as the source of its reply
+ */
+type packetInfo struct {
+	v4 *ipv4.PacketConn
+	v6 *ipv6.PacketConn
+}
+
+// listener socket to packet info, every listener adds its own socket when it starts
+var (
+	packetInfoConns = make(map[*net.UDPConn]*packetInfo)
+	packetInfoLock  sync.RWMutex
+)
+
+func packetInfoFor(conn *net.UDPConn) *packetInfo {
+	packetInfoLock.RLock()
+	defer packetInfoLock.RUnlock()
+	return packetInfoConns[conn]
+}
+
+/*
+*	Turns on destination address reporting for sockets on an unspecified address, where the platform supports it
+ */
+func enablePacketInfo(conn *net.UDPConn) {
+	local, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok || (local.IP != nil && !local.IP.IsUnspecified()) {
+		return
+	}
+	info := &packetInfo{}
+	var err error
+	if local.IP.To4() != nil {
+		info.v4 = ipv4.NewPacketConn(conn)
+		err = info.v4.SetControlMessage(ipv4.FlagDst, true)
+	} else {
+		info.v6 = ipv6.NewPacketConn(conn)
+		err = info.v6.SetControlMessage(ipv6.FlagDst, true)
+	}
+	if err != nil {
+		logging.LogMessage(logging.LogDebug, "Destination address reporting unavailable on "+conn.LocalAddr().String()+": "+err.Error())
+		return
+	}
+	packetInfoLock.Lock()
+	packetInfoConns[conn] = info
+	packetInfoLock.Unlock()
+}
+
+/*
+*	Reads one packet, dst is the address it was sent to when packet info is enabled on conn and nil otherwise
+ */
+func readPacket(conn *net.UDPConn, buf []byte) (int, *net.UDPAddr, net.IP, error) {
+	info := packetInfoFor(conn)
+	if info == nil {
+		n, addr, err := conn.ReadFromUDP(buf)
+		return n, addr, nil, err
+	}
+	var n int
+	var src net.Addr
+	var dst net.IP
+	var err error
+	if info.v4 != nil {
+		var cm *ipv4.ControlMessage
+		if n, cm, src, err = info.v4.ReadFrom(buf); cm != nil {
+			dst = cm.Dst
+		}
+	} else {
+		var cm *ipv6.ControlMessage
+		if n, cm, src, err = info.v6.ReadFrom(buf); cm != nil {
+			dst = cm.Dst
+		}
+	}
+	addr, _ := src.(*net.UDPAddr)
+	return n, addr, dst, err
+}
+
+/*
+*	Sends a reply from dst when it is known. IPv4 clients of a dual-stack IPv6 socket arrive as mapped addresses,
+*	the IPv6 source option does not apply to them and their replies use the kernel's choice
+ */
+func writeReply(conn *net.UDPConn, res []byte, addr *net.UDPAddr, dst net.IP) {
+	info := packetInfoFor(conn)
+	var err error
+	switch {
+	case info == nil || dst == nil || dst.IsUnspecified():
+		_, err = conn.WriteToUDP(res, addr)
+	case info.v4 != nil:
+		_, err = info.v4.WriteTo(res, &ipv4.ControlMessage{Src: dst}, addr)
+	case dst.To4() != nil:
+		_, err = conn.WriteToUDP(res, addr)
+	default:
+		_, err = info.v6.WriteTo(res, &ipv6.ControlMessage{Src: dst}, addr)
+	}
+	if err != nil {
+		logging.LogMessage(logging.LogDebug, "Failed to send reply to "+logging.Addr(addr)+": "+err.Error())
+	}
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	Sends packet from a loopback socket to dst and returns the answer with the address it came from. Every
+*	127.0.0.0/8 address is local on Linux, so the aliases need no setup
+ */
+func exchangeFrom(t *testing.T, dst *net.UDPAddr, packet []byte) ([]byte, *net.UDPAddr) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(exchangeTimeout))
+	if _, err := conn.WriteToUDP(packet, dst); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 65535)
+	n, from, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("no answer from %v: %v", dst, err)
+	}
+	return buf[:n], from
+}
+
+/*
+*	A listener on 0.0.0.0 answers from the address each query was sent to, on the listener's error path and on
+*	answers from the state worker
+ */
+func TestReplyFromQueryDestination(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go serveListener(conn, reqChan, testConfig(t), false)
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	for _, ip := range []net.IP{net.IPv4(127, 0, 0, 2), net.IPv4(127, 0, 0, 3)} {
+		dst := &net.UDPAddr{IP: ip, Port: port}
+		for _, packet := range [][]byte{
+			rawQuery(t, 0x1481, 0x0100),
+			rawQuery(t, 0x1482, 0x0100, question("nas.lab.home.", dnsmessage.TypeA)),
+		} {
+			res, from := exchangeFrom(t, dst, packet)
+			if !from.IP.Equal(ip) || from.Port != port {
+				t.Errorf("answer to a query sent to %v came from %v", dst, from)
+			}
+			if m := unpack(t, res); m.Header.ID != headerID(packet) {
+				t.Errorf("answer from %v has ID %#x, want %#x", from, m.Header.ID, headerID(packet))
+			}
+		}
+	}
+}
+
+func TestPacketInfoOnlyForUnspecifiedAddress(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	enablePacketInfo(conn)
+	if packetInfoFor(conn) != nil {
+		t.Fatal("packet info enabled on a socket bound to one address")
+	}
+}
+
+func headerID(packet []byte) uint16 {
+	return uint16(packet[0])<<8 | uint16(packet[1])
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/TasSM/labns/internal/blocklist"
+	"github.com/TasSM/labns/internal/config"
+)
+
+// listener socket to profile name, written once at startup before any listener is served
+var listenerProfiles = make(map[*net.UDPConn]string)
+
+var traceAllProfiles atomic.Value
+
+/*
+*	Per-profile state owned by the state worker, the default profile "" uses the global settings
+ */
+type profileState struct {
+	name    string
+	blocker *Blocker
+	cache   *responseCache
+}
+
+/*
+*	Assigns the socket to a named profile, must be called before StartDNSService
+ */
+func SetListenerProfile(conn *net.UDPConn, profile string) {
+	listenerProfiles[conn] = profile
+}
+
+func setTraceAllProfiles(conf *config.Configuration) {
+	all := make(map[string]bool)
+	for _, p := range conf.Profiles {
+		if p.TraceAll {
+			all[p.Name] = true
+		}
+	}
+	traceAllProfiles.Store(all)
+}
+
+func tracesAll(profile string) bool {
+	all, _ := traceAllProfiles.Load().(map[string]bool)
+	return all[profile]
+}
+
+/*
+*	Builds the blocker and cache of every profile, records and upstreams stay shared with the default profile
+ */
+func newProfileStates(conf *config.Configuration, blocker *Blocker, cache *responseCache) (map[string]*profileState, error) {
+	states := map[string]*profileState{"": {blocker: blocker, cache: cache}}
+	for _, p := range conf.Profiles {
+		state := &profileState{name: p.Name, blocker: blocker, cache: cache}
+		if p.DisableBlocking {
+			state.blocker = &Blocker{Set: blocklist.NewSet(), TTL: blocker.TTL}
+		} else if p.Blocklists != nil || len(p.Allowlist) > 0 {
+			local := *conf
+			if p.Blocklists != nil {
+				local.Blocklists = p.Blocklists
+			}
+			local.Allowlist = append(append([]string{}, conf.Allowlist...), p.Allowlist...)
+			b, err := CreateBlocker(&local)
+			if err != nil {
+				return nil, err
+			}
+			state.blocker = b
+		}
+		if p.Cache != nil {
+			state.cache = newResponseCache(p.Cache)
+		}
+		states[p.Name] = state
+	}
+	return states, nil
+}
+
+/*
+*	Returns the state of the profile serving conn, in-process queries have no socket and use the default profile
+ */
+func profileFor(states map[string]*profileState, conn *net.UDPConn) *profileState {
+	if state, ok := states[listenerProfiles[conn]]; ok {
+		return state
+	}
+	return states[""]
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/TasSM/labns/internal/config"
+)
+
+/*
+*	RDATA of an SSHFP record (RFC 4255): algorithm, fingerprint type, fingerprint
+ */
+func BuildSSHFPData(p *config.SSHFPParams) ([]byte, error) {
+	fp, err := config.DecodeHex(p.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{p.Algorithm, p.FingerprintType}, fp...), nil
+}
+
+/*
+*	RDATA of a TLSA record (RFC 6698): usage, selector, matching type, certificate association data
+ */
+func BuildTLSAData(p *config.TLSAParams) ([]byte, error) {
+	data, err := config.DecodeHex(p.CertData)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{p.Usage, p.Selector, p.MatchingType}, data...), nil
+}
+
+/*
+*	RDATA of an HINFO record (RFC 1035): the CPU and OS as two character-strings
+ */
+func BuildHINFOData(p *config.HINFOParams) ([]byte, error) {
+	if len(p.CPU) > 255 || len(p.OS) > 255 {
+		return nil, errors.New("HINFO strings are limited to 255 characters")
+	}
+	out := append([]byte{byte(len(p.CPU))}, p.CPU...)
+	out = append(out, byte(len(p.OS)))
+	return append(out, p.OS...), nil
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+)
+
+const (
+	auditCheckInterval = time.Minute
+	auditProbeTimeout  = 2 * time.Second
+)
+
+var (
+	recordAudit atomic.Value
+	auditState  = &auditFailures{counts: make(map[string]uint32)}
+)
+
+/*
+*	Consecutive audits each target address has failed, written by the audit and read by the admin API, so it is
+*	guarded by a mutex. Addresses that answer are removed, as are addresses no record points to any more
+ */
+type auditFailures struct {
+	lock   sync.Mutex
+	counts map[string]uint32
+}
+
+/*
+*	Loads the audit settings, a reload replaces them without resetting the failure counts
+ */
+func SetRecordAudit(a *config.RecordAudit) {
+	recordAudit.Store(a)
+}
+
+/*
+*	Reports how many audits in a row the target of a record has failed and whether that makes the record possibly
+*	stale, which is only reported when TagStale is set
+ */
+func RecordAuditStatus(target string) (uint32, bool) {
+	a, _ := recordAudit.Load().(*config.RecordAudit)
+	if a == nil || !a.Enabled {
+		return 0, false
+	}
+	auditState.lock.Lock()
+	failures := auditState.counts[target]
+	auditState.lock.Unlock()
+	return failures, a.TagStale && failures >= a.FailAfter
+}
+
+/*
+*	Runs an audit of the local record targets every IntervalMinutes while RecordAudit is enabled, the first one a
+*	minute after startup or after a reload changed the settings. The audit only reports, records are served the
+*	same whatever it finds
+ */
+var recordAuditTask = backgroundTask{
+	Name: "record-audit",
+	Config: func(s *snapshot) interface{} {
+		if !s.conf.RecordAudit.Enabled {
+			return nil
+		}
+		return s.conf.RecordAudit
+	},
+	Run: func(ctx context.Context, s *snapshot) error {
+		a := &s.conf.RecordAudit
+		var last time.Time
+		ticker := time.NewTicker(auditCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+			if time.Since(last) < time.Duration(a.IntervalMinutes)*time.Minute {
+				continue
+			}
+			runRecordAudit(a, LocalRecords())
+			last = time.Now()
+		}
+	},
+	Restart: restartOnError,
+}
+
+/*
+*	The addresses A and AAAA records point to mapped to the names pointing there, records with NoAudit and
+*	unspecified addresses are left out
+ */
+func auditTargets(records []config.LocalDNSRecord) map[string][]string {
+	targets := make(map[string][]string)
+	for _, r := range records {
+		if (r.Type != "A" && r.Type != "AAAA") || r.NoAudit {
+			continue
+		}
+		ip := net.ParseIP(r.Target)
+		if ip == nil || ip.IsUnspecified() {
+			continue
+		}
+		targets[ip.String()] = append(targets[ip.String()], r.Name)
+	}
+	return targets
+}
+
+func runRecordAudit(a *config.RecordAudit, records []config.LocalDNSRecord) {
+	targets := auditTargets(records)
+	addresses := make([]string, 0, len(targets))
+	for ip := range targets {
+		addresses = append(addresses, ip)
+	}
+	sort.Strings(addresses)
+	pace := time.NewTicker(time.Duration(a.ProbeIntervalMs) * time.Millisecond)
+	defer pace.Stop()
+	started := time.Now()
+	reachable := make(map[string]bool, len(addresses))
+	for _, ip := range addresses {
+		reachable[ip] = probeTarget(net.ParseIP(ip), a.Ports, pace.C)
+	}
+	auditState.lock.Lock()
+	for ip := range auditState.counts {
+		if _, ok := targets[ip]; !ok {
+			delete(auditState.counts, ip)
+		}
+	}
+	var stale []string
+	for _, ip := range addresses {
+		if reachable[ip] {
+			delete(auditState.counts, ip)
+			continue
+		}
+		auditState.counts[ip]++
+		if n := auditState.counts[ip]; n >= a.FailAfter {
+			stale = append(stale, fmt.Sprintf("%s (%s, %d audits)", ip, strings.Join(targets[ip], " "), n))
+		}
+	}
+	auditState.lock.Unlock()
+	summary := fmt.Sprintf("Record audit probed %d targets in %s", len(addresses), time.Since(started).Round(time.Second))
+	if len(stale) == 0 {
+		logging.LogMessage(logging.LogInfo, summary+", none unreachable for "+fmt.Sprint(a.FailAfter)+" or more audits")
+		return
+	}
+	logging.LogMessage(logging.LogInfo, fmt.Sprintf("%s, %d unreachable for %d or more audits and possibly stale: %s",
+		summary, len(stale), a.FailAfter, strings.Join(stale, ", ")))
+}
+
+/*
+*	Tries a TCP connection to each port in turn, one attempt per tick of pace. A refused connection means the host
+*	is there, only timeouts and unreachable errors on every port count as down
+ */
+func probeTarget(ip net.IP, ports []uint16, pace <-chan time.Time) bool {
+	for _, port := range ports {
+		<-pace
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), fmt.Sprint(port)), auditProbeTimeout)
+		if err == nil {
+			conn.Close()
+			return true
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"net"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnsname"
+)
+
+/*
+*	Returns the configuration the state worker last applied, nil before the service has started
+ */
+func RunningConfig() *config.Configuration {
+	if s := loadedSnapshot(); s != nil {
+		return &s.conf
+	}
+	return nil
+}
+
+/*
+*	Returns the local records currently being served, including their Comment and Tags. They belong to the running
+*	snapshot and must not be modified
+ */
+func LocalRecords() []config.LocalDNSRecord {
+	if s := loadedSnapshot(); s != nil {
+		return s.conf.LocalRecords
+	}
+	return nil
+}
+
+// the addresses a NULL record answers with, each query type picks the one of its family
+var nullAddresses = []net.IP{net.IPv4zero, net.IPv6unspecified}
+
+/*
+*	Names of NULL records mapped to their TTL, these are answered with the unspecified addresses instead of
+*	being built into local records
+ */
+func newNullRoutes(records []config.LocalDNSRecord) map[string]uint32 {
+	routes := make(map[string]uint32)
+	for _, r := range records {
+		if r.Type == "NULL" {
+			routes[dnsname.Key(r.Name)] = r.TTL
+		}
+	}
+	return routes
+}
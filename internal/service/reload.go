@@ -0,0 +1,102 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/TasSM/labns/internal/audit"
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+	"github.com/TasSM/labns/internal/stats"
+)
+
+/*
+*	Feature blocks compared on reload, each reports whether its configuration differs between two configurations
+ */
+var reloadBlocks = []struct {
+	Name    string
+	Changed func(a, b *config.Configuration) bool
+}{
+	{"blocklists", func(a, b *config.Configuration) bool {
+		return !reflect.DeepEqual(a.Blocklists, b.Blocklists) || !reflect.DeepEqual(a.Allowlist, b.Allowlist) ||
+			!reflect.DeepEqual(a.BlockResponse, b.BlockResponse) || !reflect.DeepEqual(a.ClientGroups, b.ClientGroups) ||
+			!reflect.DeepEqual(a.BlockedResponseTTL, b.BlockedResponseTTL)
+	}},
+	{"cache", func(a, b *config.Configuration) bool { return !reflect.DeepEqual(a.Cache, b.Cache) }},
+	{"cache-bypass", func(a, b *config.Configuration) bool {
+		return !reflect.DeepEqual(a.CacheBypassClients, b.CacheBypassClients)
+	}},
+	{"local-zones", func(a, b *config.Configuration) bool { return !reflect.DeepEqual(a.LocalZones, b.LocalZones) }},
+	{"pinned-names", func(a, b *config.Configuration) bool { return !reflect.DeepEqual(a.PinnedNames, b.PinnedNames) }},
+	{"admin-access", func(a, b *config.Configuration) bool { return !reflect.DeepEqual(a.AdminAccess, b.AdminAccess) }},
+	{"generated-ranges", func(a, b *config.Configuration) bool { return !reflect.DeepEqual(a.GeneratedRanges, b.GeneratedRanges) }},
+	{"delegations", func(a, b *config.Configuration) bool { return !reflect.DeepEqual(a.Delegations, b.Delegations) }},
+	{"forwarding-rules", func(a, b *config.Configuration) bool { return !reflect.DeepEqual(a.ForwardingRules, b.ForwardingRules) }},
+	{"overrides", func(a, b *config.Configuration) bool { return a.OverridesFile != b.OverridesFile }},
+	{"answer-ordering", func(a, b *config.Configuration) bool {
+		return a.AnswerOrdering != b.AnswerOrdering || a.OrderUpstreamAnswers != b.OrderUpstreamAnswers
+	}},
+	{"strip-ech", func(a, b *config.Configuration) bool {
+		return a.StripECH != b.StripECH || !reflect.DeepEqual(a.StripECHExempt, b.StripECHExempt)
+	}},
+	{"search-domain", func(a, b *config.Configuration) bool {
+		return a.SearchDomain != b.SearchDomain || *a.NeverForwardSingleLabel != *b.NeverForwardSingleLabel
+	}},
+	{"health-records", func(a, b *config.Configuration) bool { return a.HealthRecords != b.HealthRecords }},
+	{"fast-path", func(a, b *config.Configuration) bool { return !reflect.DeepEqual(a.FastPath, b.FastPath) }},
+	{"record-audit", func(a, b *config.Configuration) bool { return !reflect.DeepEqual(a.RecordAudit, b.RecordAudit) }},
+	{"answer-rewrites", func(a, b *config.Configuration) bool { return !reflect.DeepEqual(a.AnswerRewrites, b.AnswerRewrites) }},
+	{"ttl-overrides", func(a, b *config.Configuration) bool { return !reflect.DeepEqual(a.TTLOverrides, b.TTLOverrides) }},
+	{"response-hook", func(a, b *config.Configuration) bool { return !reflect.DeepEqual(a.ResponseHook, b.ResponseHook) }},
+	{"offline", func(a, b *config.Configuration) bool { return a.Offline != b.Offline }},
+	{"drift-detection", func(a, b *config.Configuration) bool { return a.DriftDetection != b.DriftDetection }},
+	{"mirror", func(a, b *config.Configuration) bool { return !reflect.DeepEqual(a.MirrorTo, b.MirrorTo) }},
+	{"resource-limits", func(a, b *config.Configuration) bool { return a.ResourceLimits != b.ResourceLimits }},
+	{"ttl-decay", func(a, b *config.Configuration) bool { return a.TTLDecay != b.TTLDecay }},
+	{"trace-domains", func(a, b *config.Configuration) bool { return !reflect.DeepEqual(a.TraceDomains, b.TraceDomains) }},
+}
+
+/*
+*	Logs what a successful reload changed and updates the reload counters
+ */
+func logReloadSummary(before, after *config.Configuration, changes []audit.Change) {
+	counts := map[audit.Action]int{}
+	for _, c := range changes {
+		counts[c.Action]++
+	}
+	var blocks []string
+	for _, b := range reloadBlocks {
+		if b.Changed(before, after) {
+			blocks = append(blocks, b.Name)
+		}
+	}
+	reconfigured := "none"
+	if len(blocks) > 0 {
+		reconfigured = strings.Join(blocks, ", ")
+	}
+	logging.LogMessage(logging.LogInfo, fmt.Sprintf("Configuration reloaded: %d records added, %d removed, %d modified, reconfigured: %s",
+		counts[audit.ActionAdd], counts[audit.ActionRemove], counts[audit.ActionUpdate], reconfigured))
+	if !sameUpstreams(&before.UpstreamNameservers, &after.UpstreamNameservers) {
+		logging.LogMessage(logging.LogInfo, "UpstreamNameservers changed in the configuration file, the change applies after a restart")
+	}
+	stats.Increment(stats.Reloads)
+	stats.Set(stats.LastReload, uint64(serviceClock.Now().Unix()))
+}
+
+/*
+*	Counts and logs a reload that was rejected, the running configuration is kept
+ */
+func ReloadFailed(err error) {
+	stats.Increment(stats.ReloadFailures)
+	logging.LogMessage(logging.LogError, "Reload failed, keeping current configuration: "+err.Error())
+}
+
+/*
+*	Compares upstream settings ignoring the order primary and secondary are currently in after failovers
+ */
+func sameUpstreams(running, configured *config.UpstreamNameservers) bool {
+	swapped := *running
+	swapped.Primary, swapped.Secondary = running.Secondary, running.Primary
+	return reflect.DeepEqual(*running, *configured) || reflect.DeepEqual(swapped, *configured)
+}
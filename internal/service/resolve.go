@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var running int32
+
+var inProcessClient = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+
+/*
+*	Answers a packed query in-process through the same pipeline as network clients, StartDNSService must be running
+ */
+func Resolve(ctx context.Context, query []byte) ([]byte, error) {
+	return resolve(ctx, query, nil, false)
+}
+
+func resolve(ctx context.Context, query []byte, conn *net.UDPConn, refresh bool) ([]byte, error) {
+	if atomic.LoadInt32(&running) == 0 {
+		return nil, errors.New("DNS service is not running")
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(query); err != nil {
+		return nil, err
+	}
+	if len(m.Questions) != 1 {
+		return nil, errors.New("query must have exactly one question")
+	}
+	m.Header.Response = false
+	if m.ID == 0 {
+		m.ID = uint16(rand.Intn(65535) + 1)
+	}
+	packed, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+	packed[3] |= query[3] & (flagCheckingDisabled | flagAuthenticData)
+	key, err := HashMessageFields(&packed)
+	if err != nil {
+		return nil, err
+	}
+	received := serviceClock.Now()
+	result := make(chan []byte, 1)
+	qctx, cancel := context.WithTimeout(ctx, queryDeadline)
+	defer cancel()
+	reqChan <- StateOperation{Operation: OpAdd, RequestHash: key, RequestorAddr: inProcessClient, RequestId: m.ID, Question: m.Questions[0],
+		Header: m.Header, ByteData: packed, Ctx: qctx, Cancel: cancel, Received: received, Conn: conn, Refresh: refresh, Trace: startTrace(m.Questions[0].Name.String(), m.ID, received),
+		Reply: func(res []byte) {
+			select {
+			case result <- res:
+			default:
+			}
+		}}
+	// the worker cancels qctx when it answers, so completion is detected on result and the caller's own deadline
+	timer := time.NewTimer(queryDeadline)
+	defer timer.Stop()
+	select {
+	case res := <-result:
+		return res, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, context.DeadlineExceeded
+	}
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	Builds the responses to one query so every answer path gets the same guarantees: the query's ID and question
+*	are echoed, the header follows the ResponseHeader rules and the sections are written in order with names
+*	compressed. A query with EDNS gets an OPT record back advertising outboundUDPSize with its DO bit echoed
+ */
+type responseBuilder struct {
+	header    dnsmessage.Header
+	questions []dnsmessage.Question
+	edns      bool
+	dnssecOK  bool
+}
+
+func newResponseBuilder(query []byte) (*responseBuilder, error) {
+	var m dnsmessage.Message
+	if err := m.Unpack(query); err != nil {
+		return nil, err
+	}
+	b := &responseBuilder{header: m.Header, questions: m.Questions}
+	for _, r := range m.Additionals {
+		if r.Header.Type == dnsmessage.TypeOPT {
+			b.edns, b.dnssecOK = true, r.Header.DNSSECAllowed()
+		}
+	}
+	return b, nil
+}
+
+/*
+*	NOERROR with answers, which must belong to the question or the CNAME chain leading from it
+ */
+func (b *responseBuilder) Answer(answers []dnsmessage.Resource, authoritative bool) ([]byte, error) {
+	return b.build(ResponseHeader(b.header, dnsmessage.RCodeSuccess, authoritative), answers, nil, nil)
+}
+
+/*
+*	NXDOMAIN, with the zone's SOA in the authority section when there is one so the answer can be cached negatively
+ */
+func (b *responseBuilder) NXDomain(soa *dnsmessage.Resource, authoritative bool) ([]byte, error) {
+	return b.build(ResponseHeader(b.header, dnsmessage.RCodeNameError, authoritative), nil, authority(soa), nil)
+}
+
+/*
+*	NOERROR without answers for a name that exists without records of the asked type, with an optional SOA
+ */
+func (b *responseBuilder) NoData(soa *dnsmessage.Resource, authoritative bool) ([]byte, error) {
+	return b.build(ResponseHeader(b.header, dnsmessage.RCodeSuccess, authoritative), nil, authority(soa), nil)
+}
+
+/*
+*	SERVFAIL, FORMERR, REFUSED and the other error rcodes, never authoritative and without records
+ */
+func (b *responseBuilder) Error(rcode dnsmessage.RCode) ([]byte, error) {
+	return b.build(ResponseHeader(b.header, rcode, false), nil, nil, nil)
+}
+
+/*
+*	TC set and no records, telling the client to ask again over TCP
+ */
+func (b *responseBuilder) Truncated(authoritative bool) ([]byte, error) {
+	header := ResponseHeader(b.header, dnsmessage.RCodeSuccess, authoritative)
+	header.Truncated = true
+	return b.build(header, nil, nil, nil)
+}
+
+/*
+*	A referral to a delegated child zone: no answers, the child's NS records as authority and their glue addresses as
+*	additional records. Never authoritative, that is left to the child's servers
+ */
+func (b *responseBuilder) Referral(ns, glue []dnsmessage.Resource) ([]byte, error) {
+	return b.build(ResponseHeader(b.header, dnsmessage.RCodeSuccess, false), nil, ns, glue)
+}
+
+func authority(soa *dnsmessage.Resource) []dnsmessage.Resource {
+	if soa == nil {
+		return nil
+	}
+	return []dnsmessage.Resource{*soa}
+}
+
+func (b *responseBuilder) build(header dnsmessage.Header, answers, authorities, additionals []dnsmessage.Resource) ([]byte, error) {
+	builder := dnsmessage.NewBuilder(make([]byte, 0, 512), header)
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	for _, q := range b.questions {
+		if err := builder.Question(q); err != nil {
+			return nil, err
+		}
+	}
+	if err := builder.StartAnswers(); err != nil {
+		return nil, err
+	}
+	for _, r := range answers {
+		if err := addResource(&builder, r); err != nil {
+			return nil, err
+		}
+	}
+	if err := builder.StartAuthorities(); err != nil {
+		return nil, err
+	}
+	for _, r := range authorities {
+		if err := addResource(&builder, r); err != nil {
+			return nil, err
+		}
+	}
+	if err := builder.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	for _, r := range additionals {
+		if err := addResource(&builder, r); err != nil {
+			return nil, err
+		}
+	}
+	if b.edns {
+		var h dnsmessage.ResourceHeader
+		if err := h.SetEDNS0(outboundUDPSize, dnsmessage.RCodeSuccess, b.dnssecOK); err != nil {
+			return nil, err
+		}
+		if err := builder.OPTResource(h, dnsmessage.OPTResource{}); err != nil {
+			return nil, err
+		}
+	}
+	return builder.Finish()
+}
+
+func addResource(builder *dnsmessage.Builder, r dnsmessage.Resource) error {
+	switch body := r.Body.(type) {
+	case *dnsmessage.AResource:
+		return builder.AResource(r.Header, *body)
+	case *dnsmessage.AAAAResource:
+		return builder.AAAAResource(r.Header, *body)
+	case *dnsmessage.CNAMEResource:
+		return builder.CNAMEResource(r.Header, *body)
+	case *dnsmessage.PTRResource:
+		return builder.PTRResource(r.Header, *body)
+	case *dnsmessage.TXTResource:
+		return builder.TXTResource(r.Header, *body)
+	case *dnsmessage.SOAResource:
+		return builder.SOAResource(r.Header, *body)
+	case *dnsmessage.MXResource:
+		return builder.MXResource(r.Header, *body)
+	case *dnsmessage.NSResource:
+		return builder.NSResource(r.Header, *body)
+	case *dnsmessage.SRVResource:
+		return builder.SRVResource(r.Header, *body)
+	case *dnsmessage.UnknownResource:
+		return builder.UnknownResource(r.Header, *body)
+	}
+	return errors.New(fmt.Sprintf("can't add a %v record to a response", r.Header.Type))
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/TasSM/labns/internal/wirecorpus"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var responseDir = filepath.Join("..", "..", "testdata", "response")
+
+/*
+*	Builds each response shape for the query of its golden file in testdata/response, which holds the exact bytes
+ */
+var responseShapes = map[string]func(t *testing.T, b *responseBuilder) ([]byte, error){
+	"answer-rrset": func(t *testing.T, b *responseBuilder) ([]byte, error) {
+		name := dnsmessage.MustNewName("nas.lab.home.")
+		header := dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 300}
+		return b.Answer([]dnsmessage.Resource{
+			{Header: header, Body: &dnsmessage.AResource{A: [4]byte{192, 168, 1, 10}}},
+			{Header: header, Body: &dnsmessage.AResource{A: [4]byte{192, 168, 1, 11}}},
+		}, true)
+	},
+	"nxdomain-soa": func(t *testing.T, b *responseBuilder) ([]byte, error) {
+		soa := testSOA(t)
+		return b.NXDomain(&soa, true)
+	},
+	"nodata-soa": func(t *testing.T, b *responseBuilder) ([]byte, error) {
+		soa := testSOA(t)
+		return b.NoData(&soa, true)
+	},
+	"servfail": func(t *testing.T, b *responseBuilder) ([]byte, error) {
+		return b.Error(dnsmessage.RCodeServerFailure)
+	},
+	"formerr": func(t *testing.T, b *responseBuilder) ([]byte, error) {
+		return b.Error(dnsmessage.RCodeFormatError)
+	},
+	"refused": func(t *testing.T, b *responseBuilder) ([]byte, error) {
+		return b.Error(dnsmessage.RCodeRefused)
+	},
+	"truncated": func(t *testing.T, b *responseBuilder) ([]byte, error) {
+		return b.Truncated(false)
+	},
+}
+
+func testSOA(t *testing.T) dnsmessage.Resource {
+	t.Helper()
+	soa, err := syntheticSOA(dnsmessage.MustNewName("lab.home."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return soa
+}
+
+/*
+*	Compares each shape byte for byte, ID and TTLs included since nothing in them varies. With -update the bytes
+*	built are written into the golden files instead
+ */
+func TestResponseShapes(t *testing.T) {
+	cases, err := wirecorpus.Load(responseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for k := range cases {
+		c := &cases[k]
+		build, ok := responseShapes[c.Name]
+		if !ok {
+			t.Errorf("golden file %s has no response shape", c.Path)
+			continue
+		}
+		seen[c.Name] = true
+		t.Run(c.Name, func(t *testing.T) {
+			b, err := newResponseBuilder(c.Query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := build(t, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *updateGolden {
+				c.Response = res
+				if err := c.Save(); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !bytes.Equal(res, c.Response) {
+				t.Errorf("response differs from the golden copy:\n%s", wirecorpus.FormatHex(res, "+ ")+wirecorpus.FormatHex(c.Response, "- "))
+			}
+		})
+	}
+	for name := range responseShapes {
+		if !seen[name] {
+			t.Errorf("response shape %s has no golden file in %s", name, responseDir)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const privateReverseTTL = 10800
+
+/*
+*	RFC 6303 reverse zones for RFC 1918 and ULA space, queries for them are answered locally so they never
+*	reach public resolvers
+ */
+var privateReverseZones = func() localZones {
+	zones := []string{"10.in-addr.arpa.", "168.192.in-addr.arpa.", "d.f.ip6.arpa."}
+	for i := 16; i <= 31; i++ {
+		zones = append(zones, fmt.Sprintf("%d.172.in-addr.arpa.", i))
+	}
+	return newLocalZones(zones)
+}()
+
+/*
+*	Answers a query under a private reverse zone: NXDOMAIN with the zone's synthetic SOA, or the SOA itself
+*	for an SOA query at the apex
+ */
+func BuildPrivateReverseResponse(query []byte, question dnsmessage.Question, zone string) ([]byte, error) {
+	b, err := newResponseBuilder(query)
+	if err != nil {
+		return nil, err
+	}
+	apex, err := dnsmessage.NewName(zone)
+	if err != nil {
+		return nil, err
+	}
+	soa, err := syntheticSOA(apex)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(question.Name.String(), zone) {
+		return b.NXDomain(&soa, true)
+	}
+	if question.Type == dnsmessage.TypeSOA {
+		return b.Answer([]dnsmessage.Resource{soa}, true)
+	}
+	return b.NoData(&soa, true)
+}
+
+/*
+*	The SOA suggested by RFC 6303 section 3: the zone as its own primary, nobody.invalid. as contact and a 3 hour negative TTL
+ */
+func syntheticSOA(apex dnsmessage.Name) (dnsmessage.Resource, error) {
+	contact, err := dnsmessage.NewName("nobody.invalid.")
+	if err != nil {
+		return dnsmessage.Resource{}, err
+	}
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: apex, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: privateReverseTTL},
+		Body:   &dnsmessage.SOAResource{NS: apex, MBox: contact, Serial: 1, Refresh: 3600, Retry: 1200, Expire: 604800, MinTTL: privateReverseTTL},
+	}, nil
+}
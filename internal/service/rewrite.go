@@ -0,0 +1,87 @@
+package service
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+	"github.com/TasSM/labns/internal/stats"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type answerRewrite struct {
+	index   int
+	domains localZones
+	match   *net.IPNet
+	replace net.IP
+}
+
+/*
+*	The AnswerRewrites in configuration order, the first one matching an address rewrites it
+ */
+type answerRewrites []answerRewrite
+
+func newAnswerRewrites(rewrites []config.AnswerRewrite) answerRewrites {
+	out := make(answerRewrites, 0, len(rewrites))
+	for k, v := range rewrites {
+		// both were checked by ReadConfig
+		match, _ := config.ParseClientAddress(v.Match)
+		out = append(out, answerRewrite{index: k, domains: newLocalZones(v.Domains), match: match, replace: net.ParseIP(v.Replace)})
+	}
+	return out
+}
+
+func (r answerRewrites) find(owner, qname string, ip net.IP) *answerRewrite {
+	for k := range r {
+		if r[k].match.Contains(ip) && (r[k].domains.Contains(owner) || r[k].domains.Contains(qname)) {
+			return &r[k]
+		}
+	}
+	return nil
+}
+
+/*
+*	Replaces the addresses of A/AAAA answers matched by a rewrite, for records owned by or answered for one of its
+*	domains, reporting whether anything changed. Each rewrite is logged with the address it replaced
+ */
+func (r answerRewrites) apply(packet []byte, qname string) ([]byte, bool) {
+	if len(r) == 0 {
+		return packet, false
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(packet); err != nil {
+		return packet, false
+	}
+	changed := false
+	for k := range m.Answers {
+		owner := m.Answers[k].Header.Name.String()
+		switch body := m.Answers[k].Body.(type) {
+		case *dnsmessage.AResource:
+			if rw := r.find(owner, qname, net.IP(body.A[:])); rw != nil {
+				logRewrite(rw, "A", owner, net.IP(body.A[:]))
+				copy(body.A[:], rw.replace.To4())
+				changed = true
+			}
+		case *dnsmessage.AAAAResource:
+			if rw := r.find(owner, qname, net.IP(body.AAAA[:])); rw != nil {
+				logRewrite(rw, "AAAA", owner, net.IP(body.AAAA[:]))
+				copy(body.AAAA[:], rw.replace.To16())
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return packet, false
+	}
+	packed, err := m.Pack()
+	if err != nil {
+		return packet, false
+	}
+	return packed, true
+}
+
+func logRewrite(rw *answerRewrite, qtype, owner string, original net.IP) {
+	stats.Increment(stats.AnswerRewritten)
+	logging.LogEvery(logging.LogInfo, fmt.Sprintf("Rewrote %s answer for %s from %v to %v (AnswerRewrite %d)", qtype, logging.Name(owner), original, rw.replace, rw.index))
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"net"
+	"testing"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func answerAddress(t *testing.T, res dnsmessage.Message) string {
+	t.Helper()
+	if len(res.Answers) != 1 {
+		t.Fatalf("got %d answers, want 1", len(res.Answers))
+	}
+	switch body := res.Answers[0].Body.(type) {
+	case *dnsmessage.AResource:
+		return net.IP(body.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		return net.IP(body.AAAA[:]).String()
+	}
+	t.Fatalf("answer is a %s record, want A or AAAA", res.Answers[0].Header.Type)
+	return ""
+}
+
+func TestAnswerRewritesOnlyMatchingAddresses(t *testing.T) {
+	conf := testConfig(t)
+	up := newUpstream(t)
+	up.Handle("edge.cdn.test.", dnsmessage.TypeA, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.A("edge.cdn.test.", 60, "203.0.113.7")}})
+	up.Handle("edge.cdn.test.", dnsmessage.TypeAAAA, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.AAAA("edge.cdn.test.", 60, "2001:db8:bad::7")}})
+	up.Handle("other.cdn.test.", dnsmessage.TypeA, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.A("other.cdn.test.", 60, "203.0.113.77")}})
+	up.Handle("other.cdn.test.", dnsmessage.TypeAAAA, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.AAAA("other.cdn.test.", 60, "2001:db8:600d::7")}})
+	forwardTo(conf, "cdn.test.", up)
+	conf.AnswerRewrites = []config.AnswerRewrite{
+		{Domains: []string{"cdn.test."}, Match: "203.0.113.0/29", Replace: "203.0.113.9"},
+		{Domains: []string{"cdn.test."}, Match: "2001:db8:bad::/48", Replace: "2001:db8:bad::9"},
+	}
+	reload(t, conf)
+
+	cases := []struct {
+		name  string
+		qtype dnsmessage.Type
+		want  string
+	}{
+		{"edge.cdn.test.", dnsmessage.TypeA, "203.0.113.9"},
+		{"edge.cdn.test.", dnsmessage.TypeAAAA, "2001:db8:bad::9"},
+		{"other.cdn.test.", dnsmessage.TypeA, "203.0.113.77"},
+		{"other.cdn.test.", dnsmessage.TypeAAAA, "2001:db8:600d::7"},
+	}
+	// the second round is answered from the cache, which must hold the rewritten form
+	for round := 0; round < 2; round++ {
+		for _, c := range cases {
+			if got := answerAddress(t, lookup(t, c.name, c.qtype, 0)); got != c.want {
+				t.Errorf("round %d: %s %s answered %s, want %s", round, c.name, c.qtype, got, c.want)
+			}
+		}
+	}
+	if n := len(up.Queries()); n != len(cases) {
+		t.Fatalf("upstream received %d queries, want %d with the second round cached", n, len(cases))
+	}
+}
+
+func TestAnswerRewritesOnlyApplyToTheirDomains(t *testing.T) {
+	rewrites := newAnswerRewrites([]config.AnswerRewrite{{Domains: []string{"cdn.test."}, Match: "203.0.113.7", Replace: "203.0.113.9"}})
+	query, err := BuildQuery("www.elsewhere.test.", dnsmessage.TypeA, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var q dnsmessage.Message
+	q.Unpack(query)
+	res, err := dnstest.Build(q, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.A("www.elsewhere.test.", 60, "203.0.113.7")}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, changed := rewrites.apply(res, "www.elsewhere.test."); changed {
+		t.Fatal("an address matching a rewrite was rewritten outside the rewrite's domains")
+	}
+	if _, changed := rewrites.apply(res, "www.cdn.test."); !changed {
+		t.Fatal("an answer for a name inside the rewrite's domains was not rewritten")
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+	"github.com/TasSM/labns/internal/stats"
+)
+
+const (
+	resourceCheckInterval = 10 * time.Second
+	// the goroutine profile logged with a warning is cut to this many bytes
+	resourceProfileBytes = 8192
+)
+
+type routineKind int
+
+const (
+	routineReply routineKind = iota
+	routineUpstreamSend
+	routineUpstreamWait
+	routineUpstreamExchange
+	routineRefresh
+	routineWarmup
+	routineFaultDelay
+	routineDrift
+	routineHook
+	routineTask
+	routineKinds
+)
+
+var routineNames = [routineKinds]string{"reply", "upstream_send", "upstream_wait", "upstream_exchange", "refresh", "warmup", "fault_delay", "drift", "hook", "task"}
+
+var (
+	routineCounts    [routineKinds]int64
+	resourceLimits   atomic.Value
+	resourceWarnings = &logging.RateLimited{Interval: 10 * time.Minute}
+	fileWarnings     = &logging.RateLimited{Interval: 10 * time.Minute}
+	overloadWarnings = &logging.RateLimited{Interval: 10 * time.Second}
+)
+
+/*
+*	Loads the ResourceLimits settings, a reload replaces them
+ */
+func SetResourceLimits(l *config.ResourceLimits) {
+	resourceLimits.Store(l)
+}
+
+/*
+*	Runs f on a new goroutine counted under kind until it returns
+ */
+func spawn(kind routineKind, f func()) {
+	atomic.AddInt64(&routineCounts[kind], 1)
+	go func() {
+		defer atomic.AddInt64(&routineCounts[kind], -1)
+		f()
+	}()
+}
+
+/*
+*	Reports whether the process runs MaxGoroutines or more goroutines, new work that would add more is shed or
+*	done inline instead
+ */
+func overloaded() bool {
+	l, _ := resourceLimits.Load().(*config.ResourceLimits)
+	return l != nil && runtime.NumGoroutine() >= int(l.MaxGoroutines)
+}
+
+/*
+*	Runs the reply or upstream send f on its own goroutine, or inline when overloaded so sending never adds to the
+*	goroutine count past the cap
+ */
+func spawnSend(kind routineKind, f func()) {
+	if overloaded() {
+		f()
+		return
+	}
+	spawn(kind, f)
+}
+
+/*
+*	Publishes the goroutine counts and open files as stats every resourceCheckInterval and logs a warning with a
+*	goroutine profile when they pass WarnGoroutines or WarnOpenFilesPercent of the file limit
+ */
+func watchResources() {
+	for range time.Tick(resourceCheckInterval) {
+		l, _ := resourceLimits.Load().(*config.ResourceLimits)
+		total := runtime.NumGoroutine()
+		stats.Set(stats.Goroutines, uint64(total))
+		parts := make([]string, 0, routineKinds)
+		for k := range routineNames {
+			n := atomic.LoadInt64(&routineCounts[k])
+			stats.Set(stats.RoutineCount(routineNames[k]), uint64(n))
+			parts = append(parts, fmt.Sprintf("%s=%d", routineNames[k], n))
+		}
+		files, limit, ok := openFiles()
+		if ok {
+			stats.Set(stats.OpenFiles, uint64(files))
+		}
+		if l == nil {
+			continue
+		}
+		if total >= int(l.WarnGoroutines) {
+			resourceWarnings.LogMessage(logging.LogError, fmt.Sprintf("%d goroutines running, above WarnGoroutines of %d (%s), goroutine profile:\n%s",
+				total, l.WarnGoroutines, strings.Join(parts, " "), goroutineProfile()))
+		}
+		if ok && limit > 0 && uint64(files)*100 >= limit*uint64(l.WarnOpenFilesPercent) {
+			fileWarnings.LogMessage(logging.LogError, fmt.Sprintf("%d of the %d open files allowed are in use, above WarnOpenFilesPercent of %d%%",
+				files, limit, l.WarnOpenFilesPercent))
+		}
+	}
+}
+
+func goroutineProfile() string {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return err.Error()
+	}
+	if buf.Len() > resourceProfileBytes {
+		return string(buf.Bytes()[:resourceProfileBytes]) + "\n(truncated)"
+	}
+	return buf.String()
+}
@@ -0,0 +1,27 @@
+package service
+
+import (
+	"os"
+	"syscall"
+)
+
+/*
+*	Counts the open file descriptors from /proc and reads the soft RLIMIT_NOFILE
+ */
+func openFiles() (int, uint64, bool) {
+	dir, err := os.Open("/proc/self/fd")
+	if err != nil {
+		return 0, 0, false
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return 0, 0, false
+	}
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return len(names), 0, true
+	}
+	// the directory being read holds one descriptor itself
+	return len(names) - 1, limit.Cur, true
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package service
+
+// open files are only counted on Linux
+func openFiles() (int, uint64, bool) {
+	return 0, 0, false
+}
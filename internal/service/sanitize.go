@@ -0,0 +1,110 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+
+	"github.com/TasSM/labns/internal/dnsname"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	flagCheckingDisabled = 0x10
+	flagAuthenticData    = 0x20
+)
+
+// the UDP payload size advertised upstream, the DNS flag day 2020 recommendation
+const outboundUDPSize = 1232
+
+/*
+*	Rebuilds a client query for forwarding with only what resolution needs: the question, RD, CD and, when the
+*	client sent EDNS, an OPT with our own payload size and the client's DO bit. Every EDNS option, including
+*	cookies, padding and client subnet, is left out so nothing identifies the client or its software
+ */
+func sanitizeQuery(query []byte, id uint16) ([]byte, error) {
+	var m dnsmessage.Message
+	if err := m.Unpack(query); err != nil {
+		return nil, err
+	}
+	if len(m.Questions) != 1 {
+		return nil, errors.New("query must have exactly one question")
+	}
+	out := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, OpCode: m.Header.OpCode, RecursionDesired: true},
+		Questions: m.Questions,
+	}
+	for _, r := range m.Additionals {
+		if r.Header.Type != dnsmessage.TypeOPT {
+			continue
+		}
+		var h dnsmessage.ResourceHeader
+		if err := h.SetEDNS0(outboundUDPSize, dnsmessage.RCodeSuccess, r.Header.DNSSECAllowed()); err != nil {
+			return nil, err
+		}
+		out.Additionals = []dnsmessage.Resource{{Header: h, Body: &dnsmessage.OPTResource{}}}
+		break
+	}
+	packed, err := out.Pack()
+	if err != nil {
+		return nil, err
+	}
+	// dnsmessage has no CD flag, it is copied from the query header as is
+	packed[3] |= query[3] & flagCheckingDisabled
+	return packed, nil
+}
+
+/*
+*	Picks a random query ID that no pending request is using, IDs are drawn from crypto/rand so they can't be
+*	predicted by someone forging upstream responses
+ */
+func freshRequestId() uint16 {
+	var b [2]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			continue
+		}
+		id := binary.BigEndian.Uint16(b[:])
+		if id != 0 && stateMap[id] == nil {
+			return id
+		}
+	}
+}
+
+/*
+*	Identifies a client query for spotting retransmissions: the client's socket, its query ID and the question key.
+*	Two clients, or one client asking two questions, with the same ID are never mistaken for each other
+ */
+type clientQueryKey struct {
+	Addr     string
+	ID       uint16
+	Question string
+}
+
+/*
+*	Forgets a pending request by the ID it was forwarded with, along with the client query pointing at it
+ */
+func removePending(id uint16) {
+	if p := stateMap[id]; p != nil {
+		if fid, ok := forwardedIds[p.ClientKey]; ok && fid == id {
+			delete(forwardedIds, p.ClientKey)
+		}
+	}
+	delete(stateMap, id)
+}
+
+/*
+*	Reports whether the first question of an upstream response is the forwarded one, in any case, so an answer
+*	carrying a pending ID can only be delivered to the query that asked it
+ */
+func answersQuestion(packet []byte, name string, qtype dnsmessage.Type) bool {
+	var p dnsmessage.Parser
+	if _, err := p.Start(packet); err != nil {
+		return false
+	}
+	q, err := p.Question()
+	if err != nil {
+		return false
+	}
+	return q.Type == qtype && q.Class == dnsmessage.ClassINET && dnsname.Key(q.Name.String()) == dnsname.Key(name)
+}
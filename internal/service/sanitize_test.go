@@ -0,0 +1,113 @@
+package service
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/TasSM/labns/internal/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	A TXT answer of about 900 bytes, past the 512 bytes of plain DNS but within the payload size labns advertises
+ */
+func largeAnswer(name string) []dnsmessage.Resource {
+	var txt []string
+	for k := 0; k < 10; k++ {
+		txt = append(txt, strings.Repeat(string(rune('a'+k)), 80))
+	}
+	return []dnsmessage.Resource{dnstest.TXT(name, 60, txt...)}
+}
+
+func TestForwardsResponseOver512Bytes(t *testing.T) {
+	conf := testConfig(t)
+	up := newUpstream(t)
+	up.Handle("big.large.test.", dnsmessage.TypeTXT, dnstest.Response{Answers: largeAnswer("big.large.test.")})
+	forwardTo(conf, "large.test.", up)
+	reload(t, conf)
+
+	res := lookup(t, "big.large.test.", dnsmessage.TypeTXT, 1232)
+	if res.Header.Truncated || res.Header.RCode != dnsmessage.RCodeSuccess || len(res.Answers) != 1 {
+		t.Fatalf("got %s tc=%t with %d answers, want the whole answer", res.Header.RCode, res.Header.Truncated, len(res.Answers))
+	}
+	txt := res.Answers[0].Body.(*dnsmessage.TXTResource).TXT
+	if len(txt) != 10 || txt[9] != strings.Repeat("j", 80) {
+		t.Fatalf("TXT answer arrived incomplete: %d strings", len(txt))
+	}
+	if packed, _ := res.Pack(); len(packed) <= 512 {
+		t.Fatalf("response is %d bytes, the test needs one over 512", len(packed))
+	}
+}
+
+func TestTruncatesLargeResponseForClientWithoutEDNS(t *testing.T) {
+	conf := testConfig(t)
+	up := newUpstream(t)
+	up.Handle("big.large.test.", dnsmessage.TypeTXT, dnstest.Response{Answers: largeAnswer("big.large.test.")})
+	forwardTo(conf, "large.test.", up)
+	reload(t, conf)
+
+	res := lookup(t, "big.large.test.", dnsmessage.TypeTXT, 0)
+	if !res.Header.Truncated || len(res.Answers) != 0 || res.Header.ID != 0x4242 {
+		t.Fatalf("got tc=%t with %d answers and ID %#x, want an empty truncated response with the query ID", res.Header.Truncated, len(res.Answers), res.Header.ID)
+	}
+	if len(res.Questions) != 1 || res.Questions[0].Name.String() != "big.large.test." {
+		t.Fatalf("truncated response does not echo the question: %v", res.Questions)
+	}
+}
+
+/*
+*	A query as dig sends it with +cookie and an option labns has no use for, which must not reach the upstream
+ */
+func TestSanitizeOutboundStripsClientMetadata(t *testing.T) {
+	conf := testConfig(t)
+	up := newUpstream(t)
+	up.Handle("host.sanitize.test.", dnsmessage.TypeA, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.A("host.sanitize.test.", 60, "192.0.2.9")}})
+	forwardTo(conf, "sanitize.test.", up)
+	reload(t, conf)
+
+	var h dnsmessage.ResourceHeader
+	h.SetEDNS0(4096, dnsmessage.RCodeSuccess, true)
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 0x1234, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName("host.sanitize.test."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+		Additionals: []dnsmessage.Resource{{Header: h, Body: &dnsmessage.OPTResource{Options: []dnsmessage.Option{
+			{Code: ednsOptionCookie, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+			{Code: 65001, Data: []byte("client-build-1.2.3")},
+		}}}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exchange(t, packed)
+
+	queries := up.Queries()
+	if len(queries) != 1 {
+		t.Fatalf("upstream received %d queries, want 1", len(queries))
+	}
+	var sent dnsmessage.Message
+	if err := sent.Unpack(queries[0].Raw); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent.Additionals) != 1 {
+		t.Fatalf("forwarded query has %d additional records, want only the OPT", len(sent.Additionals))
+	}
+	opt := sent.Additionals[0]
+	if n := len(opt.Body.(*dnsmessage.OPTResource).Options); n != 0 {
+		t.Fatalf("forwarded OPT carries %d options, want none", n)
+	}
+	if int(opt.Header.Class) != outboundUDPSize || !opt.Header.DNSSECAllowed() {
+		t.Fatalf("forwarded OPT advertises %d bytes do=%t, want %d with the client's DO bit", opt.Header.Class, opt.Header.DNSSECAllowed(), outboundUDPSize)
+	}
+	if bytes.Contains(queries[0].Raw, []byte("client-build")) {
+		t.Fatal("forwarded query still contains the client's option data")
+	}
+	want, err := sanitizeQuery(packed, sent.Header.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(queries[0].Raw, want) {
+		t.Fatalf("forwarded query differs from the rebuilt one:\n got  %x\n want %x", queries[0].Raw, want)
+	}
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/TasSM/labns/internal/config"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	Reports whether name has exactly one label, the root itself is not single-label
+ */
+func isSingleLabel(name string) bool {
+	return name != "." && strings.Count(strings.TrimSuffix(name, "."), ".") == 0
+}
+
+/*
+*	Search domains applied to queries from matching client networks, the most specific network wins
+ */
+type clientSearchDomains []clientSearchDomain
+
+type clientSearchDomain struct {
+	network *net.IPNet
+	domain  string
+}
+
+func newClientSearchDomains(domains map[string]string) clientSearchDomains {
+	var out clientSearchDomains
+	for cidr, domain := range domains {
+		network, err := config.ParseClientAddress(cidr)
+		if err != nil {
+			continue
+		}
+		out = append(out, clientSearchDomain{network: network, domain: domain})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, _ := out[i].network.Mask.Size()
+		b, _ := out[j].network.Mask.Size()
+		return a > b
+	})
+	return out
+}
+
+func (c clientSearchDomains) Match(client net.IP) string {
+	for _, v := range c {
+		if v.network.Contains(client) {
+			return v.domain
+		}
+	}
+	return ""
+}
+
+/*
+*	Answers a query found under a search domain with a CNAME to the expanded name followed by that name's local record,
+*	so the answer still matches the question the client asked
+ */
+func BuildSearchDomainResponse(query []byte, question dnsmessage.Question, expanded string, record []byte) ([]byte, error) {
+	var local dnsmessage.Message
+	if err := local.Unpack(record); err != nil {
+		return nil, err
+	}
+	target, err := dnsmessage.NewName(expanded)
+	if err != nil {
+		return nil, err
+	}
+	var ttl uint32
+	if len(local.Answers) > 0 {
+		ttl = local.Answers[0].Header.TTL
+	}
+	cname := dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   &dnsmessage.CNAMEResource{CNAME: target},
+	}
+	b, err := newResponseBuilder(query)
+	if err != nil {
+		return nil, err
+	}
+	return b.Answer(append([]dnsmessage.Resource{cname}, local.Answers...), true)
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"net"
+	"testing"
+
+	"github.com/TasSM/labns/internal/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	Counts the queries for name that reached s, the default upstreams are shared by every test
+ */
+func queriesFor(s *dnstest.Server, name string) int {
+	n := 0
+	for _, q := range s.Queries() {
+		if q.Question.Name.String() == name {
+			n++
+		}
+	}
+	return n
+}
+
+func TestIsSingleLabel(t *testing.T) {
+	cases := map[string]bool{"nas.": true, "nas": true, ".": false, "nas.lab.home.": false, "com.": true, "lab.home": false}
+	for name, want := range cases {
+		if got := isSingleLabel(name); got != want {
+			t.Errorf("isSingleLabel(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestSingleLabelNameIsNotForwarded(t *testing.T) {
+	before := queriesFor(primary, "nas.") + queriesFor(secondary, "nas.")
+	res := lookup(t, "nas.", dnsmessage.TypeA, 0)
+	if res.Header.RCode != dnsmessage.RCodeNameError || len(res.Answers) != 0 {
+		t.Fatalf("single-label query answered %s with %d answers, want NXDOMAIN", res.Header.RCode, len(res.Answers))
+	}
+	if got := queriesFor(primary, "nas.") + queriesFor(secondary, "nas.") - before; got != 0 {
+		t.Fatalf("single-label query reached the upstreams %d times", got)
+	}
+}
+
+func TestSingleLabelNameForwardedWhenAllowed(t *testing.T) {
+	conf := testConfig(t)
+	never := false
+	conf.NeverForwardSingleLabel = &never
+	reload(t, conf)
+	before := queriesFor(primary, "com.")
+	res := lookup(t, "com.", dnsmessage.TypeNS, 0)
+	// the corpus upstream doesn't know com. and answers NXDOMAIN itself
+	if res.Header.RCode != dnsmessage.RCodeNameError {
+		t.Fatalf("forwarded single-label query answered %s, want the upstream NXDOMAIN", res.Header.RCode)
+	}
+	if got := queriesFor(primary, "com.") - before; got != 1 {
+		t.Fatalf("single-label query reached the primary upstream %d times, want 1", got)
+	}
+}
+
+func TestRootIsForwarded(t *testing.T) {
+	before := queriesFor(primary, ".")
+	lookup(t, ".", dnsmessage.TypeNS, 0)
+	if got := queriesFor(primary, ".") - before; got != 1 {
+		t.Fatalf("query for the root reached the primary upstream %d times, want 1", got)
+	}
+}
+
+func TestSearchDomainAnswersWithCNAME(t *testing.T) {
+	conf := testConfig(t)
+	conf.SearchDomain = "lab.home."
+	reload(t, conf)
+
+	res := lookup(t, "nas.", dnsmessage.TypeA, 0)
+	if res.Header.RCode != dnsmessage.RCodeSuccess || len(res.Answers) != 2 {
+		t.Fatalf("nas. under the search domain answered %s with %d answers, want a CNAME and the A record", res.Header.RCode, len(res.Answers))
+	}
+	cname, ok := res.Answers[0].Body.(*dnsmessage.CNAMEResource)
+	if !ok || res.Answers[0].Header.Name.String() != "nas." || cname.CNAME.String() != "nas.lab.home." {
+		t.Fatalf("first answer is %v, want nas. CNAME nas.lab.home.", res.Answers[0])
+	}
+	a, ok := res.Answers[1].Body.(*dnsmessage.AResource)
+	if !ok || res.Answers[1].Header.Name.String() != "nas.lab.home." || net.IP(a.A[:]).String() != "192.168.1.10" {
+		t.Fatalf("second answer is %v, want the local record of nas.lab.home.", res.Answers[1])
+	}
+	if !res.Header.Authoritative {
+		t.Fatal("answer from the local record under the search domain is not authoritative")
+	}
+
+	// the search domain holds no such name, so the single-label rule still applies
+	before := queriesFor(primary, "scanner.")
+	if res := lookup(t, "scanner.", dnsmessage.TypeA, 0); res.Header.RCode != dnsmessage.RCodeNameError {
+		t.Fatalf("scanner. answered %s, want NXDOMAIN", res.Header.RCode)
+	}
+	if got := queriesFor(primary, "scanner.") - before; got != 0 {
+		t.Fatalf("single-label name missing under the search domain was forwarded %d times", got)
+	}
+	// a name with more than one label is never expanded
+	if res := lookup(t, "nas.lab.", dnsmessage.TypeA, 0); len(res.Answers) != 0 {
+		t.Fatalf("nas.lab. got %d answers, want it left alone by the search domain", len(res.Answers))
+	}
+}
+
+func TestClientSearchDomain(t *testing.T) {
+	conf := testConfig(t)
+	conf.ClientSearchDomains = map[string]string{"127.0.0.0/8": "lab.home."}
+	reload(t, conf)
+
+	res := lookup(t, "printer.", dnsmessage.TypeA, 0)
+	if len(res.Answers) != 2 || res.Answers[1].Header.Name.String() != "printer.lab.home." {
+		t.Fatalf("printer. from a client with a search domain got %v, want a CNAME to printer.lab.home. and its record", res.Answers)
+	}
+	// names inside a local zone are answered as asked
+	if res := lookup(t, "nas.lab.home.", dnsmessage.TypeA, 0); len(res.Answers) != 1 {
+		t.Fatalf("nas.lab.home. got %d answers, want its own record alone", len(res.Answers))
+	}
+}
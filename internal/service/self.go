@@ -0,0 +1,111 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/TasSM/labns/internal/dnsname"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	Names labns answers about itself: SelfHostname resolves to the listen addresses and each listen address
+*	has a PTR back to it, without SelfHostname the reverse names are still kept from going upstream
+ */
+type selfRecords struct {
+	hostname string
+	addrs    []net.IP
+	reverse  map[string]bool
+}
+
+func newSelfRecords(hostname string, conns []*net.UDPConn) *selfRecords {
+	s := &selfRecords{hostname: hostname, reverse: make(map[string]bool)}
+	for _, ip := range listenIPs(conns) {
+		s.reverse[reverseName(ip)] = true
+		if !ip.IsLoopback() {
+			s.addrs = append(s.addrs, ip)
+		}
+	}
+	return s
+}
+
+/*
+*	The addresses the listeners are bound to, a listener on an unspecified address answers on every
+*	non link-local address of its family
+ */
+func listenIPs(conns []*net.UDPConn) []net.IP {
+	var out []net.IP
+	seen := make(map[string]bool)
+	add := func(ip net.IP) {
+		if !seen[ip.String()] {
+			seen[ip.String()] = true
+			out = append(out, ip)
+		}
+	}
+	for _, c := range conns {
+		local := c.LocalAddr().(*net.UDPAddr)
+		if !local.IP.IsUnspecified() {
+			add(local.IP)
+			continue
+		}
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			// a "::" socket is dual stack unless an IPv4 socket was bound alongside it
+			if local.IP.To4() != nil && ipNet.IP.To4() == nil {
+				continue
+			}
+			add(ipNet.IP)
+		}
+	}
+	return out
+}
+
+func reverseName(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", v4[3], v4[2], v4[1], v4[0])
+	}
+	var b strings.Builder
+	v6 := ip.To16()
+	for i := len(v6) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "%x.%x.", v6[i]&0x0f, v6[i]>>4)
+	}
+	b.WriteString("ip6.arpa.")
+	return b.String()
+}
+
+func (s *selfRecords) IsHostname(name string) bool {
+	return s.hostname != "" && strings.EqualFold(name, s.hostname)
+}
+
+func (s *selfRecords) IsReverse(name string) bool {
+	return s.reverse[dnsname.Key(name)]
+}
+
+/*
+*	Answers a PTR query for a listen address with SelfHostname, other query types for the name get NODATA
+ */
+func BuildPTRResponse(query []byte, question dnsmessage.Question, target string, ttl uint32) ([]byte, error) {
+	b, err := newResponseBuilder(query)
+	if err != nil {
+		return nil, err
+	}
+	if question.Type != dnsmessage.TypePTR {
+		return b.NoData(nil, true)
+	}
+	ptr, err := dnsmessage.NewName(target)
+	if err != nil {
+		return nil, err
+	}
+	return b.Answer([]dnsmessage.Resource{{
+		Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   &dnsmessage.PTRResource{PTR: ptr},
+	}}, true)
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const selfCheckTimeout = time.Second
+
+/*
+*	Sends a query for the health name to every listener from a separate socket once the service is running, and
+*	logs a warning naming the listener when no answer arrives. A listener bound to a wildcard address is tested
+*	through a concrete address of the host, since the bind succeeding says nothing about a firewall in front of it
+ */
+func runSelfCheck(conns []*net.UDPConn, conf *config.Configuration) {
+	name := "health." + conf.HealthRecords.Suffix
+	for _, conn := range conns {
+		listen := conn.LocalAddr().(*net.UDPAddr)
+		target := &net.UDPAddr{IP: selfCheckAddress(listen.IP), Port: listen.Port}
+		elapsed, err := selfCheck(target, name)
+		if err != nil {
+			logging.LogEvery(logging.LogError, "**********************************************************************")
+			logging.LogMessage(logging.LogError, fmt.Sprintf("Self-check failed: labns is listening on %s but a query sent to %s from this host got no answer (%v)", listen, target, err))
+			logging.LogEvery(logging.LogError, fmt.Sprintf("The socket is bound, so packets to UDP port %d are most likely dropped by the host firewall (nftables, iptables or firewalld)", listen.Port))
+			logging.LogEvery(logging.LogError, "**********************************************************************")
+			continue
+		}
+		logging.LogMessage(logging.LogInfo, fmt.Sprintf("Self-check passed: a query sent to %s was answered by the listener on %s in %s", target, listen, elapsed.Round(time.Microsecond)))
+	}
+}
+
+/*
+*	The address the self-check sends to for a listener bound to ip, the first global address of the same family
+*	for a wildcard listener and the loopback address when the host has none
+ */
+func selfCheckAddress(ip net.IP) net.IP {
+	if !ip.IsUnspecified() {
+		return ip
+	}
+	v4 := ip.To4() != nil
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok || ipNet.IP.IsLoopback() || !ipNet.IP.IsGlobalUnicast() || (ipNet.IP.To4() != nil) != v4 {
+				continue
+			}
+			return ipNet.IP
+		}
+	}
+	if v4 {
+		return net.IPv4(127, 0, 0, 1)
+	}
+	return net.IPv6loopback
+}
+
+func selfCheck(target *net.UDPAddr, name string) (time.Duration, error) {
+	id := uint16(rand.Intn(1<<16-1) + 1)
+	query, err := BuildQuery(name, dnsmessage.TypeTXT, id)
+	if err != nil {
+		return 0, err
+	}
+	conn, err := net.DialUDP("udp", nil, target)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	start := time.Now()
+	conn.SetDeadline(start.Add(selfCheckTimeout))
+	if _, err := conn.Write(query); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 512)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return 0, errors.New(fmt.Sprintf("no answer within %s", selfCheckTimeout))
+			}
+			return 0, err
+		}
+		if n >= 12 && uint16(buf[0])<<8|uint16(buf[1]) == id {
+			return time.Since(start), nil
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnstest"
+	"github.com/TasSM/labns/internal/logging"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const exchangeTimeout = 2 * time.Second
+
+/*
+*	The service keeps process wide state, so one instance is started for the whole package and tests change what it
+*	serves with a reload. It runs with testdata/wire/config.json, forwarding to a primary and secondary upstream
+*	scripted with the answers the wire corpus was recorded against
+ */
+var (
+	primary    *dnstest.Server
+	secondary  *dnstest.Server
+	listenAddr string
+)
+
+var corpusDir = filepath.Join("..", "..", "testdata", "wire")
+
+func TestMain(m *testing.M) {
+	go logging.InitLogging(os.DevNull)
+	code, err := runService(m)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	os.Exit(code)
+}
+
+func runService(m *testing.M) (int, error) {
+	var err error
+	if primary, err = corpusUpstream(); err != nil {
+		return 0, err
+	}
+	defer primary.Close()
+	if secondary, err = corpusUpstream(); err != nil {
+		return 0, err
+	}
+	defer secondary.Close()
+	conf, err := baseConfig()
+	if err != nil {
+		return 0, err
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return 0, err
+	}
+	listenAddr = conn.LocalAddr().String()
+	go StartDNSService([]*net.UDPConn{conn}, conf)
+	if err := waitForService(); err != nil {
+		return 0, err
+	}
+	return m.Run(), nil
+}
+
+/*
+*	Waits until the service is running and answering, so no test queues an operation before the state worker exists
+ */
+func waitForService() error {
+	query, err := BuildQuery("nas.lab.home.", dnsmessage.TypeA, 1)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for atomic.LoadInt32(&running) == 0 {
+		if time.Now().After(deadline) {
+			return errors.New("service did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, err = exchangeWith(query)
+	return err
+}
+
+/*
+*	The deterministic upstream the wire corpus is recorded against, names it doesn't know are NXDOMAIN
+ */
+func corpusUpstream() (*dnstest.Server, error) {
+	s, err := dnstest.NewServer("127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s.Default(dnstest.Response{RCode: dnsmessage.RCodeNameError})
+	s.Handle("example.com.", dnsmessage.TypeA, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.A("example.com.", 300, "93.184.216.34")}})
+	s.Handle("example.com.", dnsmessage.TypeAAAA, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.AAAA("example.com.", 300, "2606:2800:220:1:248:1893:25c8:1946")}})
+	s.Handle("www.example.com.", dnsmessage.TypeA, dnstest.Response{Answers: []dnsmessage.Resource{
+		dnstest.CNAME("www.example.com.", 300, "example.com."), dnstest.A("example.com.", 300, "93.184.216.34")}})
+	s.Handle("example.com.", dnsmessage.TypeTXT, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.TXT("example.com.", 300, "v=spf1 -all")}})
+	return s, nil
+}
+
+/*
+*	Loads the corpus configuration with its upstreams pointed at the scripted servers, a fresh copy on every call
+ */
+func baseConfig() (*config.Configuration, error) {
+	conf, err := config.LoadConfig(filepath.Join(corpusDir, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+	conf.UpstreamNameservers.Primary = nameserverFor(primary)
+	conf.UpstreamNameservers.Secondary = nameserverFor(secondary)
+	return conf, nil
+}
+
+func nameserverFor(s *dnstest.Server) config.Nameserver {
+	_, port, _ := net.SplitHostPort(s.Addr())
+	number, _ := strconv.ParseUint(port, 10, 16)
+	return config.Nameserver{IPv4: "127.0.0.1", Port: uint16(number)}
+}
+
+func testConfig(t *testing.T) *config.Configuration {
+	t.Helper()
+	conf, err := baseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+/*
+*	Reloads the service with conf for the rest of the test and back to the corpus configuration once it ends. Queries
+*	sent after reload returns are handled under conf, the state worker takes operations in the order they are queued
+ */
+func reload(t *testing.T, conf *config.Configuration) {
+	t.Helper()
+	if !reloadAndWait(conf) {
+		t.Fatal("reloaded configuration was not installed")
+	}
+	t.Cleanup(func() {
+		if base, err := baseConfig(); err == nil && !reloadAndWait(base) {
+			t.Error("base configuration was not installed again")
+		}
+		FlushCache()
+	})
+}
+
+/*
+*	Reloads conf and waits for the state worker to install it, tests that use the cache or the fast path directly
+*	would otherwise race with a reload still queued by the test before
+ */
+func reloadAndWait(conf *config.Configuration) bool {
+	before := loadedSnapshot()
+	Reload(conf)
+	for deadline := time.Now().Add(exchangeTimeout); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if loadedSnapshot() != before {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+*	Starts an upstream for a single test, closed when the test ends
+ */
+func newUpstream(t *testing.T) *dnstest.Server {
+	t.Helper()
+	s, err := dnstest.NewServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+/*
+*	Forwards the domain and everything under it to servers with a rule of its own, so a test can script the answers
+ */
+func forwardTo(conf *config.Configuration, domain string, servers ...*dnstest.Server) *config.ForwardingRule {
+	rule := config.ForwardingRule{Domains: []string{domain}, TimeoutMs: 200}
+	for _, s := range servers {
+		rule.Nameservers = append(rule.Nameservers, nameserverFor(s))
+	}
+	retries := uint8(0)
+	rule.Strategy, rule.Retries = "failover", &retries
+	conf.ForwardingRules = append(conf.ForwardingRules, rule)
+	return &conf.ForwardingRules[len(conf.ForwardingRules)-1]
+}
+
+/*
+*	Sends a raw query to the service and returns the raw response
+ */
+func exchange(t *testing.T, query []byte) []byte {
+	t.Helper()
+	res, err := exchangeWith(query)
+	if err != nil {
+		t.Fatalf("no response to query: %v", err)
+	}
+	return res
+}
+
+func exchangeWith(query []byte) ([]byte, error) {
+	conn, err := net.Dial("udp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(exchangeTimeout))
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+/*
+*	Resolves name and type through the service, with an OPT record advertising size when size is not zero
+ */
+func lookup(t *testing.T, name string, qtype dnsmessage.Type, size uint16) dnsmessage.Message {
+	t.Helper()
+	m := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 0x4242, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	if size != 0 {
+		var h dnsmessage.ResourceHeader
+		h.SetEDNS0(int(size), dnsmessage.RCodeSuccess, false)
+		m.Additionals = []dnsmessage.Resource{{Header: h, Body: &dnsmessage.OPTResource{}}}
+	}
+	query, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res dnsmessage.Message
+	if err := res.Unpack(exchange(t, query)); err != nil {
+		t.Fatalf("response to %s %s does not parse: %v", name, qtype, err)
+	}
+	return res
+}
+
+func TestForwardsToUpstream(t *testing.T) {
+	conf := testConfig(t)
+	up := newUpstream(t)
+	up.Handle("host.forwarded.test.", dnsmessage.TypeA, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.A("host.forwarded.test.", 60, "192.0.2.7")}})
+	forwardTo(conf, "forwarded.test.", up)
+	reload(t, conf)
+
+	res := lookup(t, "host.forwarded.test.", dnsmessage.TypeA, 0)
+	if res.Header.RCode != dnsmessage.RCodeSuccess || len(res.Answers) != 1 {
+		t.Fatalf("got %s with %d answers, want NOERROR with 1", res.Header.RCode, len(res.Answers))
+	}
+	if a := res.Answers[0].Body.(*dnsmessage.AResource).A; net.IP(a[:]).String() != "192.0.2.7" {
+		t.Fatalf("got address %v, want 192.0.2.7", net.IP(a[:]))
+	}
+	if got := len(up.Queries()); got != 1 {
+		t.Fatalf("upstream received %d queries, want 1", got)
+	}
+	// the second lookup is answered from the cache
+	lookup(t, "host.forwarded.test.", dnsmessage.TypeA, 0)
+	if got := len(up.Queries()); got != 1 {
+		t.Fatalf("upstream received %d queries after a cached lookup, want 1", got)
+	}
+}
+
+func TestFailsOverToNextUpstream(t *testing.T) {
+	conf := testConfig(t)
+	dead, live := newUpstream(t), newUpstream(t)
+	dead.Default(dnstest.Response{Drop: true})
+	live.Handle("host.failover.test.", dnsmessage.TypeA, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.A("host.failover.test.", 60, "192.0.2.8")}})
+	forwardTo(conf, "failover.test.", dead, live)
+	reload(t, conf)
+
+	res := lookup(t, "host.failover.test.", dnsmessage.TypeA, 0)
+	if res.Header.RCode != dnsmessage.RCodeSuccess || len(res.Answers) != 1 {
+		t.Fatalf("got %s with %d answers, want NOERROR with 1", res.Header.RCode, len(res.Answers))
+	}
+	if len(dead.Queries()) != 1 || len(live.Queries()) != 1 {
+		t.Fatalf("dropping upstream got %d queries and answering one %d, want 1 each", len(dead.Queries()), len(live.Queries()))
+	}
+}
+
+func TestLocalZoneNameNeverReachesUpstream(t *testing.T) {
+	primary.Reset()
+	secondary.Reset()
+	res := lookup(t, "secret-host.lab.home.", dnsmessage.TypeA, 0)
+	if res.Header.RCode != dnsmessage.RCodeNameError {
+		t.Fatalf("got %s, want NXDOMAIN", res.Header.RCode)
+	}
+	if n := len(primary.Queries()) + len(secondary.Queries()); n != 0 {
+		t.Fatalf("upstreams received %d queries for a name inside a local zone", n)
+	}
+}
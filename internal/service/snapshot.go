@@ -0,0 +1,109 @@
+package service
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnsname"
+)
+
+// the snapshot the state worker answers from, published for readers outside the worker
+var currentSnapshot atomic.Value
+
+/*
+*	Everything the state worker derives from one configuration to answer queries. A snapshot is built whole and
+*	not changed once published: a reload builds the next one from the new configuration and the previous snapshot,
+*	and the worker switches over between two operations, so each query is answered from a single configuration and
+*	a failed build leaves the running snapshot untouched. Only the caches inside profiles fill up as answers arrive
+ */
+type snapshot struct {
+	conf         config.Configuration
+	records      map[string][]byte
+	ages         *recordAges
+	localNames   map[string]bool
+	zones        localZones
+	fastPath     fastPathNames
+	clientSearch clientSearchDomains
+	pinned       *pinnedNames
+	cnames       localCNAMEs
+	nullRoutes   map[string]uint32
+	selfNames    *selfRecords
+	health       healthNames
+	rules        forwardingRules
+	generated    generatedRanges
+	delegated    *delegations
+	echExempt    localZones
+	rewrites     answerRewrites
+	ttlPins      *ttlOverrides
+	bypass       cacheBypassClients
+	profiles     map[string]*profileState
+	overrides    *overridesFile
+}
+
+/*
+*	Builds the snapshot for conf, prev is the running snapshot or nil at startup. Local record ages are carried over
+*	from prev, caches always start empty since cached answers may have come from upstreams or rules that no longer
+*	apply. The overrides file is kept from prev while its path is unchanged, so reloading doesn't drop its entries
+ */
+func newSnapshot(conf *config.Configuration, prev *snapshot) (*snapshot, error) {
+	s := &snapshot{conf: *conf}
+	var err error
+	if s.records, err = CreateLocalRecords(conf); err != nil {
+		return nil, errors.New("local records: " + err.Error())
+	}
+	blocker, err := CreateBlocker(conf)
+	if err != nil {
+		return nil, errors.New("blocklists: " + err.Error())
+	}
+	if s.delegated, err = newDelegations(conf); err != nil {
+		return nil, errors.New("delegations: " + err.Error())
+	}
+	if s.profiles, err = newProfileStates(conf, blocker, newResponseCache(&conf.Cache)); err != nil {
+		return nil, errors.New("profile blocklists: " + err.Error())
+	}
+	var ages *recordAges
+	if prev != nil {
+		ages = prev.ages
+	}
+	s.ages = newRecordAges(conf, ages, serviceClock.Now())
+	s.localNames = make(map[string]bool)
+	for _, v := range conf.LocalRecords {
+		s.localNames[dnsname.Key(v.Name)] = true
+	}
+	s.zones = newLocalZones(conf.LocalZones)
+	s.fastPath = newFastPath(&conf.FastPath)
+	s.clientSearch = newClientSearchDomains(conf.ClientSearchDomains)
+	s.pinned = newPinnedNames(conf.PinnedNames)
+	s.cnames = newLocalCNAMEs(conf.LocalRecords)
+	s.nullRoutes = newNullRoutes(conf.LocalRecords)
+	s.selfNames = newSelfRecords(conf.SelfHostname, listeners)
+	s.health = newHealthNames(&conf.HealthRecords)
+	s.rules = newForwardingRules(conf.ForwardingRules)
+	s.generated = newGeneratedRanges(conf.GeneratedRanges)
+	s.echExempt = newLocalZones(conf.StripECHExempt)
+	s.rewrites = newAnswerRewrites(conf.AnswerRewrites)
+	s.ttlPins = newTTLOverrides(conf.TTLOverrides)
+	s.bypass = newCacheBypassClients(conf.CacheBypassClients)
+	if prev != nil && prev.overrides != nil && prev.conf.OverridesFile == conf.OverridesFile {
+		s.overrides = prev.overrides
+	} else if conf.OverridesFile != "" {
+		s.overrides = newOverridesFile(conf.OverridesFile)
+	}
+	return s, nil
+}
+
+/*
+*	Makes s the snapshot queries are answered from and restarts the background tasks whose configuration changed,
+*	called by the state worker only
+ */
+func (s *snapshot) install() {
+	fastPath = s.fastPath
+	currentSnapshot.Store(s)
+	tasks.Apply(s)
+}
+
+func loadedSnapshot() *snapshot {
+	s, _ := currentSnapshot.Load().(*snapshot)
+	return s
+}
@@ -0,0 +1,26 @@
+package service
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/TasSM/labns/internal/logging"
+)
+
+var (
+	httpMethods  = [][]byte{[]byte("GET "), []byte("POST"), []byte("HEAD")}
+	httpWarnings = &logging.RateLimited{Interval: 10 * time.Second}
+)
+
+/*
+*	Cheap first-bytes check for an HTTP request sent to the DNS port, a real DNS query never starts with these bytes
+*	in its ID and flags that also parse as a message
+ */
+func looksLikeHTTP(packet []byte) bool {
+	for _, m := range httpMethods {
+		if bytes.HasPrefix(packet, m) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TasSM/labns/internal/stats"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestLooksLikeHTTP(t *testing.T) {
+	cases := map[string]bool{
+		"GET / HTTP/1.1\r\n":         true,
+		"POST /reload HTTP/1.1\r\n":  true,
+		"HEAD /metrics HTTP/1.0\r\n": true,
+		"GET":                        false,
+		"PUT / HTTP/1.1\r\n":         false,
+		"get / HTTP/1.1\r\n":         false,
+		"":                           false,
+	}
+	for payload, want := range cases {
+		if got := looksLikeHTTP([]byte(payload)); got != want {
+			t.Errorf("looksLikeHTTP(%q) = %v, want %v", payload, got, want)
+		}
+	}
+	if looksLikeHTTP(rawQuery(t, 0x4745, 0x0100, question("nas.lab.home.", dnsmessage.TypeA))) {
+		t.Error("a DNS query was taken for HTTP")
+	}
+}
+
+func TestHTTPOnDNSPortIsDropped(t *testing.T) {
+	before := stats.Get(stats.Malformed)
+	if res := sendExpecting(t, listenAddr, []byte("GET /metrics HTTP/1.1\r\nHost: labns\r\n\r\n"), 300*time.Millisecond); res != nil {
+		t.Fatalf("HTTP request on the DNS port was answered with %d bytes, want it dropped", len(res))
+	}
+	if got := stats.Get(stats.Malformed) - before; got != 1 {
+		t.Fatalf("HTTP request on the DNS port counted %d times as malformed, want 1", got)
+	}
+	if res := lookup(t, "nas.lab.home.", dnsmessage.TypeA, 0); len(res.Answers) != 1 {
+		t.Fatalf("query after the HTTP request has %d answers, want 1", len(res.Answers))
+	}
+}
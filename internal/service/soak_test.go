@@ -0,0 +1,98 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TasSM/labns/internal/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func inflightTotal() int {
+	clientsInflight.lock.Lock()
+	defer clientsInflight.lock.Unlock()
+	total := 0
+	for _, n := range clientsInflight.counts {
+		total += n
+	}
+	return total
+}
+
+func routinesRunning() int64 {
+	var total int64
+	for k := range routineCounts {
+		total += atomic.LoadInt64(&routineCounts[k])
+	}
+	return total
+}
+
+/*
+*	Waits until nothing is forwarded or waited on any more, returning what is still held once the deadline passes
+ */
+func waitForIdle(baseline int, deadline time.Duration) string {
+	var state string
+	for end := time.Now().Add(deadline); time.Now().Before(end); time.Sleep(50 * time.Millisecond) {
+		inflight, limiter, routines, goroutines := inflightTotal(), upstreamLimiter.InUse(), routinesRunning(), runtime.NumGoroutine()
+		state = fmt.Sprintf("%d inflight, %d upstream slots, %d counted goroutines, %d goroutines against %d before", inflight, limiter, routines, goroutines, baseline)
+		// a few goroutines of the runtime and the test itself come and go
+		if inflight == 0 && limiter == 0 && routines == 0 && goroutines <= baseline+5 {
+			return ""
+		}
+	}
+	return state
+}
+
+/*
+*	An upstream that never answers, queried round after round as it would be through an outage. Every query must
+*	time out and give back its inflight count, upstream slot and goroutines, so what the service holds afterwards is
+*	what it held before however many queries went unanswered
+ */
+func TestBlackholedUpstreamLeaksNothing(t *testing.T) {
+	conf := testConfig(t)
+	up := newUpstream(t)
+	up.Default(dnstest.Response{Drop: true})
+	forwardTo(conf, "blackhole.test.", up)
+	reload(t, conf)
+
+	if state := waitForIdle(runtime.NumGoroutine(), 5*time.Second); state != "" {
+		t.Fatalf("service is not idle before the test: %s", state)
+	}
+	baseline := runtime.NumGoroutine()
+
+	conn, err := net.Dial("udp", listenAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	rounds, perRound := 10, 80
+	if testing.Short() {
+		rounds = 2
+	}
+	id := uint16(0)
+	for round := 0; round < rounds; round++ {
+		for k := 0; k < perRound; k++ {
+			id++
+			query, err := BuildQuery(fmt.Sprintf("host-%d.blackhole.test.", id), dnsmessage.TypeA, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := conn.Write(query); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// every query is forwarded, they stay within MaxConcurrentUpstreamQueries and MaxInflightPerClient
+		for end := time.Now().Add(5 * time.Second); len(up.Queries()) < int(id) && time.Now().Before(end); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := len(up.Queries()); got != int(id) {
+			t.Fatalf("blackholed upstream received %d of the %d queries sent", got, id)
+		}
+		if state := waitForIdle(baseline, 5*time.Second); state != "" {
+			t.Fatalf("round %d of %d queries to a blackholed upstream left %s", round+1, perRound, state)
+		}
+	}
+}
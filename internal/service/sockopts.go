@@ -0,0 +1,69 @@
+package service
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// the buffer setters of a *net.UDPConn
+type socketBufferSetter interface {
+	SetReadBuffer(bytes int) error
+	SetWriteBuffer(bytes int) error
+}
+
+/*
+*	Applies UDPReceiveBufferBytes, UDPSendBufferBytes and IPTOS to a socket before it is served and logs the buffer
+*	sizes the kernel granted. Failures are logged and the socket is served with the defaults
+ */
+func tuneSocket(conn *net.UDPConn, conf *config.Configuration) {
+	addr := conn.LocalAddr().String()
+	setSocketBuffers(conn, addr, conf)
+	if conf.IPTOS != 0 {
+		var err error
+		if local, ok := conn.LocalAddr().(*net.UDPAddr); ok && local.IP.To4() != nil {
+			err = ipv4.NewConn(conn).SetTOS(int(conf.IPTOS))
+		} else {
+			err = ipv6.NewConn(conn).SetTrafficClass(int(conf.IPTOS))
+		}
+		if err != nil {
+			logging.LogMessage(logging.LogError, fmt.Sprintf("Unable to set the TOS of %s to %d: %v", addr, conf.IPTOS, err))
+		}
+	}
+	if conf.UDPReceiveBufferBytes == 0 && conf.UDPSendBufferBytes == 0 {
+		return
+	}
+	receive, send, ok := socketBuffers(conn)
+	if !ok {
+		return
+	}
+	logging.LogMessage(logging.LogInfo, fmt.Sprintf("UDP buffers of %s are %d bytes for receiving and %d for sending", addr, receive, send))
+	if conf.UDPReceiveBufferBytes > 0 && receive < int(conf.UDPReceiveBufferBytes) {
+		logging.LogMessage(logging.LogInfo, fmt.Sprintf("Receive buffer of %s was limited to %d of the %d bytes requested, raise the net.core.rmem_max sysctl to allow more",
+			addr, receive, conf.UDPReceiveBufferBytes))
+	}
+	if conf.UDPSendBufferBytes > 0 && send < int(conf.UDPSendBufferBytes) {
+		logging.LogMessage(logging.LogInfo, fmt.Sprintf("Send buffer of %s was limited to %d of the %d bytes requested, raise the net.core.wmem_max sysctl to allow more",
+			addr, send, conf.UDPSendBufferBytes))
+	}
+}
+
+/*
+*	Requests the configured buffer sizes, a size left at 0 keeps the system default
+ */
+func setSocketBuffers(s socketBufferSetter, addr string, conf *config.Configuration) {
+	if conf.UDPReceiveBufferBytes > 0 {
+		if err := s.SetReadBuffer(int(conf.UDPReceiveBufferBytes)); err != nil {
+			logging.LogMessage(logging.LogError, fmt.Sprintf("Unable to set the receive buffer of %s: %v", addr, err))
+		}
+	}
+	if conf.UDPSendBufferBytes > 0 {
+		if err := s.SetWriteBuffer(int(conf.UDPSendBufferBytes)); err != nil {
+			logging.LogMessage(logging.LogError, fmt.Sprintf("Unable to set the send buffer of %s: %v", addr, err))
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package service
+
+import (
+	"net"
+	"syscall"
+)
+
+/*
+*	Reads SO_RCVBUF and SO_SNDBUF. Linux reports double the usable size to account for its bookkeeping, so the
+*	values are halved to compare with what was requested
+ */
+func socketBuffers(conn *net.UDPConn) (int, int, bool) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, 0, false
+	}
+	var receive, send int
+	var rcvErr, sndErr error
+	err = raw.Control(func(fd uintptr) {
+		receive, rcvErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		send, sndErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	})
+	if err != nil || rcvErr != nil || sndErr != nil {
+		return 0, 0, false
+	}
+	return receive / 2, send / 2, true
+}
@@ -0,0 +1,30 @@
+package service
+
+import (
+	"net"
+	"testing"
+
+	"github.com/TasSM/labns/internal/config"
+	"golang.org/x/net/ipv4"
+)
+
+func TestTuneSocketOnLinux(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// small enough for any rmem_max and wmem_max
+	tuneSocket(conn, &config.Configuration{UDPReceiveBufferBytes: 96 << 10, UDPSendBufferBytes: 80 << 10, IPTOS: 0xb8})
+
+	receive, send, ok := socketBuffers(conn)
+	if !ok {
+		t.Fatal("buffer sizes could not be read back")
+	}
+	if receive != 96<<10 || send != 80<<10 {
+		t.Fatalf("kernel reports buffers of %d and %d bytes, want the 98304 and 81920 requested", receive, send)
+	}
+	if tos, err := ipv4.NewConn(conn).TOS(); err != nil || tos != 0xb8 {
+		t.Fatalf("TOS is %#x (%v), want 0xb8", tos, err)
+	}
+}
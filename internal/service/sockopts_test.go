@@ -0,0 +1,53 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/TasSM/labns/internal/config"
+)
+
+/*
+*	Records the sizes requested of it, failing every call when err is set
+ */
+type recordingBuffers struct {
+	calls []string
+	err   error
+}
+
+func (r *recordingBuffers) SetReadBuffer(bytes int) error {
+	r.calls = append(r.calls, fmt.Sprintf("read %d", bytes))
+	return r.err
+}
+
+func (r *recordingBuffers) SetWriteBuffer(bytes int) error {
+	r.calls = append(r.calls, fmt.Sprintf("write %d", bytes))
+	return r.err
+}
+
+func TestSocketBuffersSetToConfiguredSizes(t *testing.T) {
+	cases := []struct {
+		receive uint32
+		send    uint32
+		calls   string
+	}{
+		{4 << 20, 1 << 20, "[read 4194304 write 1048576]"},
+		{2 << 20, 0, "[read 2097152]"},
+		{0, 512 << 10, "[write 524288]"},
+		{0, 0, "[]"},
+	}
+	for _, c := range cases {
+		r := &recordingBuffers{}
+		setSocketBuffers(r, "127.0.0.1:53", &config.Configuration{UDPReceiveBufferBytes: c.receive, UDPSendBufferBytes: c.send})
+		if got := fmt.Sprint(r.calls); got != c.calls {
+			t.Errorf("buffers of %d and %d bytes set with %s, want %s", c.receive, c.send, got, c.calls)
+		}
+	}
+	// a failed receive buffer still leaves the send buffer to be set
+	r := &recordingBuffers{err: errors.New("operation not permitted")}
+	setSocketBuffers(r, "127.0.0.1:53", &config.Configuration{UDPReceiveBufferBytes: 1 << 20, UDPSendBufferBytes: 1 << 20})
+	if len(r.calls) != 2 {
+		t.Fatalf("setters called %v after a failure, want both", r.calls)
+	}
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"net"
+	"time"
+
+	"github.com/TasSM/labns/internal/logging"
+)
+
+var sourceWarnings = &logging.RateLimited{Interval: 10 * time.Second}
+
+/*
+*	The directed broadcast addresses of the IPv4 networks on the host's interfaces when the listener starts, point
+*	to point and single address networks have none
+ */
+func interfaceBroadcasts() map[string]bool {
+	out := make(map[string]bool)
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return out
+	}
+	for _, a := range addrs {
+		network, ok := a.(*net.IPNet)
+		if !ok || network.IP.To4() == nil {
+			continue
+		}
+		if ones, bits := network.Mask.Size(); bits != 32 || ones >= 31 {
+			continue
+		}
+		ip, mask := network.IP.To4(), net.IP(network.Mask).To4()
+		broadcast := make(net.IP, net.IPv4len)
+		for k := range broadcast {
+			broadcast[k] = ip[k] | ^mask[k]
+		}
+		out[broadcast.String()] = true
+	}
+	return out
+}
+
+/*
+*	Returns why a packet from addr must never be answered, or "" when it may be. A reply to port 0 can't be sent and
+*	one to a multicast or broadcast address would reach every host listening there, so these sources are spoofed or
+*	broken and the packet is dropped before it is parsed
+ */
+func bogusSource(addr *net.UDPAddr, broadcasts map[string]bool) string {
+	switch {
+	case addr == nil || addr.IP == nil:
+		return "no source address"
+	case addr.Port == 0:
+		return "source port 0"
+	case addr.IP.IsUnspecified():
+		return "unspecified source address"
+	case addr.IP.IsMulticast():
+		return "multicast source address"
+	case addr.IP.Equal(net.IPv4bcast) || broadcasts[addr.IP.String()]:
+		return "broadcast source address"
+	}
+	return ""
+}
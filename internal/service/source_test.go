@@ -0,0 +1,64 @@
+package service
+
+import (
+	"net"
+	"testing"
+)
+
+func TestBogusSource(t *testing.T) {
+	broadcasts := map[string]bool{"192.168.1.255": true}
+	cases := []struct {
+		addr *net.UDPAddr
+		want string
+	}{
+		{&net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 40000}, ""},
+		{&net.UDPAddr{IP: net.ParseIP("2001:db8::20"), Port: 40000}, ""},
+		{&net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 5353, Zone: "eth0"}, ""},
+		{&net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 0}, "source port 0"},
+		{&net.UDPAddr{IP: net.IPv4zero, Port: 40000}, "unspecified source address"},
+		{&net.UDPAddr{IP: net.IPv6unspecified, Port: 40000}, "unspecified source address"},
+		{&net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}, "multicast source address"},
+		{&net.UDPAddr{IP: net.ParseIP("ff02::1"), Port: 40000}, "multicast source address"},
+		{&net.UDPAddr{IP: net.IPv4bcast, Port: 40000}, "broadcast source address"},
+		// the 16 byte form an IPv6 socket reports for IPv4 clients
+		{&net.UDPAddr{IP: net.ParseIP("::ffff:255.255.255.255"), Port: 40000}, "broadcast source address"},
+		{&net.UDPAddr{IP: net.IPv4(192, 168, 1, 255), Port: 40000}, "broadcast source address"},
+		{&net.UDPAddr{IP: net.IPv4(192, 168, 2, 255), Port: 40000}, ""},
+		{&net.UDPAddr{Port: 40000}, "no source address"},
+		{nil, "no source address"},
+	}
+	for _, c := range cases {
+		if got := bogusSource(c.addr, broadcasts); got != c.want {
+			t.Errorf("bogusSource(%v) = %q, want %q", c.addr, got, c.want)
+		}
+	}
+}
+
+/*
+*	The loopback network is on every Linux host as 127.0.0.1/8, other platforms may list it as a single address
+ */
+func TestInterfaceBroadcasts(t *testing.T) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		t.Skip("interface addresses unavailable:", err)
+	}
+	loopback := false
+	for _, a := range addrs {
+		if network, ok := a.(*net.IPNet); ok && network.String() == "127.0.0.1/8" {
+			loopback = true
+		}
+	}
+	if !loopback {
+		t.Skip("no 127.0.0.1/8 interface address")
+	}
+	broadcasts := interfaceBroadcasts()
+	if !broadcasts["127.255.255.255"] {
+		t.Fatalf("broadcasts %v miss 127.255.255.255 of the loopback network", broadcasts)
+	}
+	if bogusSource(&net.UDPAddr{IP: net.IPv4(127, 255, 255, 255), Port: 40000}, broadcasts) == "" {
+		t.Fatal("the loopback broadcast address is accepted as a source")
+	}
+	if bogusSource(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}, broadcasts) != "" {
+		t.Fatal("the loopback address is refused as a source")
+	}
+}
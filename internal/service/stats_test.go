@@ -0,0 +1,37 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TasSM/labns/internal/stats"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestQueriesAreCountedInBuckets(t *testing.T) {
+	other, formerr, malformed := stats.Get("qtype_OTHER"), stats.Get("rcode_FORMERR"), stats.Get(stats.Malformed)
+
+	// a junk type for a local name, answered without going upstream
+	lookup(t, "nas.lab.home.", 65280, 0)
+	if got := stats.Get("qtype_OTHER") - other; got != 1 {
+		t.Fatalf("query of type 65280 raised qtype_OTHER by %d, want 1", got)
+	}
+	if _, ok := stats.Snapshot()["qtype_65280"]; ok {
+		t.Fatal("type 65280 has a counter of its own")
+	}
+
+	exchange(t, rawQuery(t, 0x1401, 0x0100))
+	if got := stats.Get("rcode_FORMERR") - formerr; got != 1 {
+		t.Fatalf("query without a question raised rcode_FORMERR by %d, want 1", got)
+	}
+	if res := sendExpecting(t, listenAddr, []byte{0x14, 0x02, 0x01}, 300*time.Millisecond); res != nil {
+		t.Fatal("a truncated header was answered")
+	}
+	// both the query without a question and the packet that does not parse are malformed
+	if got := stats.Get(stats.Malformed) - malformed; got != 2 {
+		t.Fatalf("malformed went up by %d, want 2", got)
+	}
+	if got := lookup(t, "nas.lab.home.", dnsmessage.TypeA, 0); got.Header.RCode != dnsmessage.RCodeSuccess {
+		t.Fatalf("query after the malformed packets answered %s", got.Header.RCode)
+	}
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	Reloads, flushes and upstream answers racing queries on the state worker, meant for -race. Each reload swaps
+*	between generations of the configuration that answer stress.lab.home with different addresses, and every query
+*	must get an answer from one whole generation
+ */
+func TestStateWorkerUnderConcurrentReloads(t *testing.T) {
+	const generations = 4
+	up := newUpstream(t)
+	up.Handle("host.stress.test.", dnsmessage.TypeA, dnstest.Response{Answers: []dnsmessage.Resource{dnstest.A("host.stress.test.", 60, "192.0.2.200")}})
+	confs := make([]*config.Configuration, generations)
+	want := map[string]bool{}
+	for k := range confs {
+		confs[k] = testConfig(t)
+		addr := fmt.Sprintf("192.0.2.%d", k+1)
+		confs[k].LocalRecords = append(confs[k].LocalRecords, config.LocalDNSRecord{Name: "stress.lab.home.", Type: "A", TTL: 60, Target: addr})
+		forwardTo(confs[k], "stress.test.", up)
+		want[addr] = true
+	}
+	reload(t, confs[0])
+
+	duration := 2 * time.Second
+	if testing.Short() {
+		duration = 300 * time.Millisecond
+	}
+	stop := make(chan struct{})
+	var writers sync.WaitGroup
+	writers.Add(3)
+	go func() {
+		defer writers.Done()
+		for k := 1; ; k++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			Reload(confs[k%generations])
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	go func() {
+		defer writers.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			FlushCache()
+			time.Sleep(2 * time.Millisecond)
+		}
+	}()
+	go func() {
+		defer writers.Done()
+		// the admin API reads the loaded snapshot from its own goroutines
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			LocalRecords()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	var clients sync.WaitGroup
+	var answered int64
+	errs := make(chan error, 8)
+	for c := 0; c < 8; c++ {
+		clients.Add(1)
+		go func(c int) {
+			defer clients.Done()
+			for k := 0; ; k++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				name, expect := "stress.lab.home.", ""
+				if k%2 == 1 {
+					name, expect = "host.stress.test.", "192.0.2.200"
+				}
+				// the service drops queries with ID 0
+				query, err := BuildQuery(name, dnsmessage.TypeA, uint16(c<<12|(k%0xfff+1)))
+				if err != nil {
+					errs <- err
+					return
+				}
+				res, err := exchangeWith(query)
+				if err != nil {
+					errs <- fmt.Errorf("query for %s got no answer: %v", name, err)
+					return
+				}
+				var m dnsmessage.Message
+				if err := m.Unpack(res); err != nil || len(m.Answers) != 1 {
+					errs <- fmt.Errorf("answer for %s has %d records (%v), want 1", name, len(m.Answers), err)
+					return
+				}
+				a := m.Answers[0].Body.(*dnsmessage.AResource).A
+				got := net.IP(a[:]).String()
+				if (expect != "" && got != expect) || (expect == "" && !want[got]) {
+					errs <- fmt.Errorf("answer for %s is %s, which no configuration generation has", name, got)
+					return
+				}
+				atomic.AddInt64(&answered, 1)
+			}
+		}(c)
+	}
+
+	select {
+	case err := <-errs:
+		close(stop)
+		clients.Wait()
+		writers.Wait()
+		t.Fatal(err)
+	case <-time.After(duration):
+	}
+	close(stop)
+	clients.Wait()
+	writers.Wait()
+	select {
+	case err := <-errs:
+		t.Fatal(err)
+	default:
+	}
+	if answered < 100 {
+		t.Fatalf("only %d queries were answered in %s, too few to exercise the worker", answered, duration)
+	}
+	t.Logf("%d queries answered across reloads", answered)
+}
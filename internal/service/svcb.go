@@ -0,0 +1,91 @@
+package service
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/TasSM/labns/internal/config"
+)
+
+const (
+	SvcParamMandatory uint16 = 0
+	SvcParamAlpn      uint16 = 1
+	SvcParamPort      uint16 = 3
+	SvcParamIPv4Hint  uint16 = 4
+	SvcParamECH       uint16 = 5
+	SvcParamIPv6Hint  uint16 = 6
+)
+
+type svcParam struct {
+	Key   uint16
+	Value []byte
+}
+
+/*
+*	Encodes SVCB/HTTPS RDATA (RFC 9460): priority, uncompressed target name and SvcParams in ascending key order
+ */
+func BuildSvcbData(target string, svc *config.SvcParams) ([]byte, error) {
+	var priority uint16
+	var params []svcParam
+	if svc != nil {
+		priority = svc.Priority
+		if len(svc.Alpn) > 0 {
+			var v []byte
+			for _, a := range svc.Alpn {
+				v = append(v, byte(len(a)))
+				v = append(v, a...)
+			}
+			params = append(params, svcParam{SvcParamAlpn, v})
+		}
+		if svc.Port != 0 {
+			params = append(params, svcParam{SvcParamPort, []byte{byte(svc.Port >> 8), byte(svc.Port)}})
+		}
+		if len(svc.IPv4Hint) > 0 {
+			var v []byte
+			for _, ip := range svc.IPv4Hint {
+				v = append(v, net.ParseIP(ip).To4()...)
+			}
+			params = append(params, svcParam{SvcParamIPv4Hint, v})
+		}
+		if len(svc.IPv6Hint) > 0 {
+			var v []byte
+			for _, ip := range svc.IPv6Hint {
+				v = append(v, net.ParseIP(ip).To16()...)
+			}
+			params = append(params, svcParam{SvcParamIPv6Hint, v})
+		}
+	}
+	out := []byte{byte(priority >> 8), byte(priority)}
+	name, err := wireName(target)
+	if err != nil {
+		return nil, err
+	}
+	out = append(out, name...)
+	return appendSvcParams(out, params), nil
+}
+
+func appendSvcParams(out []byte, params []svcParam) []byte {
+	sort.Slice(params, func(i, j int) bool { return params[i].Key < params[j].Key })
+	for _, p := range params {
+		out = append(out, byte(p.Key>>8), byte(p.Key), byte(len(p.Value)>>8), byte(len(p.Value)))
+		out = append(out, p.Value...)
+	}
+	return out
+}
+
+func wireName(name string) ([]byte, error) {
+	var out []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		if len(label) > 63 {
+			return nil, errors.New("label too long in " + name)
+		}
+		out = append(out, byte(len(label)))
+		out = append(out, label...)
+	}
+	return append(out, 0), nil
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TasSM/labns/internal/logging"
+)
+
+const (
+	taskRestartDelay    = time.Second
+	taskMaxRestartDelay = 5 * time.Minute
+)
+
+type restartPolicy int
+
+const (
+	// a task that returns stays stopped until its configuration changes
+	restartNever restartPolicy = iota
+	// a task that returns an error is started again after a backoff, one that returns nil stays stopped
+	restartOnError
+	// a task is started again after a backoff whenever it returns
+	restartAlways
+)
+
+/*
+*	A background goroutine bound to part of the configuration. Config returns that part for a snapshot, or nil when
+*	the task should not run under it, and the task is restarted whenever the returned value changes on a reload.
+*	Run gets the snapshot it was started under and must return once ctx is cancelled
+ */
+type backgroundTask struct {
+	Name    string
+	Config  func(s *snapshot) interface{}
+	Run     func(ctx context.Context, s *snapshot) error
+	Restart restartPolicy
+}
+
+/*
+*	The state of a background task as shown in the stats dump, LastError is kept after a successful restart
+ */
+type TaskStatus struct {
+	Name        string
+	Running     bool
+	Restarts    uint32
+	LastError   string     `json:",omitempty"`
+	LastErrorAt *time.Time `json:",omitempty"`
+}
+
+type runningTask struct {
+	config interface{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+/*
+*	Starts and stops the background tasks as snapshots are installed, so a reload never leaves a task running
+*	against configuration that is gone or running twice. A replacement only starts once the task it replaces has
+*	returned, which happens on its own goroutine so installing a snapshot never waits on a slow task
+ */
+type taskManager struct {
+	list    []backgroundTask
+	lock    sync.Mutex
+	running map[string]*runningTask
+	status  map[string]*TaskStatus
+}
+
+var (
+	backgroundTasks = []backgroundTask{overridesTask, recordAuditTask}
+	tasks           = newTaskManager(backgroundTasks)
+)
+
+func newTaskManager(list []backgroundTask) *taskManager {
+	return &taskManager{list: list, running: make(map[string]*runningTask), status: make(map[string]*TaskStatus)}
+}
+
+/*
+*	Brings the running tasks in line with s, called by the state worker when s is installed
+ */
+func (m *taskManager) Apply(s *snapshot) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for k := range m.list {
+		t := &m.list[k]
+		conf := t.Config(s)
+		prev := m.running[t.Name]
+		if prev != nil && reflect.DeepEqual(prev.config, conf) {
+			continue
+		}
+		if prev != nil && prev.config != nil {
+			prev.cancel()
+			if conf == nil {
+				logging.LogMessage(logging.LogInfo, fmt.Sprintf("Stopping background task %s, it is no longer configured", t.Name))
+			} else {
+				logging.LogMessage(logging.LogInfo, fmt.Sprintf("Restarting background task %s, its configuration changed", t.Name))
+			}
+		}
+		if conf == nil {
+			if prev != nil {
+				// kept so a later start still waits for the stopped instance to return
+				prev.config = nil
+			}
+			continue
+		}
+		if prev == nil || prev.config == nil {
+			logging.LogMessage(logging.LogInfo, "Starting background task "+t.Name)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		next := &runningTask{config: conf, cancel: cancel, done: make(chan struct{})}
+		m.running[t.Name] = next
+		if m.status[t.Name] == nil {
+			m.status[t.Name] = &TaskStatus{Name: t.Name}
+		}
+		spawn(routineTask, func() {
+			defer close(next.done)
+			if prev != nil {
+				<-prev.done
+			}
+			m.run(ctx, t, s)
+		})
+	}
+}
+
+/*
+*	Runs t until ctx is cancelled or its restart policy lets it stop
+ */
+func (m *taskManager) run(ctx context.Context, t *backgroundTask, s *snapshot) {
+	var failures int
+	for ctx.Err() == nil {
+		m.update(t.Name, func(st *TaskStatus) { st.Running = true })
+		err := t.Run(ctx, s)
+		m.update(t.Name, func(st *TaskStatus) {
+			st.Running = false
+			if err != nil {
+				now := time.Now()
+				st.LastError, st.LastErrorAt = err.Error(), &now
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			failures = 0
+		} else {
+			failures++
+		}
+		if t.Restart == restartNever || (err == nil && t.Restart == restartOnError) {
+			if err != nil {
+				logging.LogMessage(logging.LogError, fmt.Sprintf("Background task %s failed, leaving it stopped until its configuration changes: %v", t.Name, err))
+			}
+			return
+		}
+		wait := retryDelay(failures, taskRestartDelay, taskMaxRestartDelay)
+		if err != nil {
+			logging.LogMessage(logging.LogError, fmt.Sprintf("Background task %s failed, restarting it in %s: %v", t.Name, wait.Round(time.Millisecond), err))
+		}
+		select {
+		case <-time.After(wait):
+			m.update(t.Name, func(st *TaskStatus) { st.Restarts++ })
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *taskManager) update(name string, f func(st *TaskStatus)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	f(m.status[name])
+}
+
+/*
+*	Returns the state of every task started since startup, sorted by name
+ */
+func Tasks() []TaskStatus {
+	return tasks.statuses()
+}
+
+func (m *taskManager) statuses() []TaskStatus {
+	m.lock.Lock()
+	out := make([]TaskStatus, 0, len(m.status))
+	for _, st := range m.status {
+		out = append(out, *st)
+	}
+	m.lock.Unlock()
+	sort.Slice(out, func(a, b int) bool { return out[a].Name < out[b].Name })
+	return out
+}
+
+/*
+*	Renders the background tasks as a log line, with the last error of each task that had one
+ */
+func DumpTasks() string {
+	list := Tasks()
+	parts := make([]string, 0, len(list))
+	for _, st := range list {
+		state := "stopped"
+		if st.Running {
+			state = "running"
+		}
+		part := fmt.Sprintf("%s=%s restarts=%d", st.Name, state, st.Restarts)
+		if st.LastErrorAt != nil {
+			part += fmt.Sprintf(" last_error=%q at %s", st.LastError, st.LastErrorAt.Format(time.RFC3339))
+		}
+		parts = append(parts, part)
+	}
+	return "background tasks: " + strings.Join(parts, ", ")
+}
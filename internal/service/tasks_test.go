@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+)
+
+/*
+*	A task configured by the SearchDomain of a snapshot, recording when each instance starts and returns
+ */
+type taskEvents struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (e *taskEvents) add(event string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.events = append(e.events, event)
+}
+
+func (e *taskEvents) wait(t *testing.T, want ...string) {
+	t.Helper()
+	var got []string
+	for deadline := time.Now().Add(exchangeTimeout); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		e.lock.Lock()
+		got = append([]string{}, e.events...)
+		e.lock.Unlock()
+		if len(got) >= len(want) {
+			break
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("task events are %v, want %v", got, want)
+	}
+}
+
+func domainSnapshot(domain string) *snapshot {
+	return &snapshot{conf: config.Configuration{SearchDomain: domain}}
+}
+
+func domainTask(events *taskEvents, restart restartPolicy, run func(ctx context.Context) error) backgroundTask {
+	return backgroundTask{
+		Name: "domain",
+		Config: func(s *snapshot) interface{} {
+			if s.conf.SearchDomain == "" {
+				return nil
+			}
+			return s.conf.SearchDomain
+		},
+		Run: func(ctx context.Context, s *snapshot) error {
+			events.add("start " + s.conf.SearchDomain)
+			err := run(ctx)
+			events.add("stop " + s.conf.SearchDomain)
+			return err
+		},
+		Restart: restart,
+	}
+}
+
+func TestTaskFollowsItsConfiguration(t *testing.T) {
+	events := &taskEvents{}
+	m := newTaskManager([]backgroundTask{domainTask(events, restartOnError, func(ctx context.Context) error {
+		<-ctx.Done()
+		// a slow shutdown, the replacement must not start before it is over
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	})})
+
+	m.Apply(domainSnapshot("a."))
+	events.wait(t, "start a.")
+	// an unchanged configuration leaves the task alone
+	m.Apply(domainSnapshot("a."))
+	m.Apply(domainSnapshot("b."))
+	events.wait(t, "start a.", "stop a.", "start b.")
+	m.Apply(domainSnapshot(""))
+	events.wait(t, "start a.", "stop a.", "start b.", "stop b.")
+	m.Apply(domainSnapshot("c."))
+	events.wait(t, "start a.", "stop a.", "start b.", "stop b.", "start c.")
+	m.Apply(domainSnapshot(""))
+	events.wait(t, "start a.", "stop a.", "start b.", "stop b.", "start c.", "stop c.")
+
+	if st := m.statuses(); len(st) != 1 || st[0].Running || st[0].Restarts != 0 || st[0].LastErrorAt != nil {
+		t.Fatalf("status after the task was stopped is %+v, want it stopped without restarts or errors", st)
+	}
+}
+
+/*
+*	Restarts wait out a backoff of at least taskRestartDelay, so every case waits past the longest one
+ */
+func TestTaskRestartPolicy(t *testing.T) {
+	cases := []struct {
+		name     string
+		restart  restartPolicy
+		fails    bool
+		restarts uint32
+	}{
+		{"never after an error", restartNever, true, 0},
+		{"on error after success", restartOnError, false, 0},
+		{"on error after an error", restartOnError, true, 1},
+		{"always after success", restartAlways, false, 1},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			events := &taskEvents{}
+			var runs int32
+			m := newTaskManager([]backgroundTask{domainTask(events, c.restart, func(ctx context.Context) error {
+				// the restarted instance keeps running until it is stopped
+				if atomic.AddInt32(&runs, 1) > 1 {
+					<-ctx.Done()
+					return nil
+				}
+				if c.fails {
+					return errors.New("probe failed")
+				}
+				return nil
+			})})
+			m.Apply(domainSnapshot("a."))
+			defer m.Apply(domainSnapshot(""))
+			time.Sleep(taskRestartDelay*3/2 + 200*time.Millisecond)
+
+			st := m.statuses()[0]
+			if st.Restarts != c.restarts || st.Running != (c.restarts > 0) {
+				t.Errorf("task restarted %d times and is running %t, want %d restarts", st.Restarts, st.Running, c.restarts)
+			}
+			if c.fails != (st.LastErrorAt != nil) || (c.fails && st.LastError != "probe failed") {
+				t.Errorf("last error is %q at %v", st.LastError, st.LastErrorAt)
+			}
+		})
+	}
+}
+
+/*
+*	Reloads switching the overrides file and the record audit on, off and between settings faster than the tasks
+*	start must end with one instance of each task running and leave no goroutine behind once both are turned off
+ */
+func TestRapidReloadsKeepTasksFlat(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "a.hosts"), filepath.Join(dir, "b.hosts")}
+	for _, path := range paths {
+		if err := os.WriteFile(path, []byte("192.0.2.70 override.lab.home\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if state := waitForIdle(runtime.NumGoroutine(), 5*time.Second); state != "" {
+		t.Fatalf("service is not idle before the test: %s", state)
+	}
+	baseline := runtime.NumGoroutine()
+
+	for k := 0; k < 100; k++ {
+		conf := testConfig(t)
+		if k%3 != 0 {
+			conf.OverridesFile = paths[k%2]
+		}
+		if k%4 != 0 {
+			conf.RecordAudit = config.RecordAudit{Enabled: true, IntervalMinutes: uint32(60 + k%5), Ports: []uint16{22}, FailAfter: 3, ProbeIntervalMs: 1000}
+		}
+		Reload(conf)
+	}
+	conf := testConfig(t)
+	conf.OverridesFile = paths[0]
+	conf.RecordAudit = config.RecordAudit{Enabled: true, IntervalMinutes: 60, Ports: []uint16{22}, FailAfter: 3, ProbeIntervalMs: 1000}
+	reload(t, conf)
+
+	// a replacement waits on its own goroutine for the instance it replaces, so both counts settle a moment later
+	var running int64
+	var stopped []string
+	for deadline := time.Now().Add(exchangeTimeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		running, stopped = atomic.LoadInt64(&routineCounts[routineTask]), nil
+		for _, st := range Tasks() {
+			if !st.Running {
+				stopped = append(stopped, st.Name)
+			}
+		}
+		if running == 2 && len(stopped) == 0 {
+			break
+		}
+	}
+	if running != 2 || len(stopped) != 0 {
+		t.Fatalf("%d task goroutines and %v stopped after the reloads, want one running instance per task", running, stopped)
+	}
+
+	base, err := baseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloadAndWait(base) {
+		t.Fatal("base configuration was not installed again")
+	}
+	if state := waitForIdle(baseline, 5*time.Second); state != "" {
+		t.Fatalf("goroutines left after the tasks were turned off: %s", state)
+	}
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TasSM/labns/internal/dnstest"
+	"github.com/TasSM/labns/internal/stats"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	A TXT answer too large for the 512 bytes of a query without EDNS
+ */
+func largeTXT(name string) []dnsmessage.Resource {
+	var answers []dnsmessage.Resource
+	for k := 0; k < 8; k++ {
+		answers = append(answers, dnstest.TXT(name, 60, strings.Repeat(string(rune('a'+k)), 200)))
+	}
+	return answers
+}
+
+func networks(s *dnstest.Server, name string) string {
+	var out []string
+	for _, q := range s.Queries() {
+		if q.Question.Name.String() == name {
+			out = append(out, q.Network)
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func TestTruncatedAnswerRetriedOverTCP(t *testing.T) {
+	conf := testConfig(t)
+	name := "big.tcp.test."
+	up := newUpstream(t)
+	up.Handle(name, dnsmessage.TypeTXT, dnstest.Response{Answers: largeTXT(name), Truncate: true})
+	forwardTo(conf, "tcp.test.", up)
+	reload(t, conf)
+	before := stats.Get(stats.TCPFallback)
+
+	res := lookup(t, name, dnsmessage.TypeTXT, 4096)
+	if res.Header.Truncated || res.Header.RCode != dnsmessage.RCodeSuccess || len(res.Answers) != 8 {
+		t.Fatalf("truncated upstream answer reached the client as %s with TC %t and %d answers, want all 8 over TCP",
+			res.Header.RCode, res.Header.Truncated, len(res.Answers))
+	}
+	if got := networks(up, name); got != "udp tcp" {
+		t.Fatalf("upstream received the query over %q, want udp then tcp", got)
+	}
+	if got := stats.Get(stats.TCPFallback) - before; got != 1 {
+		t.Fatalf("%s went up by %d, want 1", stats.TCPFallback, got)
+	}
+	// a client without EDNS still gets TC from labns itself, the full answer is in the cache
+	if res := lookup(t, name, dnsmessage.TypeTXT, 0); !res.Header.Truncated {
+		t.Fatal("answer over 512 bytes sent to a client without EDNS is not truncated")
+	}
+}
+
+/*
+*	An upstream that truncates over UDP and never answers over TCP is left to its timeout, and the next upstream answers
+ */
+func TestFailedTCPFallbackFailsOver(t *testing.T) {
+	conf := testConfig(t)
+	name := "big.tcpfail.test."
+	broken, working := newUpstream(t), newUpstream(t)
+	rule := forwardTo(conf, "tcpfail.test.", broken, working)
+	broken.Handle(name, dnsmessage.TypeTXT, dnstest.Response{Answers: largeTXT(name), Truncate: true, TCPDelay: 3 * time.Duration(rule.TimeoutMs) * time.Millisecond})
+	working.Handle(name, dnsmessage.TypeTXT, dnstest.Response{Answers: largeTXT(name)})
+	reload(t, conf)
+
+	res := lookup(t, name, dnsmessage.TypeTXT, 4096)
+	if res.Header.Truncated || len(res.Answers) != 8 {
+		t.Fatalf("answer after a failed TCP fallback has TC %t and %d answers, want the next upstream's full answer", res.Header.Truncated, len(res.Answers))
+	}
+	if got := networks(broken, name); got != "udp tcp" {
+		t.Fatalf("first upstream received the query over %q, want udp then tcp", got)
+	}
+	if got := networks(working, name); got != "udp" {
+		t.Fatalf("next upstream received the query over %q, want udp", got)
+	}
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/TasSM/labns/internal/logging"
+)
+
+var traceDomains atomic.Value
+
+/*
+*	Per-query trace, a nil *queryTrace is valid and every method on it is a no-op
+ */
+type queryTrace struct {
+	id    uint16
+	name  string
+	start time.Time
+}
+
+/*
+*	Replaces the suffixes whose queries are traced, an empty list turns tracing off
+ */
+func SetTraceDomains(domains []string) {
+	traceDomains.Store(newLocalZones(domains))
+}
+
+func TraceDomains() []string {
+	zones, _ := traceDomains.Load().(localZones)
+	out := make([]string, 0, len(zones))
+	for k := range zones {
+		out = append(out, k)
+	}
+	return out
+}
+
+/*
+*	Starts a trace when name falls under one of the trace domains
+ */
+func startTrace(name string, id uint16, start time.Time) *queryTrace {
+	zones, _ := traceDomains.Load().(localZones)
+	if !zones.Contains(name) {
+		return nil
+	}
+	return &queryTrace{id: id, name: name, start: start}
+}
+
+func (t *queryTrace) Step(format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+	logging.LogMessage(logging.LogInfo, fmt.Sprintf("trace %s id=%d +%.1fms: %s", logging.Name(t.name), t.id,
+		float64(time.Since(t.start))/float64(time.Millisecond), fmt.Sprintf(format, args...)))
+}
@@ -0,0 +1,312 @@
+package service
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+	"github.com/TasSM/labns/internal/stats"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// bounds an exchange whose context carries no deadline
+const defaultExchangeTimeout = 5 * time.Second
+
+/*
+*	Carries DNS messages to one upstream nameserver. Exchange sends a query and returns the response with the same
+*	ID, it may be called from several goroutines at once. Close releases whatever the transport holds open and is
+*	called once the nameserver is no longer configured, an exchange still running may then fail
+ */
+type UpstreamTransport interface {
+	Exchange(ctx context.Context, query []byte) ([]byte, error)
+	Close() error
+}
+
+/*
+*	Creates the transport for a nameserver whose Protocol names it, called the first time it is forwarded to
+ */
+type TransportFactory func(ns config.Nameserver) (UpstreamTransport, error)
+
+/*
+*	Implemented by transports whose responses arrive on the listener sockets, as the built-in UDP one's do. Queries
+*	are then sent without waiting and the listener hands the responses to the state worker, any other transport
+*	has Exchange called on its own goroutine and the response handed over when it returns. Either way timeouts,
+*	failover, retries and upstream health are handled by the state worker the same for every transport
+ */
+type datagramTransport interface {
+	Send(query []byte) error
+}
+
+var (
+	transportLock      sync.Mutex
+	transportFactories = map[string]TransportFactory{"udp": newUDPTransport, "tcp": newTCPTransport}
+	transports         = make(map[string]UpstreamTransport)
+	exchangeWarnings   = &logging.RateLimited{Interval: time.Minute}
+)
+
+/*
+*	Makes a transport available as a Nameserver Protocol, for programs embedding labns. Must be called before the
+*	configuration using it is loaded, names are case-insensitive and the built-in udp and tcp can't be replaced
+ */
+func RegisterTransport(name string, factory TransportFactory) error {
+	name = strings.ToLower(name)
+	transportLock.Lock()
+	defer transportLock.Unlock()
+	if name == "" || factory == nil {
+		return errors.New("a transport needs a name and a factory")
+	}
+	if transportFactories[name] != nil {
+		return errors.New(fmt.Sprintf("a transport named %s is already registered", name))
+	}
+	transportFactories[name] = factory
+	config.RegisterProtocol(name)
+	return nil
+}
+
+func transportKey(ns *config.Nameserver) string {
+	return protocolOf(ns) + "://" + upstreamKey(ns) + "/" + ns.BindAddress + "%" + ns.BindInterface
+}
+
+func protocolOf(ns *config.Nameserver) string {
+	if ns.Protocol == "" {
+		return "udp"
+	}
+	return ns.Protocol
+}
+
+/*
+*	Returns the transport for ns, creating it on first use
+ */
+func transportFor(ns *config.Nameserver) (UpstreamTransport, error) {
+	key := transportKey(ns)
+	transportLock.Lock()
+	defer transportLock.Unlock()
+	if t := transports[key]; t != nil {
+		return t, nil
+	}
+	factory := transportFactories[protocolOf(ns)]
+	if factory == nil {
+		return nil, errors.New(fmt.Sprintf("no transport is registered for protocol %s", protocolOf(ns)))
+	}
+	t, err := factory(*ns)
+	if err != nil {
+		return nil, err
+	}
+	transports[key] = t
+	return t, nil
+}
+
+/*
+*	Closes the transports of nameservers conf no longer uses
+ */
+func retainTransports(conf *config.Configuration) {
+	used := make(map[string]bool)
+	for _, ns := range configuredNameservers(conf) {
+		used[transportKey(&ns)] = true
+	}
+	transportLock.Lock()
+	defer transportLock.Unlock()
+	for key, t := range transports {
+		if used[key] {
+			continue
+		}
+		if err := t.Close(); err != nil {
+			logging.LogMessage(logging.LogError, fmt.Sprintf("Unable to close upstream transport %s: %v", key, err))
+		}
+		delete(transports, key)
+	}
+}
+
+func configuredNameservers(conf *config.Configuration) []config.Nameserver {
+	nameservers := conf.UpstreamNameservers.Nameservers()
+	for _, rule := range conf.ForwardingRules {
+		nameservers = append(nameservers, rule.Nameservers...)
+	}
+	for k := range conf.Delegations {
+		if conf.Delegations[k].Recurse {
+			nameservers = append(nameservers, conf.Delegations[k].Upstreams()...)
+		}
+	}
+	return nameservers
+}
+
+/*
+*	Runs an exchange for the state worker and queues its response as if it had arrived on a listener, failures are
+*	left to the upstream timeout like a lost UDP packet
+ */
+func exchangeUpstream(ctx context.Context, t UpstreamTransport, ns *config.Nameserver, payload []byte) {
+	from := &net.UDPAddr{IP: net.ParseIP(ns.IPv4), Port: int(ns.Port)}
+	if ns.IPv4 == "" {
+		from.IP = net.ParseIP(ns.IPv6)
+	}
+	spawn(routineUpstreamExchange, func() {
+		res, err := t.Exchange(ctx, payload)
+		if err != nil {
+			if ctx.Err() == nil {
+				stats.Increment(stats.ExchangeFailed)
+				exchangeWarnings.LogMessage(logging.LogError, fmt.Sprintf("Exchange with upstream %s over %s failed: %v", upstreamKey(ns), protocolOf(ns), err))
+			}
+			return
+		}
+		var m dnsmessage.Message
+		if err := m.Unpack(res); err != nil || !m.Header.Response || len(m.Questions) == 0 {
+			stats.Increment(stats.ExchangeFailed)
+			exchangeWarnings.LogMessage(logging.LogError, fmt.Sprintf("Invalid response from upstream %s over %s", upstreamKey(ns), protocolOf(ns)))
+			return
+		}
+		reqChan <- upstreamResponse(&m, from, nil)
+	})
+}
+
+/*
+*	The OpRespond handing a response from an upstream to the state worker
+ */
+func upstreamResponse(m *dnsmessage.Message, from *net.UDPAddr, conn *net.UDPConn) StateOperation {
+	packed, _ := m.Pack()
+	summary := ": empty"
+	if len(m.Answers) > 0 {
+		summary = ": " + GetAddressFromResource(m.Answers[0])
+	}
+	return StateOperation{Operation: OpRespond, RequestId: m.ID, RequestorAddr: from, ByteData: packed, Summary: summary, Conn: conn}
+}
+
+func exchangeDeadline(ctx context.Context) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline
+	}
+	return time.Now().Add(defaultExchangeTimeout)
+}
+
+func queryID(query []byte) (uint16, error) {
+	if len(query) < 12 {
+		return 0, errors.New("query is shorter than a DNS header")
+	}
+	return binary.BigEndian.Uint16(query), nil
+}
+
+/*
+*	Plain DNS over UDP. The state worker's queries go out through the shared listener or upstream source sockets,
+*	other exchanges open a socket of their own bound like the upstream's source socket
+ */
+type udpTransport struct {
+	ns   config.Nameserver
+	addr *net.UDPAddr
+}
+
+func newUDPTransport(ns config.Nameserver) (UpstreamTransport, error) {
+	ip := net.ParseIP(ns.IPv4)
+	if ns.IPv4 == "" {
+		ip = net.ParseIP(ns.IPv6)
+	}
+	if ip == nil {
+		return nil, errors.New("cannot forward to invalid upstream: neither IPv4 or IPv6 specified")
+	}
+	return &udpTransport{ns: ns, addr: &net.UDPAddr{IP: ip, Port: int(ns.Port)}}, nil
+}
+
+func (t *udpTransport) Send(query []byte) error {
+	sock := upstreamSockets[upstreamKey(&t.ns)]
+	if sock == nil {
+		sock = upstreamConn(t.addr.IP)
+	}
+	spawnSend(routineUpstreamSend, func() { sock.WriteToUDP(query, t.addr) })
+	return nil
+}
+
+func (t *udpTransport) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	id, err := queryID(query)
+	if err != nil {
+		return nil, err
+	}
+	var conn *net.UDPConn
+	if t.ns.BindAddress != "" || t.ns.BindInterface != "" {
+		conn, err = openUpstreamSocket(&t.ns)
+	} else {
+		conn, err = net.ListenUDP("udp", nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(exchangeDeadline(ctx))
+	if _, err := conn.WriteToUDP(query, t.addr); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil, err
+		}
+		if from.IP.Equal(t.addr.IP) && from.Port == t.addr.Port && n >= 12 && binary.BigEndian.Uint16(buf) == id {
+			return append([]byte{}, buf[:n]...), nil
+		}
+	}
+}
+
+func (t *udpTransport) Close() error {
+	return nil
+}
+
+/*
+*	DNS over TCP (RFC 7766) with one connection per exchange, from the upstream's BindAddress and BindInterface
+ */
+type tcpTransport struct {
+	ns     config.Nameserver
+	addr   string
+	dialer net.Dialer
+}
+
+func newTCPTransport(ns config.Nameserver) (UpstreamTransport, error) {
+	t := &tcpTransport{ns: ns, addr: upstreamKey(&ns)}
+	if ns.BindAddress != "" {
+		t.dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(ns.BindAddress)}
+	}
+	if ns.BindInterface != "" {
+		t.dialer.Control = bindToDevice(ns.BindInterface)
+	}
+	return t, nil
+}
+
+func (t *tcpTransport) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	id, err := queryID(query)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := t.dialer.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(exchangeDeadline(ctx))
+	out := make([]byte, 2, 2+len(query))
+	binary.BigEndian.PutUint16(out, uint16(len(query)))
+	if _, err := conn.Write(append(out, query...)); err != nil {
+		return nil, err
+	}
+	for {
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		res := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, res); err != nil {
+			return nil, err
+		}
+		if len(res) >= 12 && binary.BigEndian.Uint16(res) == id {
+			return res, nil
+		}
+	}
+}
+
+func (t *tcpTransport) Close() error {
+	return nil
+}
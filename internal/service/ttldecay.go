@@ -0,0 +1,87 @@
+package service
+
+import (
+	"time"
+
+	"github.com/TasSM/labns/internal/audit"
+	"github.com/TasSM/labns/internal/config"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	When each local answer set last changed, by question key. A set that decays is answered with a TTL of the
+*	seconds since it changed, no lower than MinTTL and no higher than its configured TTL, so clients come back soon
+*	after a change and stable records keep their full TTL
+ */
+type recordAges struct {
+	min      uint32
+	values   map[string]string
+	modified map[string]time.Time
+	decaying map[string]bool
+}
+
+/*
+*	Builds the ages for a configuration, sets whose records are the same as in prev keep their time and the rest
+*	are dated now, so every set counts as changed at startup
+ */
+func newRecordAges(conf *config.Configuration, prev *recordAges, now time.Time) *recordAges {
+	a := &recordAges{min: conf.TTLDecay.MinTTL, values: make(map[string]string), modified: make(map[string]time.Time),
+		decaying: make(map[string]bool)}
+	for _, r := range conf.LocalRecords {
+		if r.Type == "NULL" {
+			continue
+		}
+		key := questionKey(r.Name, r.QueryType())
+		a.values[key] += audit.RecordValue(&r) + "\n"
+		if (r.TTLDecay == nil && conf.TTLDecay.Enabled) || (r.TTLDecay != nil && *r.TTLDecay) {
+			a.decaying[key] = true
+		}
+	}
+	for key, value := range a.values {
+		a.modified[key] = now
+		if prev != nil && prev.values[key] == value {
+			a.modified[key] = prev.modified[key]
+		}
+	}
+	return a
+}
+
+/*
+*	Returns a local answer with the TTLs of the decaying sets in it lowered, each answer is matched to its set by
+*	owner name and type so CNAME chains decay link by link. msg is returned unchanged when nothing decays
+ */
+func (a *recordAges) Apply(msg []byte, now time.Time) []byte {
+	if len(a.decaying) == 0 || msg == nil {
+		return msg
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(msg); err != nil {
+		return msg
+	}
+	lowered := false
+	for k := range m.Answers {
+		key := questionKey(m.Answers[k].Header.Name.String(), m.Answers[k].Header.Type)
+		if !a.decaying[key] {
+			continue
+		}
+		ttl := a.min
+		if age := now.Sub(a.modified[key]) / time.Second; age > time.Duration(ttl) {
+			ttl = uint32(age)
+			if age > 1<<31 {
+				ttl = 1 << 31
+			}
+		}
+		if m.Answers[k].Header.TTL > ttl {
+			m.Answers[k].Header.TTL = ttl
+			lowered = true
+		}
+	}
+	if !lowered {
+		return msg
+	}
+	res, err := m.Pack()
+	if err != nil {
+		return msg
+	}
+	return res
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// long past every TTL in these tests
+const hoursOld = 10 * time.Hour
+
+func decayConfig(enabled bool, records ...config.LocalDNSRecord) *config.Configuration {
+	return &config.Configuration{TTLDecay: config.TTLDecay{Enabled: enabled, MinTTL: 30}, LocalRecords: records}
+}
+
+func decayRecord(name string, target string, decay *bool) config.LocalDNSRecord {
+	return config.LocalDNSRecord{Name: name, Type: "A", TTL: 3600, Target: target, TTLDecay: decay}
+}
+
+/*
+*	The TTL of the first answer once ages has been applied to a local answer for name
+ */
+func decayedTTL(t *testing.T, ages *recordAges, name string, now time.Time) uint32 {
+	t.Helper()
+	return answerTTL(t, ages.Apply(upstreamAnswer(t, name, 3600), now))
+}
+
+func TestTTLDecaysWithRecordAge(t *testing.T) {
+	ages := newRecordAges(decayConfig(true, decayRecord("tv.lab.home.", "192.0.2.80", nil)), nil, clockStart)
+	cases := []struct {
+		age time.Duration
+		ttl uint32
+	}{
+		{0, 30},
+		{10 * time.Second, 30},
+		{10 * time.Minute, 600},
+		{59*time.Minute + 59*time.Second, 3599},
+		{hoursOld, 3600},
+	}
+	for _, c := range cases {
+		if got := decayedTTL(t, ages, "tv.lab.home.", clockStart.Add(c.age)); got != c.ttl {
+			t.Errorf("TTL %s after the record changed is %d, want %d", c.age, got, c.ttl)
+		}
+	}
+	packet := upstreamAnswer(t, "tv.lab.home.", 3600)
+	if out := ages.Apply(packet, clockStart.Add(hoursOld)); !bytes.Equal(out, packet) {
+		t.Error("answer of a record older than its TTL was repacked")
+	}
+}
+
+func TestReloadKeepsAgeOfUnchangedRecords(t *testing.T) {
+	first := newRecordAges(decayConfig(true, decayRecord("tv.lab.home.", "192.0.2.80", nil), decayRecord("radio.lab.home.", "192.0.2.81", nil)), nil, clockStart)
+	reloaded := clockStart.Add(2 * time.Hour)
+	second := newRecordAges(decayConfig(true, decayRecord("tv.lab.home.", "192.0.2.80", nil), decayRecord("radio.lab.home.", "192.0.2.82", nil)), first, reloaded)
+
+	if got := decayedTTL(t, second, "tv.lab.home.", reloaded); got != 3600 {
+		t.Errorf("unchanged record answered with TTL %d after a reload, want its full 3600", got)
+	}
+	if got := decayedTTL(t, second, "radio.lab.home.", reloaded); got != 30 {
+		t.Errorf("changed record answered with TTL %d right after a reload, want MinTTL 30", got)
+	}
+	if got := decayedTTL(t, second, "radio.lab.home.", reloaded.Add(5*time.Minute)); got != 300 {
+		t.Errorf("changed record answered with TTL %d five minutes after a reload, want 300", got)
+	}
+}
+
+func TestTTLDecayPerRecord(t *testing.T) {
+	on, off := true, false
+	ages := newRecordAges(decayConfig(true, decayRecord("tv.lab.home.", "192.0.2.80", &off), decayRecord("radio.lab.home.", "192.0.2.81", nil)), nil, clockStart)
+	if got := decayedTTL(t, ages, "tv.lab.home.", clockStart); got != 3600 {
+		t.Errorf("record with TTLDecay false answered with TTL %d, want 3600", got)
+	}
+	if got := decayedTTL(t, ages, "radio.lab.home.", clockStart); got != 30 {
+		t.Errorf("record under the global setting answered with TTL %d, want 30", got)
+	}
+
+	ages = newRecordAges(decayConfig(false, decayRecord("tv.lab.home.", "192.0.2.80", &on), decayRecord("radio.lab.home.", "192.0.2.81", nil)), nil, clockStart)
+	if got := decayedTTL(t, ages, "tv.lab.home.", clockStart); got != 30 {
+		t.Errorf("record with TTLDecay true answered with TTL %d, want 30", got)
+	}
+	if got := decayedTTL(t, ages, "radio.lab.home.", clockStart); got != 3600 {
+		t.Errorf("record with decay off globally answered with TTL %d, want 3600", got)
+	}
+}
+
+func TestCNAMEChainDecaysPerLink(t *testing.T) {
+	conf := decayConfig(true, decayRecord("tv.lab.home.", "192.0.2.80", nil),
+		config.LocalDNSRecord{Name: "media.lab.home.", Type: "CNAME", TTL: 3600, Target: "tv.lab.home."})
+	ages := newRecordAges(conf, nil, clockStart)
+	later := clockStart.Add(time.Hour / 2)
+	conf.LocalRecords[0].Target = "192.0.2.90"
+	ages = newRecordAges(conf, ages, later)
+
+	packet := upstreamAnswer(t, "media.lab.home.", 3600, dnstest.CNAME("media.lab.home.", 3600, "tv.lab.home."), dnstest.A("tv.lab.home.", 3600, "192.0.2.90"))
+	var m dnsmessage.Message
+	if err := m.Unpack(ages.Apply(packet, later.Add(time.Minute))); err != nil {
+		t.Fatal(err)
+	}
+	if m.Answers[0].Header.TTL != 1860 || m.Answers[1].Header.TTL != 60 {
+		t.Fatalf("chain answered with TTLs %d and %d, want 1860 for the unchanged CNAME and 60 for the changed A record",
+			m.Answers[0].Header.TTL, m.Answers[1].Header.TTL)
+	}
+}
+
+func TestLocalAnswerTTLDecays(t *testing.T) {
+	conf := testConfig(t)
+	conf.TTLDecay = config.TTLDecay{Enabled: true, MinTTL: 45}
+	conf.LocalRecords = append(conf.LocalRecords, config.LocalDNSRecord{Name: "fresh.lab.home.", Type: "A", TTL: 3600, Target: "192.0.2.85"})
+	reload(t, conf)
+
+	res := lookup(t, "fresh.lab.home.", dnsmessage.TypeA, 0)
+	if len(res.Answers) != 1 || res.Answers[0].Header.TTL != 45 {
+		t.Fatalf("record added by a reload answered %v, want its address with MinTTL 45", res.Answers)
+	}
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnsname"
+	"github.com/TasSM/labns/internal/stats"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	The TTLs forced on forwarded answers by name, an exact name wins over a suffix and the most specific suffix wins
+*	over the ones above it
+ */
+type ttlOverrides struct {
+	exact    map[string]uint32
+	suffixes map[string]uint32
+	zones    localZones
+}
+
+func newTTLOverrides(overrides []config.TTLOverride) *ttlOverrides {
+	o := &ttlOverrides{exact: make(map[string]uint32), suffixes: make(map[string]uint32)}
+	var zones []string
+	for _, v := range overrides {
+		if strings.HasPrefix(v.Name, "*.") {
+			name := strings.TrimPrefix(v.Name, "*.")
+			o.suffixes[dnsname.Key(name)] = v.TTL
+			zones = append(zones, name)
+			continue
+		}
+		o.exact[dnsname.Key(v.Name)] = v.TTL
+	}
+	o.zones = newLocalZones(zones)
+	return o
+}
+
+/*
+*	Returns the TTL pinned for name, ok is false when no override covers it
+ */
+func (o *ttlOverrides) Lookup(name string) (uint32, bool) {
+	if ttl, ok := o.exact[dnsname.Key(name)]; ok {
+		return ttl, true
+	}
+	zone, ok := o.zones.Match(name)
+	return o.suffixes[zone], ok
+}
+
+/*
+*	Sets every TTL of a NOERROR response with answers for a pinned name, returning the TTL applied. Other responses,
+*	and ones that fail to parse, are returned unchanged
+ */
+func (o *ttlOverrides) apply(packet []byte, name string) ([]byte, uint32, bool) {
+	ttl, ok := o.Lookup(name)
+	if !ok {
+		return packet, 0, false
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(packet); err != nil || m.Header.RCode != dnsmessage.RCodeSuccess || len(m.Answers) == 0 {
+		return packet, 0, false
+	}
+	setTTLs(&m, ttl, false)
+	packed, err := m.Pack()
+	if err != nil {
+		return packet, 0, false
+	}
+	stats.Increment(stats.TTLPinned)
+	return packed, ttl, true
+}
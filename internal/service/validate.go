@@ -0,0 +1,61 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/dnsname"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	Rejects upstream responses that exceed the configured size, answer count or CNAME chain length
+ */
+func validateUpstreamResponse(packet []byte, limits *config.ResponseLimits) error {
+	if len(packet) > int(limits.MaxBytes) {
+		return errors.New(fmt.Sprintf("response is %d bytes, limit is %d", len(packet), limits.MaxBytes))
+	}
+	var p dnsmessage.Parser
+	if _, err := p.Start(packet); err != nil {
+		return err
+	}
+	question, err := p.Question()
+	if err != nil {
+		return err
+	}
+	if err = p.SkipAllQuestions(); err != nil {
+		return err
+	}
+	cnames := make(map[string]string)
+	answers := 0
+	for {
+		h, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		answers++
+		if answers > int(limits.MaxAnswers) {
+			return errors.New(fmt.Sprintf("response has more than %d answers", limits.MaxAnswers))
+		}
+		if h.Type != dnsmessage.TypeCNAME {
+			if err = p.SkipAnswer(); err != nil {
+				return err
+			}
+			continue
+		}
+		res, err := p.CNAMEResource()
+		if err != nil {
+			return err
+		}
+		cnames[dnsname.Key(h.Name.String())] = dnsname.Key(res.CNAME.String())
+	}
+	_, err = followChain(dnsname.Key(question.Name.String()), func(name string) (string, bool) {
+		next, ok := cnames[name]
+		return next, ok
+	}, int(limits.MaxCNAMEChain))
+	return err
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const warmupConcurrency = 8
+
+/*
+*	Resolves the configured names through the normal pipeline so their answers are cached before clients ask,
+*	failures are only logged
+ */
+func warmUp(names []config.WarmupName) {
+	if len(names) == 0 {
+		return
+	}
+	start := time.Now()
+	slots := make(chan struct{}, warmupConcurrency)
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	failed := 0
+	for _, w := range names {
+		wg.Add(1)
+		slots <- struct{}{}
+		w := w
+		spawn(routineWarmup, func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := warmUpName(w); err != nil {
+				logging.LogMessage(logging.LogError, fmt.Sprintf("Warm-up of %s %s failed: %v", w.Name, w.Type, err))
+				lock.Lock()
+				failed++
+				lock.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	logging.LogMessage(logging.LogInfo, fmt.Sprintf("Warm-up resolved %d of %d names in %dms", len(names)-failed, len(names), time.Since(start).Milliseconds()))
+}
+
+func warmUpName(w config.WarmupName) error {
+	query, err := BuildQuery(w.Name, config.RecordTypeMap[w.Type], 0)
+	if err != nil {
+		return err
+	}
+	res, err := Resolve(context.Background(), query)
+	if err != nil {
+		return err
+	}
+	if rcode := responseRCode(res); rcode != dnsmessage.RCodeSuccess {
+		return fmt.Errorf("answered %s", rcode)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/TasSM/labns/internal/wirecorpus"
+)
+
+var updateGolden = flag.Bool("update", false, "write the responses built or received into the golden files instead of comparing them")
+
+/*
+*	Replays the wire corpus in file name order against the service and compares every response byte for byte with
+*	its golden copy, apart from the ID and TTLs. The cache is flushed first so the cases see the state they were
+*	recorded in, later cases may be answered from what earlier ones cached. With -update the responses received
+*	are written into the case files instead
+ */
+func TestWireCorpus(t *testing.T) {
+	cases, err := wirecorpus.Load(corpusDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	FlushCache()
+	for k := range cases {
+		c := &cases[k]
+		t.Run(c.Name, func(t *testing.T) {
+			res := exchange(t, c.Query)
+			if *updateGolden {
+				c.Response = res
+				if err := c.Save(); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if diff := wirecorpus.Diff(res, c.Response); diff != "" {
+				t.Errorf("response differs from the golden copy (- golden, + received):\n%s", diff)
+			}
+		})
+	}
+}
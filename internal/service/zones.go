@@ -0,0 +1,71 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/TasSM/labns/internal/dnsname"
+)
+
+type localZones map[string]bool
+
+func newLocalZones(zones []string) localZones {
+	z := make(localZones, len(zones))
+	for _, v := range zones {
+		z[dnsname.Key(v)] = true
+	}
+	return z
+}
+
+/*
+*	Reports whether name is a zone labns is authoritative for, or falls under one
+ */
+func (z localZones) Contains(name string) bool {
+	_, ok := z.Match(name)
+	return ok
+}
+
+/*
+*	Returns the most specific zone name falls under
+ */
+func (z localZones) Match(name string) (string, bool) {
+	if len(z) == 0 {
+		return "", false
+	}
+	name = dnsname.Key(name)
+	for {
+		if z[name] {
+			return name, true
+		}
+		idx := strings.Index(name, ".")
+		if idx < 0 || idx == len(name)-1 {
+			return "", false
+		}
+		name = name[idx+1:]
+	}
+}
+
+/*
+*	Names answered only from local data, exact names plus "*.suffix." entries covering the suffix and everything below it
+ */
+type pinnedNames struct {
+	exact    map[string]bool
+	suffixes localZones
+}
+
+func newPinnedNames(names []string) *pinnedNames {
+	p := &pinnedNames{exact: make(map[string]bool)}
+	var suffixes []string
+	for _, v := range names {
+		if strings.HasPrefix(v, "*.") {
+			suffixes = append(suffixes, strings.TrimPrefix(v, "*."))
+			continue
+		}
+		p.exact[dnsname.Key(v)] = true
+	}
+	p.suffixes = newLocalZones(suffixes)
+	return p
+}
+
+func (p *pinnedNames) Contains(name string) bool {
+	return p.exact[dnsname.Key(name)] || p.suffixes.Contains(name)
+}
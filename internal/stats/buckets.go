@@ -0,0 +1,62 @@
+package stats
+
+import (
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const OtherBucket = "OTHER"
+
+var (
+	qtypeBuckets = map[dnsmessage.Type]string{
+		dnsmessage.TypeA:     "A",
+		dnsmessage.TypeAAAA:  "AAAA",
+		dnsmessage.TypeCNAME: "CNAME",
+		dnsmessage.TypeMX:    "MX",
+		dnsmessage.TypeTXT:   "TXT",
+		dnsmessage.TypeSRV:   "SRV",
+		dnsmessage.TypePTR:   "PTR",
+		dnsmessage.TypeSOA:   "SOA",
+		dnsmessage.TypeNS:    "NS",
+		65:                   "HTTPS",
+	}
+	rcodeBuckets = map[dnsmessage.RCode]string{
+		dnsmessage.RCodeSuccess:        "NOERROR",
+		dnsmessage.RCodeFormatError:    "FORMERR",
+		dnsmessage.RCodeServerFailure:  "SERVFAIL",
+		dnsmessage.RCodeNameError:      "NXDOMAIN",
+		dnsmessage.RCodeNotImplemented: "NOTIMP",
+		dnsmessage.RCodeRefused:        "REFUSED",
+	}
+)
+
+/*
+*	Maps a query type onto a fixed set of names so stats and log labels stay bounded, anything else is OTHER
+ */
+func QTypeBucket(t dnsmessage.Type) string {
+	if name, ok := qtypeBuckets[t]; ok {
+		return name
+	}
+	return OtherBucket
+}
+
+func RCodeBucket(rc dnsmessage.RCode) string {
+	if name, ok := rcodeBuckets[rc]; ok {
+		return name
+	}
+	return OtherBucket
+}
+
+func CountQuery(t dnsmessage.Type) {
+	Increment(Counter("qtype_" + QTypeBucket(t)))
+}
+
+func CountResponse(rc dnsmessage.RCode) {
+	Increment(Counter("rcode_" + RCodeBucket(rc)))
+}
+
+/*
+*	Counts an answer by where it came from, e.g. local, cache or upstream
+ */
+func CountAnswer(source string) {
+	Increment(Counter("answers_" + source))
+}
@@ -0,0 +1,70 @@
+package stats
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestQTypeBucket(t *testing.T) {
+	cases := map[dnsmessage.Type]string{
+		dnsmessage.TypeA:    "A",
+		dnsmessage.TypeAAAA: "AAAA",
+		dnsmessage.TypeMX:   "MX",
+		65:                  "HTTPS",
+		dnsmessage.TypeOPT:  OtherBucket,
+		dnsmessage.TypeALL:  OtherBucket,
+		65280:               OtherBucket,
+		65535:               OtherBucket,
+		0:                   OtherBucket,
+	}
+	for qtype, want := range cases {
+		if got := QTypeBucket(qtype); got != want {
+			t.Errorf("QTypeBucket(%d) = %s, want %s", qtype, got, want)
+		}
+	}
+}
+
+func TestRCodeBucket(t *testing.T) {
+	cases := map[dnsmessage.RCode]string{
+		dnsmessage.RCodeSuccess:       "NOERROR",
+		dnsmessage.RCodeNameError:     "NXDOMAIN",
+		dnsmessage.RCodeServerFailure: "SERVFAIL",
+		dnsmessage.RCodeRefused:       "REFUSED",
+		// YXDOMAIN and the extended rcodes have no bucket of their own
+		6:    OtherBucket,
+		23:   OtherBucket,
+		4095: OtherBucket,
+	}
+	for rcode, want := range cases {
+		if got := RCodeBucket(rcode); got != want {
+			t.Errorf("RCodeBucket(%d) = %s, want %s", rcode, got, want)
+		}
+	}
+}
+
+/*
+*	Every type from 0 to 65535 is counted under one of the eleven buckets, whatever a client sends
+ */
+func TestCountQueryCardinality(t *testing.T) {
+	before := Snapshot()
+	for qtype := 0; qtype <= 65535; qtype++ {
+		CountQuery(dnsmessage.Type(qtype))
+	}
+	names := map[Counter]bool{}
+	for c := range Snapshot() {
+		if strings.HasPrefix(string(c), "qtype_") {
+			names[c] = true
+		}
+	}
+	if len(names) != len(qtypeBuckets)+1 {
+		t.Fatalf("types are counted under %d names, want %d", len(names), len(qtypeBuckets)+1)
+	}
+	if got := Get("qtype_OTHER") - before["qtype_OTHER"]; got != 65536-uint64(len(qtypeBuckets)) {
+		t.Fatalf("qtype_OTHER went up by %d, want %d", got, 65536-len(qtypeBuckets))
+	}
+	if got := Get("qtype_A") - before["qtype_A"]; got != 1 {
+		t.Fatalf("qtype_A went up by %d, want 1", got)
+	}
+}
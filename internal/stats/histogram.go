@@ -0,0 +1,97 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// upper bounds in milliseconds, anything slower lands in the final overflow bucket
+var LatencyBucketsMs = [...]float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+
+type Histogram struct {
+	Buckets [len(LatencyBucketsMs) + 1]uint64
+	Count   uint64
+	SumMs   float64
+}
+
+var histograms = make(map[string]*Histogram)
+
+/*
+*	Records d in the latency histogram for labels, e.g. "source=upstream qtype=TypeA"
+ */
+func ObserveLatency(labels string, d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	i := sort.SearchFloat64s(LatencyBucketsMs[:], ms)
+	lock.Lock()
+	defer lock.Unlock()
+	h := histograms[labels]
+	if h == nil {
+		h = &Histogram{}
+		histograms[labels] = h
+	}
+	h.Buckets[i]++
+	h.Count++
+	h.SumMs += ms
+}
+
+func Histograms() map[string]Histogram {
+	lock.Lock()
+	defer lock.Unlock()
+	out := make(map[string]Histogram, len(histograms))
+	for k, v := range histograms {
+		out[k] = *v
+	}
+	return out
+}
+
+/*
+*	Returns the upper bound of the bucket holding quantile q, or -1 past the last bound
+ */
+func (h Histogram) Quantile(q float64) float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(h.Count) + 0.5)
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, n := range h.Buckets {
+		seen += n
+		if seen >= rank {
+			if i == len(LatencyBucketsMs) {
+				return -1
+			}
+			return LatencyBucketsMs[i]
+		}
+	}
+	return -1
+}
+
+func formatBound(ms float64) string {
+	if ms < 0 {
+		return fmt.Sprintf(">%g", LatencyBucketsMs[len(LatencyBucketsMs)-1])
+	}
+	return fmt.Sprintf("<=%g", ms)
+}
+
+/*
+*	Renders one line per histogram with count, mean and bucketed p50/p99 in milliseconds
+ */
+func DumpHistograms() string {
+	snap := Histograms()
+	keys := make([]string, 0, len(snap))
+	for k := range snap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lines := make([]string, 0, len(keys))
+	for _, k := range keys {
+		h := snap[k]
+		lines = append(lines, fmt.Sprintf("latency %s: count=%d mean=%.1fms p50%sms p99%sms",
+			k, h.Count, h.SumMs/float64(h.Count), formatBound(h.Quantile(0.5)), formatBound(h.Quantile(0.99))))
+	}
+	return strings.Join(lines, "\n")
+}
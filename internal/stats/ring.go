@@ -0,0 +1,109 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	RingInterval = 10 * time.Second
+	// 15 minutes of samples plus the one the oldest window is measured from
+	ringSlots = 91
+	// SERVFAIL answers kept for the status view
+	recentServfails = 10
+)
+
+type ringSample struct {
+	at       time.Time
+	counters map[Counter]uint64
+}
+
+/*
+*	A SERVFAIL answer, Name has QueryLogPrivacy applied by the caller
+ */
+type ServfailEntry struct {
+	Time   time.Time
+	Name   string
+	Source string
+}
+
+var (
+	ringLock  sync.Mutex
+	ring      [ringSlots]ringSample
+	ringNext  int
+	servfails []ServfailEntry
+)
+
+/*
+*	Samples every counter each RingInterval so Since can report what changed over the last minutes without an
+*	external metrics system, runs until the process exits
+ */
+func SampleRing() {
+	sampleRing(time.Now())
+	for now := range time.Tick(RingInterval) {
+		sampleRing(now)
+	}
+}
+
+func sampleRing(now time.Time) {
+	snap := Snapshot()
+	ringLock.Lock()
+	defer ringLock.Unlock()
+	ring[ringNext] = ringSample{at: now, counters: snap}
+	ringNext = (ringNext + 1) % ringSlots
+}
+
+/*
+*	Returns how much each counter grew over the last window and the time that was measured over, which is shorter
+*	than window while less history has been sampled. The elapsed time is 0 before the first sample
+ */
+func Since(window time.Duration, now time.Time) (map[Counter]uint64, time.Duration) {
+	ringLock.Lock()
+	var base *ringSample
+	for k := range ring {
+		s := &ring[k]
+		if s.counters == nil || now.Sub(s.at) > window {
+			continue
+		}
+		if base == nil || s.at.Before(base.at) {
+			base = s
+		}
+	}
+	ringLock.Unlock()
+	if base == nil {
+		return map[Counter]uint64{}, 0
+	}
+	current := Snapshot()
+	out := make(map[Counter]uint64, len(current))
+	for c, v := range current {
+		if v >= base.counters[c] {
+			out[c] = v - base.counters[c]
+		}
+	}
+	return out, now.Sub(base.at)
+}
+
+/*
+*	Remembers a SERVFAIL answer, only the most recent ones are kept
+ */
+func RecordServfail(name, source string, at time.Time) {
+	ringLock.Lock()
+	defer ringLock.Unlock()
+	servfails = append(servfails, ServfailEntry{Time: at, Name: name, Source: source})
+	if len(servfails) > recentServfails {
+		servfails = servfails[len(servfails)-recentServfails:]
+	}
+}
+
+/*
+*	Returns the most recent SERVFAIL answers, newest first
+ */
+func RecentServfails() []ServfailEntry {
+	ringLock.Lock()
+	defer ringLock.Unlock()
+	out := make([]ServfailEntry, 0, len(servfails))
+	for k := len(servfails) - 1; k >= 0; k-- {
+		out = append(out, servfails[k])
+	}
+	return out
+}
@@ -0,0 +1,147 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type Counter string
+
+const (
+	UpstreamRejected Counter = "upstream_rejected"
+	InflightRejected Counter = "client_inflight_rejected"
+	Blocked          Counter = "blocked"
+	CaseMismatch     Counter = "case_mismatch"
+	SyslogDropped    Counter = "syslog_dropped"
+	Malformed        Counter = "malformed"
+	UpstreamInvalid  Counter = "upstream_response_rejected"
+	LoopDetected     Counter = "loop_detected"
+	CacheHit         Counter = "cache_hit"
+	CacheMiss        Counter = "cache_miss"
+	CacheBailiwick   Counter = "cache_out_of_bailiwick"
+	CacheTooLarge    Counter = "cache_entry_too_large"
+	FastPathHit      Counter = "fast_path_hit"
+	FastPathStale    Counter = "fast_path_stale"
+	FastPathRefresh  Counter = "fast_path_refresh"
+	Reloads          Counter = "reloads"
+	ReloadFailures   Counter = "reload_failures"
+	LastReload       Counter = "last_reload_timestamp"
+	HistoryDropped   Counter = "history_dropped"
+	FaultServfail    Counter = "fault_servfail"
+	FaultDelay       Counter = "fault_delay"
+	FaultDrop        Counter = "fault_drop"
+	LoadShed         Counter = "load_shed"
+	Goroutines       Counter = "goroutines"
+	OpenFiles        Counter = "open_files"
+	MirrorSent       Counter = "mirror_sent"
+	MirrorDropped    Counter = "mirror_dropped"
+	MirrorAnswered   Counter = "mirror_answered"
+	MirrorMatched    Counter = "mirror_matched"
+	MirrorMismatched Counter = "mirror_mismatched"
+	MirrorUnanswered Counter = "mirror_unanswered"
+	DriftChecked     Counter = "drift_checked"
+	DriftMismatched  Counter = "drift_mismatched"
+	DriftSkipped     Counter = "drift_skipped"
+	DriftFailed      Counter = "drift_failed"
+	Offline          Counter = "offline"
+	OfflineStale     Counter = "offline_stale"
+	OfflineServfail  Counter = "offline_servfail"
+	ExchangeFailed   Counter = "upstream_exchange_failed"
+	HookRuns         Counter = "hook_runs"
+	HookCached       Counter = "hook_cached"
+	HookAnswered     Counter = "hook_answered"
+	HookBlocked      Counter = "hook_blocked"
+	HookFailed       Counter = "hook_failed"
+	HookTimedOut     Counter = "hook_timed_out"
+	HookSkipped      Counter = "hook_skipped"
+	AnswerRewritten  Counter = "answer_rewritten"
+	TTLPinned        Counter = "ttl_pinned"
+	BogusSource      Counter = "bogus_source"
+	TCPFallback      Counter = "tcp_fallback"
+	ClassNotIN       Counter = "class_not_in"
+	CacheBypass      Counter = "cache_bypass"
+)
+
+var (
+	lock     sync.Mutex
+	counters = make(map[Counter]uint64)
+)
+
+/*
+*	Returns the per-listener query counter for the socket bound to addr
+ */
+func ListenerQueries(addr string) Counter {
+	return Counter("queries_" + addr)
+}
+
+/*
+*	Returns the gauge of goroutines running for one kind of work, e.g. "reply" or "upstream_wait"
+ */
+func RoutineCount(kind string) Counter {
+	return Counter("goroutines_" + kind)
+}
+
+/*
+*	Returns the query and blocked counters of the named listener profile
+ */
+func ProfileQueries(profile string) Counter {
+	return Counter("profile_" + profile + "_queries")
+}
+
+func ProfileBlocked(profile string) Counter {
+	return Counter("profile_" + profile + "_blocked")
+}
+
+func Increment(c Counter) {
+	Add(c, 1)
+}
+
+func Add(c Counter, n uint64) {
+	lock.Lock()
+	defer lock.Unlock()
+	counters[c] += n
+}
+
+/*
+*	Sets c to v, for gauges such as timestamps reported alongside the counters
+ */
+func Set(c Counter, v uint64) {
+	lock.Lock()
+	defer lock.Unlock()
+	counters[c] = v
+}
+
+func Get(c Counter) uint64 {
+	lock.Lock()
+	defer lock.Unlock()
+	return counters[c]
+}
+
+func Snapshot() map[Counter]uint64 {
+	lock.Lock()
+	defer lock.Unlock()
+	out := make(map[Counter]uint64, len(counters))
+	for k, v := range counters {
+		out[k] = v
+	}
+	return out
+}
+
+/*
+*	Renders all counters as a single sorted "name=value" line for the log
+ */
+func Dump() string {
+	snap := Snapshot()
+	keys := make([]string, 0, len(snap))
+	for k := range snap {
+		keys = append(keys, string(k))
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%d", k, snap[Counter(k)]))
+	}
+	return "stats: " + strings.Join(parts, " ")
+}
@@ -0,0 +1,145 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	topWindow       = time.Hour
+	topSlots        = 12
+	topClientsLimit = 1024
+	topDomainsLimit = 4096
+)
+
+type TopEntry struct {
+	Key   string
+	Count uint64
+}
+
+/*
+*	Sliding-window key counter made of fixed time slots, each capped with the space-saving algorithm so memory stays bounded
+ */
+type Rolling struct {
+	mu       sync.Mutex
+	slotSize time.Duration
+	capacity int
+	epochs   [topSlots]int64
+	slots    [topSlots]map[string]uint64
+}
+
+var (
+	TopClients = NewRolling(topWindow, topClientsLimit)
+	TopDomains = NewRolling(topWindow, topDomainsLimit)
+)
+
+func NewRolling(window time.Duration, capacity int) *Rolling {
+	r := &Rolling{slotSize: window / topSlots, capacity: capacity}
+	for i := range r.slots {
+		r.slots[i] = make(map[string]uint64)
+	}
+	return r
+}
+
+func (r *Rolling) Add(key string, now time.Time) {
+	epoch := now.UnixNano() / int64(r.slotSize)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := epoch % topSlots
+	slot := r.slots[i]
+	if r.epochs[i] != epoch {
+		r.epochs[i] = epoch
+		slot = make(map[string]uint64)
+		r.slots[i] = slot
+	}
+	if _, ok := slot[key]; ok || len(slot) < r.capacity {
+		slot[key]++
+		return
+	}
+	// slot is full, the new key takes over the smallest entry and inherits its count as the error bound
+	var minKey string
+	var minCount uint64
+	for k, v := range slot {
+		if minKey == "" || v < minCount {
+			minKey, minCount = k, v
+		}
+	}
+	delete(slot, minKey)
+	slot[key] = minCount + 1
+}
+
+/*
+*	Returns the n highest counts across the slots still inside the window
+ */
+func (r *Rolling) Top(n int, now time.Time) []TopEntry {
+	epoch := now.UnixNano() / int64(r.slotSize)
+	merged := make(map[string]uint64)
+	r.mu.Lock()
+	for i, slot := range r.slots {
+		if epoch-r.epochs[i] >= topSlots {
+			continue
+		}
+		for k, v := range slot {
+			merged[k] += v
+		}
+	}
+	r.mu.Unlock()
+	out := make([]TopEntry, 0, len(merged))
+	for k, v := range merged {
+		out = append(out, TopEntry{Key: k, Count: v})
+	}
+	sort.Slice(out, func(a, b int) bool {
+		if out[a].Count != out[b].Count {
+			return out[a].Count > out[b].Count
+		}
+		return out[a].Key < out[b].Key
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+/*
+*	Collapses a query name to its registered domain, keeping depth labels (one more when the second level looks like co.uk)
+ */
+func RegisteredDomain(name string, depth int) string {
+	labels := strings.Split(strings.TrimSuffix(strings.ToLower(name), "."), ".")
+	if depth <= 0 {
+		depth = 2
+	}
+	if n := len(labels); n > depth && len(labels[n-1]) == 2 && isSecondLevelSuffix(labels[n-2]) {
+		depth++
+	}
+	if len(labels) > depth {
+		labels = labels[len(labels)-depth:]
+	}
+	return strings.Join(labels, ".") + "."
+}
+
+func isSecondLevelSuffix(label string) bool {
+	switch label {
+	case "co", "com", "net", "org", "gov", "ac", "edu", "ne", "or":
+		return true
+	}
+	return false
+}
+
+func formatTop(entries []TopEntry) string {
+	parts := make([]string, 0, len(entries))
+	for _, e := range entries {
+		parts = append(parts, fmt.Sprintf("%s=%d", e.Key, e.Count))
+	}
+	return strings.Join(parts, " ")
+}
+
+/*
+*	Renders the top n clients and domains of the last hour as two log lines
+ */
+func DumpTop(n int) string {
+	now := time.Now()
+	return "top clients: " + formatTop(TopClients.Top(n, now)) + "\ntop domains: " + formatTop(TopDomains.Top(n, now))
+}
@@ -0,0 +1,214 @@
+package wirecorpus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const bytesPerLine = 16
+
+/*
+*	One query of the corpus and the response labns is expected to send for it. Case files hold comment lines
+*	starting with #, the query as lines starting with > and the response as lines starting with <, both in hex
+ */
+type Case struct {
+	Name     string
+	Path     string
+	Comment  []string
+	Query    []byte
+	Response []byte
+}
+
+/*
+*	Reads every .txt case in dir, sorted by file name since later cases may depend on what earlier ones cached
+ */
+func Load(dir string) ([]Case, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	cases := make([]Case, 0, len(paths))
+	for _, path := range paths {
+		c, err := readCase(path)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s: %v", path, err))
+		}
+		cases = append(cases, c)
+	}
+	return cases, nil
+}
+
+func readCase(path string) (Case, error) {
+	c := Case{Name: strings.TrimSuffix(filepath.Base(path), ".txt"), Path: path}
+	f, err := os.Open(path)
+	if err != nil {
+		return c, err
+	}
+	defer f.Close()
+	var query, response strings.Builder
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#"):
+			c.Comment = append(c.Comment, strings.TrimSpace(strings.TrimPrefix(line, "#")))
+		case strings.HasPrefix(line, ">"):
+			query.WriteString(strings.ReplaceAll(line[1:], " ", ""))
+		case strings.HasPrefix(line, "<"):
+			response.WriteString(strings.ReplaceAll(line[1:], " ", ""))
+		default:
+			return c, errors.New(fmt.Sprintf("unexpected line %q, should start with #, > or <", line))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return c, err
+	}
+	if c.Query, err = hex.DecodeString(query.String()); err != nil || len(c.Query) < 12 {
+		return c, errors.New("query is missing or not a DNS message in hex")
+	}
+	if c.Response, err = hex.DecodeString(response.String()); err != nil {
+		return c, errors.New("response is not valid hex")
+	}
+	return c, nil
+}
+
+/*
+*	Writes c back to its file with the response replaced, used to accept a changed answer
+ */
+func (c *Case) Save() error {
+	var b strings.Builder
+	for _, line := range c.Comment {
+		b.WriteString("# " + line + "\n")
+	}
+	b.WriteString("\n" + FormatHex(c.Query, "> ") + "\n" + FormatHex(c.Response, "< "))
+	return ioutil.WriteFile(c.Path, []byte(b.String()), 0644)
+}
+
+/*
+*	Renders msg as lines of 16 bytes in groups of two, so diffs of case files point at the bytes that changed
+ */
+func FormatHex(msg []byte, prefix string) string {
+	var b strings.Builder
+	for start := 0; start < len(msg); start += bytesPerLine {
+		end := start + bytesPerLine
+		if end > len(msg) {
+			end = len(msg)
+		}
+		b.WriteString(prefix)
+		for k := start; k < end; k += 2 {
+			if k > start {
+				b.WriteString(" ")
+			}
+			b.WriteString(hex.EncodeToString(msg[k:min(k+2, end)]))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+/*
+*	Returns a copy of msg with the ID and every TTL zeroed, the parts of a response that differ from run to run. The
+*	OPT record's TTL field carries flags and is kept. A message that can't be walked is returned unmasked
+ */
+func Mask(msg []byte) []byte {
+	out := append([]byte{}, msg...)
+	if len(out) < 12 {
+		return out
+	}
+	out[0], out[1] = 0, 0
+	offsets, err := ttlOffsets(out)
+	if err != nil {
+		return out
+	}
+	for _, off := range offsets {
+		copy(out[off:off+4], []byte{0, 0, 0, 0})
+	}
+	return out
+}
+
+func ttlOffsets(msg []byte) ([]int, error) {
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	records := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+	off := 12
+	var err error
+	for k := 0; k < questions; k++ {
+		if off, err = skipName(msg, off); err != nil {
+			return nil, err
+		}
+		off += 4
+	}
+	var offsets []int
+	for k := 0; k < records; k++ {
+		if off, err = skipName(msg, off); err != nil {
+			return nil, err
+		}
+		if off+10 > len(msg) {
+			return nil, errors.New("record header runs past the end of the message")
+		}
+		if binary.BigEndian.Uint16(msg[off:]) != 41 {
+			offsets = append(offsets, off+4)
+		}
+		off += 10 + int(binary.BigEndian.Uint16(msg[off+8:]))
+	}
+	if off > len(msg) {
+		return nil, errors.New("record data runs past the end of the message")
+	}
+	return offsets, nil
+}
+
+func skipName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, errors.New("name runs past the end of the message")
+		}
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			return off + 1, nil
+		case length&0xc0 == 0xc0:
+			return off + 2, nil
+		default:
+			off += 1 + length
+		}
+	}
+}
+
+/*
+*	Compares two responses with Mask applied, returning an empty string when they match and otherwise the hex
+*	lines that differ, the expected one first
+ */
+func Diff(got, want []byte) string {
+	gotLines := strings.Split(FormatHex(Mask(got), ""), "\n")
+	wantLines := strings.Split(FormatHex(Mask(want), ""), "\n")
+	var b strings.Builder
+	for k := 0; k < len(gotLines) || k < len(wantLines); k++ {
+		var g, w string
+		if k < len(gotLines) {
+			g = gotLines[k]
+		}
+		if k < len(wantLines) {
+			w = wantLines[k]
+		}
+		if g != w {
+			b.WriteString(fmt.Sprintf("  %04x  - %s\n        + %s\n", k*bytesPerLine, w, g))
+		}
+	}
+	return b.String()
+}
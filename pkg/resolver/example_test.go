@@ -0,0 +1,104 @@
+package resolver_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/TasSM/labns/pkg/resolver"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+*	An upstream answering every A query with the same address, in place of a real resolver
+ */
+type staticUpstream struct {
+	addr [4]byte
+}
+
+func (s staticUpstream) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	var q dnsmessage.Message
+	if err := q.Unpack(query); err != nil {
+		return nil, err
+	}
+	res := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: q.ID, Response: true, RecursionDesired: q.RecursionDesired, RecursionAvailable: true},
+		Questions: q.Questions,
+	}
+	if q.Questions[0].Type == dnsmessage.TypeA {
+		res.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: q.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.AResource{A: s.addr},
+		}}
+	}
+	return res.Pack()
+}
+
+func (s staticUpstream) Close() error {
+	return nil
+}
+
+func printAnswers(res dnsmessage.Message) {
+	for _, a := range res.Answers {
+		if body, ok := a.Body.(*dnsmessage.AResource); ok {
+			fmt.Println(a.Header.Name, net.IP(body.A[:]))
+		}
+	}
+}
+
+func Example() {
+	resolver.RegisterTransport("static", func(ns resolver.Nameserver) (resolver.UpstreamTransport, error) {
+		return staticUpstream{addr: [4]byte{192, 0, 2, 80}}, nil
+	})
+	r, err := resolver.New(&resolver.Configuration{
+		LocalRecords: []resolver.LocalDNSRecord{{Name: "nas.lab.home.", Type: "A", TTL: 300, Target: "192.168.1.10"}},
+		UpstreamNameservers: resolver.UpstreamNameservers{
+			Primary:   resolver.Nameserver{IPv4: "192.0.2.1", Protocol: "static"},
+			Secondary: resolver.Nameserver{IPv4: "192.0.2.2", Protocol: "static"},
+		},
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	resolver.StartLogging(os.DevNull)
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	go r.ServeUDP(conn)
+
+	for _, name := range []string{"nas.lab.home.", "www.example.com."} {
+		query := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: 1, RecursionDesired: true},
+			Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+		}
+		// Resolve fails until the pipeline has started
+		for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+			res, err := r.Resolve(context.Background(), query)
+			if err == nil {
+				printAnswers(res)
+				break
+			}
+		}
+	}
+	// Output:
+	// nas.lab.home. 192.168.1.10
+	// www.example.com. 192.0.2.80
+}
+
+func ExampleNew() {
+	_, err := resolver.New(&resolver.Configuration{
+		LocalRecords: []resolver.LocalDNSRecord{{Name: "nas.lab.home.", Type: "A", TTL: 300, Target: "not-an-address"}},
+		UpstreamNameservers: resolver.UpstreamNameservers{
+			Primary:   resolver.Nameserver{IPv4: "192.0.2.1"},
+			Secondary: resolver.Nameserver{IPv4: "192.0.2.2"},
+		},
+	})
+	fmt.Println(err)
+	// Output:
+	// Target for LocalRecord at index 0 is invalid: "not-an-address" is not an IPv4 address
+}
@@ -0,0 +1,111 @@
+/*
+*	Package resolver embeds the labns resolution pipeline (local records, zones, blocklists and upstream forwarding)
+*	in another Go program. The pipeline keeps process wide state, so only one Resolver can be served per process.
+ */
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+
+	"github.com/TasSM/labns/internal/config"
+	"github.com/TasSM/labns/internal/logging"
+	"github.com/TasSM/labns/internal/service"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type (
+	Configuration       = config.Configuration
+	LocalDNSRecord      = config.LocalDNSRecord
+	Nameserver          = config.Nameserver
+	UpstreamNameservers = config.UpstreamNameservers
+	ForwardingRule      = config.ForwardingRule
+	Blocklist           = config.Blocklist
+	BlockResponse       = config.BlockResponse
+	UpstreamTransport   = service.UpstreamTransport
+	TransportFactory    = service.TransportFactory
+)
+
+type Resolver struct {
+	conf *Configuration
+}
+
+var serving int32
+
+/*
+*	Starts writing labns log lines to path, or stderr when empty, the pipeline blocks once its log buffer fills if this is not running
+ */
+func StartLogging(path string) {
+	go logging.InitLogging(path)
+}
+
+/*
+*	Adds a transport Nameservers can select with their Protocol, e.g. a unix socket to a local stub. Register it
+*	before loading or validating a configuration that uses it. Timeouts, failover, retries and upstream health apply
+*	to it as to the built-in udp and tcp
+ */
+func RegisterTransport(name string, factory TransportFactory) error {
+	return service.RegisterTransport(name, factory)
+}
+
+func LoadConfig(path string) (*Configuration, error) {
+	return config.LoadConfig(path)
+}
+
+func ReadConfig(r io.Reader) (*Configuration, error) {
+	return config.ReadConfig(r)
+}
+
+/*
+*	Validates conf and applies the same defaults as a configuration file, conf itself is not modified
+ */
+func New(conf *Configuration) (*Resolver, error) {
+	serial, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	validated, err := config.ReadConfig(bytes.NewReader(serial))
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{conf: validated}, nil
+}
+
+/*
+*	Serves queries on conns until the process exits, upstream queries are also sent from these sockets
+ */
+func (r *Resolver) ServeUDP(conns ...*net.UDPConn) error {
+	if len(conns) == 0 {
+		return errors.New("at least one socket is required")
+	}
+	if !atomic.CompareAndSwapInt32(&serving, 0, 1) {
+		return errors.New("a Resolver is already being served in this process")
+	}
+	service.StartDNSService(conns, r.conf)
+	return nil
+}
+
+/*
+*	Resolves msg in-process, ServeUDP must already be running in another goroutine
+ */
+func (r *Resolver) Resolve(ctx context.Context, msg dnsmessage.Message) (dnsmessage.Message, error) {
+	var out dnsmessage.Message
+	if atomic.LoadInt32(&serving) == 0 {
+		return out, errors.New("Resolve requires ServeUDP to be running")
+	}
+	query, err := msg.Pack()
+	if err != nil {
+		return out, err
+	}
+	res, err := service.Resolve(ctx, query)
+	if err != nil {
+		return out, err
+	}
+	err = out.Unpack(res)
+	return out, err
+}
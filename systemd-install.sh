@@ -11,7 +11,7 @@ BIN_PATH=/usr/local/bin/
 
 # build app
 go mod download
-CGO_ENABLED=0 GOOS=linux go build -o ./bin/main ./cmd/labns/main.go
+CGO_ENABLED=0 GOOS=linux go build -o ./bin/main ./cmd/labns
 
 # install as systemd service
 mkdir -p $LABNS_ETC_PATH